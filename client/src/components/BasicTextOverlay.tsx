@@ -61,10 +61,15 @@ export class BasicTextOverlay extends React.PureComponent<BasicTextOverlayProps,
 			let s = "\u00A0\u00A0\u00A0\u00A0" + entry.address;
 			s += "\u00A0\u00A0\u00A0\u00A0";
 			s += "\u00A0\u00A0\u00A0\u00A0";
-			if (entry.latency === -1) {
-				s += "pendingScan";
-			} else {
-				s += entry.latency > 0 ? `${entry.latency}ms` : "unreachable";
+			switch (entry.status) {
+				case "reachable":
+					s += `${entry.latency.toFixed(1)}ms`;
+					break;
+				case "unknown":
+					s += "pendingScan";
+					break;
+				default:
+					s += entry.status;
 			}
 			if (this.props.demoMode) {
 				entry.hostname = getFakeHostname(entry.address);
@@ -27,6 +27,7 @@ import { messageSenders } from "./websocket/MessageHandlers";
 import {
 	ScanTarget,
 	ScanEntry,
+	ScanStatus,
 	createStaleEntries,
 	getMergedEntries
 } from "./websocket/messagehandlers/ManualPingScan";
@@ -296,6 +297,20 @@ export class Main extends React.Component<{}, MainState> {
 				}
 			}
 		};
+		this.state.triggers.updateScanStatus = (aggregate, status) => {
+			const scanTargets = [...this.state.scanTargets];
+			for (var i = 0; i < scanTargets.length; i++) {
+				if (scanTargets[i].target === aggregate) {
+					if (status === null) {
+						scanTargets[i].status = undefined;
+					} else {
+						const previous = scanTargets[i].status || { position: 0, queueLength: 0, completed: 0, total: 0 };
+						scanTargets[i].status = { ...previous, ...status };
+					}
+					this.setState({ scanTargets: scanTargets });
+				}
+			}
+		};
 		this.state.triggers.searchFieldIsEmpty = () => {
 			return this.state.emptySearchField;
 		};
@@ -329,6 +344,7 @@ export interface MainTriggers {
 	startScanning: (net: string) => void;
 	getScanTargets: () => ScanTarget[];
 	updateScanTarget: (net: string, entries: ScanEntry[]) => void;
+	updateScanStatus: (net: string, status: Partial<ScanStatus> | null) => void;
 	searchFieldIsEmpty: () => boolean;
 	selectScanTarget: (target: string) => void;
 	openScannerPopup: () => void;
@@ -8,10 +8,18 @@ const pingResultWidth = 120;
 const lastAttemptWidth = 160;
 const extraDataWidth = 160;
 
+export interface PingResult {
+	status: "unknown" | "reachable" | "timeout" | "unreachable" | "error";
+	latency?: number;
+	code?: number;
+	error?: string;
+	probe?: string;
+}
+
 export interface HostData {
 	addresses: string[];
 	aRecords: string[];
-	pingResults: string[];
+	pingResults: PingResult[];
 	lastAttempts: string[];
 	customData: string[][];
 }
@@ -81,19 +89,30 @@ export class Right extends React.PureComponent<RightProps, RightState> {
 	pingResultRenderer = (rowIndex: number) => {
 		if (this.props.hostData.pingResults.length > rowIndex) {
 			const value = this.props.hostData.pingResults[rowIndex];
-			if (value[0] === "-") {
-				return (
-					<Cell key={`pingResult#:${rowIndex}`}>
-						<React.Fragment>
-							<div style={{ textAlign: "center", color: "rgb(255, 0, 0)" }}>{"unreachable"}</div>
-						</React.Fragment>
-					</Cell>
-				);
+			let text = "";
+			let color = "rgb(255, 0, 0)";
+			switch (value.status) {
+				case "reachable":
+					text = `${(value.latency || 0).toFixed(1)}ms`;
+					color = "rgb(0, 240, 0)";
+					break;
+				case "timeout":
+					text = "timeout";
+					break;
+				case "unreachable":
+					text = `unreachable (${value.code || 0})`;
+					break;
+				case "error":
+					text = "error";
+					color = "rgb(255, 165, 0)";
+					break;
+				default:
+					return <Cell key={`pingResult#:${rowIndex}`} />;
 			}
 			return (
-				<Cell key={`pingResult#:${rowIndex}`}>
+				<Cell key={`pingResult#:${rowIndex}`} tooltip={value.error}>
 					<React.Fragment>
-						<div style={{ textAlign: "center", color: "rgb(0, 240, 0)" }}>{value}</div>
+						<div style={{ textAlign: "center", color: color }}>{text}</div>
 					</React.Fragment>
 				</Cell>
 			);
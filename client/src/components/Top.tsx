@@ -23,7 +23,7 @@ import netparser from "netparser";
 
 import { MainState as TopProps, rootElement } from "./Main";
 import { BasicTextOverlayMode } from "./BasicTextOverlay";
-import { getScanTargetPercentage } from "./websocket/messagehandlers/ManualPingScan";
+import { getScanTargetPercentage, getScanStatusText } from "./websocket/messagehandlers/ManualPingScan";
 
 class TopState {
 	clientWidth = rootElement.clientWidth;
@@ -83,6 +83,9 @@ export class Top extends React.Component<TopProps, TopState> {
 					>
 						<div className="bp3-progress-meter" style={{ width: `${Math.floor(percentNormal * 100)}%` }} />
 					</div>
+					<span className="bp3-text-muted bp3-text-small" style={{ paddingTop: "5px", display: "inline-block", width: "100%", textAlign: "center" }}>
+						{getScanStatusText(scanTarget) || "\u00a0"}
+					</span>
 				</Button>
 			);
 		}
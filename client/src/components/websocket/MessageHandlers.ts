@@ -6,7 +6,14 @@ import { receiveSomeHosts, sendSomeHosts } from "./messagehandlers/SomeHosts";
 import { receiveSpecificHosts, sendSpecificHosts } from "./messagehandlers/SpecificHosts";
 import { receiveHistory, sendHistory } from "./messagehandlers/History";
 import { receiveDebugLog, sendDebugLog } from "./messagehandlers/DebugLog";
-import { receiveManualPingScan, sendManualPingScan } from "./messagehandlers/ManualPingScan";
+import {
+	receiveManualPingScan,
+	receiveManualPingScanQueue,
+	receiveManualPingScanProgress,
+	receiveManualPingScanResult,
+	receiveManualPingScanComplete,
+	sendManualPingScan
+} from "./messagehandlers/ManualPingScan";
 import { sendCreateSubnet } from "./messagehandlers/CreateSubnet";
 import { sendModifySubnet } from "./messagehandlers/ModifySubnet";
 import { sendDeleteSubnet } from "./messagehandlers/DeleteSubnet";
@@ -20,7 +27,11 @@ export const messageReceivers = {
 	receiveSpecificHosts,
 	receiveHistory,
 	receiveDebugLog,
-	receiveManualPingScan
+	receiveManualPingScan,
+	receiveManualPingScanQueue,
+	receiveManualPingScanProgress,
+	receiveManualPingScanResult,
+	receiveManualPingScanComplete
 };
 
 export const messageSenders = {
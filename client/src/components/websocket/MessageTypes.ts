@@ -1,5 +1,5 @@
 import { Subnet } from "../left/SubnetTree";
-import { HostData } from "../Right";
+import { HostData, PingResult } from "../Right";
 import { ScanEntry } from "./messagehandlers/ManualPingScan";
 
 export enum kind {
@@ -14,7 +14,17 @@ export enum kind {
 	ManualPingScan,
 	CreateSubnet,
 	ModifySubnet,
-	DeleteSubnet
+	DeleteSubnet,
+	Login,
+	Logout,
+	Subscribe,
+	Unsubscribe,
+	RevertAction,
+	HostDetail,
+	ManualPingScanQueue,
+	ManualPingScanProgress,
+	ManualPingScanResult,
+	ManualPingScanComplete
 }
 
 export enum serverErrorTypes {
@@ -23,7 +33,10 @@ export enum serverErrorTypes {
 	DoesNotExist,
 	AlreadyExists,
 	AuthenticationFailure,
-	UnknownFault
+	UnknownFault,
+	Conflict,
+	RateLimited,
+	ScanTooLarge
 }
 
 export interface SubnetRequest {
@@ -99,26 +112,67 @@ export interface inboundSomeHosts extends base {
 	hosts: HostData;
 }
 
+export interface HistoryEvent {
+	id: string;
+	time: string;
+	actor: string;
+	sourceIP: string;
+	action: string;
+	target: string;
+	before?: SubnetSkeleton;
+	after?: SubnetSkeleton;
+	legacy?: string;
+	revertOf?: string;
+	prevHash?: string;
+	hash?: string;
+	signature?: string;
+}
+
 export interface outboundHistory extends base {
 	messageType: kind.History;
 	sessionGUID: string;
+	cidr?: string;
+	includeDescendants?: boolean;
+	actor?: string;
+	action?: string;
+	since?: string;
+	until?: string;
+	text?: string;
+	offset?: number;
+	limit?: number;
 }
 
 export interface inboundHistory extends base {
 	messageType: kind.History;
 	sessionGUID: string;
 	history: string[];
+	events: HistoryEvent[];
+	total: number;
 }
 
 export interface outboundDebugLog extends base {
 	messageType: kind.DebugLog;
 	sessionGUID: string;
+	level?: string;
+	since?: string;
+	until?: string;
+	limit?: number;
+}
+
+export interface LogEntry {
+	time: string;
+	level: string;
+	msg: string;
+	requestID?: string;
+	remoteIP?: string;
+	actor?: string;
 }
 
 export interface inboundDebugLog extends base {
 	messageType: kind.DebugLog;
 	sessionGUID: string;
 	debugLog: string[];
+	entries: LogEntry[];
 }
 
 export interface outboundManualPingScan extends base {
@@ -134,6 +188,37 @@ export interface inboundManualPingScan extends base {
 	results: ScanEntry[];
 }
 
+export interface inboundManualPingScanQueue extends base {
+	messageType: kind.ManualPingScanQueue;
+	sessionGUID: string;
+	position: number;
+	queueLength: number;
+}
+
+export interface inboundManualPingScanProgress extends base {
+	messageType: kind.ManualPingScanProgress;
+	sessionGUID: string;
+	completed: number;
+	total: number;
+}
+
+export interface inboundManualPingScanResult extends base {
+	messageType: kind.ManualPingScanResult;
+	sessionGUID: string;
+	result: ScanEntry;
+	completed: number;
+	total: number;
+}
+
+export interface inboundManualPingScanComplete extends base {
+	messageType: kind.ManualPingScanComplete;
+	sessionGUID: string;
+	results: ScanEntry[];
+	completed: number;
+	total: number;
+	cancelled: boolean;
+}
+
 export interface outboundCreateSubnet extends base {
 	messageType: kind.CreateSubnet;
 	sessionGUID: string;
@@ -152,6 +237,113 @@ export interface outboundDeleteSubnet extends base {
 	subnetRequest: SubnetRequest;
 }
 
+export interface outboundLogin extends base {
+	messageType: kind.Login;
+	sessionGUID: string;
+	user: string;
+	pass: string;
+}
+
+export interface inboundLogin extends base {
+	messageType: kind.Login;
+	sessionGUID: string;
+	user: string;
+	groups: string[];
+	idleTimeout: number;
+}
+
+export interface outboundLogout extends base {
+	messageType: kind.Logout;
+	sessionGUID: string;
+}
+
+export interface inboundLogout extends base {
+	messageType: kind.Logout;
+	sessionGUID: string;
+}
+
+export interface SubnetSkeleton {
+	net: string;
+	desc: string;
+	details: string;
+	vlan: string;
+	mod: string;
+}
+
+export interface ServerEvent {
+	revision: number;
+	type: string;
+	time: string;
+	actor?: string;
+	subnet?: string;
+	old?: SubnetSkeleton;
+	new?: SubnetSkeleton;
+	history?: string;
+	address?: string;
+	reachable: boolean;
+}
+
+export interface outboundSubscribe extends base {
+	messageType: kind.Subscribe;
+	sessionGUID: string;
+	eventTypes: string[];
+}
+
+export interface inboundSubscribe extends base {
+	messageType: kind.Subscribe;
+	sessionGUID: string;
+	event: ServerEvent;
+}
+
+export interface outboundUnsubscribe extends base {
+	messageType: kind.Unsubscribe;
+	sessionGUID: string;
+}
+
+export interface outboundRevertAction extends base {
+	messageType: kind.RevertAction;
+	sessionGUID: string;
+	user: string;
+	pass: string;
+	eventID: string;
+}
+
+export interface inboundRevertAction extends base {
+	messageType: kind.RevertAction;
+	sessionGUID: string;
+	event: HistoryEvent;
+}
+
+export interface PingObservation extends PingResult {
+	time: string;
+}
+
+export interface HostDetailData {
+	address: string;
+	firstSeenAlive: string | null;
+	lastSeenAlive: string | null;
+	lastUpdate: string | null;
+	last: PingResult;
+	recent: PingObservation[];
+	probes: number;
+	replies: number;
+	uptime: number;
+	aRecord: string;
+	customData: string[][];
+}
+
+export interface outboundHostDetail extends base {
+	messageType: kind.HostDetail;
+	sessionGUID: string;
+	address: string;
+}
+
+export interface inboundHostDetail extends base {
+	messageType: kind.HostDetail;
+	sessionGUID: string;
+	host: HostDetailData;
+}
+
 export type AllKnownOutboundTypes =
 	| outboundPing
 	| outboundAllSubnets
@@ -162,4 +354,10 @@ export type AllKnownOutboundTypes =
 	| outboundManualPingScan
 	| outboundCreateSubnet
 	| outboundModifySubnet
-	| outboundDeleteSubnet;
+	| outboundDeleteSubnet
+	| outboundLogin
+	| outboundLogout
+	| outboundSubscribe
+	| outboundUnsubscribe
+	| outboundRevertAction
+	| outboundHostDetail;
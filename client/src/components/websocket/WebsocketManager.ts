@@ -4,6 +4,8 @@ import { messageReceivers, messageSenders } from "./MessageHandlers";
 import { isObject } from "util";
 import { getScanTargetPercentage, getUnscannedNetworks } from "./messagehandlers/ManualPingScan";
 
+const manualScanPatience = 30 * 1000;
+
 interface pendingRequest {
 	creationTime: number;
 	sentMessage: message.base;
@@ -64,6 +66,10 @@ export class WebsocketManager {
 
 	private createScannerTask = () => {
 		const interval = setInterval(() => {
+			// results are streamed back so wait for the previous scan to complete unless it was rejected
+			for (var pending of this.findSpecificPendingMessageType(message.kind.ManualPingScan)) {
+				if (Date.now() - pending.creationTime < manualScanPatience) return;
+			}
 			if (this.isConnected()) {
 				const scanTargets = this.mainTriggers.getScanTargets();
 				for (var scanTarget of scanTargets) {
@@ -112,6 +118,18 @@ export class WebsocketManager {
 				case message.kind.ManualPingScan:
 					messageReceivers.receiveManualPingScan(baseMsg, this);
 					break;
+				case message.kind.ManualPingScanQueue:
+					messageReceivers.receiveManualPingScanQueue(baseMsg, this);
+					break;
+				case message.kind.ManualPingScanProgress:
+					messageReceivers.receiveManualPingScanProgress(baseMsg, this);
+					break;
+				case message.kind.ManualPingScanResult:
+					messageReceivers.receiveManualPingScanResult(baseMsg, this);
+					break;
+				case message.kind.ManualPingScanComplete:
+					messageReceivers.receiveManualPingScanComplete(baseMsg, this);
+					break;
 				default:
 					console.error(`received an invalid messageType: '${baseMsg.messageType}'`);
 			}
@@ -5,11 +5,21 @@ import { WebsocketManager } from "../WebsocketManager";
 export interface ScanTarget {
 	target: string;
 	entries: ScanEntry[];
+	status?: ScanStatus;
+}
+
+export interface ScanStatus {
+	position: number; // zero once the scan is running
+	queueLength: number;
+	completed: number;
+	total: number;
 }
 
 export interface ScanEntry {
 	address: string;
+	status: "unknown" | "reachable" | "timeout" | "unreachable" | "error";
 	latency: number;
+	code?: number;
 	hostname: string;
 	timeSinceUpdate: number;
 	isConfirmed: boolean;
@@ -22,6 +32,48 @@ export function receiveManualPingScan(baseMsg: message.base, websocketManager: W
 	if (origReq !== undefined) {
 		const origMsg = origReq.sentMessage as message.outboundManualPingScan;
 		websocketManager.mainTriggers.updateScanTarget(origMsg.aggregate, msg.results);
+	}
+}
+
+export function receiveManualPingScanQueue(baseMsg: message.base, websocketManager: WebsocketManager) {
+	const msg = baseMsg as message.inboundManualPingScanQueue;
+	const origReq = websocketManager.findPendingMessage(msg.sessionGUID);
+	if (origReq !== undefined) {
+		const origMsg = origReq.sentMessage as message.outboundManualPingScan;
+		websocketManager.mainTriggers.updateScanStatus(origMsg.aggregate, {
+			position: msg.position,
+			queueLength: msg.queueLength
+		});
+	}
+}
+
+export function receiveManualPingScanProgress(baseMsg: message.base, websocketManager: WebsocketManager) {
+	const msg = baseMsg as message.inboundManualPingScanProgress;
+	const origReq = websocketManager.findPendingMessage(msg.sessionGUID);
+	if (origReq !== undefined) {
+		const origMsg = origReq.sentMessage as message.outboundManualPingScan;
+		websocketManager.mainTriggers.updateScanStatus(origMsg.aggregate, { completed: msg.completed, total: msg.total });
+	}
+}
+
+export function receiveManualPingScanResult(baseMsg: message.base, websocketManager: WebsocketManager) {
+	const msg = baseMsg as message.inboundManualPingScanResult;
+	const origReq = websocketManager.findPendingMessage(msg.sessionGUID);
+	if (origReq !== undefined) {
+		const origMsg = origReq.sentMessage as message.outboundManualPingScan;
+		websocketManager.mainTriggers.updateScanTarget(origMsg.aggregate, [msg.result]);
+		websocketManager.mainTriggers.updateScanStatus(origMsg.aggregate, { completed: msg.completed, total: msg.total });
+	}
+}
+
+export function receiveManualPingScanComplete(baseMsg: message.base, websocketManager: WebsocketManager) {
+	const msg = baseMsg as message.inboundManualPingScanComplete;
+	console.debug("receiveManualPingScanComplete:", msg);
+	const origReq = websocketManager.findPendingMessage(msg.sessionGUID);
+	if (origReq !== undefined) {
+		const origMsg = origReq.sentMessage as message.outboundManualPingScan;
+		websocketManager.mainTriggers.updateScanTarget(origMsg.aggregate, msg.results);
+		websocketManager.mainTriggers.updateScanStatus(origMsg.aggregate, null);
 		websocketManager.removePendingMessage(origReq.sentMessage.sessionGUID);
 	}
 }
@@ -65,7 +117,8 @@ export function createStaleEntries(net: string) {
 	while (i < 1e5 && netparser.networkContainsAddress(net, ip)) {
 		results.push({
 			address: ip,
-			latency: Number.MAX_SAFE_INTEGER,
+			status: "unknown",
+			latency: 0,
 			hostname: "",
 			timeSinceUpdate: Number.MAX_SAFE_INTEGER,
 			isConfirmed: false
@@ -90,6 +143,18 @@ export function getScanTargetPercentage(scanTarget: ScanTarget) {
 	return Math.max(0.1, Math.min(finishedEntries / desiredLength, 1));
 }
 
+export function getScanStatusText(scanTarget: ScanTarget) {
+	const status = scanTarget.status;
+	if (!status) return "";
+	if (status.position > 0) {
+		return `queued ${status.position} of ${status.queueLength}`;
+	}
+	if (status.total > 0) {
+		return `scanning ${status.completed} of ${status.total}`;
+	}
+	return "scanning";
+}
+
 export function getUnscannedNetworks(scanTarget: ScanTarget) {
 	const unscanned = [] as string[];
 	const threeMinutes = 1000 * 60 * 3;
@@ -1,14 +1,15 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
-	"strings"
+	"syscall"
 	"time"
 
 	"github.com/demskie/ipam/server"
@@ -20,13 +21,38 @@ const (
 )
 
 func main() {
-	// create a new server object
-	ipam := server.NewIPAMServer()
 	cwd, err := os.Getwd()
 	if err != nil {
 		log.Fatalf("unable to get current working directory > %v\n", err)
 	}
 
+	// persist subnets.csv and history.jsonl in the current working directory
+	store, err := server.NewFileStore(cwd)
+	if err != nil {
+		log.Fatalf("unable to create file store > %v\n", err)
+	}
+
+	// create a new server object which imports subnets.csv and history.jsonl (or a legacy history.txt)
+	ipam, err := server.NewIPAMServer(store)
+	if err != nil {
+		log.Fatalf("unable to create server > %v\n", err)
+	}
+
+	// record this program's own log lines alongside the server's
+	ipam.CaptureStandardLogger()
+
+	// keep periodic snapshots so subnets can be viewed as they were at any point in time
+	err = ipam.SetSnapshotDirectory(filepath.Join(cwd, "snapshots"))
+	if err != nil {
+		log.Fatalf("unable to load snapshots > %v\n", err)
+	}
+
+	// remember ping results across restarts
+	err = ipam.SetPingStateFile(filepath.Join(cwd, "pingstate.jsonl"), time.Minute)
+	if err != nil {
+		log.Printf("unable to restore ping results > %v\n", err)
+	}
+
 	// creating a custom http handler as an example
 	ipam.AttachCustomHandlerFunc("/healthcheck", func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
@@ -45,33 +71,24 @@ func main() {
 	// don't require authentication from users
 	ipam.SetAuthCallback(func(user, pass string) bool { return true })
 
-	// import the subnets.csv file
-	subnetsFilePath := filepath.Join(cwd, "subnets.csv")
-	subnetsBytes, err := ioutil.ReadFile(subnetsFilePath)
-	if err != nil {
-		log.Fatalf("unable to read subnets.csv > %v\n", err)
-	}
-	ipam.IngestSubnetCSVLines(strings.Split(string(subnetsBytes), "\n"))
-
-	// import the history.txt file
-	historyFilePath := filepath.Join(cwd, "history.txt")
-	historyBytes, err := ioutil.ReadFile(historyFilePath)
-	if err != nil {
-		log.Fatalf("unable to read history.txt > %v\n", err)
-	}
-	ipam.IngestUserHistory(strings.Split(string(historyBytes), "\n"))
-
 	// start pinging hosts in the background
 	go ipam.PingSweepSubnets(pingsPerSecond, goroutineCount)
 
-	// on every user action receive a new string slice that has all subnets and history so far
-	for mutatedData := range ipam.ServeAndReceiveChan("client/build/", "", "", false) {
-		// overwrite existing subnets.csv
-		subnetsBytes = []byte(strings.Join(mutatedData.Subnets, ""))
-		ioutil.WriteFile(subnetsFilePath, subnetsBytes, 0644)
+	// stop the web servers, drain the pinger and flush everything to disk on ctrl+c or SIGTERM
+	go func() {
+		sigs := make(chan os.Signal, 1)
+		signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+		<-sigs
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		err := ipam.Shutdown(ctx)
+		if err != nil {
+			log.Printf("unable to shutdown cleanly > %v\n", err)
+		}
+	}()
 
-		// overwrite existing history.txt
-		historyBytes = []byte(strings.Join(mutatedData.History, ""))
-		ioutil.WriteFile(historyFilePath, historyBytes, 0644)
+	// on every user action receive the data that was just persisted to the store until Shutdown closes the channel
+	for mutatedData := range ipam.ServeAndReceiveChan("client/build/", "", "", false) {
+		log.Printf("persisted %v subnets and %v history lines\n", len(mutatedData.Subnets), len(mutatedData.History))
 	}
 }
@@ -10,8 +10,4 @@ replace (
 require (
 	github.com/demskie/ipam/server v0.0.0-20190813214210-10b636a777f9
 	github.com/h2non/filetype v1.0.10 // indirect
-	golang.org/x/crypto v0.0.0-20190701094942-4def268fd1a4 // indirect
-	golang.org/x/sys v0.0.0-20190813064441-fde4db37ae7a // indirect
-	golang.org/x/text v0.3.2 // indirect
-	golang.org/x/tools v0.0.0-20190813214729-9dba7caff850 // indirect
 )
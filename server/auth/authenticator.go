@@ -0,0 +1,96 @@
+package auth
+
+import (
+	"errors"
+	"io"
+	"sync"
+
+	"github.com/demskie/ipam/server/logging"
+)
+
+var logger = logging.New(nil)
+
+// SetLogOutput redirects the lines logged when an authenticator fails
+func SetLogOutput(w io.Writer, flags int) {
+	logger.SetOutput(w, flags)
+}
+
+// ErrInvalidCredentials is returned when the username or password was not accepted
+var ErrInvalidCredentials = errors.New("invalid credentials")
+
+// Identity describes an authenticated user and the groups they belong to
+type Identity struct {
+	User   string
+	Groups []string
+}
+
+// InGroup returns true if the identity is a member of any of the provided groups
+func (id *Identity) InGroup(groups ...string) bool {
+	if id == nil {
+		return false
+	}
+	for _, want := range groups {
+		for _, have := range id.Groups {
+			if want == have {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Authenticator verifies a username and password and returns the resulting identity
+type Authenticator interface {
+	Authenticate(user, pass string) (*Identity, error)
+}
+
+// CallbackAuthenticator adapts the legacy func(user, pass string) bool callback
+type CallbackAuthenticator func(user, pass string) bool
+
+// Authenticate calls the wrapped callback and returns an identity without any groups
+func (cb CallbackAuthenticator) Authenticate(user, pass string) (*Identity, error) {
+	if cb == nil || !cb(user, pass) {
+		return nil, ErrInvalidCredentials
+	}
+	return &Identity{User: user, Groups: []string{}}, nil
+}
+
+// Chain tries each authenticator in order and returns the first successful identity
+type Chain struct {
+	mtx            *sync.RWMutex
+	authenticators []Authenticator
+	log            *logging.Logger
+}
+
+// NewChain returns a new Chain object
+func NewChain(authenticators ...Authenticator) *Chain {
+	return &Chain{
+		mtx:            &sync.RWMutex{},
+		authenticators: authenticators,
+		log:            logger,
+	}
+}
+
+// SetLogger replaces the logger that failing authenticators are reported to
+func (c *Chain) SetLogger(log *logging.Logger) {
+	c.mtx.Lock()
+	c.log = log
+	c.mtx.Unlock()
+}
+
+// Authenticate returns ErrInvalidCredentials if no authenticator accepted the credentials
+func (c *Chain) Authenticate(user, pass string) (*Identity, error) {
+	c.mtx.RLock()
+	log := c.log
+	c.mtx.RUnlock()
+	for i, a := range c.authenticators {
+		id, err := a.Authenticate(user, pass)
+		if err == nil {
+			return id, nil
+		}
+		if err != ErrInvalidCredentials {
+			log.Errorf("authenticator %v of %v failed for '%v' > %v\n", i+1, len(c.authenticators), user, err)
+		}
+	}
+	return nil, ErrInvalidCredentials
+}
@@ -0,0 +1,122 @@
+package auth
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// HtpasswdFile authenticates users against an apache style htpasswd file containing bcrypt hashes
+type HtpasswdFile struct {
+	mtx        *sync.RWMutex
+	passwdPath string
+	groupPath  string
+	hashes     map[string][]byte
+	groups     map[string][]string
+}
+
+// NewHtpasswdFile loads the htpasswd file and an optional htgroup file ("group: user1 user2")
+func NewHtpasswdFile(passwdPath, groupPath string) (*HtpasswdFile, error) {
+	h := &HtpasswdFile{
+		mtx:        &sync.RWMutex{},
+		passwdPath: passwdPath,
+		groupPath:  groupPath,
+		hashes:     make(map[string][]byte, 0),
+		groups:     make(map[string][]string, 0),
+	}
+	return h, h.Reload()
+}
+
+// Reload rereads the htpasswd and htgroup files from disk
+func (h *HtpasswdFile) Reload() error {
+	f, err := os.Open(h.passwdPath)
+	if err != nil {
+		return fmt.Errorf("unable to open htpasswd file > %v", err)
+	}
+	defer f.Close()
+	hashes, err := parseHtpasswd(f)
+	if err != nil {
+		return err
+	}
+	groups := make(map[string][]string, 0)
+	if h.groupPath != "" {
+		g, err := os.Open(h.groupPath)
+		if err != nil {
+			return fmt.Errorf("unable to open htgroup file > %v", err)
+		}
+		defer g.Close()
+		groups, err = parseHtgroup(g)
+		if err != nil {
+			return err
+		}
+	}
+	h.mtx.Lock()
+	h.hashes = hashes
+	h.groups = groups
+	h.mtx.Unlock()
+	return nil
+}
+
+func parseHtpasswd(r io.Reader) (map[string][]byte, error) {
+	hashes := make(map[string][]byte, 0)
+	scanner := bufio.NewScanner(r)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		s := strings.SplitN(line, ":", 2)
+		if len(s) != 2 {
+			return nil, fmt.Errorf("htpasswd line %v is not formatted correctly", lineNum)
+		}
+		if !strings.HasPrefix(s[1], "$2") {
+			return nil, fmt.Errorf("htpasswd line %v does not contain a bcrypt hash", lineNum)
+		}
+		hashes[s[0]] = []byte(s[1])
+	}
+	return hashes, scanner.Err()
+}
+
+func parseHtgroup(r io.Reader) (map[string][]string, error) {
+	groups := make(map[string][]string, 0)
+	scanner := bufio.NewScanner(r)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		s := strings.SplitN(line, ":", 2)
+		if len(s) != 2 {
+			return nil, fmt.Errorf("htgroup line %v is not formatted correctly", lineNum)
+		}
+		group := strings.TrimSpace(s[0])
+		for _, user := range strings.Fields(s[1]) {
+			groups[user] = append(groups[user], group)
+		}
+	}
+	return groups, scanner.Err()
+}
+
+// Authenticate compares the password against the stored bcrypt hash
+func (h *HtpasswdFile) Authenticate(user, pass string) (*Identity, error) {
+	h.mtx.RLock()
+	hash, exists := h.hashes[user]
+	groups := append([]string{}, h.groups[user]...)
+	h.mtx.RUnlock()
+	if !exists {
+		return nil, ErrInvalidCredentials
+	}
+	if bcrypt.CompareHashAndPassword(hash, []byte(pass)) != nil {
+		return nil, ErrInvalidCredentials
+	}
+	return &Identity{User: user, Groups: groups}, nil
+}
@@ -0,0 +1,106 @@
+package auth
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// LDAPConfig describes how to bind as a user and where to find their groups
+type LDAPConfig struct {
+	URL            string // ldap://host:389 or ldaps://host:636
+	StartTLS       bool
+	TLSConfig      *tls.Config
+	UserDNTemplate string // uid=%s,ou=people,dc=example,dc=com
+	GroupBaseDN    string // ou=groups,dc=example,dc=com
+	GroupFilter    string // (&(objectClass=groupOfNames)(member=%s))
+	GroupAttribute string // cn
+	Timeout        time.Duration
+}
+
+// LDAPAuthenticator authenticates users by performing a simple bind against an LDAP server
+type LDAPAuthenticator struct {
+	cfg LDAPConfig
+}
+
+// NewLDAPAuthenticator returns a new LDAPAuthenticator object
+func NewLDAPAuthenticator(cfg LDAPConfig) (*LDAPAuthenticator, error) {
+	u, err := url.Parse(cfg.URL)
+	if err != nil || u.Hostname() == "" {
+		return nil, fmt.Errorf("'%v' is not a valid LDAP URL", cfg.URL)
+	}
+	if cfg.StartTLS && cfg.TLSConfig == nil {
+		cfg.TLSConfig = &tls.Config{ServerName: u.Hostname()}
+	}
+	if strings.Count(cfg.UserDNTemplate, "%s") != 1 {
+		return nil, fmt.Errorf("UserDNTemplate must contain exactly one '%%s'")
+	}
+	if cfg.GroupBaseDN != "" && strings.Count(cfg.GroupFilter, "%s") != 1 {
+		return nil, fmt.Errorf("GroupFilter must contain exactly one '%%s'")
+	}
+	if cfg.GroupAttribute == "" {
+		cfg.GroupAttribute = "cn"
+	}
+	if cfg.Timeout == 0 {
+		cfg.Timeout = 10 * time.Second
+	}
+	return &LDAPAuthenticator{cfg: cfg}, nil
+}
+
+// Authenticate binds as the user and then searches for their group memberships
+func (l *LDAPAuthenticator) Authenticate(user, pass string) (*Identity, error) {
+	// an empty password would result in an unauthenticated bind which always succeeds
+	if user == "" || pass == "" {
+		return nil, ErrInvalidCredentials
+	}
+	conn, err := ldap.DialURL(l.cfg.URL,
+		ldap.DialWithDialer(&net.Dialer{Timeout: l.cfg.Timeout}),
+		ldap.DialWithTLSConfig(l.cfg.TLSConfig),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to LDAP server > %v", err)
+	}
+	defer conn.Close()
+	conn.SetTimeout(l.cfg.Timeout)
+	if l.cfg.StartTLS {
+		err = conn.StartTLS(l.cfg.TLSConfig)
+		if err != nil {
+			return nil, fmt.Errorf("unable to negotiate StartTLS > %v", err)
+		}
+	}
+	userDN := fmt.Sprintf(l.cfg.UserDNTemplate, ldap.EscapeDN(user))
+	err = conn.Bind(userDN, pass)
+	if err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return nil, ErrInvalidCredentials
+		}
+		return nil, fmt.Errorf("unable to bind as '%v' > %v", userDN, err)
+	}
+	id := &Identity{User: user, Groups: []string{}}
+	if l.cfg.GroupBaseDN == "" {
+		return id, nil
+	}
+	req := ldap.NewSearchRequest(
+		l.cfg.GroupBaseDN,
+		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, int(l.cfg.Timeout/time.Second), false,
+		fmt.Sprintf(l.cfg.GroupFilter, ldap.EscapeFilter(userDN)),
+		[]string{l.cfg.GroupAttribute},
+		nil,
+	)
+	res, err := conn.Search(req)
+	if err != nil {
+		return nil, fmt.Errorf("unable to search groups for '%v' > %v", userDN, err)
+	}
+	for _, entry := range res.Entries {
+		group := entry.GetAttributeValue(l.cfg.GroupAttribute)
+		if group != "" {
+			id.Groups = append(id.Groups, group)
+		}
+	}
+	return id, nil
+}
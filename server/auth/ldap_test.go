@@ -0,0 +1,230 @@
+package auth
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+)
+
+// ldapStub answers simple binds and group searches well enough for LDAPAuthenticator
+type ldapStub struct {
+	listener net.Listener
+	mtx      *sync.Mutex
+	users    map[string]string   // dn => password
+	groups   map[string][]string // cn => member dns
+	binds    []string
+	filters  []string
+}
+
+func newLDAPStub(t *testing.T) *ldapStub {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stub := &ldapStub{
+		listener: listener,
+		mtx:      &sync.Mutex{},
+		users: map[string]string{
+			"uid=alice,ou=people,dc=example,dc=com":  "secret",
+			"uid=b\\,ob,ou=people,dc=example,dc=com": "hunter2",
+		},
+		groups: map[string][]string{
+			"netops":  {"uid=alice,ou=people,dc=example,dc=com"},
+			"readers": {"uid=alice,ou=people,dc=example,dc=com", "uid=b\\,ob,ou=people,dc=example,dc=com"},
+		},
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go stub.serve(conn)
+		}
+	}()
+	t.Cleanup(func() { listener.Close() })
+	return stub
+}
+
+func (s *ldapStub) url() string {
+	return "ldap://" + s.listener.Addr().String()
+}
+
+func (s *ldapStub) serve(conn net.Conn) {
+	defer conn.Close()
+	for {
+		packet, err := ber.ReadPacket(conn)
+		if err != nil || len(packet.Children) < 2 {
+			return
+		}
+		id := packet.Children[0].Value.(int64)
+		op := packet.Children[1]
+		switch op.Tag {
+		case ldap.ApplicationBindRequest:
+			dn := op.Children[1].Value.(string)
+			pass := op.Children[2].Data.String()
+			s.mtx.Lock()
+			s.binds = append(s.binds, dn)
+			expected, exists := s.users[dn]
+			s.mtx.Unlock()
+			code := ldap.LDAPResultSuccess
+			if !exists || expected != pass {
+				code = ldap.LDAPResultInvalidCredentials
+			}
+			conn.Write(ldapResponse(id, ldap.ApplicationBindResponse, code).Bytes())
+		case ldap.ApplicationSearchRequest:
+			filter, err := ldap.DecompileFilter(op.Children[6])
+			if err != nil {
+				return
+			}
+			s.mtx.Lock()
+			s.filters = append(s.filters, filter)
+			for cn, members := range s.groups {
+				for _, member := range members {
+					if strings.Contains(filter, "(member="+ldap.EscapeFilter(member)+")") {
+						conn.Write(ldapGroupEntry(id, cn).Bytes())
+					}
+				}
+			}
+			s.mtx.Unlock()
+			conn.Write(ldapResponse(id, ldap.ApplicationSearchResultDone, ldap.LDAPResultSuccess).Bytes())
+		default:
+			return
+		}
+	}
+}
+
+func ldapMessage(id int64, op *ber.Packet) *ber.Packet {
+	msg := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Response")
+	msg.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, id, "MessageID"))
+	msg.AppendChild(op)
+	return msg
+}
+
+func ldapResponse(id int64, tag ber.Tag, code int) *ber.Packet {
+	op := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "Response")
+	op.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, int64(code), "resultCode"))
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "matchedDN"))
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "diagnosticMessage"))
+	return ldapMessage(id, op)
+}
+
+func ldapGroupEntry(id int64, cn string) *ber.Packet {
+	op := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationSearchResultEntry, nil, "Search Result Entry")
+	dn := fmt.Sprintf("cn=%v,ou=groups,dc=example,dc=com", cn)
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, dn, "objectName"))
+	attributes := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "attributes")
+	attribute := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "attribute")
+	attribute.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "cn", "type"))
+	values := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "vals")
+	values.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, cn, "value"))
+	attribute.AppendChild(values)
+	attributes.AppendChild(attribute)
+	op.AppendChild(attributes)
+	return ldapMessage(id, op)
+}
+
+func newTestLDAPAuthenticator(t *testing.T, stub *ldapStub, groups bool) *LDAPAuthenticator {
+	cfg := LDAPConfig{
+		URL:            stub.url(),
+		UserDNTemplate: "uid=%s,ou=people,dc=example,dc=com",
+	}
+	if groups {
+		cfg.GroupBaseDN = "ou=groups,dc=example,dc=com"
+		cfg.GroupFilter = "(&(objectClass=groupOfNames)(member=%s))"
+	}
+	l, err := NewLDAPAuthenticator(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l
+}
+
+func TestLDAPAuthenticateWithGroups(t *testing.T) {
+	stub := newLDAPStub(t)
+	id, err := newTestLDAPAuthenticator(t, stub, true).Authenticate("alice", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id.User != "alice" || len(id.Groups) != 2 || !id.InGroup("netops") || !id.InGroup("readers") {
+		t.Fatalf("expected alice to be in netops and readers but got %+v", id)
+	}
+}
+
+func TestLDAPAuthenticateWithoutGroups(t *testing.T) {
+	stub := newLDAPStub(t)
+	id, err := newTestLDAPAuthenticator(t, stub, false).Authenticate("alice", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(id.Groups) != 0 || len(stub.filters) != 0 {
+		t.Fatalf("expected no group search without a GroupBaseDN but got %+v", id)
+	}
+}
+
+func TestLDAPRejectsInvalidCredentials(t *testing.T) {
+	stub := newLDAPStub(t)
+	l := newTestLDAPAuthenticator(t, stub, true)
+	for _, creds := range [][2]string{{"alice", "wrong"}, {"mallory", "secret"}, {"alice", ""}, {"", "secret"}} {
+		_, err := l.Authenticate(creds[0], creds[1])
+		if err != ErrInvalidCredentials {
+			t.Fatalf("expected ErrInvalidCredentials for %v but got %v", creds, err)
+		}
+	}
+	if len(stub.binds) != 2 {
+		t.Fatalf("expected empty credentials to never be sent but saw binds %v", stub.binds)
+	}
+}
+
+func TestLDAPEscapesUserInput(t *testing.T) {
+	stub := newLDAPStub(t)
+	l := newTestLDAPAuthenticator(t, stub, true)
+	_, err := l.Authenticate("alice,ou=people,dc=example,dc=com", "secret")
+	if err != ErrInvalidCredentials {
+		t.Fatalf("expected a DN injection to be rejected but got %v", err)
+	}
+	id, err := l.Authenticate("b,ob", "hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(id.Groups) != 1 || id.Groups[0] != "readers" {
+		t.Fatalf("expected b,ob to only be in readers but got %+v", id)
+	}
+	filter := stub.filters[len(stub.filters)-1]
+	if !strings.Contains(filter, `uid=b\5c,ob`) {
+		t.Fatalf("expected the user DN to be escaped in the group filter but got '%v'", filter)
+	}
+}
+
+func TestLDAPConnectionFailure(t *testing.T) {
+	stub := newLDAPStub(t)
+	l := newTestLDAPAuthenticator(t, stub, false)
+	stub.listener.Close()
+	_, err := l.Authenticate("alice", "secret")
+	if err == nil || err == ErrInvalidCredentials {
+		t.Fatalf("expected a connection error but got %v", err)
+	}
+	_, err = NewChain(l, CallbackAuthenticator(func(user, pass string) bool { return pass == "secret" })).Authenticate("alice", "secret")
+	if err != nil {
+		t.Fatalf("expected the chain to fall through to the next authenticator but got %v", err)
+	}
+}
+
+func TestNewLDAPAuthenticatorValidatesConfig(t *testing.T) {
+	configs := []LDAPConfig{
+		{URL: "not a url", UserDNTemplate: "uid=%s"},
+		{URL: "ldap://localhost", UserDNTemplate: "uid=alice"},
+		{URL: "ldap://localhost", UserDNTemplate: "uid=%s", GroupBaseDN: "ou=groups", GroupFilter: "(member=*)"},
+	}
+	for _, cfg := range configs {
+		_, err := NewLDAPAuthenticator(cfg)
+		if err == nil {
+			t.Fatalf("expected %+v to be rejected", cfg)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// Session is an authenticated identity that remains valid until it has been idle for too long
+type Session struct {
+	ID       string // generated by the server and never revealed to the client
+	Identity *Identity
+	RemoteIP string
+	Created  time.Time
+	LastSeen time.Time
+}
+
+// SessionStore keeps track of server side sessions and expires them after an idle timeout
+type SessionStore struct {
+	mtx         *sync.Mutex
+	idleTimeout time.Duration
+	sessions    map[string]*Session
+}
+
+// NewSessionStore returns a new SessionStore object
+func NewSessionStore(idleTimeout time.Duration) *SessionStore {
+	return &SessionStore{
+		mtx:         &sync.Mutex{},
+		idleTimeout: idleTimeout,
+		sessions:    make(map[string]*Session, 0),
+	}
+}
+
+// SetIdleTimeout changes how long a session may go unused before it expires
+func (s *SessionStore) SetIdleTimeout(idleTimeout time.Duration) {
+	s.mtx.Lock()
+	s.idleTimeout = idleTimeout
+	s.mtx.Unlock()
+}
+
+// GetIdleTimeout returns how long a session may go unused before it expires
+func (s *SessionStore) GetIdleTimeout() time.Duration {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return s.idleTimeout
+}
+
+// Create starts a new session for the identity under a random ID and returns a copy of it
+func (s *SessionStore) Create(id *Identity, remoteIP string) Session {
+	now := time.Now()
+	sess := &Session{
+		Identity: id,
+		RemoteIP: remoteIP,
+		Created:  now,
+		LastSeen: now,
+	}
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	for sess.ID == "" || s.sessions[sess.ID] != nil {
+		b := make([]byte, 16)
+		rand.Read(b)
+		sess.ID = hex.EncodeToString(b)
+	}
+	s.sessions[sess.ID] = sess
+	return *sess
+}
+
+// Touch returns the session if it has not expired and resets its idle timer
+func (s *SessionStore) Touch(sessionID string) (Session, bool) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	sess, exists := s.sessions[sessionID]
+	if !exists {
+		return Session{}, false
+	}
+	if time.Since(sess.LastSeen) > s.idleTimeout {
+		delete(s.sessions, sessionID)
+		return Session{}, false
+	}
+	sess.LastSeen = time.Now()
+	return *sess, true
+}
+
+// Delete ends the session
+func (s *SessionStore) Delete(sessionID string) {
+	s.mtx.Lock()
+	delete(s.sessions, sessionID)
+	s.mtx.Unlock()
+}
+
+// Sweep ends every session that has been idle for too long and returns how many there were
+func (s *SessionStore) Sweep() int {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	expired := 0
+	for sessionID, sess := range s.sessions {
+		if time.Since(sess.LastSeen) > s.idleTimeout {
+			delete(s.sessions, sessionID)
+			expired++
+		}
+	}
+	return expired
+}
+
+// Len returns the number of sessions that have not been swept yet
+func (s *SessionStore) Len() int {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return len(s.sessions)
+}
@@ -0,0 +1,60 @@
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSessionIDsAreGeneratedByTheStore(t *testing.T) {
+	s := NewSessionStore(time.Hour)
+	seen := map[string]bool{}
+	for i := 0; i < 100; i++ {
+		sess := s.Create(&Identity{User: "alice"}, "192.0.2.1")
+		if len(sess.ID) != 32 || seen[sess.ID] {
+			t.Fatalf("expected a new random session ID but got '%v'", sess.ID)
+		}
+		seen[sess.ID] = true
+	}
+	if s.Len() != 100 {
+		t.Fatalf("expected 100 sessions but got %v", s.Len())
+	}
+}
+
+func TestSessionLifetime(t *testing.T) {
+	s := NewSessionStore(time.Hour)
+	alice := s.Create(&Identity{User: "alice"}, "192.0.2.1")
+	if sess, valid := s.Touch(alice.ID); !valid || sess.Identity.User != "alice" || sess.RemoteIP != "192.0.2.1" {
+		t.Fatalf("expected the session to be valid but got %+v", sess)
+	}
+	if _, valid := s.Touch("0123456789abcdef0123456789abcdef"); valid {
+		t.Fatal("expected an unknown session to be invalid")
+	}
+	s.Delete(alice.ID)
+	if _, valid := s.Touch(alice.ID); valid || s.Len() != 0 {
+		t.Fatal("expected the session to end once it was deleted")
+	}
+}
+
+func TestIdleSessionsExpire(t *testing.T) {
+	s := NewSessionStore(time.Hour)
+	idle := s.Create(&Identity{User: "alice"}, "192.0.2.1")
+	touched := s.Create(&Identity{User: "bob"}, "192.0.2.2")
+	time.Sleep(100 * time.Millisecond)
+	if _, valid := s.Touch(touched.ID); !valid {
+		t.Fatal("expected the session to be valid before the timeout was lowered")
+	}
+	s.SetIdleTimeout(50 * time.Millisecond)
+	if expired := s.Sweep(); expired != 1 || s.Len() != 1 {
+		t.Fatalf("expected only the idle session to be swept but %v were and %v remain", expired, s.Len())
+	}
+	if _, valid := s.Touch(idle.ID); valid {
+		t.Fatal("expected the swept session to be invalid")
+	}
+	if _, valid := s.Touch(touched.ID); !valid {
+		t.Fatal("expected the recently used session to remain valid")
+	}
+	time.Sleep(100 * time.Millisecond)
+	if _, valid := s.Touch(touched.ID); valid || s.Len() != 0 {
+		t.Fatal("expected touching an expired session to end it")
+	}
+}
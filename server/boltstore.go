@@ -0,0 +1,111 @@
+package server
+
+import (
+	"fmt"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	boltBucket      = []byte("ipam")
+	boltSubnetsKey  = []byte("subnets")
+	boltHistoryKey  = []byte("history")
+	boltCommitKey   = []byte("commitMsg")
+	boltWebhooksKey = []byte("webhooks")
+	boltOpenTimeout = 5 * time.Second
+
+	// databases written before history became JSONL stored data under the file names
+	boltLegacyKeys = map[string][]byte{
+		string(boltSubnetsKey): []byte(subnetsFileName),
+		string(boltHistoryKey): []byte(legacyHistoryFileName),
+	}
+)
+
+// BoltStore persists all data inside of an embedded bbolt database
+type BoltStore struct {
+	db *bolt.DB
+}
+
+// NewBoltStore opens or creates the database file
+func NewBoltStore(path string) (*BoltStore, error) {
+	db, err := bolt.Open(path, 0644, &bolt.Options{Timeout: boltOpenTimeout})
+	if err != nil {
+		return nil, fmt.Errorf("unable to open bolt database > %v", err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(boltBucket)
+		if err != nil {
+			return err
+		}
+		return migrateBoltKeys(b)
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &BoltStore{db: db}, nil
+}
+
+// migrateBoltKeys moves data stored under a legacy key unless the current key has already been written
+func migrateBoltKeys(b *bolt.Bucket) error {
+	for key, legacyKey := range boltLegacyKeys {
+		legacy := b.Get(legacyKey)
+		if legacy == nil {
+			continue
+		}
+		if b.Get([]byte(key)) == nil {
+			err := b.Put([]byte(key), append([]byte{}, legacy...))
+			if err != nil {
+				return err
+			}
+		}
+		err := b.Delete(legacyKey)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Load reads the most recently saved data
+func (bs *BoltStore) Load() (*MutatedData, error) {
+	data := &MutatedData{}
+	err := bs.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(boltBucket)
+		data.CommitMsg = string(b.Get(boltCommitKey))
+		data.Subnets = splitLines(string(b.Get(boltSubnetsKey)))
+		data.History = splitLines(string(b.Get(boltHistoryKey)))
+		data.Webhooks = splitLines(string(b.Get(boltWebhooksKey)))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// Save replaces the stored data within a single transaction
+func (bs *BoltStore) Save(data MutatedData) error {
+	return bs.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(boltBucket)
+		err := b.Put(boltSubnetsKey, []byte(joinLines(data.Subnets)))
+		if err != nil {
+			return err
+		}
+		err = b.Put(boltHistoryKey, []byte(joinLines(data.History)))
+		if err != nil {
+			return err
+		}
+		err = b.Put(boltWebhooksKey, []byte(joinLines(data.Webhooks)))
+		if err != nil {
+			return err
+		}
+		return b.Put(boltCommitKey, []byte(data.CommitMsg))
+	})
+}
+
+// Close releases the database file
+func (bs *BoltStore) Close() error {
+	return bs.db.Close()
+}
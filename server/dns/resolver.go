@@ -0,0 +1,208 @@
+package dns
+
+import (
+	"container/list"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	miekgdns "github.com/miekg/dns"
+)
+
+// Resolver performs PTR lookups and caches the answers
+type Resolver struct {
+	mtx         *sync.RWMutex
+	servers     []string
+	client      *miekgdns.Client
+	capacity    int
+	entries     map[string]*list.Element
+	order       *list.List
+	minTTL      time.Duration
+	maxTTL      time.Duration
+	negativeTTL time.Duration
+	failureTTL  time.Duration
+}
+
+type cacheEntry struct {
+	address  string
+	hostname string
+	expires  time.Time
+}
+
+// NewResolver returns a resolver with no servers that remembers at most capacity addresses
+func NewResolver(capacity int) *Resolver {
+	if capacity < 1 {
+		capacity = 1
+	}
+	return &Resolver{
+		mtx:         &sync.RWMutex{},
+		servers:     []string{},
+		client:      &miekgdns.Client{Net: "udp", Timeout: 2 * time.Second},
+		capacity:    capacity,
+		entries:     make(map[string]*list.Element, 0),
+		order:       list.New(),
+		minTTL:      time.Minute,
+		maxTTL:      24 * time.Hour,
+		negativeTTL: 15 * time.Minute,
+		failureTTL:  5 * time.Minute,
+	}
+}
+
+// SetServers replaces the DNS servers that are queried in order and clears the cache
+func (r *Resolver) SetServers(servers ...string) error {
+	normalized := make([]string, 0, len(servers))
+	for _, server := range servers {
+		host, port, err := net.SplitHostPort(server)
+		if err != nil {
+			host, port = strings.Trim(server, "[]"), "53"
+		}
+		if net.ParseIP(host) == nil {
+			return fmt.Errorf("'%v' is not a valid DNS server address", server)
+		}
+		normalized = append(normalized, net.JoinHostPort(host, port))
+	}
+	r.mtx.Lock()
+	r.servers = normalized
+	r.entries = make(map[string]*list.Element, 0)
+	r.order.Init()
+	r.mtx.Unlock()
+	return nil
+}
+
+// GetServers returns the DNS servers that are queried
+func (r *Resolver) GetServers() []string {
+	r.mtx.RLock()
+	defer r.mtx.RUnlock()
+	return append([]string{}, r.servers...)
+}
+
+// Enabled reports whether any DNS servers have been configured
+func (r *Resolver) Enabled() bool {
+	r.mtx.RLock()
+	defer r.mtx.RUnlock()
+	return len(r.servers) > 0
+}
+
+// SetTimeout limits how long each server is given to answer
+func (r *Resolver) SetTimeout(timeout time.Duration) {
+	r.mtx.Lock()
+	r.client = &miekgdns.Client{Net: "udp", Timeout: timeout}
+	r.mtx.Unlock()
+}
+
+// SetTTLs bounds how long answers are cached and how long an address without a PTR record or
+// whose lookup failed on every server is left alone
+func (r *Resolver) SetTTLs(minTTL, maxTTL, negativeTTL, failureTTL time.Duration) {
+	r.mtx.Lock()
+	r.minTTL, r.maxTTL, r.negativeTTL, r.failureTTL = minTTL, maxTTL, negativeTTL, failureTTL
+	r.mtx.Unlock()
+}
+
+// Due reports whether address has never been resolved or its cached answer has expired
+func (r *Resolver) Due(address string) bool {
+	r.mtx.RLock()
+	defer r.mtx.RUnlock()
+	elem, exists := r.entries[address]
+	return !exists || time.Now().After(elem.Value.(*cacheEntry).expires)
+}
+
+// GetCachedHostnames returns the cached PTR name for each address without querying any servers
+func (r *Resolver) GetCachedHostnames(addresses []string) []string {
+	results := make([]string, len(addresses))
+	now := time.Now()
+	r.mtx.RLock()
+	for i := 0; i < len(addresses); i++ {
+		elem, exists := r.entries[addresses[i]]
+		if exists && now.Before(elem.Value.(*cacheEntry).expires) {
+			results[i] = elem.Value.(*cacheEntry).hostname
+		}
+	}
+	r.mtx.RUnlock()
+	return results
+}
+
+// Lookup queries each server in turn for the PTR record of address and caches the answer
+func (r *Resolver) Lookup(address string) (string, error) {
+	arpa, err := miekgdns.ReverseAddr(address)
+	if err != nil {
+		return "", fmt.Errorf("'%v' is not a valid address", address)
+	}
+	r.mtx.RLock()
+	servers := r.servers
+	client := r.client
+	r.mtx.RUnlock()
+	if len(servers) == 0 {
+		return "", fmt.Errorf("no DNS servers have been configured")
+	}
+	msg := &miekgdns.Msg{}
+	msg.SetQuestion(arpa, miekgdns.TypePTR)
+	for _, server := range servers {
+		in, _, exchangeErr := client.Exchange(msg, server)
+		if exchangeErr != nil {
+			err = fmt.Errorf("unable to query %v > %v", server, exchangeErr)
+			continue
+		}
+		switch in.Rcode {
+		case miekgdns.RcodeSuccess:
+			for _, rr := range in.Answer {
+				if ptr, ok := rr.(*miekgdns.PTR); ok {
+					hostname := strings.TrimSuffix(ptr.Ptr, ".")
+					r.store(address, hostname, time.Duration(ptr.Hdr.Ttl)*time.Second)
+					return hostname, nil
+				}
+			}
+			r.store(address, "", 0)
+			return "", nil
+		case miekgdns.RcodeNameError:
+			r.store(address, "", 0)
+			return "", nil
+		default:
+			err = fmt.Errorf("%v answered %v", server, miekgdns.RcodeToString[in.Rcode])
+		}
+	}
+	r.storeFailure(address)
+	return "", err
+}
+
+// store remembers the answer and evicts the least recently resolved address when the cache is full
+func (r *Resolver) store(address, hostname string, ttl time.Duration) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	if hostname == "" {
+		ttl = r.negativeTTL
+	} else if ttl < r.minTTL {
+		ttl = r.minTTL
+	} else if ttl > r.maxTTL {
+		ttl = r.maxTTL
+	}
+	r.insert(&cacheEntry{address: address, hostname: hostname, expires: time.Now().Add(ttl)})
+}
+
+// storeFailure keeps serving any previous answer so that unreachable servers are not queried every cycle
+func (r *Resolver) storeFailure(address string) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	hostname := ""
+	if elem, exists := r.entries[address]; exists {
+		hostname = elem.Value.(*cacheEntry).hostname
+	}
+	r.insert(&cacheEntry{address: address, hostname: hostname, expires: time.Now().Add(r.failureTTL)})
+}
+
+// insert must be called while holding the lock
+func (r *Resolver) insert(entry *cacheEntry) {
+	address := entry.address
+	if elem, exists := r.entries[address]; exists {
+		elem.Value = entry
+		r.order.MoveToFront(elem)
+		return
+	}
+	r.entries[address] = r.order.PushFront(entry)
+	for r.order.Len() > r.capacity {
+		oldest := r.order.Back()
+		r.order.Remove(oldest)
+		delete(r.entries, oldest.Value.(*cacheEntry).address)
+	}
+}
@@ -0,0 +1,218 @@
+package dns
+
+import (
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	miekgdns "github.com/miekg/dns"
+)
+
+// newDNSServer answers PTR queries from records or with rcode if it is not RcodeSuccess
+func newDNSServer(t *testing.T, rcode int, records map[string]string) (string, func() int) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mtx := &sync.Mutex{}
+	queries := 0
+	started := make(chan struct{})
+	srv := &miekgdns.Server{
+		PacketConn:        conn,
+		NotifyStartedFunc: func() { close(started) },
+		Handler: miekgdns.HandlerFunc(func(w miekgdns.ResponseWriter, req *miekgdns.Msg) {
+			mtx.Lock()
+			queries++
+			mtx.Unlock()
+			resp := &miekgdns.Msg{}
+			resp.SetReply(req)
+			name := req.Question[0].Name
+			hostname, exists := records[name]
+			switch {
+			case rcode != miekgdns.RcodeSuccess:
+				resp.Rcode = rcode
+			case !exists:
+				resp.Rcode = miekgdns.RcodeNameError
+			case hostname != "":
+				resp.Answer = append(resp.Answer, &miekgdns.PTR{
+					Hdr: miekgdns.RR_Header{Name: name, Rrtype: miekgdns.TypePTR, Class: miekgdns.ClassINET, Ttl: 1},
+					Ptr: hostname,
+				})
+			}
+			w.WriteMsg(resp)
+		}),
+	}
+	go srv.ActivateAndServe()
+	<-started
+	t.Cleanup(func() { srv.Shutdown() })
+	return conn.LocalAddr().String(), func() int {
+		mtx.Lock()
+		defer mtx.Unlock()
+		return queries
+	}
+}
+
+var testRecords = map[string]string{
+	"1.0.0.10.in-addr.arpa.": "host-one.example.com.",
+	"2.0.0.10.in-addr.arpa.": "host-two.example.com.",
+	"3.0.0.10.in-addr.arpa.": "host-three.example.com.",
+	"4.0.0.10.in-addr.arpa.": "",
+	"1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa.": "v6.example.com.",
+}
+
+func newTestResolver(t *testing.T, capacity int, servers ...string) *Resolver {
+	r := NewResolver(capacity)
+	r.SetTimeout(250 * time.Millisecond)
+	err := r.SetServers(servers...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func TestLookupCachesAnswers(t *testing.T) {
+	server, queries := newDNSServer(t, miekgdns.RcodeSuccess, testRecords)
+	r := newTestResolver(t, 16, server)
+	for _, address := range []string{"10.0.0.1", "2001:db8::1"} {
+		if !r.Due(address) {
+			t.Fatalf("expected %v to be due before it has been resolved", address)
+		}
+	}
+	hostname, err := r.Lookup("10.0.0.1")
+	if err != nil || hostname != "host-one.example.com" {
+		t.Fatalf("expected host-one.example.com but got '%v' (%v)", hostname, err)
+	}
+	hostname, err = r.Lookup("2001:db8::1")
+	if err != nil || hostname != "v6.example.com" {
+		t.Fatalf("expected v6.example.com but got '%v' (%v)", hostname, err)
+	}
+	if r.Due("10.0.0.1") {
+		t.Fatal("expected the one second TTL to be raised to the minimum")
+	}
+	hostnames := r.GetCachedHostnames([]string{"10.0.0.1", "10.0.0.2", "2001:db8::1"})
+	if hostnames[0] != "host-one.example.com" || hostnames[1] != "" || hostnames[2] != "v6.example.com" {
+		t.Fatalf("unexpected cached hostnames %v", hostnames)
+	}
+	if queries() != 2 {
+		t.Fatalf("expected reading the cache to not query the server but it saw %v queries", queries())
+	}
+}
+
+func TestLookupCachesMissingRecords(t *testing.T) {
+	server, _ := newDNSServer(t, miekgdns.RcodeSuccess, testRecords)
+	r := newTestResolver(t, 16, server)
+	for _, address := range []string{"10.0.0.4", "10.0.0.99"} {
+		hostname, err := r.Lookup(address)
+		if err != nil || hostname != "" {
+			t.Fatalf("expected no hostname for %v but got '%v' (%v)", address, hostname, err)
+		}
+		if r.Due(address) {
+			t.Fatalf("expected the missing record for %v to be cached", address)
+		}
+	}
+	r.SetTTLs(time.Minute, time.Hour, -time.Second, time.Minute)
+	r.Lookup("10.0.0.99")
+	if !r.Due("10.0.0.99") {
+		t.Fatal("expected the negative TTL to apply to missing records")
+	}
+}
+
+func TestLookupFailsOver(t *testing.T) {
+	failing, failingQueries := newDNSServer(t, miekgdns.RcodeServerFailure, testRecords)
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	silent := conn.LocalAddr().String()
+	defer conn.Close()
+	working, _ := newDNSServer(t, miekgdns.RcodeSuccess, testRecords)
+	r := newTestResolver(t, 16, failing, silent, working)
+	hostname, err := r.Lookup("10.0.0.2")
+	if err != nil || hostname != "host-two.example.com" {
+		t.Fatalf("expected the last server to answer but got '%v' (%v)", hostname, err)
+	}
+	if failingQueries() != 1 {
+		t.Fatalf("expected the failing server to be queried once but it saw %v queries", failingQueries())
+	}
+	r = newTestResolver(t, 16, silent, failing)
+	_, err = r.Lookup("10.0.0.2")
+	if err == nil || !strings.Contains(err.Error(), "SERVFAIL") {
+		t.Fatalf("expected the last server's failure to be returned but got %v", err)
+	}
+	if r.Due("10.0.0.2") {
+		t.Fatal("expected a failed lookup to be cached so that the servers are not queried every cycle")
+	}
+}
+
+func TestFailedLookupKeepsPreviousAnswer(t *testing.T) {
+	working, _ := newDNSServer(t, miekgdns.RcodeSuccess, testRecords)
+	r := newTestResolver(t, 16, working)
+	r.SetTTLs(-time.Second, -time.Second, time.Hour, time.Hour)
+	_, err := r.Lookup("10.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !r.Due("10.0.0.1") {
+		t.Fatal("expected the answer to have expired")
+	}
+	failing, failingQueries := newDNSServer(t, miekgdns.RcodeServerFailure, testRecords)
+	r.mtx.Lock()
+	r.servers = []string{failing}
+	r.mtx.Unlock()
+	_, err = r.Lookup("10.0.0.1")
+	if err == nil {
+		t.Fatal("expected SERVFAIL to be returned")
+	}
+	if r.Due("10.0.0.1") {
+		t.Fatal("expected SERVFAIL to be cached for the failure TTL")
+	}
+	if hostnames := r.GetCachedHostnames([]string{"10.0.0.1"}); hostnames[0] != "host-one.example.com" {
+		t.Fatalf("expected the previous answer to be kept while the servers are failing but got %v", hostnames)
+	}
+	r.SetTTLs(time.Minute, time.Hour, time.Hour, -time.Second)
+	r.Lookup("10.0.0.1")
+	if !r.Due("10.0.0.1") || failingQueries() != 2 {
+		t.Fatalf("expected the failure TTL to apply but the server saw %v queries", failingQueries())
+	}
+}
+
+func TestCacheEvictsLeastRecentlyResolved(t *testing.T) {
+	server, _ := newDNSServer(t, miekgdns.RcodeSuccess, testRecords)
+	r := newTestResolver(t, 2, server)
+	for _, address := range []string{"10.0.0.1", "10.0.0.2", "10.0.0.1", "10.0.0.3"} {
+		_, err := r.Lookup(address)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if r.Due("10.0.0.1") || !r.Due("10.0.0.2") || r.Due("10.0.0.3") {
+		t.Fatal("expected 10.0.0.2 to be evicted as it was resolved least recently")
+	}
+}
+
+func TestSetServers(t *testing.T) {
+	r := NewResolver(16)
+	if r.Enabled() {
+		t.Fatal("expected a resolver without servers to be disabled")
+	}
+	_, err := r.Lookup("10.0.0.1")
+	if err == nil {
+		t.Fatal("expected a lookup without servers to fail")
+	}
+	err = r.SetServers("192.0.2.1", "[2001:db8::53]", "192.0.2.2:5353")
+	if err != nil {
+		t.Fatal(err)
+	}
+	servers := r.GetServers()
+	if len(servers) != 3 || servers[0] != "192.0.2.1:53" || servers[1] != "[2001:db8::53]:53" || servers[2] != "192.0.2.2:5353" {
+		t.Fatalf("unexpected servers %v", servers)
+	}
+	if r.SetServers("dns.example.com") == nil {
+		t.Fatal("expected a hostname to be rejected")
+	}
+	if _, err = r.Lookup("not an address"); err == nil {
+		t.Fatal("expected an invalid address to be rejected")
+	}
+}
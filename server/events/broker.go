@@ -0,0 +1,207 @@
+package events
+
+import (
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/demskie/ipam/server/logging"
+	"github.com/demskie/ipam/server/subnets"
+)
+
+var logger = logging.New(nil)
+
+// SetLogOutput redirects the lines logged when subscribers fall behind
+func SetLogOutput(w io.Writer, flags int) {
+	logger.SetOutput(w, flags)
+}
+
+// Event types
+const (
+	SubnetCreated     = "subnetCreated"
+	SubnetModified    = "subnetModified"
+	SubnetDeleted     = "subnetDeleted"
+	SubnetReserved    = "subnetReserved"
+	HistoryAppended   = "historyAppended"
+	PingStatusChanged = "pingStatusChanged"
+)
+
+// Event describes a single change that subscribers are notified about
+type Event struct {
+	Revision  uint64                  `json:"revision"`
+	Type      string                  `json:"type"`
+	Time      time.Time               `json:"time"`
+	Actor     string                  `json:"actor,omitempty"`
+	Subnet    string                  `json:"subnet,omitempty"`
+	Old       *subnets.SubnetSkeleton `json:"old,omitempty"`
+	New       *subnets.SubnetSkeleton `json:"new,omitempty"`
+	History   string                  `json:"history,omitempty"`
+	Address   string                  `json:"address,omitempty"`
+	Reachable bool                    `json:"reachable"`
+}
+
+const (
+	backlogLimit          = 10000
+	transientBacklogLimit = 1000
+)
+
+// isTransient reports whether the event type is kept apart from subnet changes so that it can never evict them
+func isTransient(eventType string) bool {
+	return eventType == PingStatusChanged
+}
+
+// Broker fans out published events to every subscriber and remembers recent events for resumption
+type Broker struct {
+	mtx         *sync.Mutex
+	revision    uint64
+	trimmed     uint64 // newest revision that is no longer in the backlog
+	backlog     []Event
+	transient   []Event
+	subscribers map[chan Event]struct{}
+	lagged      map[chan Event]struct{}
+	log         *logging.Logger
+}
+
+// NewBroker returns a new Broker object
+func NewBroker() *Broker {
+	return &Broker{
+		mtx:         &sync.Mutex{},
+		revision:    0,
+		backlog:     make([]Event, 0),
+		transient:   make([]Event, 0),
+		subscribers: make(map[chan Event]struct{}, 0),
+		lagged:      make(map[chan Event]struct{}, 0),
+		log:         logger,
+	}
+}
+
+// SetLogger replaces the logger that slow subscribers are reported to
+func (b *Broker) SetLogger(log *logging.Logger) {
+	b.mtx.Lock()
+	b.log = log
+	b.mtx.Unlock()
+}
+
+// Seed continues numbering from revision if it is ahead of every revision published so far.
+// Nothing before the seeded revision can be resumed from.
+func (b *Broker) Seed(revision uint64) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	if revision > b.revision {
+		b.revision = revision
+		b.trimmed = revision
+	}
+}
+
+func trimBacklog(backlog []Event, limit int) ([]Event, uint64) {
+	if len(backlog) < limit {
+		return backlog, 0
+	}
+	removed := backlog[len(backlog)-limit/2-1].Revision
+	return append(backlog[:0], backlog[len(backlog)-limit/2:]...), removed
+}
+
+// Publish stamps the event with the next revision and delivers it to all subscribers.
+// Subscribers that are too slow to keep up are closed so that they resume from their last revision.
+func (b *Broker) Publish(evt Event) Event {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	b.revision++
+	evt.Revision = b.revision
+	if evt.Time.IsZero() {
+		evt.Time = time.Now()
+	}
+	if isTransient(evt.Type) {
+		b.transient, _ = trimBacklog(b.transient, transientBacklogLimit)
+		b.transient = append(b.transient, evt)
+	} else {
+		var removed uint64
+		b.backlog, removed = trimBacklog(b.backlog, backlogLimit)
+		if removed > b.trimmed {
+			b.trimmed = removed
+		}
+		b.backlog = append(b.backlog, evt)
+	}
+	for ch := range b.subscribers {
+		select {
+		case ch <- evt:
+		default:
+			b.log.Warnf("closing a slow subscriber that could not receive event %v\n", evt.Revision)
+			delete(b.subscribers, ch)
+			b.lagged[ch] = struct{}{}
+			close(ch)
+		}
+	}
+	return evt
+}
+
+// Subscribe returns a channel that will receive all future events
+func (b *Broker) Subscribe(bufferSize int) chan Event {
+	ch := make(chan Event, bufferSize)
+	b.mtx.Lock()
+	b.subscribers[ch] = struct{}{}
+	b.mtx.Unlock()
+	return ch
+}
+
+// SubscribeSince returns every remembered event after the revision along with a channel for future events.
+// If the backlog no longer reaches back to the revision then complete will be false.
+func (b *Broker) SubscribeSince(revision uint64, bufferSize int) (missed []Event, ch chan Event, complete bool) {
+	ch = make(chan Event, bufferSize)
+	b.mtx.Lock()
+	missed, complete = b.since(revision)
+	b.subscribers[ch] = struct{}{}
+	b.mtx.Unlock()
+	return missed, ch, complete
+}
+
+// Since returns every remembered event after the revision
+func (b *Broker) Since(revision uint64) (missed []Event, complete bool) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	return b.since(revision)
+}
+
+// since is complete when no subnet change after the revision has been forgotten. Transient events
+// are returned for as long as they are remembered but are never required for completeness.
+func (b *Broker) since(revision uint64) ([]Event, bool) {
+	missed := make([]Event, 0)
+	for _, backlog := range [][]Event{b.backlog, b.transient} {
+		i := sort.Search(len(backlog), func(i int) bool {
+			return backlog[i].Revision > revision
+		})
+		missed = append(missed, backlog[i:]...)
+	}
+	sort.Slice(missed, func(i, j int) bool {
+		return missed[i].Revision < missed[j].Revision
+	})
+	return missed, revision >= b.trimmed && revision <= b.revision
+}
+
+// Revision returns the most recently published revision
+func (b *Broker) Revision() uint64 {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	return b.revision
+}
+
+// Lagged reports whether the channel was closed because the subscriber could not keep up
+func (b *Broker) Lagged(ch chan Event) bool {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	_, lagged := b.lagged[ch]
+	return lagged
+}
+
+// Unsubscribe stops delivery and closes the channel unless Publish already has
+func (b *Broker) Unsubscribe(ch chan Event) {
+	b.mtx.Lock()
+	_, exists := b.subscribers[ch]
+	if exists {
+		delete(b.subscribers, ch)
+		close(ch)
+	}
+	delete(b.lagged, ch)
+	b.mtx.Unlock()
+}
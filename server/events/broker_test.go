@@ -0,0 +1,174 @@
+package events
+
+import (
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+func init() {
+	SetLogOutput(ioutil.Discard, 0)
+}
+
+func TestPublishDeliversToEverySubscriber(t *testing.T) {
+	b := NewBroker()
+	first, second := b.Subscribe(4), b.Subscribe(4)
+	stamped := time.Date(2019, time.April, 21, 12, 0, 0, 0, time.UTC)
+	created := b.Publish(Event{Type: SubnetCreated, Subnet: "10.0.0.0/24", Time: stamped})
+	if created.Revision != 1 || !created.Time.Equal(stamped) {
+		t.Fatalf("expected the first revision with its own time but got %+v", created)
+	}
+	b.Unsubscribe(second)
+	if _, open := <-second; !open {
+		t.Fatal("expected the event published before Unsubscribe to be delivered")
+	}
+	if _, open := <-second; open {
+		t.Fatal("expected Unsubscribe to close the channel")
+	}
+	deleted := b.Publish(Event{Type: SubnetDeleted, Subnet: "10.0.0.0/24"})
+	if deleted.Revision != 2 || deleted.Time.IsZero() || b.Revision() != 2 {
+		t.Fatalf("expected the next revision to be stamped with the current time but got %+v", deleted)
+	}
+	for _, expected := range []Event{created, deleted} {
+		if evt := <-first; evt.Revision != expected.Revision || evt.Type != expected.Type || evt.Subnet != expected.Subnet {
+			t.Fatalf("expected %+v but got %+v", expected, evt)
+		}
+	}
+	b.Unsubscribe(first)
+	b.Unsubscribe(first)
+	b.Publish(Event{Type: SubnetCreated})
+	if _, open := <-first; open {
+		t.Fatal("expected nothing to be delivered after Unsubscribe")
+	}
+}
+
+func TestSinceReplaysMissedEvents(t *testing.T) {
+	b := NewBroker()
+	for i := 0; i < 5; i++ {
+		b.Publish(Event{Type: SubnetModified})
+	}
+	tests := []struct {
+		revision uint64
+		missed   int
+		complete bool
+	}{
+		{0, 5, true},
+		{3, 2, true},
+		{5, 0, true},
+		{6, 0, false}, // a revision from before a restart that reset numbering
+	}
+	for _, test := range tests {
+		missed, complete := b.Since(test.revision)
+		if len(missed) != test.missed || complete != test.complete {
+			t.Fatalf("expected %v events after %v (complete=%v) but got %v (complete=%v)", test.missed, test.revision, test.complete, len(missed), complete)
+		}
+		for i, evt := range missed {
+			if evt.Revision != test.revision+uint64(i)+1 {
+				t.Fatalf("expected the events after %v in order but got %v at %v", test.revision, evt.Revision, i)
+			}
+		}
+	}
+}
+
+func TestSubscribeSinceLeavesNoGap(t *testing.T) {
+	b := NewBroker()
+	b.Publish(Event{Type: SubnetCreated})
+	b.Publish(Event{Type: SubnetModified})
+	missed, ch, complete := b.SubscribeSince(1, 4)
+	defer b.Unsubscribe(ch)
+	if !complete || len(missed) != 1 || missed[0].Revision != 2 {
+		t.Fatalf("expected the second event to be replayed but got %+v (complete=%v)", missed, complete)
+	}
+	b.Publish(Event{Type: SubnetDeleted})
+	if evt := <-ch; evt.Revision != 3 {
+		t.Fatalf("expected the channel to continue from the replay but got %v", evt.Revision)
+	}
+}
+
+func TestBacklogIsTrimmed(t *testing.T) {
+	b := NewBroker()
+	for i := 0; i <= backlogLimit; i++ {
+		b.Publish(Event{Type: SubnetModified})
+	}
+	// the oldest half is forgotten once the backlog is full
+	oldest := uint64(backlogLimit/2 + 1)
+	missed, complete := b.Since(oldest - 1)
+	if !complete || len(missed) != backlogLimit/2+1 || missed[0].Revision != oldest {
+		t.Fatalf("expected to resume from %v but got %v events (complete=%v)", oldest-1, len(missed), complete)
+	}
+	missed, complete = b.Since(oldest - 2)
+	if complete || missed[0].Revision != oldest {
+		t.Fatalf("expected resuming before the trimmed revision to require a reset but got complete=%v", complete)
+	}
+	if missed, complete = b.Since(b.Revision() - 1); !complete || len(missed) != 1 {
+		t.Fatal("expected recent events to still be replayed")
+	}
+}
+
+func TestPublishClosesSlowSubscribers(t *testing.T) {
+	b := NewBroker()
+	slow, fast := b.Subscribe(1), b.Subscribe(4)
+	for i := 0; i < 3; i++ {
+		b.Publish(Event{Type: SubnetCreated})
+	}
+	evt, open := <-slow
+	if !open || evt.Revision != 1 {
+		t.Fatalf("expected the buffered event to be delivered but got %+v", evt)
+	}
+	if _, open = <-slow; open {
+		t.Fatal("expected the slow subscriber to be closed rather than miss an event")
+	}
+	if !b.Lagged(slow) || b.Lagged(fast) {
+		t.Fatal("expected only the slow subscriber to be reported as lagged")
+	}
+	if len(fast) != 3 {
+		t.Fatalf("expected the other subscriber to receive every event but it has %v", len(fast))
+	}
+	b.Unsubscribe(slow)
+	b.Unsubscribe(fast)
+	if b.Lagged(slow) {
+		t.Fatal("expected Unsubscribe to forget the lagged subscriber")
+	}
+	missed, complete := b.Since(evt.Revision)
+	if !complete || len(missed) != 2 {
+		t.Fatalf("expected the slow subscriber to be able to resume but got %v events (complete=%v)", len(missed), complete)
+	}
+}
+
+func TestTransientEventsDoNotEvictSubnetChanges(t *testing.T) {
+	b := NewBroker()
+	created := b.Publish(Event{Type: SubnetCreated})
+	for i := 0; i < backlogLimit; i++ {
+		b.Publish(Event{Type: PingStatusChanged})
+	}
+	missed, complete := b.Since(0)
+	if !complete || missed[0].Revision != created.Revision {
+		t.Fatalf("expected the subnet change to survive ping events but got complete=%v", complete)
+	}
+	if len(missed) > transientBacklogLimit+1 {
+		t.Fatalf("expected ping events to be trimmed separately but %v were returned", len(missed))
+	}
+	for i := 1; i < len(missed); i++ {
+		if missed[i].Revision <= missed[i-1].Revision {
+			t.Fatal("expected missed events to be ordered by revision")
+		}
+	}
+}
+
+func TestSeed(t *testing.T) {
+	b := NewBroker()
+	b.Seed(1000)
+	if evt := b.Publish(Event{Type: SubnetCreated}); evt.Revision != 1001 {
+		t.Fatalf("expected revisions to continue from the seed but got %v", evt.Revision)
+	}
+	if _, complete := b.Since(999); complete {
+		t.Fatal("expected revisions before the seed to require a reset")
+	}
+	if missed, complete := b.Since(1000); !complete || len(missed) != 1 {
+		t.Fatal("expected to resume from the seed")
+	}
+	b.Seed(10)
+	if b.Revision() != 1001 {
+		t.Fatal("expected an older seed to be ignored")
+	}
+}
@@ -0,0 +1,181 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/demskie/ipam/server/events"
+	"github.com/demskie/ipam/server/history"
+)
+
+const (
+	eventStreamKeepalive = 15 * time.Second
+	eventLongPollTimeout = 30 * time.Second
+)
+
+// eventRevisionSeed counts microseconds from the newest history event or startup, whichever is later,
+// so that revisions handed out before a restart are never reused by the next process
+func eventRevisionSeed(evts []history.Event, now time.Time) uint64 {
+	if len(evts) > 0 && evts[len(evts)-1].Time.After(now) {
+		now = evts[len(evts)-1].Time
+	}
+	return uint64(now.UnixNano() / int64(time.Microsecond))
+}
+
+// curl -N http://localhost/api/events?since=42&types=subnetCreated,subnetDeleted
+// curl http://localhost/api/events?since=42&poll=true | python -m json.tool
+
+func (ipam *IPAMServer) handleRestfulEvents(w http.ResponseWriter, r *http.Request) {
+	remoteIP, _, _ := net.SplitHostPort(r.RemoteAddr)
+	query := r.URL.Query()
+	since := ipam.events.Revision()
+	sinceString := query.Get("since")
+	if sinceString == "" {
+		sinceString = r.Header.Get("Last-Event-ID")
+	}
+	if sinceString != "" {
+		var err error
+		since, err = strconv.ParseUint(sinceString, 10, 64)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("'%v' is not a valid revision", sinceString), http.StatusBadRequest)
+			return
+		}
+	}
+	wanted := map[string]bool{}
+	for _, eventType := range strings.Split(query.Get("types"), ",") {
+		if eventType != "" {
+			wanted[eventType] = true
+		}
+	}
+	filter := func(evts []events.Event) []events.Event {
+		results := make([]events.Event, 0, len(evts))
+		for _, evt := range evts {
+			if len(wanted) == 0 || wanted[evt.Type] {
+				results = append(results, evt)
+			}
+		}
+		return results
+	}
+	missed, subscription, complete := ipam.events.SubscribeSince(since, 256)
+	defer ipam.events.Unsubscribe(subscription)
+	if query.Get("poll") != "" {
+		ipam.longPollEvents(w, r, since, missed, subscription, complete, filter)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+	// event streams outlive the server's WriteTimeout
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+	ipam.requestLog(r).Debugf("(%v) has opened an event stream since revision %v\n", remoteIP, since)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "retry: %v\n\n", int(time.Second/time.Millisecond))
+	if !complete {
+		fmt.Fprintf(w, "event: reset\ndata: {\"revision\":%v}\n\n", ipam.events.Revision())
+	}
+	for _, evt := range filter(missed) {
+		if writeServerSentEvent(w, evt) != nil {
+			return
+		}
+	}
+	flusher.Flush()
+	keepalive := time.NewTicker(eventStreamKeepalive)
+	defer keepalive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			ipam.requestLog(r).Debugf("(%v) has closed their event stream\n", remoteIP)
+			return
+		case <-keepalive.C:
+			_, err := fmt.Fprint(w, ": keepalive\n\n")
+			if err != nil {
+				return
+			}
+		case evt, open := <-subscription:
+			if !open {
+				if ipam.events.Lagged(subscription) {
+					// the client reconnects with Last-Event-ID and resumes from the backlog
+					ipam.requestLog(r).Warnf("(%v) has fallen behind their event stream\n", remoteIP)
+				}
+				return
+			}
+			if len(wanted) > 0 && !wanted[evt.Type] {
+				continue
+			}
+			if writeServerSentEvent(w, evt) != nil {
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
+
+func writeServerSentEvent(w http.ResponseWriter, evt events.Event) error {
+	b, err := json.Marshal(evt)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %v\nevent: %v\ndata: %s\n\n", evt.Revision, evt.Type, b)
+	return err
+}
+
+func (ipam *IPAMServer) longPollEvents(w http.ResponseWriter, r *http.Request, since uint64, missed []events.Event,
+	subscription chan events.Event, complete bool, filter func([]events.Event) []events.Event) {
+	remoteIP, _, _ := net.SplitHostPort(r.RemoteAddr)
+	// the revision returned is the last one observed so that resuming from it never skips an event
+	revision := since
+	if len(missed) > 0 {
+		revision = missed[len(missed)-1].Revision
+	} else if !complete {
+		// the client is ahead of the broker (usually after a restart) and has to start over
+		revision = ipam.events.Revision()
+	}
+	matched := filter(missed)
+	if len(matched) == 0 && complete {
+		timeout := time.NewTimer(eventLongPollTimeout)
+		defer timeout.Stop()
+	wait:
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-timeout.C:
+				break wait
+			case evt, open := <-subscription:
+				if !open {
+					break wait
+				}
+				revision = evt.Revision
+				matched = filter([]events.Event{evt})
+				if len(matched) > 0 {
+					break wait
+				}
+			}
+		}
+	}
+	type outgoingJSON struct {
+		Revision uint64         `json:"revision"`
+		Complete bool           `json:"complete"`
+		Events   []events.Event `json:"events"`
+	}
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	err := json.NewEncoder(w).Encode(outgoingJSON{
+		Revision: revision,
+		Complete: complete,
+		Events:   matched,
+	})
+	if err != nil {
+		ipam.requestLog(r).Errorf("failed serializing eventsJSON for (%v) because %v\n", remoteIP, err.Error())
+	}
+}
@@ -0,0 +1,276 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/demskie/ipam/server/logging"
+)
+
+const (
+	gitCommitterName  = "ipam"
+	gitCommitterEmail = "ipam@localhost"
+	gitRemoteName     = "origin"
+	gitCommandTimeout = 30 * time.Second
+	gitPushRetry      = time.Minute
+)
+
+// GitStore persists subnets.csv and history.jsonl into a local git repository and commits every mutation.
+// webhooks.jsonl contains secrets so it is saved next to them but never committed or pushed.
+type GitStore struct {
+	files       *FileStore
+	directory   string
+	remote      string
+	mtx         *sync.RWMutex
+	emails      map[string]string
+	emailDomain string
+	pushWake    chan struct{}
+	done        chan struct{}
+	pushed      chan struct{}
+	closeOnce   *sync.Once
+	log         *logging.Logger
+}
+
+// NewGitStore initializes the repository if needed. If remote is not empty then every commit is pushed to it.
+func NewGitStore(directory, remote string) (*GitStore, error) {
+	_, err := exec.LookPath("git")
+	if err != nil {
+		return nil, fmt.Errorf("unable to find git executable > %v", err)
+	}
+	files, err := NewFileStore(directory)
+	if err != nil {
+		return nil, err
+	}
+	gs := &GitStore{
+		files:       files,
+		directory:   files.directory,
+		remote:      remote,
+		mtx:         &sync.RWMutex{},
+		emails:      make(map[string]string, 0),
+		emailDomain: gitCommitterName,
+		pushWake:    make(chan struct{}, 1),
+		done:        make(chan struct{}),
+		pushed:      make(chan struct{}),
+		closeOnce:   &sync.Once{},
+		log:         logger,
+	}
+	_, err = os.Stat(filepath.Join(gs.directory, ".git"))
+	if os.IsNotExist(err) {
+		_, err = gs.git("init", "--quiet")
+		if err != nil {
+			return nil, err
+		}
+	} else if err != nil {
+		return nil, err
+	}
+	err = gs.excludeFile(webhooksFileName)
+	if err != nil {
+		return nil, err
+	}
+	if remote != "" {
+		_, err = gs.git("remote", "get-url", gitRemoteName)
+		if err != nil {
+			_, err = gs.git("remote", "add", gitRemoteName, remote)
+		} else {
+			_, err = gs.git("remote", "set-url", gitRemoteName, remote)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	go gs.pushCommits()
+	return gs, nil
+}
+
+// excludeFile keeps a file in the working tree out of every commit
+func (gs *GitStore) excludeFile(name string) error {
+	path := filepath.Join(gs.directory, ".git", "info", "exclude")
+	b, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	pattern := "/" + name
+	for _, line := range strings.Split(string(b), "\n") {
+		if strings.TrimSpace(line) == pattern {
+			return nil
+		}
+	}
+	err = os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return err
+	}
+	if len(b) > 0 && !bytes.HasSuffix(b, []byte("\n")) {
+		b = append(b, '\n')
+	}
+	return ioutil.WriteFile(path, append(b, pattern+"\n"...), 0644)
+}
+
+// SetAuthorEmails maps actors to the email address used on their commits. Actors without
+// an entry that are not already an email address become actor@domain.
+func (gs *GitStore) SetAuthorEmails(emails map[string]string, domain string) {
+	gs.mtx.Lock()
+	defer gs.mtx.Unlock()
+	gs.emails = make(map[string]string, len(emails))
+	for actor, email := range emails {
+		gs.emails[actor] = email
+	}
+	if domain == "" {
+		domain = gitCommitterName
+	}
+	gs.emailDomain = domain
+}
+
+func (gs *GitStore) git(args ...string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), gitCommandTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = gs.directory
+	cmd.Env = append(os.Environ(),
+		"GIT_COMMITTER_NAME="+gitCommitterName,
+		"GIT_COMMITTER_EMAIL="+gitCommitterEmail,
+		"GIT_TERMINAL_PROMPT=0",
+	)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	if err != nil {
+		return "", fmt.Errorf("git %v failed > %v: %v", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+func cleanAuthorField(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '<' || r == '>' || r == '\n' {
+			return -1
+		}
+		return r
+	}, strings.TrimSpace(s))
+}
+
+func (gs *GitStore) gitAuthor(actor string) string {
+	actor = cleanAuthorField(actor)
+	if actor == "" {
+		return fmt.Sprintf("%v <%v>", gitCommitterName, gitCommitterEmail)
+	}
+	gs.mtx.RLock()
+	email, exists := gs.emails[actor]
+	domain := gs.emailDomain
+	gs.mtx.RUnlock()
+	switch {
+	case exists:
+		email = cleanAuthorField(email)
+	case strings.Contains(actor, "@"):
+		email = actor
+	default:
+		email = fmt.Sprintf("%v@%v", actor, domain)
+	}
+	return fmt.Sprintf("%v <%v>", actor, email)
+}
+
+// Load reads subnets.csv and history.jsonl from the working tree
+func (gs *GitStore) Load() (*MutatedData, error) {
+	return gs.files.Load()
+}
+
+// Save writes both files and commits them using CommitMsg and the actor. Commits are pushed in
+// the background so that an unreachable remote never fails or delays a mutation.
+func (gs *GitStore) Save(data MutatedData) error {
+	err := gs.files.Save(data)
+	if err != nil {
+		return err
+	}
+	_, err = gs.git("add", "--", subnetsFileName, historyFileName)
+	if err != nil {
+		return err
+	}
+	_, err = gs.git("diff", "--cached", "--quiet")
+	if err == nil {
+		return nil // nothing has changed
+	}
+	msg := strings.TrimSpace(data.CommitMsg)
+	if msg == "" {
+		msg = "updating subnets"
+	}
+	_, err = gs.git("commit", "--quiet", "--author", gs.gitAuthor(data.Actor), "-m", msg)
+	if err != nil {
+		return err
+	}
+	select {
+	case gs.pushWake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Push sends all commits to the configured remote
+func (gs *GitStore) Push() error {
+	if gs.remote == "" {
+		return nil
+	}
+	_, err := gs.git("push", "--quiet", gitRemoteName, "HEAD")
+	return err
+}
+
+// SetLogger replaces the logger that failed pushes are reported to
+func (gs *GitStore) SetLogger(log *logging.Logger) {
+	gs.mtx.Lock()
+	gs.log = log
+	gs.mtx.Unlock()
+}
+
+func (gs *GitStore) logger() *logging.Logger {
+	gs.mtx.RLock()
+	defer gs.mtx.RUnlock()
+	return gs.log
+}
+
+// pushCommits pushes after every commit and keeps retrying until the remote has accepted them
+func (gs *GitStore) pushCommits() {
+	defer close(gs.pushed)
+	retry := time.NewTimer(gitPushRetry)
+	retry.Stop()
+	defer retry.Stop()
+	pending := false
+	for {
+		select {
+		case <-gs.pushWake:
+		case <-retry.C:
+		case <-gs.done:
+			// one last attempt so that commits made just before Close are not left behind
+			select {
+			case <-gs.pushWake:
+				pending = true
+			default:
+			}
+			if pending && gs.Push() != nil {
+				gs.logger().Errorf("unable to push to '%v' before closing\n", gs.remote)
+			}
+			return
+		}
+		err := gs.Push()
+		pending = err != nil
+		if err != nil {
+			gs.logger().Warnf("unable to push to '%v' so retrying in %v > %v\n", gs.remote, gitPushRetry, err)
+			retry.Reset(gitPushRetry)
+		}
+	}
+}
+
+// Close stops pushing after the newest commit has been attempted
+func (gs *GitStore) Close() error {
+	gs.closeOnce.Do(func() {
+		close(gs.done)
+	})
+	<-gs.pushed
+	return nil
+}
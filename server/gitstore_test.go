@@ -0,0 +1,181 @@
+package server
+
+import (
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func runGit(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %v failed > %v: %s", args[0], err, out)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+func newTestGitStore(t *testing.T) (*GitStore, string, string) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	dir := t.TempDir()
+	remote := filepath.Join(dir, "remote.git")
+	runGit(t, dir, "init", "--quiet", "--bare", remote)
+	directory := filepath.Join(dir, "data")
+	gs, err := NewGitStore(directory, remote)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { gs.Close() })
+	return gs, directory, remote
+}
+
+func TestGitStoreCommitsAndPushes(t *testing.T) {
+	gs, directory, remote := newTestGitStore(t)
+	err := gs.Save(MutatedData{
+		CommitMsg: "creating 10.0.0.0/24",
+		Actor:     "alice",
+		Subnets:   []string{"10.0.0.0/24,one,,\n"},
+		History:   []string{"{}\n"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	gs.SetAuthorEmails(map[string]string{"carol": "carol@example.com"}, "example.org")
+	err = gs.Save(MutatedData{
+		Actor:   "carol",
+		Subnets: []string{"10.0.0.0/24,one,,\n", "10.0.1.0/24,two,,\n"},
+		History: []string{"{}\n", "{}\n"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = gs.Save(MutatedData{
+		Actor:   "bob@example.com",
+		Subnets: []string{"10.0.0.0/24,one,,\n", "10.0.1.0/24,two,,\n", "10.0.2.0/24,three,,\n"},
+		History: []string{"{}\n", "{}\n", "{}\n"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	gs.Close()
+	log := runGit(t, remote, "log", "--format=%an <%ae>|%cn|%s")
+	expected := "bob@example.com <bob@example.com>|ipam|updating subnets\n" +
+		"carol <carol@example.com>|ipam|updating subnets\n" +
+		"alice <alice@ipam>|ipam|creating 10.0.0.0/24"
+	if log != expected {
+		t.Fatalf("expected the remote to contain\n%v\nbut got\n%v", expected, log)
+	}
+	if files := runGit(t, directory, "ls-files"); files != "history.jsonl\nsubnets.csv" {
+		t.Fatalf("expected only the data files to be committed but got %v", files)
+	}
+	data, err := gs.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data.Subnets) != 4 || data.Subnets[1] != "10.0.1.0/24,two,," {
+		t.Fatalf("expected every subnet to be loaded but got %q", data.Subnets)
+	}
+}
+
+func TestGitStoreSkipsUnchangedSaves(t *testing.T) {
+	gs, directory, _ := newTestGitStore(t)
+	data := MutatedData{CommitMsg: "first", Subnets: []string{"10.0.0.0/24,one,,\n"}}
+	for i := 0; i < 3; i++ {
+		err := gs.Save(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if count := runGit(t, directory, "rev-list", "--count", "HEAD"); count != "1" {
+		t.Fatalf("expected saving unchanged data to not commit but there are %v commits", count)
+	}
+}
+
+func TestGitStoreReopensExistingRepository(t *testing.T) {
+	gs, directory, _ := newTestGitStore(t)
+	err := gs.Save(MutatedData{Subnets: []string{"10.0.0.0/24,one,,\n"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := filepath.Join(t.TempDir(), "other.git")
+	runGit(t, directory, "init", "--quiet", "--bare", other)
+	reopened, err := NewGitStore(directory, other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	if url := runGit(t, directory, "remote", "get-url", gitRemoteName); url != other {
+		t.Fatalf("expected the remote to be replaced with '%v' but got '%v'", other, url)
+	}
+	err = reopened.Push()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count := runGit(t, other, "rev-list", "--count", "--all"); count != "1" {
+		t.Fatalf("expected the existing commit to be pushed to the new remote but got %v", count)
+	}
+}
+
+func TestGitStorePushFailureKeepsCommit(t *testing.T) {
+	gs, directory, _ := newTestGitStore(t)
+	gs.remote = "/nonexistent/remote.git"
+	gs.git("remote", "set-url", gitRemoteName, gs.remote)
+	err := gs.Save(MutatedData{Subnets: []string{"10.0.0.0/24,one,,\n"}})
+	if err != nil {
+		t.Fatalf("expected a failed push to not fail the save but got %v", err)
+	}
+	if count := runGit(t, directory, "rev-list", "--count", "HEAD"); count != "1" {
+		t.Fatalf("expected the commit to be kept but there are %v commits", count)
+	}
+	if gs.Push() == nil {
+		t.Fatal("expected pushing to a missing remote to fail")
+	}
+}
+
+func TestGitAuthor(t *testing.T) {
+	gs := &GitStore{mtx: &sync.RWMutex{}, emails: map[string]string{"dave": "d<ave>@example.com"}, emailDomain: "ipam"}
+	tests := map[string]string{
+		"":                      "ipam <ipam@localhost>",
+		"alice":                 "alice <alice@ipam>",
+		" bob@example.com ":     "bob@example.com <bob@example.com>",
+		"dave":                  "dave <dave@example.com>",
+		"eve <evil>\nInjected:": "eve evilInjected: <eve evilInjected:@ipam>",
+	}
+	for actor, expected := range tests {
+		if author := gs.gitAuthor(actor); author != expected {
+			t.Fatalf("expected '%v' to become '%v' but got '%v'", actor, expected, author)
+		}
+	}
+}
+
+func TestGitStoreNeverCommitsWebhookSecrets(t *testing.T) {
+	gs, directory, _ := newTestGitStore(t)
+	err := gs.Save(MutatedData{Subnets: []string{"10.0.0.0/24,one,,\n"}, Webhooks: []string{`{"secret":"shh"}` + "\n"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if files := runGit(t, directory, "ls-files"); strings.Contains(files, webhooksFileName) {
+		t.Fatalf("expected webhooks to stay out of the repository but it tracks %v", files)
+	}
+	if status := runGit(t, directory, "status", "--porcelain"); status != "" {
+		t.Fatalf("expected webhooks to be ignored but the working tree has '%v'", status)
+	}
+	data, err := gs.Load()
+	if err != nil || len(trimLines(data.Webhooks)) != 1 {
+		t.Fatalf("expected webhooks to still be saved locally but loaded %q (%v)", data.Webhooks, err)
+	}
+	reopened, err := NewGitStore(directory, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	reopened.Close()
+	if exclude := runGit(t, directory, "check-ignore", webhooksFileName); exclude != webhooksFileName {
+		t.Fatalf("expected webhooks to be ignored once reopened but got '%v'", exclude)
+	}
+}
@@ -1,6 +1,6 @@
 module github.com/demskie/ipam/server
 
-go 1.12
+go 1.20
 
 require (
 	github.com/davecgh/go-spew v1.1.1
@@ -8,14 +8,22 @@ require (
 	github.com/demskie/randutil v1.0.0
 	github.com/demskie/simplesync v1.0.0
 	github.com/demskie/subnetmath v1.0.0
+	github.com/go-asn1-ber/asn1-ber v1.5.5
+	github.com/go-ldap/ldap/v3 v3.4.6
 	github.com/gorilla/mux v1.7.3
 	github.com/gorilla/websocket v1.4.0
-	github.com/h2non/filetype v1.0.10 // indirect
-	github.com/hashicorp/go-syslog v1.0.0
+	github.com/mattn/go-sqlite3 v1.14.17
 	github.com/miekg/dns v1.1.15
-	golang.org/x/crypto v0.0.0-20190701094942-4def268fd1a4 // indirect
-	golang.org/x/net v0.0.0-20190813141303-74dc4d7220e7
-	golang.org/x/sys v0.0.0-20190813064441-fde4db37ae7a // indirect
-	golang.org/x/text v0.3.2 // indirect
-	golang.org/x/tools v0.0.0-20190813214729-9dba7caff850 // indirect
+	go.etcd.io/bbolt v1.3.7
+	golang.org/x/crypto v0.13.0
+	golang.org/x/net v0.10.0
+)
+
+require (
+	github.com/Azure/go-ntlmssp v0.0.0-20221128193559-754e69321358 // indirect
+	github.com/golang/gddo v0.0.0-20190419222130-af0f2af80721 // indirect
+	github.com/google/uuid v1.3.1 // indirect
+	github.com/h2non/filetype v1.0.10 // indirect
+	golang.org/x/sys v0.12.0 // indirect
+	gopkg.in/kothar/brotli-go.v0 v0.0.0-20170728081549-771231d473d6 // indirect
 )
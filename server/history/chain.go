@@ -0,0 +1,114 @@
+package history
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+)
+
+// computeHash returns the hex encoded sha256 of the event excluding its own hash and signature
+func (e Event) computeHash() string {
+	e.Hash, e.Signature = "", ""
+	b, _ := json.Marshal(e)
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// seal links every event to its predecessor and signs checkpoints if a key has been configured
+func (r *UserActions) seal(evts []Event) {
+	prevHash := ""
+	for i := range evts {
+		evts[i].PrevHash = prevHash
+		evts[i].Hash = evts[i].computeHash()
+		evts[i].Signature = ""
+		if r.isCheckpoint(i) {
+			evts[i].Signature = r.sign(evts[i].Hash)
+		}
+		prevHash = evts[i].Hash
+	}
+}
+
+func (r *UserActions) sign(hash string) string {
+	return hex.EncodeToString(ed25519.Sign(r.signingKey, []byte(hash)))
+}
+
+// SetSigningKey causes every Nth event to be signed as a checkpoint of the hash chain. History migrated
+// from history.txt before a key was set is resealed and signed, in which case true is returned.
+func (r *UserActions) SetSigningKey(key ed25519.PrivateKey, checkpointEvery int) bool {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	r.signingKey = key
+	r.checkpointEvery = checkpointEvery
+	if !r.migrated || key == nil {
+		return false
+	}
+	// only history that was never hash chained is signed so that stripped signatures cannot be restored
+	r.seal(r.events)
+	r.migrated = false
+	return true
+}
+
+func (r *UserActions) isCheckpoint(i int) bool {
+	return r.signingKey != nil && r.checkpointEvery > 0 && (i+1)%r.checkpointEvery == 0
+}
+
+// ChainReport is the result of verifying the hash chain
+type ChainReport struct {
+	Valid                bool   `json:"valid"`
+	Checked              int    `json:"checked"`
+	Head                 string `json:"head"`
+	Checkpoints          int    `json:"checkpoints"`
+	UnverifiedCheckpoint int    `json:"unverifiedCheckpoints"`
+	BrokenIndex          int    `json:"brokenIndex"`
+	BrokenID             string `json:"brokenID,omitempty"`
+	Reason               string `json:"reason,omitempty"`
+}
+
+// Verify walks the hash chain from the oldest event and reports the first broken link.
+// Every Nth event must carry a valid signature when a signing key has been configured.
+func (r *UserActions) Verify() ChainReport {
+	r.mtx.RLock()
+	defer r.mtx.RUnlock()
+	report := ChainReport{Valid: true, BrokenIndex: -1}
+	var publicKey ed25519.PublicKey
+	if r.signingKey != nil {
+		publicKey = r.signingKey.Public().(ed25519.PublicKey)
+	}
+	prevHash := ""
+	for i, evt := range r.events {
+		reason := ""
+		switch {
+		case evt.Hash == "":
+			reason = "event is missing its hash"
+		case evt.PrevHash != prevHash:
+			reason = "event does not reference the hash of the previous event"
+		case evt.computeHash() != evt.Hash:
+			reason = "event contents do not match its hash"
+		case evt.Signature == "" && r.isCheckpoint(i):
+			reason = "checkpoint is missing its signature"
+		case evt.Signature != "":
+			report.Checkpoints++
+			if publicKey == nil {
+				report.UnverifiedCheckpoint++
+				break
+			}
+			sig, err := hex.DecodeString(evt.Signature)
+			if err != nil || !ed25519.Verify(publicKey, []byte(evt.Hash), sig) {
+				reason = "checkpoint signature is invalid"
+			}
+		}
+		if reason != "" {
+			report.Valid = false
+			report.BrokenIndex = i
+			report.BrokenID = evt.ID
+			report.Reason = fmt.Sprintf("%v (%v)", reason, evt.Time.Format(defaultTimeLayout))
+			return report
+		}
+		report.Checked++
+		prevHash = evt.Hash
+	}
+	report.Head = prevHash
+	return report
+}
@@ -0,0 +1,168 @@
+package history
+
+import (
+	"crypto/ed25519"
+	"strings"
+	"testing"
+
+	"github.com/demskie/ipam/server/subnets"
+)
+
+func recordEvents(r *UserActions, count int) {
+	for i := 0; i < count; i++ {
+		r.Record(Event{
+			Actor:  "alice",
+			Action: ActionCreateSubnet,
+			Target: "10.0.0.0/24",
+			After:  &subnets.SubnetSkeleton{Net: "10.0.0.0/24", Desc: "test"},
+		})
+	}
+}
+
+func TestVerifyAcceptsRecordedChain(t *testing.T) {
+	r := NewUserActions()
+	recordEvents(r, 5)
+	report := r.Verify()
+	if !report.Valid || report.Checked != 5 || report.BrokenIndex != -1 {
+		t.Fatalf("expected a valid chain of 5 events but got %+v", report)
+	}
+	evts := r.GetAllEvents()
+	if report.Head != evts[len(evts)-1].Hash {
+		t.Fatalf("expected head %v but got %v", evts[len(evts)-1].Hash, report.Head)
+	}
+}
+
+func TestVerifyDetectsTampering(t *testing.T) {
+	tests := []struct {
+		name   string
+		tamper func(evts []Event)
+		reason string
+	}{
+		{"modified", func(evts []Event) { evts[2].Actor = "mallory" }, "do not match"},
+		{"removed hash", func(evts []Event) { evts[2].Hash = "" }, "missing its hash"},
+		{"reordered", func(evts []Event) { evts[1], evts[2] = evts[2], evts[1] }, "previous event"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := NewUserActions()
+			recordEvents(r, 4)
+			evts := r.GetAllEvents()
+			test.tamper(evts)
+			r.OverwriteEvents(evts)
+			report := r.Verify()
+			if report.Valid || !strings.Contains(report.Reason, test.reason) {
+				t.Fatalf("expected a broken chain mentioning '%v' but got %+v", test.reason, report)
+			}
+		})
+	}
+}
+
+func TestOverwriteUserHistoryDoesNotResealJSONL(t *testing.T) {
+	r := NewUserActions()
+	recordEvents(r, 3)
+	lines := r.ExportJSONL()
+	for i := range lines {
+		lines[i] = strings.Replace(lines[i], `"hash":`, `"stripped":`, 1)
+		lines[i] = strings.Replace(lines[i], `"prevHash":`, `"strippedPrev":`, 1)
+	}
+	loaded := NewUserActions()
+	loaded.OverwriteUserHistory(lines)
+	report := loaded.Verify()
+	if report.Valid || report.BrokenIndex != 0 {
+		t.Fatalf("expected unhashed JSONL records to be reported as broken but got %+v", report)
+	}
+}
+
+func TestOverwriteUserHistorySealsLegacyLines(t *testing.T) {
+	r := NewUserActions()
+	r.OverwriteUserHistory([]string{
+		"01-02-2019 15:04:05 (alice@10.1.1.1) is creating subnet: [net='10.0.0.0/24' desc='one' details='' vlan='']",
+		"01-03-2019 15:04:05 (bob) is deleting subnet: [net='10.0.0.0/24' desc='one' details='' vlan='']",
+	})
+	report := r.Verify()
+	if !report.Valid || report.Checked != 2 {
+		t.Fatalf("expected migrated history.txt lines to be sealed but got %+v", report)
+	}
+}
+
+func TestCheckpointSignatures(t *testing.T) {
+	_, key, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := NewUserActions()
+	r.SetSigningKey(key, 2)
+	recordEvents(r, 5)
+	report := r.Verify()
+	if !report.Valid || report.Checkpoints != 2 || report.UnverifiedCheckpoint != 0 {
+		t.Fatalf("expected two verified checkpoints but got %+v", report)
+	}
+	_, otherKey, _ := ed25519.GenerateKey(nil)
+	r.SetSigningKey(otherKey, 2)
+	report = r.Verify()
+	if report.Valid || !strings.Contains(report.Reason, "signature") {
+		t.Fatalf("expected checkpoints signed by another key to be rejected but got %+v", report)
+	}
+}
+
+func TestDiscardOnlyRemovesNewestEvent(t *testing.T) {
+	r := NewUserActions()
+	recordEvents(r, 3)
+	evts := r.GetAllEvents()
+	if r.Discard(evts[0].ID) {
+		t.Fatal("expected an older event to be kept")
+	}
+	if !r.Discard(evts[2].ID) || len(r.GetAllEvents()) != 2 || !r.Verify().Valid {
+		t.Fatal("expected the newest event to be discarded without breaking the chain")
+	}
+}
+
+func TestVerifyRequiresCheckpointSignatures(t *testing.T) {
+	_, key, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := NewUserActions()
+	r.SetSigningKey(key, 2)
+	recordEvents(r, 4)
+	evts := r.GetAllEvents()
+	for i := range evts {
+		evts[i].Actor = "mallory"
+		evts[i].Signature = ""
+	}
+	r.OverwriteEvents(evts)
+	r.seal(evts)
+	for i := range evts {
+		evts[i].Signature = ""
+	}
+	r.OverwriteEvents(evts)
+	report := r.Verify()
+	if report.Valid || report.BrokenIndex != 1 || !strings.Contains(report.Reason, "missing its signature") {
+		t.Fatalf("expected a rewritten chain without signatures to be rejected but got %+v", report)
+	}
+	if r.SetSigningKey(key, 2) {
+		t.Fatal("expected stripped JSONL history to not be signed again")
+	}
+}
+
+func TestSigningKeySignsMigratedHistory(t *testing.T) {
+	_, key, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := NewUserActions()
+	r.OverwriteUserHistory([]string{
+		"01-02-2019 15:04:05 (alice) is creating subnet: [net='10.0.0.0/24' desc='one' details='' vlan='']",
+		"01-03-2019 15:04:05 (alice) is creating subnet: [net='10.0.1.0/24' desc='two' details='' vlan='']",
+	})
+	if !r.SetSigningKey(key, 2) {
+		t.Fatal("expected migrated history to be signed once a key is set")
+	}
+	report := r.Verify()
+	if !report.Valid || report.Checkpoints != 1 {
+		t.Fatalf("expected migrated history to contain a signed checkpoint but got %+v", report)
+	}
+	if r.SetSigningKey(key, 2) {
+		t.Fatal("expected migrated history to only be signed once")
+	}
+}
@@ -0,0 +1,246 @@
+package history
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/demskie/ipam/server/subnets"
+)
+
+// Action describes what kind of change a user made
+type Action string
+
+// Actions
+const (
+	ActionUnknown       Action = "unknown"
+	ActionCreateSubnet  Action = "createSubnet"
+	ActionModifySubnet  Action = "modifySubnet"
+	ActionDeleteSubnet  Action = "deleteSubnet"
+	ActionReserveHost   Action = "reserveHost"
+	ActionReserveSubnet Action = "reserveSubnet"
+	ActionRevert        Action = "revert"
+)
+
+var actionVerbs = map[Action]string{
+	ActionUnknown:       "doing something unknown",
+	ActionCreateSubnet:  "creating subnet",
+	ActionModifySubnet:  "pushing changes",
+	ActionDeleteSubnet:  "deleting subnet",
+	ActionReserveHost:   "reserving host",
+	ActionReserveSubnet: "reserving subnet",
+	ActionRevert:        "reverting changes",
+}
+
+// Verb returns the phrase used by the original history.txt format
+func (a Action) Verb() string {
+	verb, exists := actionVerbs[a]
+	if exists {
+		return verb
+	}
+	return actionVerbs[ActionUnknown]
+}
+
+// ParseVerb returns the Action matching a phrase from the original history.txt format
+func ParseVerb(verb string) Action {
+	for action, v := range actionVerbs {
+		if v == verb {
+			return action
+		}
+	}
+	return ActionUnknown
+}
+
+// Event is a single structured history record
+type Event struct {
+	ID        string                  `json:"id"`
+	Time      time.Time               `json:"time"`
+	Actor     string                  `json:"actor"`
+	SourceIP  string                  `json:"sourceIP"`
+	Action    Action                  `json:"action"`
+	Target    string                  `json:"target"`
+	Before    *subnets.SubnetSkeleton `json:"before,omitempty"`
+	After     *subnets.SubnetSkeleton `json:"after,omitempty"`
+	Legacy    string                  `json:"legacy,omitempty"`
+	RevertOf  string                  `json:"revertOf,omitempty"`
+	PrevHash  string                  `json:"prevHash,omitempty"`
+	Hash      string                  `json:"hash,omitempty"`
+	Signature string                  `json:"signature,omitempty"`
+}
+
+func newEventID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// IsReversible returns true if the event contains enough information to apply the inverse operation
+func (e Event) IsReversible() bool {
+	return e.Legacy == "" && (e.Before != nil || e.After != nil)
+}
+
+// Who returns the actor and source address formatted as "actor@sourceIP"
+func (e Event) Who() string {
+	switch {
+	case e.Actor != "" && e.SourceIP != "":
+		return fmt.Sprintf("%v@%v", e.Actor, e.SourceIP)
+	case e.Actor != "":
+		return e.Actor
+	}
+	return e.SourceIP
+}
+
+// Changes returns the fields that were affected using the original history.txt notation
+func (e Event) Changes() []string {
+	switch {
+	case e.Action == ActionModifySubnet && e.Before != nil && e.After != nil:
+		differences := e.Before.ListDifferences(e.After)
+		if differences != nil {
+			return differences
+		}
+		return []string{fmt.Sprintf("net='%v'", e.Target)}
+	case e.Action == ActionReserveHost || e.Action == ActionReserveSubnet:
+		if e.After != nil {
+			return e.After.ToSlice()[:3]
+		}
+	case e.After != nil:
+		return e.After.ToSlice()
+	case e.Before != nil:
+		return e.Before.ToSlice()
+	}
+	return []string{fmt.Sprintf("net='%v'", e.Target)}
+}
+
+// Message returns the event without a timestamp and is suitable for use as a commit message
+func (e Event) Message() string {
+	if e.Legacy != "" && len(e.Legacy) > len(defaultTimeLayout) {
+		return strings.TrimSpace(e.Legacy[len(defaultTimeLayout):]) + "\n"
+	}
+	return fmt.Sprintf("(%v) is %v: %v\n", e.Who(), e.Action.Verb(), e.Changes())
+}
+
+// String returns the event formatted identically to the original history.txt lines
+func (e Event) String() string {
+	if e.Legacy != "" {
+		return e.Legacy
+	}
+	return fmt.Sprintf("%v (%v) is %v: %v", e.Time.Format(defaultTimeLayout), e.Who(), e.Action.Verb(), e.Changes())
+}
+
+var legacyLinePattern = regexp.MustCompile(`^(\d\d-\d\d-\d\d\d\d \d\d:\d\d:\d\d) \((.*?)\) is (.+?): \[(.*)\]$`)
+var legacyFieldPattern = regexp.MustCompile(`(?:^| )(net|desc|details|vlan)='`)
+
+func parseLegacySkeleton(changes string) *subnets.SubnetSkeleton {
+	skeleton := &subnets.SubnetSkeleton{}
+	matches := legacyFieldPattern.FindAllStringSubmatchIndex(changes, -1)
+	for i, m := range matches {
+		end := len(changes)
+		if i+1 < len(matches) {
+			end = matches[i+1][0]
+		}
+		val := strings.TrimSuffix(changes[m[1]:end], "'")
+		switch changes[m[2]:m[3]] {
+		case "net":
+			skeleton.Net = val
+		case "desc":
+			skeleton.Desc = val
+		case "details":
+			skeleton.Details = val
+		case "vlan":
+			skeleton.Vlan = val
+		}
+	}
+	return skeleton
+}
+
+// ParseLegacyLine converts a line from the original history.txt format into an Event
+func ParseLegacyLine(line string) (Event, error) {
+	line = strings.TrimSpace(line)
+	m := legacyLinePattern.FindStringSubmatch(line)
+	if m == nil {
+		return Event{}, fmt.Errorf("'%v' is not a valid history line", line)
+	}
+	t, err := time.ParseInLocation(defaultTimeLayout, m[1], time.Local)
+	if err != nil {
+		return Event{}, err
+	}
+	evt := Event{
+		ID:     newEventID(),
+		Time:   t,
+		Action: ParseVerb(m[3]),
+	}
+	who := m[2]
+	i := strings.LastIndex(who, "@")
+	if i >= 0 && net.ParseIP(who[i+1:]) != nil {
+		evt.Actor, evt.SourceIP = who[:i], who[i+1:]
+	} else if net.ParseIP(who) != nil {
+		evt.SourceIP = who
+	} else {
+		evt.Actor = who
+	}
+	skeleton := parseLegacySkeleton(m[4])
+	skeleton.Mod = m[1]
+	evt.Target = skeleton.Net
+	switch evt.Action {
+	case ActionDeleteSubnet:
+		evt.Before = skeleton
+	case ActionCreateSubnet, ActionReserveHost, ActionReserveSubnet:
+		evt.After = skeleton
+	default:
+		// modifications only recorded the fields that changed
+		evt.Legacy = line
+	}
+	return evt, nil
+}
+
+// ParseLine accepts either a JSONL record or a line from the original history.txt format
+func ParseLine(line string) (Event, error) {
+	line = strings.TrimSpace(line)
+	if strings.HasPrefix(line, "{") {
+		evt := Event{}
+		err := json.Unmarshal([]byte(line), &evt)
+		return evt, err
+	}
+	return ParseLegacyLine(line)
+}
+
+// WriteJSONL writes one JSON encoded event per line
+func WriteJSONL(w io.Writer, evts []Event) error {
+	enc := json.NewEncoder(w)
+	for _, evt := range evts {
+		err := enc.Encode(evt)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadJSONL reads events written by WriteJSONL
+func ReadJSONL(r io.Reader) ([]Event, error) {
+	evts := []Event{}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		evt := Event{}
+		err := json.Unmarshal([]byte(line), &evt)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse history line %v > %v", lineNum, err)
+		}
+		evts = append(evts, evt)
+	}
+	return evts, scanner.Err()
+}
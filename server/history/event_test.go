@@ -0,0 +1,138 @@
+package history
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/demskie/ipam/server/subnets"
+)
+
+func TestParseLegacyLine(t *testing.T) {
+	tests := []struct {
+		line       string
+		action     Action
+		actor      string
+		sourceIP   string
+		target     string
+		before     *subnets.SubnetSkeleton
+		after      *subnets.SubnetSkeleton
+		reversible bool
+	}{
+		{
+			line:       `04-21-2019 15:30:52 (::1) is deleting subnet: [net='127.0.0.0/24' desc='' details='' vlan='']`,
+			action:     ActionDeleteSubnet,
+			sourceIP:   "::1",
+			target:     "127.0.0.0/24",
+			before:     &subnets.SubnetSkeleton{Net: "127.0.0.0/24", Mod: "04-21-2019 15:30:52"},
+			reversible: true,
+		},
+		{
+			line:       `04-21-2019 15:24:55 (alex.demskie@::1) is creating subnet: [net='10.100.64.0/18' desc='CORP VRF' details='' vlan='']`,
+			action:     ActionCreateSubnet,
+			actor:      "alex.demskie",
+			sourceIP:   "::1",
+			target:     "10.100.64.0/18",
+			after:      &subnets.SubnetSkeleton{Net: "10.100.64.0/18", Desc: "CORP VRF", Mod: "04-21-2019 15:24:55"},
+			reversible: true,
+		},
+		{
+			line:       `04-21-2019 14:44:10 (alex.demskie@127.0.0.1) is creating subnet: [net='10.100.0.0/16' desc='SEA1' details='Main Seattle Office' vlan='']`,
+			action:     ActionCreateSubnet,
+			actor:      "alex.demskie",
+			sourceIP:   "127.0.0.1",
+			target:     "10.100.0.0/16",
+			after:      &subnets.SubnetSkeleton{Net: "10.100.0.0/16", Desc: "SEA1", Details: "Main Seattle Office", Mod: "04-21-2019 14:44:10"},
+			reversible: true,
+		},
+		{
+			line:       `04-22-2019 09:12:01 (alex.demskie@127.0.0.1) is reserving host: [net='10.100.0.5/32' desc='printer' details='']`,
+			action:     ActionReserveHost,
+			actor:      "alex.demskie",
+			sourceIP:   "127.0.0.1",
+			target:     "10.100.0.5/32",
+			after:      &subnets.SubnetSkeleton{Net: "10.100.0.5/32", Desc: "printer", Mod: "04-22-2019 09:12:01"},
+			reversible: true,
+		},
+		{
+			// modifications only recorded the fields that changed so they can never be reverted
+			line:     `04-22-2019 10:00:00 (alex.demskie@127.0.0.1) is pushing changes: [net='10.100.0.0/16' desc='SEA1 HQ' vlan='100']`,
+			action:   ActionModifySubnet,
+			actor:    "alex.demskie",
+			sourceIP: "127.0.0.1",
+			target:   "10.100.0.0/16",
+		},
+		{
+			line:   `04-22-2019 10:05:00 (bob) is pushing changes: [net='10.100.32.0/19' details='it's got a quote']`,
+			action: ActionModifySubnet,
+			actor:  "bob",
+			target: "10.100.32.0/19",
+		},
+		{
+			line:   `04-22-2019 10:10:00 (alice) is defragmenting: [net='10.0.0.0/8']`,
+			action: ActionUnknown,
+			actor:  "alice",
+			target: "10.0.0.0/8",
+		},
+	}
+	for _, test := range tests {
+		evt, err := ParseLegacyLine(test.line + "\n")
+		if err != nil {
+			t.Fatalf("expected '%v' to be parsed but got %v", test.line, err)
+		}
+		expectedTime, _ := time.ParseInLocation(defaultTimeLayout, test.line[:len(defaultTimeLayout)], time.Local)
+		if evt.ID == "" || !evt.Time.Equal(expectedTime) {
+			t.Fatalf("expected '%v' to be given an ID and its time but got %+v", test.line, evt)
+		}
+		if evt.Action != test.action || evt.Actor != test.actor || evt.SourceIP != test.sourceIP || evt.Target != test.target {
+			t.Fatalf("expected %v by '%v' from '%v' on %v but got %v by '%v' from '%v' on %v", test.action, test.actor, test.sourceIP, test.target, evt.Action, evt.Actor, evt.SourceIP, evt.Target)
+		}
+		if fmt.Sprint(evt.Before) != fmt.Sprint(test.before) || fmt.Sprint(evt.After) != fmt.Sprint(test.after) {
+			t.Fatalf("expected '%v' to have before %+v and after %+v but got %+v and %+v", test.line, test.before, test.after, evt.Before, evt.After)
+		}
+		if evt.IsReversible() != test.reversible {
+			t.Fatalf("expected '%v' reversible to be %v", test.line, test.reversible)
+		}
+		if evt.String() != test.line {
+			t.Fatalf("expected the line to be reproduced exactly but got\n%v\ninstead of\n%v", evt.String(), test.line)
+		}
+	}
+}
+
+func TestParseLegacyLineRejectsInvalidLines(t *testing.T) {
+	for _, line := range []string{
+		"",
+		"not a history line",
+		`2019-04-21 15:30:52 (::1) is deleting subnet: [net='127.0.0.0/24']`,
+		`04-21-2019 15:30:52 (::1) is deleting subnet: net='127.0.0.0/24'`,
+		`13-45-2019 15:30:52 (::1) is deleting subnet: [net='127.0.0.0/24']`,
+	} {
+		if _, err := ParseLegacyLine(line); err == nil {
+			t.Fatalf("expected '%v' to be rejected", line)
+		}
+	}
+}
+
+func TestOverwriteUserHistoryMigratesLegacyLines(t *testing.T) {
+	r := NewUserActions()
+	r.OverwriteUserHistory([]string{
+		`04-21-2019 15:30:52 (::1) is deleting subnet: [net='127.0.0.0/24' desc='' details='' vlan='']` + "\n",
+		`04-22-2019 10:00:00 (alex.demskie@127.0.0.1) is pushing changes: [net='10.100.0.0/16' desc='SEA1 HQ']` + "\n",
+		"garbage\n",
+		`04-21-2019 12:28:50 (::1) is creating subnet: [net='127.0.0.0/24' desc='' details='' vlan='']` + "\n",
+		"\n",
+	})
+	evts := r.GetAllEvents()
+	if len(evts) != 3 {
+		t.Fatalf("expected the invalid line to be skipped but got %v events", len(evts))
+	}
+	if evts[0].Action != ActionCreateSubnet || evts[1].Action != ActionDeleteSubnet || evts[2].Action != ActionModifySubnet {
+		t.Fatalf("expected the events to be sorted oldest first but got %v, %v and %v", evts[0].Action, evts[1].Action, evts[2].Action)
+	}
+	if evts[2].Legacy == "" || evts[2].IsReversible() {
+		t.Fatalf("expected the modification to be kept as a non-reversible legacy event but got %+v", evts[2])
+	}
+	if report := r.Verify(); !report.Valid || report.Checked != 3 {
+		t.Fatalf("expected the migrated history to be sealed but got %+v", report)
+	}
+}
@@ -0,0 +1,140 @@
+package history
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/demskie/subnetmath"
+)
+
+// Query is used to filter and paginate history. Empty fields match everything.
+type Query struct {
+	CIDR               string
+	IncludeDescendants bool
+	Actor              string
+	Action             string
+	Since              time.Time
+	Until              time.Time
+	Text               string
+	Offset             int
+	Limit              int
+}
+
+// MaxQueryLimit caps how many events a single search returns and is used when Limit is 0
+const MaxQueryLimit = 10000
+
+// PageLimit returns how many events a search using q returns at most
+func (q Query) PageLimit() int {
+	if q.Limit == 0 || q.Limit > MaxQueryLimit {
+		return MaxQueryLimit
+	}
+	return q.Limit
+}
+
+type compiledQuery struct {
+	Query
+	network *net.IPNet
+	ones    int
+	action  Action
+}
+
+func (q Query) compile() (*compiledQuery, error) {
+	cq := &compiledQuery{Query: q}
+	if q.CIDR != "" {
+		cq.network = subnetmath.ParseNetworkCIDR(q.CIDR)
+		if cq.network == nil {
+			return nil, fmt.Errorf("'%v' is not a valid CIDR network", q.CIDR)
+		}
+		cq.ones, _ = cq.network.Mask.Size()
+	}
+	if q.Action != "" {
+		cq.action = ParseVerb(q.Action)
+		if cq.action == ActionUnknown {
+			cq.action = Action(q.Action)
+		}
+		if _, exists := actionVerbs[cq.action]; !exists {
+			return nil, fmt.Errorf("'%v' is not a valid action", q.Action)
+		}
+	}
+	if q.Offset < 0 || q.Limit < 0 {
+		return nil, fmt.Errorf("offset and limit must not be negative")
+	}
+	cq.Limit = q.PageLimit()
+	cq.Actor = strings.ToLower(q.Actor)
+	cq.Text = strings.ToLower(q.Text)
+	return cq, nil
+}
+
+func (cq *compiledQuery) matches(evt Event) bool {
+	if cq.network != nil {
+		target := subnetmath.ParseNetworkCIDR(evt.Target)
+		if target == nil {
+			return false
+		}
+		if cq.IncludeDescendants {
+			ones, _ := target.Mask.Size()
+			if !cq.network.Contains(target.IP) || ones < cq.ones {
+				return false
+			}
+		} else if !subnetmath.NetworksAreIdentical(cq.network, target) {
+			return false
+		}
+	}
+	if cq.action != "" && evt.Action != cq.action {
+		return false
+	}
+	if cq.Actor != "" && !strings.Contains(strings.ToLower(evt.Who()), cq.Actor) {
+		return false
+	}
+	if !cq.Since.IsZero() && evt.Time.Before(cq.Since) {
+		return false
+	}
+	if !cq.Until.IsZero() && !evt.Time.Before(cq.Until) {
+		return false
+	}
+	if cq.Text != "" && !strings.Contains(strings.ToLower(evt.String()), cq.Text) {
+		return false
+	}
+	return true
+}
+
+// Search returns the matching events with the newest first along with the total number of matches
+func (r *UserActions) Search(q Query) (results []Event, total int, err error) {
+	cq, err := q.compile()
+	if err != nil {
+		return nil, 0, err
+	}
+	r.mtx.RLock()
+	defer r.mtx.RUnlock()
+	results = []Event{}
+	for i := len(r.events) - 1; i >= 0; i-- {
+		if !cq.matches(r.events[i]) {
+			continue
+		}
+		if total >= cq.Offset && len(results) < cq.Limit {
+			results = append(results, r.events[i])
+		}
+		total++
+	}
+	return results, total, nil
+}
+
+// ParseQueryTime accepts RFC3339, a plain date, or the original history.txt timestamp format
+func ParseQueryTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err == nil {
+		return t, nil
+	}
+	for _, layout := range []string{"2006-01-02", "2006-01-02T15:04:05", defaultTimeLayout} {
+		t, err = time.ParseInLocation(layout, s, time.Local)
+		if err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("'%v' is not a valid RFC3339 timestamp", s)
+}
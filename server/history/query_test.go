@@ -0,0 +1,148 @@
+package history
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/demskie/ipam/server/subnets"
+)
+
+var queryEpoch = time.Date(2019, time.April, 21, 12, 0, 0, 0, time.UTC)
+
+// newQueryHistory returns history with one event per hour in the order given
+func newQueryHistory(evts ...Event) *UserActions {
+	for i := range evts {
+		evts[i].ID = fmt.Sprint(i)
+		evts[i].Time = queryEpoch.Add(time.Duration(i) * time.Hour)
+		evts[i].After = &subnets.SubnetSkeleton{Net: evts[i].Target, Desc: "desc " + evts[i].Target}
+	}
+	r := NewUserActions()
+	r.OverwriteEvents(evts)
+	return r
+}
+
+func searchIDs(t *testing.T, r *UserActions, q Query) (string, int) {
+	t.Helper()
+	results, total, err := r.Search(q)
+	if err != nil {
+		t.Fatalf("expected %+v to be valid but got %v", q, err)
+	}
+	ids := ""
+	for _, evt := range results {
+		ids += evt.ID
+	}
+	return ids, total
+}
+
+func TestSearchFilters(t *testing.T) {
+	r := newQueryHistory(
+		Event{Actor: "alice", SourceIP: "192.0.2.1", Action: ActionCreateSubnet, Target: "10.0.0.0/8"},
+		Event{Actor: "Bob", Action: ActionCreateSubnet, Target: "10.1.0.0/16"},
+		Event{Actor: "alice", Action: ActionModifySubnet, Target: "10.1.0.0/16"},
+		Event{SourceIP: "::1", Action: ActionDeleteSubnet, Target: "10.1.2.0/24"},
+		Event{Actor: "carol", Action: ActionReserveHost, Target: "192.168.0.1/32"},
+		Event{Actor: "bob", Action: ActionCreateSubnet, Target: "2001:db8::/32"},
+	)
+	tests := []struct {
+		name     string
+		query    Query
+		expected string
+	}{
+		{"everything newest first", Query{}, "543210"},
+		{"exact cidr", Query{CIDR: "10.1.0.0/16"}, "21"},
+		{"descendants", Query{CIDR: "10.0.0.0/8", IncludeDescendants: true}, "3210"},
+		{"descendants exclude parents", Query{CIDR: "10.1.0.0/16", IncludeDescendants: true}, "321"},
+		{"ipv6 descendants", Query{CIDR: "2001::/16", IncludeDescendants: true}, "5"},
+		{"actor is case insensitive", Query{Actor: "BOB"}, "51"},
+		{"actor matches source address", Query{Actor: "192.0.2"}, "0"},
+		{"action name", Query{Action: "createSubnet"}, "510"},
+		{"action verb", Query{Action: "deleting subnet"}, "3"},
+		{"since is inclusive", Query{Since: queryEpoch.Add(4 * time.Hour)}, "54"},
+		{"until is exclusive", Query{Until: queryEpoch.Add(2 * time.Hour)}, "10"},
+		{"text matches the formatted line", Query{Text: "DESC 10.1.0"}, "21"},
+		{"combined", Query{CIDR: "10.0.0.0/8", IncludeDescendants: true, Actor: "alice", Action: "modifySubnet"}, "2"},
+		{"nothing matches", Query{Actor: "mallory"}, ""},
+	}
+	for _, test := range tests {
+		ids, total := searchIDs(t, r, test.query)
+		if ids != test.expected || total != len(test.expected) {
+			t.Fatalf("%v: expected '%v' but got '%v' with a total of %v", test.name, test.expected, ids, total)
+		}
+	}
+}
+
+func TestSearchPaginates(t *testing.T) {
+	evts := make([]Event, 10)
+	for i := range evts {
+		evts[i] = Event{Action: ActionCreateSubnet, Target: "10.0.0.0/24"}
+	}
+	r := newQueryHistory(evts...)
+	tests := []struct {
+		offset, limit int
+		expected      string
+	}{
+		{0, 3, "987"},
+		{3, 3, "654"},
+		{8, 3, "10"},
+		{10, 3, ""},
+		{5, 0, "43210"},
+	}
+	for _, test := range tests {
+		ids, total := searchIDs(t, r, Query{Offset: test.offset, Limit: test.limit})
+		if ids != test.expected || total != 10 {
+			t.Fatalf("expected offset %v limit %v to return '%v' of 10 but got '%v' of %v", test.offset, test.limit, test.expected, ids, total)
+		}
+	}
+}
+
+func TestSearchCapsLimit(t *testing.T) {
+	evts := make([]Event, MaxQueryLimit+5)
+	for i := range evts {
+		evts[i] = Event{Action: ActionCreateSubnet, Target: "10.0.0.0/24"}
+	}
+	r := newQueryHistory(evts...)
+	for _, limit := range []int{0, MaxQueryLimit + 1} {
+		q := Query{Limit: limit}
+		results, total, err := r.Search(q)
+		if err != nil || len(results) != MaxQueryLimit || total != len(evts) || q.PageLimit() != MaxQueryLimit {
+			t.Fatalf("expected limit %v to return %v of %v but got %v of %v (%v)", limit, MaxQueryLimit, len(evts), len(results), total, err)
+		}
+	}
+}
+
+func TestSearchRejectsInvalidQueries(t *testing.T) {
+	r := newQueryHistory()
+	for _, q := range []Query{
+		{CIDR: "10.0.0.0"},
+		{CIDR: "10.1.0.1/16"},
+		{CIDR: "not a network"},
+		{Action: "defragmenting"},
+		{Action: string(ActionUnknown) + "x"},
+		{Offset: -1},
+		{Limit: -1},
+	} {
+		if _, _, err := r.Search(q); err == nil {
+			t.Fatalf("expected %+v to be rejected", q)
+		}
+	}
+}
+
+func TestParseQueryTime(t *testing.T) {
+	valid := map[string]time.Time{
+		"":                     {},
+		"2019-04-21T12:00:00Z": queryEpoch,
+		"2019-04-21":           time.Date(2019, time.April, 21, 0, 0, 0, 0, time.Local),
+		"2019-04-21T12:00:00":  time.Date(2019, time.April, 21, 12, 0, 0, 0, time.Local),
+		"04-21-2019 12:00:00":  time.Date(2019, time.April, 21, 12, 0, 0, 0, time.Local),
+	}
+	for s, expected := range valid {
+		parsed, err := ParseQueryTime(s)
+		if err != nil || !parsed.Equal(expected) {
+			t.Fatalf("expected '%v' to be %v but got %v (%v)", s, expected, parsed, err)
+		}
+	}
+	if _, err := ParseQueryTime("yesterday"); err == nil {
+		t.Fatal("expected an invalid time to be rejected")
+	}
+}
@@ -0,0 +1,128 @@
+package history
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// RotatingFile is an io.WriteCloser that starts a new file once the current one is too large or too old
+type RotatingFile struct {
+	mtx        *sync.Mutex
+	path       string
+	maxSize    int64
+	maxAge     time.Duration
+	maxBackups int
+	file       *os.File
+	size       int64
+	opened     time.Time
+}
+
+// NewRotatingFile opens the file for appending. Zero values disable the corresponding limit.
+func NewRotatingFile(path string, maxSize int64, maxAge time.Duration, maxBackups int) (*RotatingFile, error) {
+	rf := &RotatingFile{
+		mtx:        &sync.Mutex{},
+		path:       path,
+		maxSize:    maxSize,
+		maxAge:     maxAge,
+		maxBackups: maxBackups,
+	}
+	return rf, rf.open()
+}
+
+func (rf *RotatingFile) open() error {
+	f, err := os.OpenFile(rf.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("unable to open log file > %v", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	rf.file = f
+	rf.size = info.Size()
+	rf.opened = time.Now()
+	if rf.size > 0 {
+		// ModTime is when the file was last written so the age comes from its first entry instead
+		if started, ok := firstEntryTime(rf.path); ok {
+			rf.opened = started
+		}
+	}
+	return nil
+}
+
+// firstEntryTime returns the time of the first JSON line in the file
+func firstEntryTime(path string) (time.Time, bool) {
+	f, err := os.Open(path)
+	if err != nil {
+		return time.Time{}, false
+	}
+	defer f.Close()
+	line, err := bufio.NewReader(f).ReadBytes('\n')
+	if err != nil && len(line) == 0 {
+		return time.Time{}, false
+	}
+	var e struct {
+		Time time.Time `json:"time"`
+	}
+	if json.Unmarshal(line, &e) != nil || e.Time.IsZero() {
+		return time.Time{}, false
+	}
+	return e.Time, true
+}
+
+// Write appends to the current file after rotating it if necessary
+func (rf *RotatingFile) Write(p []byte) (int, error) {
+	rf.mtx.Lock()
+	defer rf.mtx.Unlock()
+	if rf.file == nil {
+		return 0, os.ErrClosed
+	}
+	if rf.size > 0 && ((rf.maxSize > 0 && rf.size+int64(len(p)) > rf.maxSize) ||
+		(rf.maxAge > 0 && time.Since(rf.opened) > rf.maxAge)) {
+		err := rf.rotate()
+		if err != nil {
+			return 0, err
+		}
+	}
+	n, err := rf.file.Write(p)
+	rf.size += int64(n)
+	return n, err
+}
+
+func (rf *RotatingFile) rotate() error {
+	rf.file.Close()
+	rf.file = nil
+	backup := fmt.Sprintf("%v.%v", rf.path, time.Now().Format("20060102T150405.000000000"))
+	err := os.Rename(rf.path, backup)
+	if err != nil {
+		return fmt.Errorf("unable to rotate log file > %v", err)
+	}
+	if rf.maxBackups > 0 {
+		backups, _ := filepath.Glob(rf.path + ".*")
+		sort.Strings(backups)
+		for len(backups) > rf.maxBackups {
+			os.Remove(backups[0])
+			backups = backups[1:]
+		}
+	}
+	return rf.open()
+}
+
+// Close closes the current file
+func (rf *RotatingFile) Close() error {
+	rf.mtx.Lock()
+	defer rf.mtx.Unlock()
+	if rf.file == nil {
+		return nil
+	}
+	err := rf.file.Close()
+	rf.file = nil
+	return err
+}
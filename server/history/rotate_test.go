@@ -0,0 +1,87 @@
+package history
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func logFiles(t *testing.T, path string) []string {
+	t.Helper()
+	names, err := filepath.Glob(path + "*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return names
+}
+
+func TestRotatingFileRotatesBySize(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ipam.log")
+	rf, err := NewRotatingFile(path, 10, 0, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rf.Close()
+	for _, line := range []string{"aaaaaaaa\n", "bbbbbbbb\n", "cccccccc\n", "dddddddd\n"} {
+		if _, err := rf.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+		// backups are named by time so they must not share a timestamp
+		time.Sleep(time.Millisecond)
+	}
+	if files := logFiles(t, path); len(files) != 3 {
+		t.Fatalf("expected the current file and two backups but got %v", files)
+	}
+	b, _ := ioutil.ReadFile(path)
+	if string(b) != "dddddddd\n" {
+		t.Fatalf("expected only the newest line in the current file but got %q", b)
+	}
+}
+
+func TestRotatingFileAgeComesFromFirstEntry(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ipam.log")
+	started := time.Now().Add(-2 * time.Hour).UTC().Format(time.RFC3339Nano)
+	err := ioutil.WriteFile(path, []byte(`{"time":"`+started+`","level":"INFO","msg":"old"}`+"\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// writing the file just now must not make it look new
+	os.Chtimes(path, time.Now(), time.Now())
+	rf, err := NewRotatingFile(path, 0, time.Hour, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rf.Close()
+	if _, err = rf.Write([]byte("{}\n")); err != nil {
+		t.Fatal(err)
+	}
+	if files := logFiles(t, path); len(files) != 2 {
+		t.Fatalf("expected a file whose first entry is too old to be rotated but got %v", files)
+	}
+	b, _ := ioutil.ReadFile(path)
+	if strings.Contains(string(b), "old") {
+		t.Fatalf("expected the old entry to have moved to a backup but the current file contains %q", b)
+	}
+}
+
+func TestRotatingFileKeepsRecentFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ipam.log")
+	started := time.Now().UTC().Format(time.RFC3339Nano)
+	err := ioutil.WriteFile(path, []byte(`{"time":"`+started+`","level":"INFO","msg":"recent"}`+"\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Chtimes(path, time.Now().Add(-2*time.Hour), time.Now().Add(-2*time.Hour))
+	rf, err := NewRotatingFile(path, 0, time.Hour, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rf.Close()
+	rf.Write([]byte("{}\n"))
+	if files := logFiles(t, path); len(files) != 1 {
+		t.Fatalf("expected a file that was started recently to be kept but got %v", files)
+	}
+}
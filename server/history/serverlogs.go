@@ -1,76 +1,223 @@
 package history
 
 import (
-	"bytes"
+	"encoding/json"
+	"fmt"
 	"io"
 	"log"
 	"os"
+	"strings"
 	"sync"
+	"time"
+
+	"github.com/demskie/ipam/server/logging"
 )
 
-// ServerLogger is the object containing the threadsafe bytesBuffer
+var logger = logging.New(nil)
+
+// SetLogOutput redirects the lines logged while loading history
+func SetLogOutput(w io.Writer, flags int) {
+	logger.SetOutput(w, flags)
+}
+
+// Entry is a single structured server log record
+type Entry struct {
+	Time      time.Time     `json:"time"`
+	Level     logging.Level `json:"level"`
+	Message   string        `json:"msg"`
+	RequestID string        `json:"requestID,omitempty"`
+	RemoteIP  string        `json:"remoteIP,omitempty"`
+	Actor     string        `json:"actor,omitempty"`
+}
+
+// String formats the entry as a single human readable line
+func (e Entry) String() string {
+	line := fmt.Sprintf("%v %v %v", e.Time.Format("2006/01/02 15:04:05"), e.Level, e.Message)
+	if e.RequestID != "" {
+		line += " requestID=" + e.RequestID
+	}
+	if e.RemoteIP != "" {
+		line += " remoteIP=" + e.RemoteIP
+	}
+	if e.Actor != "" {
+		line += " actor=" + e.Actor
+	}
+	return line
+}
+
+const defaultRingSize = 20000
+
+// ServerLogger records log lines as structured entries and keeps the most recent ones in a ring buffer
 type ServerLogger struct {
-	buf     *protectedBuffer
-	wrSlice []io.Writer
+	mtx      *sync.Mutex
+	writeMtx *sync.Mutex
+	minLevel logging.Level
+	jsonOut  bool
+	stdout   io.Writer
+	file     io.WriteCloser
+	wrSlice  []io.Writer
+	handlers []func(Entry)
+	ring     []Entry
+	next     int
+	full     bool
 }
 
-// NewServerLogger copys all logging into a buffer that can be retrieved with GetString()
+// NewServerLogger returns a ServerLogger that other loggers may write their lines to
 func NewServerLogger() *ServerLogger {
-	pb := newProtectedBuffer()
-	wrSlice := []io.Writer{os.Stdout, pb}
-	log.SetOutput(io.MultiWriter(wrSlice...))
-	return &ServerLogger{pb, wrSlice}
+	s := &ServerLogger{
+		mtx:      &sync.Mutex{},
+		writeMtx: &sync.Mutex{},
+		minLevel: logging.LevelInfo,
+		stdout:   os.Stdout,
+		wrSlice:  []io.Writer{},
+		ring:     make([]Entry, defaultRingSize),
+	}
+	return s
 }
 
-// GetString returns the raw multiline string seen in stdout
-func (s *ServerLogger) GetString() string {
-	return s.buf.String()
+// CaptureStandardLogger redirects the standard logger so that every line becomes a structured entry
+func (s *ServerLogger) CaptureStandardLogger() {
+	log.SetFlags(0)
+	log.SetOutput(s)
 }
 
-// AddIOWriter could be used to log to remote syslog or local file
-func (s *ServerLogger) AddIOWriter(wr io.Writer) {
-	s.buf.m.Lock()
-	s.wrSlice = append(s.wrSlice, wr)
-	log.SetOutput(io.MultiWriter(s.wrSlice...))
-	s.buf.m.Unlock()
+// Write accepts lines from a logger that does not know their level and records them as info
+func (s *ServerLogger) Write(p []byte) (int, error) {
+	msg := strings.TrimRight(string(p), "\n")
+	s.Log(Entry{Level: logging.LevelInfo, Message: msg})
+	return len(p), nil
 }
 
-type protectedBuffer struct {
-	b bytes.Buffer
-	m sync.Mutex
+// Log records the entry and writes it to every output if it meets the minimum level
+func (s *ServerLogger) Log(e Entry) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	s.mtx.Lock()
+	if e.Level < s.minLevel {
+		s.mtx.Unlock()
+		return
+	}
+	s.ring[s.next] = e
+	s.next = (s.next + 1) % len(s.ring)
+	if s.next == 0 {
+		s.full = true
+	}
+	jsonOut, handlers := s.jsonOut, s.handlers
+	s.mtx.Unlock()
+	// outputs are written without holding mtx so that a slow one does not stall readers of the ring buffer
+	text := e.String() + "\n"
+	b, _ := json.Marshal(e)
+	b = append(b, '\n')
+	s.writeMtx.Lock()
+	if jsonOut {
+		s.stdout.Write(b)
+	} else {
+		io.WriteString(s.stdout, text)
+	}
+	if s.file != nil {
+		_, err := s.file.Write(b)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "unable to write to log file > %v\n", err)
+		}
+	}
+	for _, wr := range s.wrSlice {
+		io.WriteString(wr, text)
+	}
+	s.writeMtx.Unlock()
+	for _, handler := range handlers {
+		handler(e)
+	}
+}
+
+// Logf records a formatted message with the provided level and request fields
+func (s *ServerLogger) Logf(level logging.Level, requestID, remoteIP, actor, format string, v ...interface{}) {
+	s.Log(Entry{
+		Level:     level,
+		Message:   strings.TrimRight(fmt.Sprintf(format, v...), "\n"),
+		RequestID: requestID,
+		RemoteIP:  remoteIP,
+		Actor:     actor,
+	})
+}
+
+// SetLevel changes the minimum level that is recorded
+func (s *ServerLogger) SetLevel(level logging.Level) {
+	s.mtx.Lock()
+	s.minLevel = level
+	s.mtx.Unlock()
+}
+
+// SetJSONOutput switches stdout between human readable lines and JSON lines
+func (s *ServerLogger) SetJSONOutput(enabled bool) {
+	s.mtx.Lock()
+	s.jsonOut = enabled
+	s.mtx.Unlock()
 }
 
-func newProtectedBuffer() *protectedBuffer {
-	return &protectedBuffer{
-		b: *bytes.NewBuffer(make([]byte, 0)),
-		m: sync.Mutex{},
+// SetFile writes JSON lines to the file and closes any previous one
+func (s *ServerLogger) SetFile(file io.WriteCloser) {
+	s.writeMtx.Lock()
+	previous := s.file
+	s.file = file
+	s.writeMtx.Unlock()
+	if previous != nil {
+		previous.Close()
 	}
 }
 
-func (b *protectedBuffer) Read(p []byte) (n int, err error) {
-	b.m.Lock()
-	defer b.m.Unlock()
-	return b.b.Read(p)
+// AddIOWriter could be used to log to remote syslog or local file
+func (s *ServerLogger) AddIOWriter(wr io.Writer) {
+	s.writeMtx.Lock()
+	s.wrSlice = append(s.wrSlice, wr)
+	s.writeMtx.Unlock()
 }
 
-const megabyte = 1e6
-const limit = 8 * megabyte
-const shrinkFactor = 0.75
+// AddEntryHandler is called with every recorded entry and must not log through this ServerLogger
+func (s *ServerLogger) AddEntryHandler(handler func(Entry)) {
+	s.mtx.Lock()
+	s.handlers = append(append([]func(Entry){}, s.handlers...), handler)
+	s.mtx.Unlock()
+}
 
-// https://play.golang.org/p/RIN852Pj6wW
+// EntryQuery filters the entries returned by GetEntries
+type EntryQuery struct {
+	MinLevel logging.Level
+	Since    time.Time
+	Until    time.Time
+	Limit    int
+}
 
-func (b *protectedBuffer) Write(p []byte) (n int, err error) {
-	b.m.Lock()
-	defer b.m.Unlock()
-	if b.b.Len() >= limit {
-		start := b.b.Len() - (limit * shrinkFactor)
-		b.b = *bytes.NewBuffer(b.b.Bytes()[start:])
+// GetEntries returns the matching entries with the oldest first. Limit keeps the newest entries.
+func (s *ServerLogger) GetEntries(q EntryQuery) []Entry {
+	s.mtx.Lock()
+	ordered := make([]Entry, 0, len(s.ring))
+	if s.full {
+		ordered = append(ordered, s.ring[s.next:]...)
 	}
-	return b.b.Write(p)
+	ordered = append(ordered, s.ring[:s.next]...)
+	s.mtx.Unlock()
+	results := make([]Entry, 0, len(ordered))
+	for _, e := range ordered {
+		if e.Level < q.MinLevel ||
+			(!q.Since.IsZero() && e.Time.Before(q.Since)) ||
+			(!q.Until.IsZero() && e.Time.After(q.Until)) {
+			continue
+		}
+		results = append(results, e)
+	}
+	if q.Limit > 0 && len(results) > q.Limit {
+		results = results[len(results)-q.Limit:]
+	}
+	return results
 }
 
-func (b *protectedBuffer) String() string {
-	b.m.Lock()
-	defer b.m.Unlock()
-	return b.b.String()
+// GetString returns every buffered entry as human readable lines
+func (s *ServerLogger) GetString() string {
+	var sb strings.Builder
+	for _, e := range s.GetEntries(EntryQuery{}) {
+		sb.WriteString(e.String())
+		sb.WriteString("\n")
+	}
+	return sb.String()
 }
@@ -0,0 +1,107 @@
+package history
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/demskie/ipam/server/logging"
+)
+
+var epoch = time.Date(2019, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+func newTestServerLogger(ringSize int) *ServerLogger {
+	s := NewServerLogger()
+	s.stdout = ioutil.Discard
+	s.ring = make([]Entry, ringSize)
+	return s
+}
+
+func TestServerLoggerRingKeepsNewestEntries(t *testing.T) {
+	s := newTestServerLogger(3)
+	for i := 0; i < 5; i++ {
+		s.Logf(logging.LevelInfo, "", "", "", "entry %v", i)
+	}
+	entries := s.GetEntries(EntryQuery{})
+	if len(entries) != 3 || entries[0].Message != "entry 2" || entries[2].Message != "entry 4" {
+		t.Fatalf("expected entries 2 through 4 oldest first but got %+v", entries)
+	}
+	entries = s.GetEntries(EntryQuery{Limit: 2})
+	if len(entries) != 2 || entries[0].Message != "entry 3" {
+		t.Fatalf("expected the limit to keep the newest entries but got %+v", entries)
+	}
+}
+
+func TestServerLoggerFiltersEntries(t *testing.T) {
+	s := newTestServerLogger(10)
+	s.Log(Entry{Level: logging.LevelDebug, Message: "hidden"})
+	s.SetLevel(logging.LevelDebug)
+	s.Log(Entry{Time: epoch, Level: logging.LevelDebug, Message: "debug"})
+	s.Log(Entry{Time: epoch.Add(time.Minute), Level: logging.LevelWarn, Message: "warn"})
+	s.Log(Entry{Time: epoch.Add(2 * time.Minute), Level: logging.LevelError, Message: "error"})
+	if entries := s.GetEntries(EntryQuery{}); len(entries) != 3 {
+		t.Fatalf("expected entries below the minimum level to be dropped but got %+v", entries)
+	}
+	entries := s.GetEntries(EntryQuery{MinLevel: logging.LevelWarn, Until: epoch.Add(time.Minute)})
+	if len(entries) != 1 || entries[0].Message != "warn" {
+		t.Fatalf("expected only the warning but got %+v", entries)
+	}
+	entries = s.GetEntries(EntryQuery{Since: epoch.Add(90 * time.Second)})
+	if len(entries) != 1 || entries[0].Message != "error" {
+		t.Fatalf("expected only the error but got %+v", entries)
+	}
+}
+
+func TestServerLoggerWritesOutputs(t *testing.T) {
+	s := newTestServerLogger(10)
+	stdout, writer := &bytes.Buffer{}, &bytes.Buffer{}
+	s.stdout = stdout
+	s.AddIOWriter(writer)
+	s.Logf(logging.LevelWarn, "abc", "10.0.0.1", "alice", "something happened\n")
+	expected := "WARN something happened requestID=abc remoteIP=10.0.0.1 actor=alice\n"
+	if !bytes.HasSuffix(stdout.Bytes(), []byte(expected)) {
+		t.Fatalf("expected stdout to end with %q but got %q", expected, stdout.String())
+	}
+	if writer.String() != stdout.String() {
+		t.Fatalf("expected writers to receive %q but got %q", stdout.String(), writer.String())
+	}
+	stdout.Reset()
+	s.SetJSONOutput(true)
+	s.Logf(logging.LevelError, "", "", "", "failed")
+	if !bytes.Contains(stdout.Bytes(), []byte(`"level":"ERROR","msg":"failed"`)) {
+		t.Fatalf("expected a JSON line but got %q", stdout.String())
+	}
+}
+
+func TestServerLoggerHandlersMayReadEntries(t *testing.T) {
+	s := newTestServerLogger(10)
+	seen := make(chan int, 1)
+	s.AddEntryHandler(func(e Entry) {
+		// the ring buffer is not locked while outputs and handlers run
+		seen <- len(s.GetEntries(EntryQuery{}))
+	})
+	done := make(chan struct{})
+	go func() {
+		s.Logf(logging.LevelInfo, "", "", "", "hello")
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected a handler to be able to read entries without deadlocking")
+	}
+	if count := <-seen; count != 1 {
+		t.Fatalf("expected the handler to see its own entry but saw %v", count)
+	}
+}
+
+func TestServerLoggerStandardLinesAreInfo(t *testing.T) {
+	s := newTestServerLogger(10)
+	fmt.Fprintln(s, "unable to do anything")
+	entries := s.GetEntries(EntryQuery{})
+	if len(entries) != 1 || entries[0].Level != logging.LevelInfo {
+		t.Fatalf("expected lines without a level to be recorded as info but got %+v", entries)
+	}
+}
@@ -1,87 +1,167 @@
 package history
 
 import (
-	"fmt"
-	"log"
+	"bytes"
+	"crypto/ed25519"
 	"sort"
+	"strings"
 	"sync"
 	"time"
+
+	"github.com/demskie/ipam/server/logging"
 )
 
 // UserActions is used to contains a list of all changes
 type UserActions struct {
-	mtx   *sync.RWMutex
-	stack []string
+	mtx             *sync.RWMutex
+	events          []Event
+	signingKey      ed25519.PrivateKey
+	checkpointEvery int
+	migrated        bool // sealed from history.txt and not yet signed
+	log             *logging.Logger
 }
 
 // NewUserActions returns a new UserActions object
 func NewUserActions() *UserActions {
 	return &UserActions{
-		mtx:   &sync.RWMutex{},
-		stack: make([]string, 0),
+		mtx:    &sync.RWMutex{},
+		events: make([]Event, 0),
+		log:    logger,
 	}
 }
 
+// SetLogger replaces the logger that invalid history lines are reported to
+func (r *UserActions) SetLogger(log *logging.Logger) {
+	r.mtx.Lock()
+	r.log = log
+	r.mtx.Unlock()
+}
+
 const defaultTimeLayout string = "01-02-2006 15:04:05"
 
-// OverwriteUserHistory reinitializes the UserActions history
+// OverwriteUserHistory reinitializes the UserActions history from JSONL or legacy history.txt lines
 func (r *UserActions) OverwriteUserHistory(history []string) {
-	r.mtx.Lock()
-	r.stack = make([]string, 0, len(history))
+	r.mtx.RLock()
+	log := r.log
+	r.mtx.RUnlock()
+	evts := make([]Event, 0, len(history))
+	legacyOnly := true
 	for _, line := range history {
-		r.stack = append(r.stack, line)
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		evt, err := ParseLine(line)
+		if err != nil {
+			log.Warnf("caught an invalid userAction: '%v'\n", line)
+			continue
+		}
+		if strings.HasPrefix(strings.TrimSpace(line), "{") {
+			legacyOnly = false
+		}
+		evts = append(evts, evt)
+	}
+	r.OverwriteEvents(evts)
+	r.mtx.Lock()
+	r.migrated = false
+	r.mtx.Unlock()
+	if !legacyOnly || len(evts) == 0 {
+		// JSONL records are never resealed so that removing their hashes is reported by Verify
+		return
 	}
+	// history.txt predates the hash chain and is sealed once as it is migrated to JSONL
+	r.mtx.Lock()
+	r.seal(r.events)
+	r.migrated = r.signingKey == nil
 	r.mtx.Unlock()
 }
 
-// RecordUserAction adds the action to history and returns the resulting string
-func (r *UserActions) RecordUserAction(user, verb string, changes []string) string {
-	t := time.Now().Format(defaultTimeLayout)
+// OverwriteEvents reinitializes the UserActions history with structured events
+func (r *UserActions) OverwriteEvents(evts []Event) {
+	sorted := make([]Event, len(evts))
+	copy(sorted, evts)
+	// hash chained events are already in their authoritative order
+	if len(sorted) == 0 || sorted[0].Hash == "" {
+		sort.SliceStable(sorted, func(i, j int) bool {
+			return sorted[i].Time.Before(sorted[j].Time)
+		})
+	}
 	r.mtx.Lock()
-	r.stack = append([]string{fmt.Sprintf("%v (%v) is %v: %v", t, user, verb, changes)}, r.stack...)
+	r.events = sorted
 	r.mtx.Unlock()
-	message := fmt.Sprintf("(%v) is %v: %v\n", user, verb, changes)
-	log.Print(message)
-	return message
 }
 
-type sortHistory struct {
-	allLines []string
-	allTimes []time.Time
+// Record assigns an ID and timestamp to the event and appends it to history
+func (r *UserActions) Record(evt Event) Event {
+	evt.ID = newEventID()
+	evt.Time = time.Now().Round(0)
+	r.mtx.Lock()
+	evt.PrevHash, evt.Signature = "", ""
+	if len(r.events) > 0 {
+		evt.PrevHash = r.events[len(r.events)-1].Hash
+	}
+	evt.Hash = evt.computeHash()
+	if r.isCheckpoint(len(r.events)) {
+		evt.Signature = r.sign(evt.Hash)
+	}
+	r.events = append(r.events, evt)
+	r.mtx.Unlock()
+	return evt
 }
 
-func (s *sortHistory) Len() int {
-	return len(s.allLines)
+// Discard removes the newest event if it matches id so that a change which could not be saved is forgotten
+func (r *UserActions) Discard(id string) bool {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	if len(r.events) == 0 || r.events[len(r.events)-1].ID != id {
+		return false
+	}
+	r.events[len(r.events)-1] = Event{}
+	r.events = r.events[:len(r.events)-1]
+	return true
 }
 
-func (s *sortHistory) Swap(i, j int) {
-	s.allLines[i], s.allLines[j] = s.allLines[j], s.allLines[i]
-	s.allTimes[i], s.allTimes[j] = s.allTimes[j], s.allTimes[i]
+// GetAllUserActions returns all user actions formatted as history.txt lines with the newest first
+func (r *UserActions) GetAllUserActions() []string {
+	r.mtx.RLock()
+	defer r.mtx.RUnlock()
+	results := make([]string, len(r.events))
+	for i, evt := range r.events {
+		results[len(results)-1-i] = evt.String() + "\n"
+	}
+	return results
 }
 
-func (s *sortHistory) Less(i, j int) bool {
-	return s.allTimes[i].After(s.allTimes[j])
+// GetAllEvents returns a copy of every event with the oldest first
+func (r *UserActions) GetAllEvents() []Event {
+	r.mtx.RLock()
+	defer r.mtx.RUnlock()
+	results := make([]Event, len(r.events))
+	copy(results, r.events)
+	return results
 }
 
-// GetAllUserActions returns a sorted slice all user actions
-func (r *UserActions) GetAllUserActions() []string {
+// GetEvent returns the event with the matching ID
+func (r *UserActions) GetEvent(id string) (Event, bool) {
 	r.mtx.RLock()
 	defer r.mtx.RUnlock()
-	historySorter := &sortHistory{
-		allLines: make([]string, 0, len(r.stack)),
-		allTimes: make([]time.Time, 0, len(r.stack)),
-	}
-	for _, line := range r.stack {
-		if len(line) >= 19 {
-			t, err := time.Parse(defaultTimeLayout, line[:19])
-			if err == nil {
-				historySorter.allLines = append(historySorter.allLines, line+"\n")
-				historySorter.allTimes = append(historySorter.allTimes, t)
-			}
-		} else if len(line) > 0 {
-			log.Printf("caught an invalid userAction: '%v'\n", line)
+	for i := len(r.events) - 1; i >= 0; i-- {
+		if r.events[i].ID == id {
+			return r.events[i], true
 		}
 	}
-	sort.Sort(historySorter)
-	return historySorter.allLines
+	return Event{}, false
+}
+
+// ExportJSONL returns one JSON encoded event per line with the oldest first
+func (r *UserActions) ExportJSONL() []string {
+	r.mtx.RLock()
+	defer r.mtx.RUnlock()
+	results := make([]string, len(r.events))
+	buf := &bytes.Buffer{}
+	for i, evt := range r.events {
+		WriteJSONL(buf, []Event{evt})
+		results[i] = buf.String()
+		buf.Reset()
+	}
+	return results
 }
@@ -3,7 +3,6 @@ package server
 import (
 	"encoding/csv"
 	"fmt"
-	"log"
 	"net"
 	"strings"
 
@@ -18,7 +17,7 @@ func (ipam *IPAMServer) IngestSubnetCSVLines(csvlines []string) error {
 	subnetColumns := make([][]string, 0, len(csvlines))
 	for lineNum, line := range csvlines {
 		if lineNum == 0 && strings.Contains(line, "SUBNET,") {
-			log.Println("skipping line 0 as it appears to be the spreadsheet header")
+			ipam.log.Infof("skipping line 0 as it appears to be the spreadsheet header\n")
 			continue
 		}
 		val, err := csv.NewReader(strings.NewReader(line)).Read()
@@ -0,0 +1,393 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"path/filepath"
+	"time"
+
+	"github.com/davecgh/go-spew/spew"
+	"github.com/demskie/archive"
+	"github.com/gorilla/websocket"
+)
+
+const defaultShutdownTimeout = 30 * time.Second
+
+// WebOptions control what the HTTP servers serve and where they listen
+type WebOptions struct {
+	PublicDir    string
+	CrtPath      string
+	KeyPath      string
+	Debug        bool
+	InsecureAddr string
+	SecureAddr   string
+	DebugAddr    string
+}
+
+// SetWebOptions must be called before Start
+func (ipam *IPAMServer) SetWebOptions(opts WebOptions) {
+	ipam.lifecycleMtx.Lock()
+	defer ipam.lifecycleMtx.Unlock()
+	if opts.InsecureAddr == "" {
+		opts.InsecureAddr = ":80"
+	}
+	if opts.SecureAddr == "" {
+		opts.SecureAddr = ":443"
+	}
+	if opts.DebugAddr == "" {
+		opts.DebugAddr = ":8080"
+	}
+	ipam.webOptions = opts
+}
+
+type listeningServer struct {
+	srv *http.Server
+	ln  net.Listener
+	tls bool
+}
+
+// Start binds the HTTP servers and returns once they are accepting connections. Cancelling ctx is the same as calling Shutdown.
+// If Start fails then nothing is left listening and it may be called again.
+func (ipam *IPAMServer) Start(ctx context.Context) (err error) {
+	ipam.lifecycleMtx.Lock()
+	if ipam.started || ipam.ctx.Err() != nil {
+		ipam.lifecycleMtx.Unlock()
+		return fmt.Errorf("server has already been started or shut down")
+	}
+	ipam.started = true
+	opts := ipam.webOptions
+	ipam.lifecycleMtx.Unlock()
+	defer func() {
+		if err != nil {
+			ipam.lifecycleMtx.Lock()
+			ipam.started = false
+			ipam.lifecycleMtx.Unlock()
+		}
+	}()
+
+	// modify filepaths to match the environment's preferred filepath seperator
+	publicDir := filepath.Clean(opts.PublicDir)
+
+	// recurse through all static web content and create compressed copies
+	fileList, err := archive.CompressWebserverFiles(publicDir)
+	if err != nil {
+		ipam.log.Errorf("unable to compress static webserver files because: %v\n", err)
+	}
+	ipam.log.Debugf("compressed the following: %v", spew.Sdump(fileList))
+
+	secure := opts.CrtPath != "" && opts.KeyPath != ""
+	servers := []*listeningServer{}
+	if secure {
+		cert, err := tls.LoadX509KeyPair(filepath.Clean(opts.CrtPath), filepath.Clean(opts.KeyPath))
+		if err != nil {
+			return fmt.Errorf("unable to load certificate > %v", err)
+		}
+		srvSecure := ipam.newSecureServer(opts.SecureAddr, cert)
+		servers = append(servers, &listeningServer{srv: srvSecure, tls: true}, &listeningServer{srv: ipam.newRedirectServer(opts.InsecureAddr)})
+	} else {
+		servers = append(servers, &listeningServer{srv: ipam.newInsecureServer(opts.InsecureAddr)})
+	}
+	for i, ls := range servers {
+		ls.ln, err = net.Listen("tcp", ls.srv.Addr)
+		if err != nil {
+			for _, previous := range servers[:i] {
+				previous.ln.Close()
+			}
+			return fmt.Errorf("unable to listen on '%v' > %v", ls.srv.Addr, err)
+		}
+	}
+	// routes are only registered once the listeners are up so that a failed Start can be retried
+	ipam.registerRoutes(publicDir, secure)
+
+	// conditionally start debug server
+	if opts.Debug {
+		srvProf := ipam.newDebugServer(opts.DebugAddr)
+		ln, err := net.Listen("tcp", srvProf.Addr)
+		if err != nil {
+			ipam.log.Errorf("startDebugServer: %v\n", err)
+		} else {
+			servers = append(servers, &listeningServer{srv: srvProf, ln: ln})
+		}
+	}
+
+	ipam.lifecycleMtx.Lock()
+	for _, ls := range servers {
+		ipam.httpServers = append(ipam.httpServers, ls.srv)
+	}
+	ipam.lifecycleMtx.Unlock()
+	for _, ls := range servers {
+		go ipam.serve(ls)
+	}
+	go func() {
+		select {
+		case <-ctx.Done():
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), defaultShutdownTimeout)
+			defer cancel()
+			ipam.Shutdown(shutdownCtx)
+		case <-ipam.ctx.Done():
+		}
+	}()
+	return nil
+}
+
+func (ipam *IPAMServer) serve(ls *listeningServer) {
+	var err error
+	if ls.tls {
+		err = ls.srv.ServeTLS(ls.ln, "", "")
+	} else {
+		err = ls.srv.Serve(ls.ln)
+	}
+	if err != http.ErrServerClosed {
+		ipam.log.Errorf("http server on '%v' has stopped unexpectedly > %v\n", ls.srv.Addr, err)
+		go ipam.Shutdown(context.Background())
+	}
+}
+
+// Shutdown stops the HTTP servers, closes websockets, drains the ping workers and flushes everything that is persisted.
+// If ctx expires first the remaining connections are closed forcefully and ctx.Err() is returned.
+func (ipam *IPAMServer) Shutdown(ctx context.Context) error {
+	ipam.lifecycleMtx.Lock()
+	if ipam.stopping {
+		ipam.lifecycleMtx.Unlock()
+		<-ipam.stopped
+		return nil
+	}
+	ipam.stopping = true
+	servers := ipam.httpServers
+	ipam.lifecycleMtx.Unlock()
+	defer close(ipam.stopped)
+	ipam.log.Infof("shutting down\n")
+
+	// stop background workers along with long running requests such as event streams
+	ipam.cancel()
+
+	// stop accepting connections and wait for requests in progress
+	var result error
+	for _, srv := range servers {
+		err := srv.Shutdown(ctx)
+		if err != nil {
+			srv.Close()
+			result = err
+		}
+	}
+
+	// websockets are hijacked so they need to be told to go away separately
+	clients := ipam.sendWebsocketClose()
+
+	// wait for the ping workers and the sweep to finish what they are doing
+	if err := waitUntil(ctx, func() {
+		ipam.pinger.Stop()
+		ipam.workers.Wait()
+	}); err != nil {
+		result = err
+	}
+
+	// deliver what is already queued and abandon the rest once ctx expires
+	if err := ipam.webhooks.Close(ctx); err != nil {
+		ipam.log.Warnf("abandoned undelivered webhooks > %v\n", err)
+		result = err
+	}
+
+	// give clients a chance to acknowledge the close frame before hanging up on them
+	if err := waitUntil(ctx, ipam.wsActive.Wait); err != nil {
+		for _, conn := range clients {
+			conn.conn.Close()
+		}
+		result = err
+	}
+
+	// flush persistence
+	ipam.lifecycleMtx.RLock()
+	pingStatePath := ipam.pingStatePath
+	ipam.lifecycleMtx.RUnlock()
+	if pingStatePath != "" {
+		err := ipam.pinger.SaveState(pingStatePath)
+		if err != nil {
+			ipam.log.Errorf("unable to save ping results > %v\n", err)
+		}
+	}
+	ipam.storeMtx.Lock()
+	ipam.snapshotIfDue()
+	if ipam.store != nil {
+		err := ipam.store.Close()
+		if err != nil {
+			ipam.log.Errorf("unable to close store > %v\n", err)
+		}
+	}
+	ipam.storeMtx.Unlock()
+	ipam.auditMtx.Lock()
+	for _, wr := range append(ipam.auditSyslogs, ipam.diagSyslogs...) {
+		if err := wr.CloseContext(ctx); err != nil && err == ctx.Err() {
+			result = err
+		}
+	}
+	ipam.auditSyslogs, ipam.diagSyslogs = nil, nil
+	ipam.auditMtx.Unlock()
+	ipam.log.Infof("shutdown complete\n")
+	ipam.debug.SetFile(nil)
+	// closing the channel last lets callers of ServeAndReceiveChan exit knowing everything was flushed
+	ipam.lifecycleMtx.Lock()
+	if ipam.mutationChan != nil {
+		close(ipam.mutationChan)
+	}
+	ipam.lifecycleMtx.Unlock()
+	return result
+}
+
+// addWorker returns false if the server is shutting down
+func (ipam *IPAMServer) addWorker() bool {
+	ipam.lifecycleMtx.Lock()
+	defer ipam.lifecycleMtx.Unlock()
+	if ipam.stopping {
+		return false
+	}
+	ipam.workers.Add(1)
+	return true
+}
+
+func waitUntil(ctx context.Context, fn func()) error {
+	done := make(chan struct{})
+	go func() {
+		fn()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// trackWebsocket returns false if the server is shutting down
+func (ipam *IPAMServer) trackWebsocket(conn *wsClient) bool {
+	ipam.wsMtx.Lock()
+	defer ipam.wsMtx.Unlock()
+	if ipam.ctx.Err() != nil {
+		return false
+	}
+	ipam.wsClients[conn] = struct{}{}
+	ipam.wsActive.Add(1)
+	return true
+}
+
+func (ipam *IPAMServer) untrackWebsocket(conn *wsClient) {
+	ipam.wsMtx.Lock()
+	defer ipam.wsMtx.Unlock()
+	if _, exists := ipam.wsClients[conn]; exists {
+		delete(ipam.wsClients, conn)
+		ipam.wsActive.Done()
+	}
+}
+
+// sendWebsocketClose sends a close frame to every client and returns them
+func (ipam *IPAMServer) sendWebsocketClose() []*wsClient {
+	ipam.wsMtx.Lock()
+	clients := make([]*wsClient, 0, len(ipam.wsClients))
+	for conn := range ipam.wsClients {
+		clients = append(clients, conn)
+	}
+	ipam.wsMtx.Unlock()
+	msg := websocket.FormatCloseMessage(websocket.CloseGoingAway, "server is shutting down")
+	for _, conn := range clients {
+		conn.conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+	}
+	return clients
+}
+
+func (ipam *IPAMServer) registerRoutes(publicDir string, secure bool) {
+	ipam.mutationMtx.Lock()
+	defer ipam.mutationMtx.Unlock()
+	if !secure {
+		ipam.httpRouter.HandleFunc("/source", ipam.handleRestfulSubnets)
+	}
+	ipam.httpRouter.HandleFunc("/api/subnets", ipam.handleRestfulSubnets)
+	ipam.httpRouter.HandleFunc("/api/hosts", ipam.handleRestfulSpecificHosts)
+	ipam.httpRouter.HandleFunc("/api/hosts/{address}", ipam.handleRestfulHostDetail)
+	ipam.httpRouter.HandleFunc("/api/subnetsdiff", ipam.handleRestfulSubnetsDiff)
+	ipam.httpRouter.HandleFunc("/api/history", ipam.handleRestfulHistory)
+	ipam.httpRouter.HandleFunc("/api/history/verify", ipam.handleRestfulVerifyHistory)
+	ipam.httpRouter.HandleFunc("/api/createsubnet", ipam.handleRestfulCreateSubnet)
+	ipam.httpRouter.HandleFunc("/api/replacesubnet", ipam.handleRestfulReplaceSubnet)
+	ipam.httpRouter.HandleFunc("/api/deletesubnet", ipam.handleRestfulDeleteSubnet)
+	ipam.httpRouter.HandleFunc("/api/reservehost", ipam.handleRestfulReserveHost)
+	ipam.httpRouter.HandleFunc("/api/reservesubnet", ipam.handleRestfulReserveSubnet)
+	ipam.httpRouter.HandleFunc("/api/events", ipam.handleRestfulEvents)
+	ipam.httpRouter.HandleFunc("/api/webhooks", ipam.handleRestfulWebhooks)
+	ipam.httpRouter.HandleFunc("/api/createwebhook", ipam.handleRestfulCreateWebhook)
+	ipam.httpRouter.HandleFunc("/api/deletewebhook", ipam.handleRestfulDeleteWebhook)
+	ipam.httpRouter.HandleFunc("/api/webhookdeliveries", ipam.handleRestfulWebhookDeliveries)
+	ipam.httpRouter.HandleFunc("/api/revert/{eventID}", ipam.handleRestfulRevert)
+	ipam.httpRouter.HandleFunc("/api/sweep", ipam.handleRestfulSweep)
+	ipam.httpRouter.HandleFunc("/api/sweepsettings", ipam.handleRestfulSweepSettings)
+	ipam.httpRouter.HandleFunc("/api/probesettings", ipam.handleRestfulProbeSettings)
+	ipam.httpRouter.HandleFunc("/sync", ipam.handleWebsocketClient)
+	ipam.httpRouter.PathPrefix("/").Handler(archive.FileServer(http.Dir(publicDir)))
+}
+
+func (ipam *IPAMServer) baseContext(net.Listener) context.Context {
+	return ipam.ctx
+}
+
+func (ipam *IPAMServer) newDebugServer(addr string) *http.Server {
+	profmux := http.NewServeMux()
+	profmux.HandleFunc("/debug/pprof/", pprof.Index)
+	profmux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	profmux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	profmux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	profmux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	profmux.Handle("/debug/pprof/block", pprof.Handler("block"))
+	profmux.Handle("/debug/pprof/goroutine", pprof.Handler("goroutine"))
+	profmux.Handle("/debug/pprof/heap", pprof.Handler("heap"))
+	profmux.Handle("/debug/pprof/threadcreate", pprof.Handler("threadcreate"))
+	profmux.Handle("/debug/pprof/mutex", pprof.Handler("mutex"))
+	return &http.Server{
+		Addr:        addr,
+		Handler:     profmux,
+		BaseContext: ipam.baseContext,
+	}
+}
+
+func (ipam *IPAMServer) newSecureServer(addr string, cert tls.Certificate) *http.Server {
+	return &http.Server{
+		ReadTimeout:  60 * time.Second,
+		WriteTimeout: 60 * time.Second,
+		IdleTimeout:  60 * time.Second,
+		Addr:         addr,
+		Handler:      ipam.httpRouter,
+		BaseContext:  ipam.baseContext,
+		TLSConfig: &tls.Config{
+			MinVersion:               tls.VersionTLS12,
+			CurvePreferences:         []tls.CurveID{tls.CurveP521, tls.CurveP384, tls.CurveP256},
+			PreferServerCipherSuites: true,
+			CipherSuites:             nil, // use default
+			Certificates:             []tls.Certificate{cert},
+		},
+		TLSNextProto: nil,
+	}
+}
+
+func (ipam *IPAMServer) newRedirectServer(addr string) *http.Server {
+	return &http.Server{
+		Addr: addr,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Redirect(w, r, "https://"+r.Host+r.URL.String(), http.StatusMovedPermanently)
+		}),
+		BaseContext: ipam.baseContext,
+	}
+}
+
+func (ipam *IPAMServer) newInsecureServer(addr string) *http.Server {
+	return &http.Server{
+		Addr:         addr,
+		Handler:      ipam.httpRouter,
+		ReadTimeout:  60 * time.Second,
+		WriteTimeout: 60 * time.Second,
+		IdleTimeout:  60 * time.Second,
+		BaseContext:  ipam.baseContext,
+	}
+}
@@ -0,0 +1,94 @@
+package server
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func freeAddress(t *testing.T) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	return ln.Addr().String()
+}
+
+func TestStartCanBeRetriedAfterFailing(t *testing.T) {
+	ipam := newTestServer(t)
+	occupied, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer occupied.Close()
+	ipam.SetWebOptions(WebOptions{PublicDir: t.TempDir(), InsecureAddr: occupied.Addr().String()})
+	err = ipam.Start(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "unable to listen") {
+		t.Fatalf("expected listening on an occupied address to fail but got %v", err)
+	}
+	ipam.SetWebOptions(WebOptions{PublicDir: t.TempDir(), CrtPath: "missing.crt", KeyPath: "missing.key", SecureAddr: freeAddress(t)})
+	err = ipam.Start(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "unable to load certificate") {
+		t.Fatalf("expected a missing certificate to fail but got %v", err)
+	}
+	addr := freeAddress(t)
+	ipam.SetWebOptions(WebOptions{PublicDir: t.TempDir(), InsecureAddr: addr})
+	err = ipam.Start(context.Background())
+	if err != nil {
+		t.Fatalf("expected Start to succeed once the problems were fixed but got %v", err)
+	}
+	resp, err := http.Get("http://" + addr + "/api/subnets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected the routes to be served but got %v", resp.Status)
+	}
+	if ipam.Start(context.Background()) == nil {
+		t.Fatal("expected starting twice to be refused")
+	}
+}
+
+func TestShutdownIsFinal(t *testing.T) {
+	ipam := newTestServer(t)
+	addr := freeAddress(t)
+	ipam.SetWebOptions(WebOptions{PublicDir: t.TempDir(), InsecureAddr: addr})
+	err := ipam.Start(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ipam.Shutdown(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = http.Get("http://" + addr + "/api/subnets"); err == nil {
+		t.Fatal("expected the listener to be closed by Shutdown")
+	}
+	if err = ipam.Shutdown(context.Background()); err != nil {
+		t.Fatalf("expected shutting down twice to succeed but got %v", err)
+	}
+	if ipam.Start(context.Background()) == nil {
+		t.Fatal("expected starting after Shutdown to be refused")
+	}
+}
+
+func TestCancellingStartContextShutsDown(t *testing.T) {
+	ipam := newTestServer(t)
+	ipam.SetWebOptions(WebOptions{PublicDir: t.TempDir(), InsecureAddr: freeAddress(t)})
+	ctx, cancel := context.WithCancel(context.Background())
+	err := ipam.Start(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	select {
+	case <-ipam.stopped:
+	case <-time.After(10 * time.Second):
+		t.Fatal("expected cancelling the Start context to shut the server down")
+	}
+}
@@ -0,0 +1,145 @@
+package logging
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Level is the severity of a log entry
+type Level int
+
+// Levels
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var levelNames = []string{"DEBUG", "INFO", "WARN", "ERROR"}
+
+func (l Level) String() string {
+	if l >= LevelDebug && int(l) < len(levelNames) {
+		return levelNames[l]
+	}
+	return levelNames[LevelInfo]
+}
+
+// ParseLevel accepts a level name such as "debug" or "warn"
+func ParseLevel(s string) (Level, error) {
+	for i, name := range levelNames {
+		if strings.EqualFold(name, strings.TrimSpace(s)) {
+			return Level(i), nil
+		}
+	}
+	if strings.EqualFold(strings.TrimSpace(s), "warning") {
+		return LevelWarn, nil
+	}
+	return LevelInfo, fmt.Errorf("'%v' is not a valid log level", s)
+}
+
+// MarshalText encodes the level as its name
+func (l Level) MarshalText() ([]byte, error) {
+	return []byte(l.String()), nil
+}
+
+// UnmarshalText decodes a level name
+func (l *Level) UnmarshalText(b []byte) error {
+	level, err := ParseLevel(string(b))
+	*l = level
+	return err
+}
+
+// Sink records leveled messages along with the request they were logged for
+type Sink interface {
+	Logf(level Level, requestID, remoteIP, actor, format string, v ...interface{})
+}
+
+// Logger writes leveled messages to a Sink or to a standard logger when it has none
+type Logger struct {
+	mtx       *sync.RWMutex
+	sink      Sink
+	std       *log.Logger
+	parent    *Logger
+	requestID string
+	remoteIP  string
+	actor     string
+}
+
+// New returns a Logger that writes to sink or to stderr if sink is nil
+func New(sink Sink) *Logger {
+	return &Logger{
+		mtx:  &sync.RWMutex{},
+		sink: sink,
+		std:  log.New(os.Stderr, "", log.LstdFlags),
+	}
+}
+
+// SetOutput detaches the Sink and writes lines to w instead
+func (l *Logger) SetOutput(w io.Writer, flags int) {
+	l.mtx.Lock()
+	l.sink = nil
+	l.std = log.New(w, "", flags)
+	l.mtx.Unlock()
+}
+
+// SetSink writes every message to sink
+func (l *Logger) SetSink(sink Sink) {
+	l.mtx.Lock()
+	l.sink = sink
+	l.mtx.Unlock()
+}
+
+// With returns a Logger that records the request fields with every message
+func (l *Logger) With(requestID, remoteIP, actor string) *Logger {
+	return &Logger{parent: l, requestID: requestID, remoteIP: remoteIP, actor: actor}
+}
+
+func (l *Logger) logf(level Level, format string, v ...interface{}) {
+	if l == nil {
+		log.Printf("%v %v", level, strings.TrimRight(fmt.Sprintf(format, v...), "\n"))
+		return
+	}
+	root := l
+	for root.parent != nil {
+		root = root.parent
+	}
+	root.mtx.RLock()
+	sink, std := root.sink, root.std
+	root.mtx.RUnlock()
+	msg := strings.TrimRight(fmt.Sprintf(format, v...), "\n")
+	if sink != nil {
+		sink.Logf(level, l.requestID, l.remoteIP, l.actor, "%v", msg)
+		return
+	}
+	for _, field := range [][2]string{{"requestID", l.requestID}, {"remoteIP", l.remoteIP}, {"actor", l.actor}} {
+		if field[1] != "" {
+			msg += " " + field[0] + "=" + field[1]
+		}
+	}
+	std.Printf("%v %v\n", level, msg)
+}
+
+// Debugf logs routine details that are only useful while troubleshooting
+func (l *Logger) Debugf(format string, v ...interface{}) {
+	l.logf(LevelDebug, format, v...)
+}
+
+// Infof logs normal operation
+func (l *Logger) Infof(format string, v ...interface{}) {
+	l.logf(LevelInfo, format, v...)
+}
+
+// Warnf logs problems caused by clients or that the server recovered from
+func (l *Logger) Warnf(format string, v ...interface{}) {
+	l.logf(LevelWarn, format, v...)
+}
+
+// Errorf logs failures that need attention
+func (l *Logger) Errorf(format string, v ...interface{}) {
+	l.logf(LevelError, format, v...)
+}
@@ -0,0 +1,61 @@
+package logging
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+type recordingSink struct {
+	lines []string
+}
+
+func (s *recordingSink) Logf(level Level, requestID, remoteIP, actor, format string, v ...interface{}) {
+	s.lines = append(s.lines, fmt.Sprintf("%v|%v|%v|%v|%v", level, requestID, remoteIP, actor, fmt.Sprintf(format, v...)))
+}
+
+func TestLoggerLevels(t *testing.T) {
+	sink := &recordingSink{}
+	l := New(sink)
+	l.Debugf("a")
+	l.Infof("b")
+	l.Warnf("c\n")
+	l.Errorf("%v", "d")
+	expected := "[DEBUG||||a INFO||||b WARN||||c ERROR||||d]"
+	if fmt.Sprint(sink.lines) != expected {
+		t.Fatalf("expected %v but got %v", expected, sink.lines)
+	}
+}
+
+func TestLoggerWithRecordsRequestFields(t *testing.T) {
+	sink := &recordingSink{}
+	l := New(nil)
+	child := l.With("abc", "10.0.0.1", "alice")
+	l.SetSink(sink)
+	child.Infof("created")
+	if len(sink.lines) != 1 || sink.lines[0] != "INFO|abc|10.0.0.1|alice|created" {
+		t.Fatalf("expected the fields and the parent's sink to be used but got %v", sink.lines)
+	}
+}
+
+func TestLoggerWritesToOutputWithoutSink(t *testing.T) {
+	var buf bytes.Buffer
+	l := New(&recordingSink{})
+	l.SetOutput(&buf, 0)
+	l.With("abc", "", "bob").Warnf("careful")
+	if buf.String() != "WARN careful requestID=abc actor=bob\n" {
+		t.Fatalf("unexpected output %q", buf.String())
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	for input, expected := range map[string]Level{"debug": LevelDebug, " INFO ": LevelInfo, "warning": LevelWarn, "Error": LevelError} {
+		level, err := ParseLevel(input)
+		if err != nil || level != expected {
+			t.Fatalf("expected '%v' to be %v but got %v (%v)", input, expected, level, err)
+		}
+	}
+	if _, err := ParseLevel("loud"); err == nil {
+		t.Fatal("expected an unknown level to be rejected")
+	}
+}
@@ -0,0 +1,436 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/demskie/ipam/server/ping"
+	"github.com/demskie/subnetmath"
+	"github.com/gorilla/websocket"
+)
+
+// ManualScanLimits bound how much probing websocket clients are able to request
+type ManualScanLimits struct {
+	MaxAddresses       int     // addresses in a single request
+	MaxQueuedPerClient int     // requests waiting in the queue for a single client
+	ClientRate         float64 // addresses per second for a single client
+	ClientBurst        int
+	GlobalRate         float64 // addresses per second for every client combined
+	GlobalBurst        int
+}
+
+// DefaultManualScanLimits allow a client to scan a /20 at once and a /26 every second thereafter
+var DefaultManualScanLimits = ManualScanLimits{
+	MaxAddresses:       4096,
+	MaxQueuedPerClient: 4,
+	ClientRate:         64,
+	ClientBurst:        4096,
+	GlobalRate:         512,
+	GlobalBurst:        65536,
+}
+
+type manualScan struct {
+	conn      *wsClient
+	client    string
+	guid      string
+	networks  []*net.IPNet
+	addresses []string
+	stopped   chan struct{}
+	stopOnce  *sync.Once
+}
+
+func newManualScan(conn *wsClient, client, guid string, networks []*net.IPNet) *manualScan {
+	return &manualScan{
+		conn:     conn,
+		client:   client,
+		guid:     guid,
+		networks: networks,
+		stopped:  make(chan struct{}),
+		stopOnce: &sync.Once{},
+	}
+}
+
+// stop abandons the scan whether it is still queued or already running
+func (scan *manualScan) stop() {
+	scan.stopOnce.Do(func() {
+		close(scan.stopped)
+	})
+}
+
+func (scan *manualScan) contains(ip net.IP) bool {
+	for _, network := range scan.networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func (scan *manualScan) isStopped() bool {
+	select {
+	case <-scan.stopped:
+		return true
+	default:
+		return false
+	}
+}
+
+// scanQueue runs manual scans one at a time ahead of the background sweep
+type scanQueue struct {
+	mtx      *sync.Mutex
+	limits   ManualScanLimits
+	global   *tokenBucket
+	clients  map[string]*tokenBucket
+	pending  []*manualScan
+	running  *manualScan
+	inFlight map[string]*manualScan // the scan that will probe each queued address
+	wake     chan struct{}
+}
+
+func newScanQueue(limits ManualScanLimits) *scanQueue {
+	return &scanQueue{
+		mtx:      &sync.Mutex{},
+		limits:   limits,
+		global:   newTokenBucket(limits.GlobalRate, limits.GlobalBurst, time.Now()),
+		clients:  make(map[string]*tokenBucket, 0),
+		pending:  make([]*manualScan, 0),
+		inFlight: make(map[string]*manualScan, 0),
+		wake:     make(chan struct{}, 1),
+	}
+}
+
+type scanRejection struct {
+	errorType float64
+	message   string
+}
+
+func (r *scanRejection) Error() string {
+	return r.message
+}
+
+// SetManualScanLimits replaces the limits applied to manual scans requested over the websocket
+func (ipam *IPAMServer) SetManualScanLimits(limits ManualScanLimits) {
+	q := ipam.manualScans
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+	now := time.Now()
+	q.limits = limits
+	q.global = newTokenBucket(limits.GlobalRate, limits.GlobalBurst, now)
+	q.clients = make(map[string]*tokenBucket, 0)
+}
+
+// enqueue charges the client for addresses that are not already queued and queues the scan behind every other scan
+func (q *scanQueue) enqueue(scan *manualScan) error {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+	count := 0
+	for _, network := range scan.networks {
+		ones, bits := network.Mask.Size()
+		if bits-ones > 30 {
+			count = q.limits.MaxAddresses + 1
+			break
+		}
+		count += 1 << uint(bits-ones)
+	}
+	if count > q.limits.MaxAddresses {
+		return &scanRejection{ScanTooLarge, fmt.Sprintf("a manual scan may contain at most %v addresses", q.limits.MaxAddresses)}
+	}
+	for _, network := range scan.networks {
+		currentIP := subnetmath.DuplicateAddr(network.IP)
+		for network.Contains(currentIP) {
+			if q.inFlight[currentIP.String()] == nil {
+				scan.addresses = append(scan.addresses, currentIP.String())
+			}
+			currentIP = subnetmath.NextAddr(currentIP)
+		}
+	}
+	queued := 0
+	for _, other := range q.pending {
+		if other.client == scan.client {
+			queued++
+		}
+	}
+	if queued >= q.limits.MaxQueuedPerClient {
+		return &scanRejection{RateLimited, fmt.Sprintf("there are already %v manual scans queued for (%v)", queued, scan.client)}
+	}
+	now := time.Now()
+	for client, bucket := range q.clients {
+		if bucket.full(now) {
+			delete(q.clients, client)
+		}
+	}
+	bucket, exists := q.clients[scan.client]
+	if !exists {
+		bucket = newTokenBucket(q.limits.ClientRate, q.limits.ClientBurst, now)
+		q.clients[scan.client] = bucket
+	}
+	// scans whose addresses are already queued are free and complete once the scans ahead of them have finished
+	if bucket.exceeds(len(scan.addresses)) || q.global.exceeds(len(scan.addresses)) {
+		return &scanRejection{ScanTooLarge, fmt.Sprintf("a manual scan of %v addresses is larger than the burst allowed by the rate limit", len(scan.addresses))}
+	}
+	wait, ok := bucket.wait(len(scan.addresses), now)
+	globalWait, globalOK := q.global.wait(len(scan.addresses), now)
+	if !ok || !globalOK {
+		return &scanRejection{RateLimited, "manual scan rate exceeded and the rate limit does not refill"}
+	}
+	if globalWait > wait {
+		wait = globalWait
+	}
+	if wait > 0 {
+		return &scanRejection{RateLimited, fmt.Sprintf("manual scan rate exceeded so try again in %v", wait.Round(time.Second))}
+	}
+	bucket.take(len(scan.addresses))
+	q.global.take(len(scan.addresses))
+	for _, address := range scan.addresses {
+		q.inFlight[address] = scan
+	}
+	q.pending = append(q.pending, scan)
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// cancel removes every queued scan that was requested over conn and stops the one that is running
+func (q *scanQueue) cancel(conn *wsClient) {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+	if q.running != nil && q.running.conn == conn {
+		q.running.stop()
+	}
+	remaining := make([]*manualScan, 0, len(q.pending))
+	removed := make([]*manualScan, 0)
+	for _, scan := range q.pending {
+		if scan.conn == conn {
+			scan.stop()
+			removed = append(removed, scan)
+		} else {
+			remaining = append(remaining, scan)
+		}
+	}
+	q.pending = remaining
+	for _, scan := range removed {
+		q.release(scan)
+	}
+}
+
+// release hands every address that an abandoned scan was going to probe to the first queued scan that skipped it
+func (q *scanQueue) release(scan *manualScan) {
+	for _, address := range scan.addresses {
+		if q.inFlight[address] != scan {
+			continue
+		}
+		delete(q.inFlight, address)
+		ip := net.ParseIP(address)
+		for _, other := range q.pending {
+			if other == scan || other.isStopped() || !other.contains(ip) {
+				continue
+			}
+			other.addresses = append(other.addresses, address)
+			q.inFlight[address] = other
+			break
+		}
+	}
+}
+
+// nextManualScan blocks until a scan is queued and marks it as running
+func (ipam *IPAMServer) nextManualScan() (*manualScan, bool) {
+	q := ipam.manualScans
+	for {
+		q.mtx.Lock()
+		if len(q.pending) > 0 {
+			scan := q.pending[0]
+			q.pending[0] = nil
+			q.pending = q.pending[1:]
+			q.running = scan
+			q.mtx.Unlock()
+			return scan, true
+		}
+		q.mtx.Unlock()
+		select {
+		case <-q.wake:
+		case <-ipam.ctx.Done():
+			return nil, false
+		}
+	}
+}
+
+func (ipam *IPAMServer) finishManualScan(scan *manualScan) {
+	q := ipam.manualScans
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+	q.running = nil
+	if scan.isStopped() {
+		q.release(scan)
+		return
+	}
+	for _, address := range scan.addresses {
+		if q.inFlight[address] == scan {
+			delete(q.inFlight, address)
+		}
+	}
+}
+
+// runManualScans probes queued manual scans one at a time until Shutdown is called
+func (ipam *IPAMServer) runManualScans() {
+	defer ipam.workers.Done()
+	for {
+		scan, ok := ipam.nextManualScan()
+		if !ok {
+			return
+		}
+		ipam.sendManualScanPositions()
+		ipam.streamManualScan(scan)
+		ipam.finishManualScan(scan)
+	}
+}
+
+// streamManualScan sends each result to the client as it arrives followed by a completion message
+func (ipam *IPAMServer) streamManualScan(scan *manualScan) {
+	if len(scan.addresses) > 0 {
+		ipam.log.Infof("manually scanning %v addresses for (%v)\n", len(scan.addresses), scan.client)
+	}
+	total := len(scan.addresses)
+	progressMtx := &sync.Mutex{}
+	completed := 0
+	lastProgress := time.Now()
+	sendManualScanProgress(scan, completed, total)
+	ipam.pinger.ProbeNow(scan.addresses, ipam.demoModeBool, scan.stopped, func(address string, res ping.Result) {
+		if scan.isStopped() {
+			return
+		}
+		progressMtx.Lock()
+		completed++
+		count := completed
+		sendProgress := time.Since(lastProgress) > time.Second
+		if sendProgress {
+			lastProgress = time.Now()
+		}
+		progressMtx.Unlock()
+		outMsg := outboundManualPingScanResult{
+			Result:    ipam.newScanResult(address, res, 0),
+			Completed: count,
+			Total:     total,
+		}
+		outMsg.MessageType = ManualPingScanResult
+		outMsg.SessionGUID = scan.guid
+		b, err := json.Marshal(outMsg)
+		if err != nil {
+			ipam.log.Errorf("error encoding ManualPingScanResult for (%v)\n", scan.client)
+		} else {
+			scan.conn.WriteMessage(websocket.TextMessage, b)
+		}
+		if sendProgress {
+			sendManualScanProgress(scan, count, total)
+		}
+	})
+	if scan.isStopped() {
+		ipam.log.Infof("stopped manually scanning for (%v) as they have disconnected\n", scan.client)
+		return
+	}
+	progressMtx.Lock()
+	outMsg := outboundManualPingScanComplete{
+		Completed: completed,
+		Total:     total,
+		Cancelled: completed < total,
+	}
+	progressMtx.Unlock()
+	outMsg.MessageType = ManualPingScanComplete
+	outMsg.SessionGUID = scan.guid
+	for _, network := range scan.networks {
+		outMsg.Results = append(outMsg.Results, ipam.pinger.GetScanResults(network)...)
+	}
+	b, err := json.Marshal(outMsg)
+	if err != nil {
+		ipam.log.Errorf("error encoding ManualPingScanComplete for (%v)\n", scan.client)
+	} else {
+		scan.conn.WriteMessage(websocket.TextMessage, b)
+	}
+}
+
+func (ipam *IPAMServer) newScanResult(address string, res ping.Result, age time.Duration) ping.ScanResult {
+	return ping.ScanResult{
+		Address:         address,
+		Status:          res.Status,
+		Latency:         res.Latency,
+		Code:            res.Code,
+		Hostname:        ipam.getHostnames([]string{address})[0],
+		TimeSinceUpdate: int(age / time.Millisecond),
+	}
+}
+
+type outboundManualPingScanProgress struct {
+	baseMessage
+	Completed int `json:"completed"`
+	Total     int `json:"total"`
+}
+
+func sendManualScanProgress(scan *manualScan, completed, total int) {
+	outMsg := outboundManualPingScanProgress{Completed: completed, Total: total}
+	outMsg.MessageType = ManualPingScanProgress
+	outMsg.SessionGUID = scan.guid
+	b, err := json.Marshal(outMsg)
+	if err != nil {
+		scan.conn.log.Errorf("error encoding ManualPingScanProgress for (%v)\n", scan.client)
+	} else {
+		scan.conn.WriteMessage(websocket.TextMessage, b)
+	}
+}
+
+type outboundManualPingScanResult struct {
+	baseMessage
+	Result    ping.ScanResult `json:"result"`
+	Completed int             `json:"completed"`
+	Total     int             `json:"total"`
+}
+
+type outboundManualPingScanComplete struct {
+	baseMessage
+	Results   []ping.ScanResult `json:"results"`
+	Completed int               `json:"completed"`
+	Total     int               `json:"total"`
+	Cancelled bool              `json:"cancelled"`
+}
+
+type outboundManualPingScanQueue struct {
+	baseMessage
+	Position    int `json:"position"`
+	QueueLength int `json:"queueLength"`
+}
+
+func sendManualScanPosition(conn *wsClient, guid string, position, queueLength int) {
+	outMsg := outboundManualPingScanQueue{Position: position, QueueLength: queueLength}
+	outMsg.MessageType = ManualPingScanQueue
+	outMsg.SessionGUID = guid
+	b, err := json.Marshal(outMsg)
+	if err != nil {
+		conn.log.Errorf("error encoding ManualPingScanQueue for (%v)\n", conn.remoteIP)
+	} else {
+		conn.WriteMessage(websocket.TextMessage, b)
+	}
+}
+
+// sendManualScanPositions tells every client with a queued or running scan where it is in the queue
+func (ipam *IPAMServer) sendManualScanPositions() {
+	q := ipam.manualScans
+	q.mtx.Lock()
+	scans := make([]*manualScan, 0, len(q.pending)+1)
+	if q.running != nil {
+		scans = append(scans, q.running)
+	}
+	scans = append(scans, q.pending...)
+	queueLength := len(q.pending)
+	isRunning := q.running != nil
+	q.mtx.Unlock()
+	for i, scan := range scans {
+		position := i
+		if !isRunning {
+			position++
+		}
+		sendManualScanPosition(scan.conn, scan.guid, position, queueLength)
+	}
+}
@@ -0,0 +1,149 @@
+package server
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/demskie/subnetmath"
+)
+
+func newTestScan(conn *wsClient, client string, cidrs ...string) *manualScan {
+	networks := []*net.IPNet{}
+	for _, cidr := range cidrs {
+		networks = append(networks, subnetmath.ParseNetworkCIDR(cidr))
+	}
+	return newManualScan(conn, client, "guid", networks)
+}
+
+func expectRejection(t *testing.T, err error, errorType float64) {
+	t.Helper()
+	rejection, ok := err.(*scanRejection)
+	if !ok || rejection.errorType != errorType {
+		t.Fatalf("expected a rejection of type %v but got %v", errorType, err)
+	}
+}
+
+func TestScanQueueRejectsLargeScans(t *testing.T) {
+	limits := DefaultManualScanLimits
+	limits.MaxAddresses = 256
+	q := newScanQueue(limits)
+	expectRejection(t, q.enqueue(newTestScan(&wsClient{}, "10.1.1.1", "10.0.0.0/23")), ScanTooLarge)
+	expectRejection(t, q.enqueue(newTestScan(&wsClient{}, "10.1.1.1", "10.0.0.0/24", "10.0.1.0/32")), ScanTooLarge)
+	expectRejection(t, q.enqueue(newTestScan(&wsClient{}, "10.1.1.1", "::/64")), ScanTooLarge)
+	if err := q.enqueue(newTestScan(&wsClient{}, "10.1.1.1", "10.0.0.0/24")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestScanQueueLimitsQueuedScansPerClient(t *testing.T) {
+	limits := DefaultManualScanLimits
+	limits.MaxQueuedPerClient = 2
+	q := newScanQueue(limits)
+	conn := &wsClient{}
+	for _, cidr := range []string{"10.0.0.0/30", "10.0.1.0/30"} {
+		if err := q.enqueue(newTestScan(conn, "10.1.1.1", cidr)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expectRejection(t, q.enqueue(newTestScan(conn, "10.1.1.1", "10.0.2.0/30")), RateLimited)
+	if err := q.enqueue(newTestScan(&wsClient{}, "10.2.2.2", "10.0.2.0/30")); err != nil {
+		t.Fatalf("expected another client to be unaffected but got %v", err)
+	}
+}
+
+func TestScanQueueRateLimits(t *testing.T) {
+	limits := DefaultManualScanLimits
+	limits.ClientRate = 1
+	limits.ClientBurst = 256
+	q := newScanQueue(limits)
+	if err := q.enqueue(newTestScan(&wsClient{}, "10.1.1.1", "10.0.0.0/24")); err != nil {
+		t.Fatal(err)
+	}
+	expectRejection(t, q.enqueue(newTestScan(&wsClient{}, "10.1.1.1", "10.0.1.0/24")), RateLimited)
+	if err := q.enqueue(newTestScan(&wsClient{}, "10.1.1.1", "10.0.0.0/24")); err != nil {
+		t.Fatalf("expected addresses that are already queued to be free but got %v", err)
+	}
+
+	limits = DefaultManualScanLimits
+	limits.GlobalRate = 1
+	limits.GlobalBurst = 256
+	q = newScanQueue(limits)
+	if err := q.enqueue(newTestScan(&wsClient{}, "10.1.1.1", "10.0.0.0/24")); err != nil {
+		t.Fatal(err)
+	}
+	expectRejection(t, q.enqueue(newTestScan(&wsClient{}, "10.2.2.2", "10.0.1.0/24")), RateLimited)
+}
+
+func TestScanQueueCancelStopsScansForConnection(t *testing.T) {
+	q := newScanQueue(DefaultManualScanLimits)
+	conn, other := &wsClient{}, &wsClient{}
+	running := newTestScan(conn, "10.1.1.1", "10.0.0.0/30")
+	queued := newTestScan(conn, "10.1.1.1", "10.0.1.0/30")
+	kept := newTestScan(other, "10.2.2.2", "10.0.2.0/30")
+	for _, scan := range []*manualScan{running, queued, kept} {
+		if err := q.enqueue(scan); err != nil {
+			t.Fatal(err)
+		}
+	}
+	q.running, q.pending = q.pending[0], q.pending[1:]
+	q.cancel(conn)
+	if !running.isStopped() || !queued.isStopped() || kept.isStopped() {
+		t.Fatal("expected only the scans requested over the cancelled connection to stop")
+	}
+	if len(q.pending) != 1 || q.pending[0] != kept {
+		t.Fatalf("expected only the other client's scan to remain queued but got %v", len(q.pending))
+	}
+	if q.inFlight["10.0.1.1"] != nil || q.inFlight["10.0.2.1"] != kept {
+		t.Fatal("expected the cancelled addresses to no longer be in flight")
+	}
+}
+
+func TestScanQueueHandsCancelledAddressesToOverlappingScans(t *testing.T) {
+	q := newScanQueue(DefaultManualScanLimits)
+	conn, other := &wsClient{}, &wsClient{}
+	cancelled := newTestScan(conn, "10.1.1.1", "10.0.0.0/30")
+	survivor := newTestScan(other, "10.2.2.2", "10.0.0.0/29")
+	for _, scan := range []*manualScan{cancelled, survivor} {
+		if err := q.enqueue(scan); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(survivor.addresses) != 4 {
+		t.Fatalf("expected addresses that are already queued to be skipped but got %v", survivor.addresses)
+	}
+	q.cancel(conn)
+	if len(survivor.addresses) != 8 || q.inFlight["10.0.0.1"] != survivor {
+		t.Fatalf("expected the remaining scan to take over the cancelled addresses but got %v", survivor.addresses)
+	}
+}
+
+func TestScanQueueHandsStoppedScanAddressesToOverlappingScans(t *testing.T) {
+	ipam := &IPAMServer{manualScans: newScanQueue(DefaultManualScanLimits)}
+	q := ipam.manualScans
+	conn, other := &wsClient{}, &wsClient{}
+	running := newTestScan(conn, "10.1.1.1", "10.0.0.0/30")
+	survivor := newTestScan(other, "10.2.2.2", "10.0.0.0/30")
+	for _, scan := range []*manualScan{running, survivor} {
+		if err := q.enqueue(scan); err != nil {
+			t.Fatal(err)
+		}
+	}
+	q.running, q.pending = q.pending[0], q.pending[1:]
+	q.cancel(conn)
+	ipam.finishManualScan(running)
+	if len(survivor.addresses) != 4 || q.inFlight["10.0.0.2"] != survivor {
+		t.Fatalf("expected the remaining scan to probe what the stopped scan did not but got %v", survivor.addresses)
+	}
+}
+
+func TestScanQueueRejectsScansLargerThanBurst(t *testing.T) {
+	limits := DefaultManualScanLimits
+	limits.ClientBurst = 16
+	q := newScanQueue(limits)
+	err := q.enqueue(newTestScan(&wsClient{}, "10.1.1.1", "10.0.0.0/24"))
+	expectRejection(t, err, ScanTooLarge)
+	if strings.Contains(err.Error(), "try again") {
+		t.Fatalf("expected a scan that can never fit to not suggest a retry but got '%v'", err)
+	}
+}
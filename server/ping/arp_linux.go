@@ -0,0 +1,74 @@
+//go:build linux
+// +build linux
+
+package ping
+
+import (
+	"bufio"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	procNetARP      = "/proc/net/arp"
+	arpFlagComplete = 0x2
+	arpPollInterval = 50 * time.Millisecond
+)
+
+// Probe triggers neighbor resolution with a throwaway datagram and then polls the kernel ARP table
+func (a ARPProber) Probe(addr net.IP, timeout time.Duration) Result {
+	if addr.To4() == nil {
+		return Result{Status: StatusError, Error: "arp probing only supports IPv4 addresses"}
+	}
+	start := time.Now()
+	if complete, err := lookupARP(addr); err != nil {
+		return Result{Status: StatusError, Error: err.Error()}
+	} else if complete {
+		return Result{Status: StatusReachable, Latency: milliseconds(time.Since(start))}
+	}
+	// the discard port is used so that hosts which do answer are not bothered with anything meaningful
+	conn, err := net.DialTimeout("udp4", net.JoinHostPort(addr.String(), "9"), timeout)
+	if err != nil {
+		return classifyDialError(err, start)
+	}
+	conn.Write(nil)
+	conn.Close()
+	for time.Since(start) < timeout {
+		time.Sleep(arpPollInterval)
+		complete, err := lookupARP(addr)
+		if err != nil {
+			return Result{Status: StatusError, Error: err.Error()}
+		}
+		if complete {
+			return Result{Status: StatusReachable, Latency: milliseconds(time.Since(start))}
+		}
+	}
+	return Result{Status: StatusTimeout}
+}
+
+// lookupARP returns true if the kernel has a completed neighbor entry for addr
+func lookupARP(addr net.IP) (bool, error) {
+	f, err := os.Open(procNetARP)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	scanner.Scan() // header
+	for scanner.Scan() {
+		// IP address       HW type     Flags       HW address            Mask     Device
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 4 || !addr.Equal(net.ParseIP(fields[0])) {
+			continue
+		}
+		flags, err := strconv.ParseUint(fields[2], 0, 32)
+		if err != nil {
+			continue
+		}
+		return flags&arpFlagComplete != 0 && fields[3] != "00:00:00:00:00:00", nil
+	}
+	return false, scanner.Err()
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+// +build !linux
+
+package ping
+
+import (
+	"net"
+	"time"
+)
+
+// Probe is not supported outside of Linux
+func (a ARPProber) Probe(addr net.IP, timeout time.Duration) Result {
+	return Result{Status: StatusError, Error: "arp probing is only supported on linux"}
+}
@@ -0,0 +1,330 @@
+package ping
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/demskie/ipam/server/logging"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+const (
+	protocolICMP     = 1
+	protocolIPv6ICMP = 58
+	maxOutstanding   = 1 << 16
+)
+
+var (
+	errTooManySent = errors.New("too many outstanding echo requests")
+	echoPayload    = []byte("HELLO-R-U-THERE")
+)
+
+type echoReply struct {
+	received    time.Time
+	unreachable bool
+	code        int
+}
+
+// SocketMode controls whether raw or unprivileged datagram ICMP sockets are used
+type SocketMode int
+
+// SocketModes
+const (
+	// SocketAuto uses raw sockets when permitted and otherwise falls back to unprivileged sockets
+	SocketAuto SocketMode = iota
+	// SocketRaw requires CAP_NET_RAW or root
+	SocketRaw
+	// SocketUnprivileged uses SOCK_DGRAM ICMP sockets which on Linux requires net.ipv4.ping_group_range
+	SocketUnprivileged
+)
+
+// packetConn is the part of icmp.PacketConn used by the engine so that tests can provide their own
+type packetConn interface {
+	ReadFrom(b []byte) (int, net.Addr, error)
+	WriteTo(b []byte, dst net.Addr) (int, error)
+	Close() error
+}
+
+func listenICMP(network, address string) (packetConn, error) {
+	conn, err := icmp.ListenPacket(network, address)
+	if err != nil {
+		return nil, err
+	}
+	return conn, nil
+}
+
+type icmpSocket struct {
+	conn       packetConn
+	privileged bool
+}
+
+type pendingProbe struct {
+	addr  net.IP
+	reply chan echoReply
+}
+
+// icmpEngine shares one long lived socket per address family between every probe
+// and matches replies to their requests using the echo identifier and sequence number
+type icmpEngine struct {
+	mtx     *sync.Mutex
+	mode    SocketMode
+	id      int
+	seq     int
+	sockets map[bool]icmpSocket
+	pending map[int]*pendingProbe
+	log     *logging.Logger
+	listen  func(network, address string) (packetConn, error)
+}
+
+// engineCount gives every engine in the process its own echo identifier so that
+// raw sockets, which receive every reply, never claim another engine's replies
+var engineCount uint32
+
+func newICMPEngine() *icmpEngine {
+	offset := int(atomic.AddUint32(&engineCount, 1) - 1)
+	return &icmpEngine{
+		mtx:     &sync.Mutex{},
+		id:      (os.Getpid() + offset) & 0xffff,
+		sockets: make(map[bool]icmpSocket, 2),
+		pending: make(map[int]*pendingProbe, 0),
+		log:     logger,
+		listen:  listenICMP,
+	}
+}
+
+// SetLogger replaces the logger that socket fallbacks and failures are reported to
+func (e *icmpEngine) SetLogger(log *logging.Logger) {
+	e.mtx.Lock()
+	e.log = log
+	e.mtx.Unlock()
+}
+
+// SetMode closes any open sockets so that the next probe opens them using the new mode
+func (e *icmpEngine) SetMode(mode SocketMode) {
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+	e.mode = mode
+	e.closeSockets()
+}
+
+// Close releases the sockets which are reopened if another probe is sent
+func (e *icmpEngine) Close() {
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+	e.closeSockets()
+}
+
+// closeSockets must be called while holding mtx
+func (e *icmpEngine) closeSockets() {
+	for isIPv6, sock := range e.sockets {
+		delete(e.sockets, isIPv6)
+		sock.conn.Close()
+	}
+}
+
+// socket returns the open socket for the address family and must be called while holding mtx
+func (e *icmpEngine) socket(isIPv6 bool) (icmpSocket, error) {
+	sock, exists := e.sockets[isIPv6]
+	if exists {
+		return sock, nil
+	}
+	rawNetwork, dgramNetwork, address := "ip4:icmp", "udp4", "0.0.0.0"
+	if isIPv6 {
+		rawNetwork, dgramNetwork, address = "ip6:ipv6-icmp", "udp6", "::"
+	}
+	var rawErr, dgramErr error
+	if e.mode != SocketUnprivileged {
+		sock.conn, rawErr = e.listen(rawNetwork, address)
+		sock.privileged = rawErr == nil
+	}
+	if sock.conn == nil && e.mode != SocketRaw {
+		if rawErr != nil && !errors.Is(rawErr, os.ErrPermission) {
+			return sock, fmt.Errorf("icmp.ListenPacket(%v) %v", rawNetwork, rawErr)
+		}
+		sock.conn, dgramErr = e.listen(dgramNetwork, address)
+	}
+	switch {
+	case sock.conn != nil:
+	case rawErr != nil && dgramErr != nil:
+		return sock, fmt.Errorf("icmp.ListenPacket(%v) %v and icmp.ListenPacket(%v) %v", rawNetwork, rawErr, dgramNetwork, dgramErr)
+	case rawErr != nil:
+		return sock, fmt.Errorf("icmp.ListenPacket(%v) %v", rawNetwork, rawErr)
+	default:
+		return sock, fmt.Errorf("icmp.ListenPacket(%v) %v", dgramNetwork, dgramErr)
+	}
+	if e.mode == SocketAuto && !sock.privileged {
+		e.log.Warnf("raw %v sockets are not permitted so unprivileged %v sockets will be used instead\n", rawNetwork, dgramNetwork)
+	}
+	e.sockets[isIPv6] = sock
+	go e.receive(sock, isIPv6)
+	return sock, nil
+}
+
+// nextSeq returns an unused sequence number and must be called while holding mtx
+func (e *icmpEngine) nextSeq() (int, error) {
+	if len(e.pending) >= maxOutstanding {
+		return 0, errTooManySent
+	}
+	for {
+		e.seq = (e.seq + 1) & 0xffff
+		if _, exists := e.pending[e.seq]; !exists {
+			return e.seq, nil
+		}
+	}
+}
+
+// Ping sends a single echo request and waits for the matching reply or destination unreachable message
+func (e *icmpEngine) Ping(addr net.IP, timeout time.Duration) Result {
+	isIPv6 := addr.To4() == nil
+	e.mtx.Lock()
+	sock, err := e.socket(isIPv6)
+	if err != nil {
+		e.mtx.Unlock()
+		return Result{Status: StatusError, Error: err.Error()}
+	}
+	seq, err := e.nextSeq()
+	if err != nil {
+		e.mtx.Unlock()
+		return Result{Status: StatusError, Error: err.Error()}
+	}
+	probe := &pendingProbe{addr: addr, reply: make(chan echoReply, 1)}
+	e.pending[seq] = probe
+	e.mtx.Unlock()
+	defer func() {
+		e.mtx.Lock()
+		delete(e.pending, seq)
+		e.mtx.Unlock()
+	}()
+	msg := icmp.Message{
+		Type: ipv4.ICMPTypeEcho, Code: 0,
+		Body: &icmp.Echo{ID: e.id, Seq: seq, Data: echoPayload},
+	}
+	if isIPv6 {
+		msg.Type = ipv6.ICMPTypeEchoRequest
+	}
+	b, err := msg.Marshal(nil)
+	if err != nil {
+		return Result{Status: StatusError, Error: fmt.Sprintf("msg.Marshal() %v", err)}
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	var dest net.Addr = &net.IPAddr{IP: addr}
+	if !sock.privileged {
+		dest = &net.UDPAddr{IP: addr}
+	}
+	sent := time.Now()
+	_, err = sock.conn.WriteTo(b, dest)
+	if err != nil {
+		return Result{Status: StatusError, Error: fmt.Sprintf("conn.WriteTo() %v", err)}
+	}
+	select {
+	case r := <-probe.reply:
+		if r.unreachable {
+			return Result{Status: StatusUnreachable, Code: r.code}
+		}
+		return Result{Status: StatusReachable, Latency: float64(r.received.Sub(sent)) / float64(time.Millisecond)}
+	case <-timer.C:
+		return Result{Status: StatusTimeout}
+	}
+}
+
+func (e *icmpEngine) receive(sock icmpSocket, isIPv6 bool) {
+	proto := protocolICMP
+	if isIPv6 {
+		proto = protocolIPv6ICMP
+	}
+	buf := make([]byte, 1500)
+	for {
+		n, peer, err := sock.conn.ReadFrom(buf)
+		received := time.Now()
+		if err != nil {
+			// the next probe will open a new socket
+			e.mtx.Lock()
+			current, exists := e.sockets[isIPv6]
+			if exists && current.conn == sock.conn {
+				delete(e.sockets, isIPv6)
+				e.log.Errorf("closing icmp socket after read failure > %v\n", err)
+			}
+			e.mtx.Unlock()
+			sock.conn.Close()
+			return
+		}
+		msg, err := icmp.ParseMessage(proto, buf[:n])
+		if err != nil {
+			continue
+		}
+		switch body := msg.Body.(type) {
+		case *icmp.Echo:
+			// unprivileged sockets only receive their own replies and the kernel replaces the ID with the local port
+			if (msg.Type != ipv4.ICMPTypeEchoReply && msg.Type != ipv6.ICMPTypeEchoReply) ||
+				(sock.privileged && body.ID != e.id) {
+				continue
+			}
+			e.deliver(body.Seq, peerIP(peer), echoReply{received: received})
+		case *icmp.DstUnreach:
+			dest, id, seq, ok := parseQuotedEcho(body.Data, isIPv6)
+			if !ok || (sock.privileged && id != e.id) {
+				continue
+			}
+			e.deliver(seq, dest, echoReply{received: received, unreachable: true, code: msg.Code})
+		}
+	}
+}
+
+// parseQuotedEcho extracts our original echo request from the datagram quoted inside an ICMP error
+func parseQuotedEcho(data []byte, isIPv6 bool) (dest net.IP, id, seq int, ok bool) {
+	var echo []byte
+	if isIPv6 {
+		if len(data) < 48 || data[6] != protocolIPv6ICMP {
+			return nil, 0, 0, false
+		}
+		dest, echo = net.IP(data[24:40]), data[40:]
+		if echo[0] != byte(ipv6.ICMPTypeEchoRequest) {
+			return nil, 0, 0, false
+		}
+	} else {
+		if len(data) < 20 {
+			return nil, 0, 0, false
+		}
+		headerLen := int(data[0]&0x0f) * 4
+		if len(data) < headerLen+8 || data[9] != protocolICMP {
+			return nil, 0, 0, false
+		}
+		dest, echo = net.IP(data[16:20]), data[headerLen:]
+		if echo[0] != byte(ipv4.ICMPTypeEcho) {
+			return nil, 0, 0, false
+		}
+	}
+	id = int(binary.BigEndian.Uint16(echo[4:6]))
+	seq = int(binary.BigEndian.Uint16(echo[6:8]))
+	return dest, id, seq, true
+}
+
+func (e *icmpEngine) deliver(seq int, from net.IP, r echoReply) {
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+	probe, exists := e.pending[seq]
+	if !exists || !probe.addr.Equal(from) {
+		return
+	}
+	delete(e.pending, seq)
+	probe.reply <- r
+}
+
+func peerIP(addr net.Addr) net.IP {
+	switch a := addr.(type) {
+	case *net.IPAddr:
+		return a.IP
+	case *net.UDPAddr:
+		return a.IP
+	}
+	return nil
+}
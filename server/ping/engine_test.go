@@ -0,0 +1,320 @@
+package ping
+
+import (
+	"errors"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+type fakePacket struct {
+	b    []byte
+	from net.Addr
+}
+
+// fakeConn hands every echo request it is sent to respond and reads whatever respond queues
+type fakeConn struct {
+	network   string
+	packets   chan fakePacket
+	closed    chan struct{}
+	closeOnce *sync.Once
+	respond   func(conn *fakeConn, dst net.IP, echo *icmp.Echo)
+}
+
+func (c *fakeConn) privileged() bool {
+	return strings.HasPrefix(c.network, "ip")
+}
+
+func (c *fakeConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	select {
+	case p := <-c.packets:
+		return copy(b, p.b), p.from, nil
+	case <-c.closed:
+		return 0, nil, errors.New("use of closed network connection")
+	}
+}
+
+func (c *fakeConn) WriteTo(b []byte, dst net.Addr) (int, error) {
+	proto := protocolICMP
+	if strings.HasSuffix(c.network, "6") || strings.HasPrefix(c.network, "ip6") {
+		proto = protocolIPv6ICMP
+	}
+	msg, err := icmp.ParseMessage(proto, b)
+	if err != nil {
+		return 0, err
+	}
+	echo, ok := msg.Body.(*icmp.Echo)
+	if !ok {
+		return 0, errors.New("expected an echo request")
+	}
+	if c.respond != nil {
+		c.respond(c, peerIP(dst), echo)
+	}
+	return len(b), nil
+}
+
+func (c *fakeConn) Close() error {
+	c.closeOnce.Do(func() { close(c.closed) })
+	return nil
+}
+
+// reply queues an echo reply from the address using the socket's addressing
+func (c *fakeConn) reply(from net.IP, id, seq int) {
+	msg := icmp.Message{Type: ipv4.ICMPTypeEchoReply, Body: &icmp.Echo{ID: id, Seq: seq, Data: echoPayload}}
+	if from.To4() == nil {
+		msg.Type = ipv6.ICMPTypeEchoReply
+	}
+	b, _ := msg.Marshal(nil)
+	c.queue(from, b)
+}
+
+func (c *fakeConn) queue(from net.IP, b []byte) {
+	var addr net.Addr = &net.IPAddr{IP: from}
+	if !c.privileged() {
+		addr = &net.UDPAddr{IP: from}
+	}
+	c.packets <- fakePacket{b: b, from: addr}
+}
+
+// newFakeEngine returns an engine whose sockets are fakeConns and the networks it listened on
+func newFakeEngine(t *testing.T, respond func(conn *fakeConn, dst net.IP, echo *icmp.Echo)) (*icmpEngine, func() []string) {
+	e := newICMPEngine()
+	mtx := &sync.Mutex{}
+	networks := []string{}
+	e.listen = func(network, address string) (packetConn, error) {
+		mtx.Lock()
+		networks = append(networks, network)
+		mtx.Unlock()
+		return &fakeConn{
+			network:   network,
+			packets:   make(chan fakePacket, 256),
+			closed:    make(chan struct{}),
+			closeOnce: &sync.Once{},
+			respond:   respond,
+		}, nil
+	}
+	t.Cleanup(e.Close)
+	return e, func() []string {
+		mtx.Lock()
+		defer mtx.Unlock()
+		return append([]string{}, networks...)
+	}
+}
+
+func echoEverything(conn *fakeConn, dst net.IP, echo *icmp.Echo) {
+	conn.reply(dst, echo.ID, echo.Seq)
+}
+
+func TestEngineSharesOneSocketPerFamily(t *testing.T) {
+	e, networks := newFakeEngine(t, echoEverything)
+	for _, address := range []string{"192.0.2.1", "192.0.2.2", "2001:db8::1", "192.0.2.3", "2001:db8::2"} {
+		res := e.Ping(net.ParseIP(address), time.Second)
+		if res.Status != StatusReachable {
+			t.Fatalf("expected %v to be reachable but got %+v", address, res)
+		}
+	}
+	if opened := strings.Join(networks(), " "); opened != "ip4:icmp ip6:ipv6-icmp" {
+		t.Fatalf("expected a single raw socket per address family but opened '%v'", opened)
+	}
+	e.Close()
+	e.Ping(net.ParseIP("192.0.2.1"), time.Second)
+	if opened := strings.Join(networks(), " "); opened != "ip4:icmp ip6:ipv6-icmp ip4:icmp" {
+		t.Fatalf("expected the socket to be reopened after Close but opened '%v'", opened)
+	}
+}
+
+func TestEngineIgnoresRepliesForOthers(t *testing.T) {
+	e, _ := newFakeEngine(t, func(conn *fakeConn, dst net.IP, echo *icmp.Echo) {
+		switch dst.String() {
+		case "192.0.2.1":
+			// another process or engine sharing the raw socket
+			conn.reply(dst, echo.ID+1, echo.Seq)
+		case "192.0.2.2":
+			conn.reply(net.ParseIP("192.0.2.99"), echo.ID, echo.Seq)
+		case "192.0.2.3":
+			conn.reply(dst, echo.ID, echo.Seq+1)
+			conn.reply(dst, echo.ID, echo.Seq)
+		}
+	})
+	expected := map[string]Status{
+		"192.0.2.1": StatusTimeout,
+		"192.0.2.2": StatusTimeout,
+		"192.0.2.3": StatusReachable,
+	}
+	for address, status := range expected {
+		if res := e.Ping(net.ParseIP(address), 100*time.Millisecond); res.Status != status {
+			t.Fatalf("expected %v to be %v but got %+v", address, status, res)
+		}
+	}
+}
+
+func TestEngineMatchesConcurrentRepliesBySequence(t *testing.T) {
+	const count = 64
+	type request struct {
+		conn    *fakeConn
+		dst     net.IP
+		id, seq int
+	}
+	requests := make(chan request, count)
+	e, _ := newFakeEngine(t, func(conn *fakeConn, dst net.IP, echo *icmp.Echo) {
+		requests <- request{conn: conn, dst: dst, id: echo.ID, seq: echo.Seq}
+	})
+	results := make([]Result, count)
+	wg := &sync.WaitGroup{}
+	for i := 0; i < count; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i] = e.Ping(net.IPv4(198, 51, 100, byte(i)), 5*time.Second)
+		}(i)
+	}
+	// answer only once every probe is outstanding and in the opposite order they were sent
+	pending := make([]request, 0, count)
+	for len(pending) < count {
+		pending = append(pending, <-requests)
+	}
+	for i := len(pending) - 1; i >= 0; i-- {
+		pending[i].conn.reply(pending[i].dst, pending[i].id, pending[i].seq)
+	}
+	wg.Wait()
+	for i, res := range results {
+		if res.Status != StatusReachable {
+			t.Fatalf("expected probe %v to be matched to its reply but got %+v", i, res)
+		}
+	}
+}
+
+func TestEnginesUseDistinctIDs(t *testing.T) {
+	first, second := newICMPEngine(), newICMPEngine()
+	if first.id == second.id {
+		t.Fatalf("expected every engine to use its own echo identifier but both use %v", first.id)
+	}
+}
+
+// quotedEcho builds the start of an echo request datagram as it is quoted inside an ICMP error
+func quotedEcho(dst net.IP, id, seq int, options int) []byte {
+	echo := []byte{byte(ipv4.ICMPTypeEcho), 0, 0, 0, byte(id >> 8), byte(id), byte(seq >> 8), byte(seq)}
+	if dst.To4() == nil {
+		echo[0] = byte(ipv6.ICMPTypeEchoRequest)
+		header := make([]byte, 40)
+		header[0] = 6 << 4
+		header[6] = protocolIPv6ICMP
+		copy(header[8:24], net.ParseIP("2001:db8::ffff"))
+		copy(header[24:40], dst.To16())
+		return append(header, echo...)
+	}
+	header := make([]byte, 20+options)
+	header[0] = 4<<4 | byte(len(header)/4)
+	header[9] = protocolICMP
+	copy(header[12:16], net.IPv4(192, 0, 2, 254).To4())
+	copy(header[16:20], dst.To4())
+	return append(header, echo...)
+}
+
+func TestParseQuotedEcho(t *testing.T) {
+	v4, v6 := net.ParseIP("192.0.2.7"), net.ParseIP("2001:db8::7")
+	udp := quotedEcho(v4, 0x1234, 9, 0)
+	udp[9] = 17
+	reply := quotedEcho(v4, 0x1234, 9, 0)
+	reply[20] = byte(ipv4.ICMPTypeEchoReply)
+	v6Reply := quotedEcho(v6, 0x1234, 9, 0)
+	v6Reply[40] = byte(ipv6.ICMPTypeEchoReply)
+	tests := []struct {
+		name   string
+		data   []byte
+		isIPv6 bool
+		ok     bool
+		dest   net.IP
+	}{
+		{"ipv4", quotedEcho(v4, 0x1234, 9, 0), false, true, v4},
+		{"ipv4 with options", quotedEcho(v4, 0x1234, 9, 8), false, true, v4},
+		{"ipv4 truncated header", quotedEcho(v4, 0x1234, 9, 0)[:19], false, false, nil},
+		{"ipv4 truncated echo", quotedEcho(v4, 0x1234, 9, 8)[:32], false, false, nil},
+		{"ipv4 quoting udp", udp, false, false, nil},
+		{"ipv4 quoting an echo reply", reply, false, false, nil},
+		{"ipv6", quotedEcho(v6, 0x1234, 9, 0), true, true, v6},
+		{"ipv6 truncated", quotedEcho(v6, 0x1234, 9, 0)[:47], true, false, nil},
+		{"ipv6 quoting an echo reply", v6Reply, true, false, nil},
+		{"ipv4 parsed as ipv6", quotedEcho(v4, 0x1234, 9, 20), true, false, nil},
+	}
+	for _, test := range tests {
+		dest, id, seq, ok := parseQuotedEcho(test.data, test.isIPv6)
+		if ok != test.ok {
+			t.Fatalf("%v: expected ok to be %v but got %v", test.name, test.ok, ok)
+		}
+		if ok && (!dest.Equal(test.dest) || id != 0x1234 || seq != 9) {
+			t.Fatalf("%v: expected %v id 4660 seq 9 but got %v id %v seq %v", test.name, test.dest, dest, id, seq)
+		}
+	}
+}
+
+// unreachable answers every probe with a destination unreachable error quoting it
+func unreachable(code int) func(conn *fakeConn, dst net.IP, echo *icmp.Echo) {
+	return func(conn *fakeConn, dst net.IP, echo *icmp.Echo) {
+		msg := icmp.Message{Type: ipv4.ICMPTypeDestinationUnreachable, Code: code, Body: &icmp.DstUnreach{Data: quotedEcho(dst, echo.ID, echo.Seq, 0)}}
+		if dst.To4() == nil {
+			msg.Type = ipv6.ICMPTypeDestinationUnreachable
+		}
+		b, _ := msg.Marshal(nil)
+		conn.queue(net.ParseIP("192.0.2.254"), b)
+	}
+}
+
+func TestEngineReportsDestinationUnreachable(t *testing.T) {
+	e, _ := newFakeEngine(t, unreachable(1))
+	for _, address := range []string{"192.0.2.1", "2001:db8::1"} {
+		res := e.Ping(net.ParseIP(address), time.Second)
+		if res.Status != StatusUnreachable || res.Code != 1 {
+			t.Fatalf("expected %v to be unreachable with code 1 but got %+v", address, res)
+		}
+	}
+}
+
+func TestEngineFallsBackToUnprivilegedSockets(t *testing.T) {
+	tests := []struct {
+		name     string
+		mode     SocketMode
+		rawErr   error
+		networks string
+		status   Status
+	}{
+		{"raw permitted", SocketAuto, nil, "ip4:icmp", StatusReachable},
+		{"raw not permitted", SocketAuto, os.ErrPermission, "ip4:icmp udp4", StatusReachable},
+		{"raw failed for another reason", SocketAuto, errors.New("protocol not available"), "ip4:icmp", StatusError},
+		{"raw required", SocketRaw, os.ErrPermission, "ip4:icmp", StatusError},
+		{"unprivileged required", SocketUnprivileged, nil, "udp4", StatusReachable},
+	}
+	for _, test := range tests {
+		// the kernel replaces the identifier of unprivileged sockets so replies never match our own
+		e, networks := newFakeEngine(t, func(conn *fakeConn, dst net.IP, echo *icmp.Echo) {
+			id := echo.ID
+			if !conn.privileged() {
+				id++
+			}
+			conn.reply(dst, id, echo.Seq)
+		})
+		listen := e.listen
+		e.listen = func(network, address string) (packetConn, error) {
+			conn, err := listen(network, address)
+			if strings.HasPrefix(network, "ip") && test.rawErr != nil {
+				return nil, &net.OpError{Op: "listen", Net: network, Err: test.rawErr}
+			}
+			return conn, err
+		}
+		e.SetMode(test.mode)
+		res := e.Ping(net.ParseIP("192.0.2.1"), time.Second)
+		if res.Status != test.status {
+			t.Fatalf("%v: expected %v but got %+v", test.name, test.status, res)
+		}
+		if opened := strings.Join(networks(), " "); opened != test.networks {
+			t.Fatalf("%v: expected '%v' to be opened but got '%v'", test.name, test.networks, opened)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package ping
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	stateFormat  = "ipam-ping-state"
+	stateVersion = 1
+)
+
+type stateHeader struct {
+	Format  string    `json:"format"`
+	Version int       `json:"version"`
+	Saved   time.Time `json:"saved"`
+}
+
+type stateRecord struct {
+	Address        string        `json:"address"`
+	Last           Result        `json:"last"`
+	LastUpdate     time.Time     `json:"lastUpdate"`
+	FirstSeenAlive time.Time     `json:"firstSeenAlive"`
+	LastSeenAlive  time.Time     `json:"lastSeenAlive"`
+	Recent         []Observation `json:"recent,omitempty"`
+	Probes         int           `json:"probes"`
+	Replies        int           `json:"replies"`
+}
+
+// SaveState atomically writes every result to path as versioned JSON lines
+func (p *Pinger) SaveState(path string) error {
+	p.mtx.RLock()
+	records := make([]stateRecord, 0, len(p.data))
+	for address, val := range p.data {
+		if val.lastUpdateTime.IsZero() {
+			continue
+		}
+		records = append(records, stateRecord{
+			Address:        address,
+			Last:           val.last,
+			LastUpdate:     val.lastUpdateTime,
+			FirstSeenAlive: val.firstSeenAlive,
+			LastSeenAlive:  val.lastSeenAlive,
+			Recent:         val.recent,
+			Probes:         val.probes,
+			Replies:        val.replies,
+		})
+	}
+	p.mtx.RUnlock()
+	p.saveMtx.Lock()
+	defer p.saveMtx.Unlock()
+	f, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".")
+	if err != nil {
+		return fmt.Errorf("unable to save ping state > %v", err)
+	}
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	err = enc.Encode(stateHeader{Format: stateFormat, Version: stateVersion, Saved: time.Now()})
+	for i := 0; err == nil && i < len(records); i++ {
+		err = enc.Encode(records[i])
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return fmt.Errorf("unable to save ping state > %v", err)
+	}
+	return nil
+}
+
+// LoadState merges results previously written by SaveState. A missing file is not an error.
+func (p *Pinger) LoadState(path string) error {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("unable to load ping state > %v", err)
+	}
+	defer f.Close()
+	dec := json.NewDecoder(bufio.NewReader(f))
+	hdr := stateHeader{}
+	err = dec.Decode(&hdr)
+	if err != nil || hdr.Format != stateFormat {
+		return fmt.Errorf("'%v' is not a ping state file", path)
+	}
+	if hdr.Version != stateVersion {
+		return fmt.Errorf("ping state version %v is not supported", hdr.Version)
+	}
+	loaded := make(map[string]result, 0)
+	for dec.More() {
+		rec := stateRecord{}
+		err = dec.Decode(&rec)
+		if err != nil {
+			return fmt.Errorf("unable to parse ping state > %v", err)
+		}
+		if rec.FirstSeenAlive.IsZero() {
+			rec.Recent = nil
+		}
+		loaded[rec.Address] = result{
+			last:           rec.Last,
+			lastUpdateTime: rec.LastUpdate,
+			firstSeenAlive: rec.FirstSeenAlive,
+			lastSeenAlive:  rec.LastSeenAlive,
+			recent:         rec.Recent,
+			probes:         rec.Probes,
+			replies:        rec.Replies,
+		}
+	}
+	p.mtx.Lock()
+	for address, val := range loaded {
+		current, exists := p.data[address]
+		if !exists || current.lastUpdateTime.Before(val.lastUpdateTime) {
+			val.isInRequestChan = current.isInRequestChan
+			p.data[address] = val
+		}
+	}
+	p.mtx.Unlock()
+	return nil
+}
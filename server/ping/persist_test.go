@@ -0,0 +1,116 @@
+package ping
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var epoch = time.Date(2019, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+func recordResults(p *Pinger, address string, now time.Time, results ...Result) {
+	val := p.data[address]
+	for i, res := range results {
+		val.record(res, now.Add(time.Duration(i)*time.Minute))
+	}
+	p.data[address] = val
+}
+
+func TestStateRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ping.state")
+	p := NewPinger()
+	recordResults(p, "10.0.0.1", epoch,
+		Result{Status: StatusReachable, Latency: 1.5, Probe: "icmp"},
+		Result{Status: StatusTimeout},
+		Result{Status: StatusReachable, Latency: 2.5, Probe: "icmp"},
+	)
+	recordResults(p, "10.0.0.2", epoch, Result{Status: StatusUnreachable, Code: 3})
+	p.data["10.0.0.3"] = result{isInRequestChan: true}
+	err := p.SaveState(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded := NewPinger()
+	err = loaded.LoadState(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded.data) != 2 {
+		t.Fatalf("expected addresses that were never probed to be skipped but loaded %v", len(loaded.data))
+	}
+	for _, address := range []string{"10.0.0.1", "10.0.0.2"} {
+		want, got := p.data[address], loaded.data[address]
+		if got.last != want.last || got.probes != want.probes || got.replies != want.replies ||
+			!got.lastUpdateTime.Equal(want.lastUpdateTime) ||
+			!got.firstSeenAlive.Equal(want.firstSeenAlive) ||
+			!got.lastSeenAlive.Equal(want.lastSeenAlive) ||
+			len(got.recent) != len(want.recent) {
+			t.Fatalf("expected %+v but loaded %+v", want, got)
+		}
+		for i := range want.recent {
+			if got.recent[i].Result != want.recent[i].Result || !got.recent[i].Time.Equal(want.recent[i].Time) {
+				t.Fatalf("expected observation %+v but loaded %+v", want.recent[i], got.recent[i])
+			}
+		}
+	}
+}
+
+func TestLoadStateKeepsNewerResults(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ping.state")
+	p := NewPinger()
+	recordResults(p, "10.0.0.1", epoch, Result{Status: StatusReachable, Latency: 1})
+	recordResults(p, "10.0.0.2", epoch, Result{Status: StatusReachable, Latency: 1})
+	err := p.SaveState(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	current := NewPinger()
+	recordResults(current, "10.0.0.1", epoch.Add(time.Hour), Result{Status: StatusTimeout})
+	recordResults(current, "10.0.0.2", epoch.Add(-time.Hour), Result{Status: StatusTimeout})
+	val := current.data["10.0.0.2"]
+	val.isInRequestChan = true
+	current.data["10.0.0.2"] = val
+	err = current.LoadState(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if current.data["10.0.0.1"].last.Status != StatusTimeout {
+		t.Fatal("expected a result newer than the saved state to be kept")
+	}
+	if current.data["10.0.0.2"].last.Status != StatusReachable || !current.data["10.0.0.2"].isInRequestChan {
+		t.Fatal("expected an older result to be replaced while staying queued")
+	}
+}
+
+func TestLoadStateVersioning(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name    string
+		content string
+		reason  string
+	}{
+		{"future version", `{"format":"ipam-ping-state","version":2}` + "\n", "version 2 is not supported"},
+		{"other format", `{"format":"something-else","version":1}` + "\n", "is not a ping state file"},
+		{"empty", "", "is not a ping state file"},
+		{"corrupt record", `{"format":"ipam-ping-state","version":1}` + "\n{\"address\":\n", "unable to parse"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(dir, strings.Replace(test.name, " ", "-", -1))
+			err := ioutil.WriteFile(path, []byte(test.content), 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = NewPinger().LoadState(path)
+			if err == nil || !strings.Contains(err.Error(), test.reason) {
+				t.Fatalf("expected an error mentioning '%v' but got %v", test.reason, err)
+			}
+		})
+	}
+	err := NewPinger().LoadState(filepath.Join(dir, "missing"))
+	if err != nil {
+		t.Fatalf("expected a missing state file to be ignored but got %v", err)
+	}
+}
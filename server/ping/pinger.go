@@ -1,62 +1,164 @@
 package ping
 
 import (
-	"log"
+	"fmt"
+	"io"
 	"math"
+	"math/rand"
 	"net"
-	"strconv"
 	"sync"
 	"time"
 
+	"github.com/demskie/ipam/server/logging"
 	"github.com/demskie/randutil"
 
 	"github.com/demskie/simplesync"
 	"github.com/demskie/subnetmath"
 )
 
+var logger = logging.New(nil)
+
+// SetLogOutput redirects the lines logged while scanning
+func SetLogOutput(w io.Writer, flags int) {
+	logger.SetOutput(w, flags)
+}
+
 const (
 	defaultTimeLayout = "01-02-2006 15:04:05"
 	maximumHostCount  = 2048
 )
 
-// Pinger will scan a network using ICMP and remember the results
+// Pinger will scan a network using ICMP or the prober configured for each subnet and remember the results
 type Pinger struct {
-	mtx         *sync.RWMutex
-	data        map[string]result
-	requestChan chan string
+	mtx            *sync.RWMutex
+	saveMtx        *sync.Mutex
+	data           map[string]result
+	requestChan    chan string
+	priorityChan   chan probeRequest
+	statusCallback func(address string, reachable bool)
+	hostnameLookup func(addresses []string) []string
+	icmp           *icmpEngine
+	probers        []probeNetwork
+	done           chan struct{}
+	stopOnce       *sync.Once
+	running        *sync.WaitGroup
+	log            *logging.Logger
 }
 
 // NewPinger returns a new Pinger object
 func NewPinger() (ping *Pinger) {
 	ping = &Pinger{
-		mtx:         &sync.RWMutex{},
-		data:        make(map[string]result, 0),
-		requestChan: make(chan string, 0),
+		mtx:          &sync.RWMutex{},
+		saveMtx:      &sync.Mutex{},
+		data:         make(map[string]result, 0),
+		requestChan:  make(chan string, 0),
+		priorityChan: make(chan probeRequest, 0),
+		icmp:         newICMPEngine(),
+		done:         make(chan struct{}),
+		stopOnce:     &sync.Once{},
+		running:      &sync.WaitGroup{},
+		log:          logger,
 	}
 	return
 }
 
+// SetLogger replaces the logger that scans and socket failures are reported to
+func (p *Pinger) SetLogger(log *logging.Logger) {
+	p.mtx.Lock()
+	p.log = log
+	p.mtx.Unlock()
+	p.icmp.SetLogger(log)
+}
+
+// Stop cancels any scans in progress and waits for the background workers to finish their current probe
+func (p *Pinger) Stop() {
+	p.mtx.Lock()
+	p.stopOnce.Do(func() {
+		close(p.done)
+	})
+	p.mtx.Unlock()
+	p.running.Wait()
+	p.icmp.Close()
+}
+
+// SetSocketMode chooses between raw and unprivileged ICMP sockets
+func (p *Pinger) SetSocketMode(mode SocketMode) {
+	p.icmp.SetMode(mode)
+}
+
+// SetStatusChangeCallback is called whenever an address changes between reachable and unreachable
+func (p *Pinger) SetStatusChangeCallback(callback func(address string, reachable bool)) {
+	p.mtx.Lock()
+	p.statusCallback = callback
+	p.mtx.Unlock()
+}
+
+// SetHostnameLookup is used to fill in the hostname of each ScanResult
+func (p *Pinger) SetHostnameLookup(lookup func(addresses []string) []string) {
+	p.mtx.Lock()
+	p.hostnameLookup = lookup
+	p.mtx.Unlock()
+}
+
+func (p *Pinger) notifyStatusChange(address string, before, after result) {
+	if before.lastUpdateTime.IsZero() || after.lastUpdateTime.IsZero() {
+		return
+	}
+	wasReachable := before.last.Reachable()
+	isReachable := after.last.Reachable()
+	if wasReachable == isReachable {
+		return
+	}
+	p.mtx.RLock()
+	callback := p.statusCallback
+	p.mtx.RUnlock()
+	if callback != nil {
+		callback(address, isReachable)
+	}
+}
+
 type result struct {
-	lastLatency     int
+	last            Result
 	lastUpdateTime  time.Time
 	isInRequestChan bool
+	firstSeenAlive  time.Time
+	lastSeenAlive   time.Time
+	recent          []Observation
+	probes          int
+	replies         int
 }
 
-// ScanNetwork will inform the backgroundScanner to look at these IPs next
+// ScanNetwork will inform the backgroundScanner to probe every IP that is not already waiting to be probed
 func (p *Pinger) ScanNetwork(network *net.IPNet) {
-	log.Printf("scanning => %v\n", network.String())
+	p.mtx.RLock()
+	log := p.log
+	p.mtx.RUnlock()
+	log.Debugf("scanning => %v\n", network.String())
 	i := 0
 	currentIP := subnetmath.DuplicateAddr(network.IP)
 	for network.Contains(currentIP) {
 		p.mtx.Lock()
-		lastResult, exists := p.data[currentIP.String()]
-		if exists && !lastResult.isInRequestChan &&
-			time.Since(lastResult.lastUpdateTime) > 3*time.Minute+10*time.Second {
+		lastResult := p.data[currentIP.String()]
+		// how often an address is probed is decided by the sweep scheduler rather than here
+		due := !lastResult.isInRequestChan
+		if due {
 			lastResult.isInRequestChan = true
 			p.data[currentIP.String()] = lastResult
-			p.requestChan <- currentIP.String()
 		}
 		p.mtx.Unlock()
+		// the lock is released before blocking so results can be stored while the workers are busy
+		if due {
+			select {
+			case p.requestChan <- currentIP.String():
+			case <-p.done:
+				p.mtx.Lock()
+				lastResult = p.data[currentIP.String()]
+				lastResult.isInRequestChan = false
+				p.data[currentIP.String()] = lastResult
+				p.mtx.Unlock()
+				return
+			}
+		}
 		currentIP = subnetmath.NextAddr(currentIP)
 		if i > 1e5 {
 			break
@@ -67,26 +169,25 @@ func (p *Pinger) ScanNetwork(network *net.IPNet) {
 
 // ScanPretendNetwork is used faking a scan for testing and demonstration purposes
 func (p *Pinger) ScanPretendNetwork(network *net.IPNet) {
-	// log.Printf("scanning => %v\n", network.String())
+	// logger.Printf("scanning => %v\n", network.String())
 	i := 0
 	currentIP := subnetmath.DuplicateAddr(network.IP)
 	rnum := randutil.CreateUniqueMathRnum()
 	for network.Contains(currentIP) {
 		reachable := rnum.Float64() < 0.75
-		time.Sleep(25 * time.Millisecond)
+		select {
+		case <-time.After(25 * time.Millisecond):
+		case <-p.done:
+			return
+		}
 		p.mtx.Lock()
 		pingData := p.data[currentIP.String()]
-		if time.Since(pingData.lastUpdateTime) > 2*time.Minute {
-			if reachable {
-				pingData.lastLatency = int(30 + rnum.NormFloat64()*200)
-			} else {
-				pingData.lastLatency = math.MinInt32
-			}
-			pingData.lastUpdateTime = time.Now()
-			pingData.isInRequestChan = false
-			p.data[currentIP.String()] = pingData
-		}
+		before := pingData
+		pingData.record(pretendResult(reachable, rnum), time.Now())
+		pingData.isInRequestChan = false
+		p.data[currentIP.String()] = pingData
 		p.mtx.Unlock()
+		p.notifyStatusChange(currentIP.String(), before, pingData)
 		currentIP = subnetmath.NextAddr(currentIP)
 		if i > 1e5 {
 			break
@@ -95,64 +196,91 @@ func (p *Pinger) ScanPretendNetwork(network *net.IPNet) {
 	}
 }
 
-func ping(ip string) (reachable bool, foundError bool) {
+func pretendResult(reachable bool, rnum *rand.Rand) Result {
+	if reachable {
+		return Result{Status: StatusReachable, Latency: 1 + math.Abs(30+rnum.NormFloat64()*200)}
+	}
+	return Result{Status: StatusTimeout}
+}
+
+func (p *Pinger) ping(ip string) Result {
 	addr := net.ParseIP(ip)
-	if addr != nil {
-		for i := 0; i < 2; i++ {
-			var err error
-			if addr.To4() != nil {
-				reachable, err = pingICMPv4(addr, 3)
-			} else {
-				reachable, err = pingICMPv6(addr, 3)
-			}
-			foundError = false
-			if err != nil {
-				foundError = true
-			}
-			return reachable, foundError
-		}
+	if addr == nil {
+		return Result{Status: StatusError, Error: fmt.Sprintf("'%v' is not a valid address", ip)}
 	}
-	return false, true
+	prober := p.proberFor(addr)
+	res := prober.Probe(addr, 3*time.Second)
+	res.Probe = prober.Name()
+	return res
 }
 
-// InitializeBackgroundPinger will create the workers needed for scanning
+// InitializeBackgroundPinger will create the workers needed for scanning and block until Stop is called
 func (p *Pinger) InitializeBackgroundPinger(maxPingsPerSecond, goroutineCount int) {
+	p.mtx.Lock()
+	select {
+	case <-p.done:
+		p.mtx.Unlock()
+		return
+	default:
+	}
+	p.running.Add(1)
+	p.mtx.Unlock()
+	defer p.running.Done()
 	interval := time.Duration(int64(goroutineCount) * (int64(time.Second) / int64(maxPingsPerSecond)))
 	workers := simplesync.NewWorkerPool(goroutineCount)
+	defer workers.Delete()
 	workers.Execute(func(threadNum int) {
-		for ipString := range p.requestChan {
-			p.mtx.RLock()
-			pingData, exists := p.data[ipString]
-			p.mtx.RUnlock()
-			if !exists || time.Since(pingData.lastUpdateTime) > 2*time.Minute {
-				start := time.Now()
-				reachable, _ := ping(ipString) // TODO: provide ternary results
-				if reachable {
-					pingData.lastLatency = 1 + int(time.Since(start)/time.Millisecond)
-				} else {
-					pingData.lastLatency = math.MinInt32
+		rnum := randutil.CreateUniqueMathRnum()
+		for {
+			req, ok := p.nextRequest()
+			if !ok {
+				return
+			}
+			var res Result
+			if req.pretend {
+				select {
+				case <-time.After(25 * time.Millisecond):
+				case <-p.done:
+					return
 				}
-				pingData.lastUpdateTime = time.Now()
+				res = pretendResult(rnum.Float64() < 0.75, rnum)
+			} else {
+				res = p.ping(req.address)
 			}
-			pingData.isInRequestChan = false
+			// the result is recorded under a single lock so that a concurrent result for the same address is not lost
 			p.mtx.Lock()
-			p.data[ipString] = pingData
+			pingData := p.data[req.address]
+			before := pingData
+			pingData.record(res, time.Now())
+			pingData.isInRequestChan = false
+			p.data[req.address] = pingData
 			p.mtx.Unlock()
-			<-time.NewTimer(interval).C
+			p.notifyStatusChange(req.address, before, pingData)
+			if req.callback != nil {
+				req.callback(req.address, pingData.last)
+			}
+			if req.wg != nil {
+				req.wg.Done()
+			}
+			select {
+			case <-time.After(interval):
+			case <-p.done:
+				return
+			}
 		}
 	})
 }
 
 // GetPingResultsForAddresses returns ping results for slice of addresses
-func (p *Pinger) GetPingResultsForAddresses(addresses []string) []string {
-	results := make([]string, len(addresses))
+func (p *Pinger) GetPingResultsForAddresses(addresses []string) []Result {
+	results := make([]Result, len(addresses))
 	p.mtx.RLock()
 	for i := 0; i < len(addresses); i++ {
 		val, exists := p.data[addresses[i]]
-		if exists {
-			results[i] = strconv.Itoa(val.lastLatency) + "ms"
+		if exists && !val.lastUpdateTime.IsZero() {
+			results[i] = val.last
 		} else {
-			results[i] = ""
+			results[i] = Result{Status: StatusUnknown}
 		}
 	}
 	p.mtx.RUnlock()
@@ -177,10 +305,12 @@ func (p *Pinger) GetPingTimesForAddresses(addresses []string) []string {
 
 // ScanResult is used by the client side to display reachability info
 type ScanResult struct {
-	Address         string `json:"address"`
-	Latency         int    `json:"latency"`
-	Hostname        string `json:"hostname"`
-	TimeSinceUpdate int    `json:"timeSinceUpdate"`
+	Address         string  `json:"address"`
+	Status          Status  `json:"status"`
+	Latency         float64 `json:"latency"`
+	Code            int     `json:"code,omitempty"`
+	Hostname        string  `json:"hostname"`
+	TimeSinceUpdate int     `json:"timeSinceUpdate"`
 }
 
 // GetScanResults returns a string slice of host addresses and their reachability status
@@ -191,18 +321,18 @@ func (p *Pinger) GetScanResults(network *net.IPNet) (results []ScanResult) {
 	for network.Contains(currentIP) {
 		ipString := currentIP.String()
 		val, exists := p.data[ipString]
-		if exists {
+		if exists && !val.lastUpdateTime.IsZero() {
 			results = append(results, ScanResult{
 				Address:         ipString,
-				Latency:         val.lastLatency,
-				Hostname:        "", // TODO: implement!
+				Status:          val.last.Status,
+				Latency:         val.last.Latency,
+				Code:            val.last.Code,
 				TimeSinceUpdate: int(time.Since(val.lastUpdateTime) / time.Millisecond),
 			})
 		} else {
 			results = append(results, ScanResult{
 				Address:         ipString,
-				Latency:         math.MinInt32,
-				Hostname:        "",
+				Status:          StatusUnknown,
 				TimeSinceUpdate: math.MaxInt32,
 			})
 		}
@@ -212,6 +342,29 @@ func (p *Pinger) GetScanResults(network *net.IPNet) (results []ScanResult) {
 		}
 		i++
 	}
+	lookup := p.hostnameLookup
 	p.mtx.RUnlock()
+	if lookup != nil {
+		addresses := make([]string, len(results))
+		for i := range results {
+			addresses[i] = results[i].Address
+		}
+		for i, hostname := range lookup(addresses) {
+			results[i].Hostname = hostname
+		}
+	}
 	return results
 }
+
+// GetAliveAddresses returns every address that has replied at least once
+func (p *Pinger) GetAliveAddresses() []string {
+	addresses := []string{}
+	p.mtx.RLock()
+	for address, val := range p.data {
+		if !val.lastSeenAlive.IsZero() {
+			addresses = append(addresses, address)
+		}
+	}
+	p.mtx.RUnlock()
+	return addresses
+}
@@ -0,0 +1,64 @@
+package ping
+
+import (
+	"sync"
+)
+
+type probeRequest struct {
+	address  string
+	pretend  bool
+	callback func(address string, res Result)
+	wg       *sync.WaitGroup
+}
+
+// nextRequest prefers manual requests over the background sweep and returns false once Stop is called
+func (p *Pinger) nextRequest() (probeRequest, bool) {
+	select {
+	case req := <-p.priorityChan:
+		return req, true
+	case <-p.done:
+		return probeRequest{}, false
+	default:
+	}
+	select {
+	case req := <-p.priorityChan:
+		return req, true
+	case address := <-p.requestChan:
+		return probeRequest{address: address}, true
+	case <-p.done:
+		return probeRequest{}, false
+	}
+}
+
+// ProbeNow probes every address ahead of the background sweep no matter how recently it was probed.
+// The callback is called by the workers as each result arrives and ProbeNow returns once every address
+// has been probed, cancel is closed or Stop is called. Pretend results are generated when pretend is true.
+func (p *Pinger) ProbeNow(addresses []string, pretend bool, cancel <-chan struct{}, callback func(address string, res Result)) {
+	wg := &sync.WaitGroup{}
+	for _, address := range addresses {
+		wg.Add(1)
+		req := probeRequest{
+			address:  address,
+			pretend:  pretend,
+			callback: callback,
+			wg:       wg,
+		}
+		select {
+		case p.priorityChan <- req:
+		case <-cancel:
+			return
+		case <-p.done:
+			return
+		}
+	}
+	finished := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+	case <-cancel:
+	case <-p.done:
+	}
+}
@@ -0,0 +1,284 @@
+package ping
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Prober checks whether a single address is alive
+type Prober interface {
+	Name() string
+	Probe(addr net.IP, timeout time.Duration) Result
+}
+
+// Name identifies the probe in results
+func (e *icmpEngine) Name() string {
+	return "icmp"
+}
+
+// Probe sends an ICMP echo request over the shared socket
+func (e *icmpEngine) Probe(addr net.IP, timeout time.Duration) Result {
+	return e.Ping(addr, timeout)
+}
+
+// TCPProber considers a host alive if any port accepts or actively refuses a connection
+type TCPProber struct {
+	Ports []int
+}
+
+// Name identifies the probe in results
+func (t TCPProber) Name() string {
+	return "tcp"
+}
+
+// Probe attempts a TCP connection to each port in order until the host responds
+func (t TCPProber) Probe(addr net.IP, timeout time.Duration) Result {
+	if len(t.Ports) == 0 {
+		return Result{Status: StatusError, Error: "no tcp ports were configured"}
+	}
+	deadline := time.Now().Add(timeout)
+	res := Result{Status: StatusTimeout}
+	for _, port := range t.Ports {
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			break
+		}
+		start := time.Now()
+		conn, err := net.DialTimeout("tcp", net.JoinHostPort(addr.String(), strconv.Itoa(port)), remaining)
+		if err == nil {
+			conn.Close()
+			return Result{Status: StatusReachable, Latency: milliseconds(time.Since(start))}
+		}
+		res = classifyDialError(err, start)
+		if res.Status == StatusReachable || res.Status == StatusError {
+			return res
+		}
+	}
+	return res
+}
+
+// UDPProber sends a datagram to a port that is expected to be closed and treats
+// an ICMP port unreachable response or any reply as proof that the host is alive
+type UDPProber struct {
+	Port    int
+	Payload []byte
+}
+
+// DefaultUDPPort is the first traceroute port which is rarely listened on
+const DefaultUDPPort = 33434
+
+// Name identifies the probe in results
+func (u UDPProber) Name() string {
+	return "udp"
+}
+
+// Probe sends a single datagram and waits for a reply or an ICMP error
+func (u UDPProber) Probe(addr net.IP, timeout time.Duration) Result {
+	port := u.Port
+	if port == 0 {
+		port = DefaultUDPPort
+	}
+	start := time.Now()
+	conn, err := net.DialTimeout("udp", net.JoinHostPort(addr.String(), strconv.Itoa(port)), timeout)
+	if err != nil {
+		return Result{Status: StatusError, Error: err.Error()}
+	}
+	defer conn.Close()
+	conn.SetDeadline(start.Add(timeout))
+	payload := u.Payload
+	if payload == nil {
+		payload = echoPayload
+	}
+	if _, err = conn.Write(payload); err != nil {
+		return classifyDialError(err, start)
+	}
+	// a connected UDP socket reports ICMP port unreachable as ECONNREFUSED on the next read
+	_, err = conn.Read(make([]byte, 1500))
+	if err == nil {
+		return Result{Status: StatusReachable, Latency: milliseconds(time.Since(start))}
+	}
+	return classifyDialError(err, start)
+}
+
+// ARPProber resolves the hardware address of hosts on directly attached IPv4 segments
+type ARPProber struct{}
+
+// Name identifies the probe in results
+func (a ARPProber) Name() string {
+	return "arp"
+}
+
+// chainProber tries each prober in order and returns the first reachable result
+type chainProber []Prober
+
+// Chain combines probers so that a host is reachable if any of them succeed
+func Chain(probers ...Prober) Prober {
+	if len(probers) == 1 {
+		return probers[0]
+	}
+	return chainProber(probers)
+}
+
+// Name identifies the probe in results
+func (c chainProber) Name() string {
+	names := make([]string, len(c))
+	for i, prober := range c {
+		names[i] = prober.Name()
+	}
+	return strings.Join(names, "|")
+}
+
+// Probe divides the timeout between probers that have not answered yet
+func (c chainProber) Probe(addr net.IP, timeout time.Duration) Result {
+	res := Result{Status: StatusError, Error: "no probes were configured"}
+	deadline := time.Now().Add(timeout)
+	for _, prober := range c {
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			break
+		}
+		res = prober.Probe(addr, remaining)
+		if res.Reachable() {
+			break
+		}
+	}
+	return res
+}
+
+// ParseProber builds a prober from a specification such as "icmp", "arp", "udp", "udp:53"
+// or "tcp:22,80,443" where several specifications separated by "|" are tried in order
+func (p *Pinger) ParseProber(spec string) (Prober, error) {
+	probers := []Prober{}
+	for _, part := range strings.Split(spec, "|") {
+		part = strings.TrimSpace(part)
+		kind, args := part, ""
+		if i := strings.Index(part, ":"); i >= 0 {
+			kind, args = part[:i], part[i+1:]
+		}
+		ports := []int{}
+		for _, s := range strings.Split(args, ",") {
+			if s = strings.TrimSpace(s); s == "" {
+				continue
+			}
+			port, err := strconv.Atoi(s)
+			if err != nil || port < 1 || port > 65535 {
+				return nil, fmt.Errorf("'%v' is not a valid port in '%v'", s, part)
+			}
+			ports = append(ports, port)
+		}
+		switch strings.ToLower(kind) {
+		case "icmp":
+			probers = append(probers, p.icmp)
+		case "tcp":
+			if len(ports) == 0 {
+				return nil, fmt.Errorf("'%v' requires at least one port", part)
+			}
+			probers = append(probers, TCPProber{Ports: ports})
+		case "udp":
+			if len(ports) > 1 {
+				return nil, fmt.Errorf("'%v' accepts a single port", part)
+			}
+			u := UDPProber{}
+			if len(ports) == 1 {
+				u.Port = ports[0]
+			}
+			probers = append(probers, u)
+		case "arp":
+			probers = append(probers, ARPProber{})
+		default:
+			return nil, fmt.Errorf("'%v' is not a known probe type", kind)
+		}
+	}
+	return Chain(probers...), nil
+}
+
+type probeNetwork struct {
+	network *net.IPNet
+	spec    string
+	prober  Prober
+}
+
+// SetSubnetProber chooses how addresses inside network are probed where the most specific network wins
+func (p *Pinger) SetSubnetProber(network *net.IPNet, spec string) error {
+	prober, err := p.ParseProber(spec)
+	if err != nil {
+		return err
+	}
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	updated := []probeNetwork{{network: network, spec: spec, prober: prober}}
+	for _, pn := range p.probers {
+		if pn.network.String() != network.String() {
+			updated = append(updated, pn)
+		}
+	}
+	sort.SliceStable(updated, func(i, j int) bool {
+		iOnes, _ := updated[i].network.Mask.Size()
+		jOnes, _ := updated[j].network.Mask.Size()
+		return iOnes > jOnes
+	})
+	p.probers = updated
+	return nil
+}
+
+// ClearSubnetProber reverts network to the default ICMP probe
+func (p *Pinger) ClearSubnetProber(network *net.IPNet) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	updated := []probeNetwork{}
+	for _, pn := range p.probers {
+		if pn.network.String() != network.String() {
+			updated = append(updated, pn)
+		}
+	}
+	p.probers = updated
+}
+
+// GetSubnetProbers returns the probe specification of every configured network
+func (p *Pinger) GetSubnetProbers() map[string]string {
+	p.mtx.RLock()
+	defer p.mtx.RUnlock()
+	results := make(map[string]string, len(p.probers))
+	for _, pn := range p.probers {
+		results[pn.network.String()] = pn.spec
+	}
+	return results
+}
+
+func (p *Pinger) proberFor(addr net.IP) Prober {
+	p.mtx.RLock()
+	defer p.mtx.RUnlock()
+	for _, pn := range p.probers {
+		if pn.network.Contains(addr) {
+			return pn.prober
+		}
+	}
+	return p.icmp
+}
+
+func classifyDialError(err error, start time.Time) Result {
+	var netErr net.Error
+	switch {
+	case errors.Is(err, syscall.ECONNREFUSED), errors.Is(err, syscall.ECONNRESET):
+		// the host itself answered with a RST or an ICMP port unreachable
+		return Result{Status: StatusReachable, Latency: milliseconds(time.Since(start))}
+	case errors.Is(err, syscall.EHOSTUNREACH), errors.Is(err, syscall.ENETUNREACH):
+		return Result{Status: StatusUnreachable}
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return Result{Status: StatusTimeout}
+	case errors.Is(err, os.ErrDeadlineExceeded):
+		return Result{Status: StatusTimeout}
+	}
+	return Result{Status: StatusError, Error: err.Error()}
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
@@ -0,0 +1,183 @@
+package ping
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/demskie/subnetmath"
+)
+
+var loopback = net.ParseIP("127.0.0.1")
+
+// unusedPort returns a loopback port that nothing is listening on
+func unusedPort(t *testing.T, network string) int {
+	if network == "udp" {
+		conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		return conn.LocalAddr().(*net.UDPAddr).Port
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	return listener.Addr().(*net.TCPAddr).Port
+}
+
+func newTCPListener(t *testing.T) int {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	return listener.Addr().(*net.TCPAddr).Port
+}
+
+// newUDPListener returns the port of a socket that replies to every datagram if echo is true
+func newUDPListener(t *testing.T, echo bool) int {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if echo {
+				conn.WriteTo(buf[:n], addr)
+			}
+		}
+	}()
+	return conn.LocalAddr().(*net.UDPAddr).Port
+}
+
+func expectStatus(t *testing.T, res Result, status Status) {
+	t.Helper()
+	if res.Status != status {
+		t.Fatalf("expected %v but got %+v", status, res)
+	}
+}
+
+func TestTCPProber(t *testing.T) {
+	open, closed := newTCPListener(t), unusedPort(t, "tcp")
+	expectStatus(t, TCPProber{Ports: []int{open}}.Probe(loopback, time.Second), StatusReachable)
+	expectStatus(t, TCPProber{Ports: []int{closed}}.Probe(loopback, time.Second), StatusReachable)
+	expectStatus(t, TCPProber{Ports: []int{closed, open}}.Probe(loopback, time.Second), StatusReachable)
+	expectStatus(t, TCPProber{}.Probe(loopback, time.Second), StatusError)
+	expectStatus(t, TCPProber{Ports: []int{open}}.Probe(loopback, 0), StatusTimeout)
+}
+
+func TestUDPProber(t *testing.T) {
+	expectStatus(t, UDPProber{Port: newUDPListener(t, true)}.Probe(loopback, time.Second), StatusReachable)
+	expectStatus(t, UDPProber{Port: unusedPort(t, "udp")}.Probe(loopback, time.Second), StatusReachable)
+	start := time.Now()
+	expectStatus(t, UDPProber{Port: newUDPListener(t, false)}.Probe(loopback, 100*time.Millisecond), StatusTimeout)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("expected the probe to give up after its timeout but it took %v", elapsed)
+	}
+}
+
+type fakeProber struct {
+	name   string
+	status Status
+	calls  *int
+}
+
+func (f fakeProber) Name() string {
+	return f.name
+}
+
+func (f fakeProber) Probe(addr net.IP, timeout time.Duration) Result {
+	*f.calls++
+	return Result{Status: f.status}
+}
+
+func TestChainStopsAtFirstReachable(t *testing.T) {
+	calls := 0
+	chain := Chain(
+		fakeProber{"a", StatusTimeout, &calls},
+		fakeProber{"b", StatusReachable, &calls},
+		fakeProber{"c", StatusReachable, &calls},
+	)
+	if chain.Name() != "a|b|c" {
+		t.Fatalf("unexpected name '%v'", chain.Name())
+	}
+	expectStatus(t, chain.Probe(loopback, time.Second), StatusReachable)
+	if calls != 2 {
+		t.Fatalf("expected the chain to stop after the second prober but it called %v", calls)
+	}
+	calls = 0
+	chain = Chain(fakeProber{"a", StatusTimeout, &calls}, fakeProber{"b", StatusUnreachable, &calls})
+	expectStatus(t, chain.Probe(loopback, time.Second), StatusUnreachable)
+}
+
+func TestParseProber(t *testing.T) {
+	p := NewPinger()
+	valid := map[string]string{
+		"icmp":               "icmp",
+		"tcp:22, 80,443":     "tcp",
+		"udp":                "udp",
+		"udp:53":             "udp",
+		"ARP":                "arp",
+		"tcp:22|udp:53|icmp": "tcp|udp|icmp",
+	}
+	for spec, name := range valid {
+		prober, err := p.ParseProber(spec)
+		if err != nil {
+			t.Fatalf("expected '%v' to be valid but got %v", spec, err)
+		}
+		if prober.Name() != name {
+			t.Fatalf("expected '%v' to be named '%v' but got '%v'", spec, name, prober.Name())
+		}
+	}
+	if prober, _ := p.ParseProber("udp:53"); prober.(UDPProber).Port != 53 {
+		t.Fatal("expected the udp port to be used")
+	}
+	for _, spec := range []string{"", "tcp", "tcp:0", "tcp:65536", "tcp:ssh", "udp:53,54", "icmp|bogus"} {
+		_, err := p.ParseProber(spec)
+		if err == nil {
+			t.Fatalf("expected '%v' to be rejected", spec)
+		}
+	}
+}
+
+func TestSubnetProberMostSpecificWins(t *testing.T) {
+	p := NewPinger()
+	wide, narrow := subnetmath.ParseNetworkCIDR("10.0.0.0/8"), subnetmath.ParseNetworkCIDR("10.1.0.0/16")
+	for network, spec := range map[*net.IPNet]string{narrow: "tcp:22", wide: "udp"} {
+		err := p.SetSubnetProber(network, spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := map[string]string{"10.1.2.3": "tcp", "10.2.0.1": "udp", "192.168.0.1": "icmp"}
+	for address, name := range tests {
+		if prober := p.proberFor(net.ParseIP(address)); prober.Name() != name {
+			t.Fatalf("expected %v to use '%v' but got '%v'", address, name, prober.Name())
+		}
+	}
+	p.ClearSubnetProber(narrow)
+	if prober := p.proberFor(net.ParseIP("10.1.2.3")); prober.Name() != "udp" {
+		t.Fatalf("expected the wider network to apply once cleared but got '%v'", prober.Name())
+	}
+	if probers := p.GetSubnetProbers(); len(probers) != 1 || probers["10.0.0.0/8"] != "udp" {
+		t.Fatalf("unexpected probers %v", probers)
+	}
+}
@@ -0,0 +1,91 @@
+package ping
+
+import (
+	"net"
+	"time"
+)
+
+const recentResultCount = 20
+
+// Observation is a single past probe result
+type Observation struct {
+	Time time.Time `json:"time"`
+	Result
+}
+
+// HostHistory summarizes the reachability of an address over time
+type HostHistory struct {
+	Address        string        `json:"address"`
+	FirstSeenAlive *time.Time    `json:"firstSeenAlive"`
+	LastSeenAlive  *time.Time    `json:"lastSeenAlive"`
+	LastUpdate     *time.Time    `json:"lastUpdate"`
+	Last           Result        `json:"last"`
+	Recent         []Observation `json:"recent"`
+	Probes         int           `json:"probes"`
+	Replies        int           `json:"replies"`
+	Uptime         float64       `json:"uptime"` // percentage of probes that were answered
+}
+
+// record stores the probe result and updates the reachability history. Recent results are only
+// kept once the address has replied so that sweeping empty ranges does not fill memory.
+func (r *result) record(res Result, now time.Time) {
+	r.last = res
+	r.lastUpdateTime = now
+	r.probes++
+	if res.Reachable() {
+		r.replies++
+		if r.firstSeenAlive.IsZero() {
+			r.firstSeenAlive = now
+		}
+		r.lastSeenAlive = now
+	}
+	if r.firstSeenAlive.IsZero() {
+		return
+	}
+	// a new slice is allocated because copies of this result may still reference the old one
+	start := 0
+	if len(r.recent) >= recentResultCount {
+		start = len(r.recent) - recentResultCount + 1
+	}
+	recent := make([]Observation, 0, recentResultCount)
+	recent = append(recent, r.recent[start:]...)
+	r.recent = append(recent, Observation{Time: now, Result: res})
+}
+
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+// GetHostHistory returns the reachability history of an address
+func (p *Pinger) GetHostHistory(address string) (HostHistory, bool) {
+	addr := net.ParseIP(address)
+	if addr == nil {
+		return HostHistory{}, false
+	}
+	address = addr.String()
+	p.mtx.RLock()
+	defer p.mtx.RUnlock()
+	val, exists := p.data[address]
+	h := HostHistory{
+		Address: address,
+		Last:    Result{Status: StatusUnknown},
+		Recent:  []Observation{},
+	}
+	if !exists || val.lastUpdateTime.IsZero() {
+		return h, true
+	}
+	h.FirstSeenAlive = optionalTime(val.firstSeenAlive)
+	h.LastSeenAlive = optionalTime(val.lastSeenAlive)
+	h.LastUpdate = optionalTime(val.lastUpdateTime)
+	h.Last = val.last
+	h.Recent = append(h.Recent, val.recent...)
+	h.Probes = val.probes
+	h.Replies = val.replies
+	if val.probes > 0 {
+		h.Uptime = 100 * float64(val.replies) / float64(val.probes)
+	}
+	return h, true
+}
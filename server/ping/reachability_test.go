@@ -0,0 +1,41 @@
+package ping
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRecentResultsOnlyKeptOnceAlive(t *testing.T) {
+	r := result{}
+	for i := 0; i < 5; i++ {
+		r.record(Result{Status: StatusTimeout}, epoch.Add(time.Duration(i)*time.Minute))
+	}
+	if len(r.recent) != 0 || r.probes != 5 {
+		t.Fatalf("expected an address that never replied to only be counted but it kept %v results", len(r.recent))
+	}
+	r.record(Result{Status: StatusReachable, Latency: 1}, epoch.Add(time.Hour))
+	for i := 0; i < recentResultCount+5; i++ {
+		r.record(Result{Status: StatusTimeout}, epoch.Add(2*time.Hour+time.Duration(i)*time.Minute))
+	}
+	if len(r.recent) != recentResultCount || r.recent[0].Time.Equal(epoch.Add(time.Hour)) {
+		t.Fatalf("expected the newest %v results to be kept but got %v", recentResultCount, len(r.recent))
+	}
+	if r.probes != 5+1+recentResultCount+5 || r.replies != 1 || !r.firstSeenAlive.Equal(epoch.Add(time.Hour)) {
+		t.Fatalf("unexpected counters %+v", r)
+	}
+}
+
+func TestConcurrentResultsAreNotLost(t *testing.T) {
+	p := NewPinger()
+	go p.InitializeBackgroundPinger(1e6, 64)
+	defer p.Stop()
+	addresses := make([]string, 512)
+	for i := range addresses {
+		addresses[i] = "192.0.2.1"
+	}
+	p.ProbeNow(addresses, true, nil, nil)
+	h, _ := p.GetHostHistory("192.0.2.1")
+	if h.Probes != len(addresses) {
+		t.Fatalf("expected every result to be recorded but only %v of %v were", h.Probes, len(addresses))
+	}
+}
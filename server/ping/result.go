@@ -0,0 +1,48 @@
+package ping
+
+import "fmt"
+
+// Status describes the outcome of a probe
+type Status string
+
+// Statuses
+const (
+	// StatusUnknown means the address has never been probed
+	StatusUnknown Status = "unknown"
+	// StatusReachable means a reply was received
+	StatusReachable Status = "reachable"
+	// StatusTimeout means nothing was received before the deadline
+	StatusTimeout Status = "timeout"
+	// StatusUnreachable means a router reported the destination as unreachable
+	StatusUnreachable Status = "unreachable"
+	// StatusError means the probe could not be sent because of a local problem such as missing permissions
+	StatusError Status = "error"
+)
+
+// Result is the outcome of a single probe
+type Result struct {
+	Status  Status  `json:"status"`
+	Latency float64 `json:"latency,omitempty"` // milliseconds
+	Code    int     `json:"code,omitempty"`    // ICMP destination unreachable code
+	Error   string  `json:"error,omitempty"`
+	Probe   string  `json:"probe,omitempty"` // which prober produced the result
+}
+
+// Reachable returns true if a reply was received
+func (r Result) Reachable() bool {
+	return r.Status == StatusReachable
+}
+
+func (r Result) String() string {
+	switch r.Status {
+	case StatusReachable:
+		return fmt.Sprintf("%.2fms", r.Latency)
+	case StatusUnreachable:
+		return fmt.Sprintf("unreachable (code %v)", r.Code)
+	case StatusError:
+		return "error: " + r.Error
+	case "":
+		return string(StatusUnknown)
+	}
+	return string(r.Status)
+}
@@ -0,0 +1,98 @@
+package ping
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/demskie/subnetmath"
+)
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestClassifyDialError(t *testing.T) {
+	opError := func(err error) error {
+		return &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", err)}
+	}
+	tests := []struct {
+		name   string
+		err    error
+		status Status
+	}{
+		{"connection refused", opError(syscall.ECONNREFUSED), StatusReachable},
+		{"connection reset", opError(syscall.ECONNRESET), StatusReachable},
+		{"host unreachable", opError(syscall.EHOSTUNREACH), StatusUnreachable},
+		{"network unreachable", opError(syscall.ENETUNREACH), StatusUnreachable},
+		{"dial timeout", &net.OpError{Op: "dial", Net: "tcp", Err: timeoutError{}}, StatusTimeout},
+		{"read deadline", &net.OpError{Op: "read", Net: "udp", Err: os.ErrDeadlineExceeded}, StatusTimeout},
+		{"permission denied", opError(syscall.EACCES), StatusError},
+		{"unknown", errors.New("something else"), StatusError},
+	}
+	for _, test := range tests {
+		res := classifyDialError(test.err, time.Now())
+		if res.Status != test.status {
+			t.Fatalf("%v: expected %v but got %+v", test.name, test.status, res)
+		}
+		if (res.Status == StatusError) != (res.Error == test.err.Error()) {
+			t.Fatalf("%v: expected only errors to carry the message but got %+v", test.name, res)
+		}
+		if res.Reachable() != (test.status == StatusReachable) {
+			t.Fatalf("%v: expected Reachable to match the status", test.name)
+		}
+	}
+}
+
+func TestResultEncoding(t *testing.T) {
+	tests := []struct {
+		res  Result
+		str  string
+		json string
+	}{
+		{Result{}, "unknown", `{"status":""}`},
+		{Result{Status: StatusUnknown}, "unknown", `{"status":"unknown"}`},
+		{Result{Status: StatusReachable, Latency: 1.234, Probe: "icmp"}, "1.23ms", `{"status":"reachable","latency":1.234,"probe":"icmp"}`},
+		{Result{Status: StatusTimeout, Probe: "tcp"}, "timeout", `{"status":"timeout","probe":"tcp"}`},
+		{Result{Status: StatusUnreachable, Code: 3}, "unreachable (code 3)", `{"status":"unreachable","code":3}`},
+		{Result{Status: StatusError, Error: "operation not permitted"}, "error: operation not permitted", `{"status":"error","error":"operation not permitted"}`},
+	}
+	for _, test := range tests {
+		if str := test.res.String(); str != test.str {
+			t.Fatalf("expected %+v to be shown as '%v' but got '%v'", test.res, test.str, str)
+		}
+		b, err := json.Marshal(test.res)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != test.json {
+			t.Fatalf("expected %+v to be encoded as %v but got %s", test.res, test.json, b)
+		}
+	}
+}
+
+func TestPingNamesTheProber(t *testing.T) {
+	p := NewPinger()
+	res := p.ping("not an address")
+	if res.Status != StatusError || res.Error != "'not an address' is not a valid address" {
+		t.Fatalf("expected an invalid address to be an error but got %+v", res)
+	}
+	calls := 0
+	err := p.SetSubnetProber(subnetmath.ParseNetworkCIDR("192.0.2.0/24"), "tcp:22")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.mtx.Lock()
+	p.probers[0].prober = fakeProber{"fake", StatusUnreachable, &calls}
+	p.mtx.Unlock()
+	res = p.ping("192.0.2.1")
+	if res.Status != StatusUnreachable || res.Probe != "fake" || calls != 1 {
+		t.Fatalf("expected the subnet's prober to produce the result and name itself but got %+v", res)
+	}
+}
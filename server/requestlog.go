@@ -0,0 +1,116 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/demskie/ipam/server/auth"
+	"github.com/demskie/ipam/server/logging"
+)
+
+type requestInfoKey struct{}
+
+// requestInfo is shared with the handler so that the actor is recorded once the request has been authenticated
+type requestInfo struct {
+	mtx   *sync.Mutex
+	id    string
+	actor string
+}
+
+func (info *requestInfo) getActor() string {
+	info.mtx.Lock()
+	defer info.mtx.Unlock()
+	return info.actor
+}
+
+const requestIDHeader = "X-Request-ID"
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (rec *statusRecorder) WriteHeader(status int) {
+	rec.status = status
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *statusRecorder) Flush() {
+	if f, ok := rec.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (rec *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := rec.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("response does not support hijacking")
+	}
+	rec.status = http.StatusSwitchingProtocols
+	return h.Hijack()
+}
+
+func (rec *statusRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+// requestLogger tags every request with an ID and records how it was handled
+func (ipam *IPAMServer) requestLogger(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if id == "" || len(id) > 64 {
+			b := make([]byte, 8)
+			rand.Read(b)
+			id = hex.EncodeToString(b)
+		}
+		w.Header().Set(requestIDHeader, id)
+		remoteIP, _, _ := net.SplitHostPort(r.RemoteAddr)
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		info := &requestInfo{mtx: &sync.Mutex{}, id: id}
+		start := time.Now()
+		next.ServeHTTP(rec, r.WithContext(context.WithValue(r.Context(), requestInfoKey{}, info)))
+		log := ipam.log.With(id, remoteIP, info.getActor())
+		format, v := "%v %v %v %v", []interface{}{r.Method, r.URL.Path, rec.status, time.Since(start).Round(time.Microsecond)}
+		switch {
+		case rec.status >= http.StatusInternalServerError:
+			log.Errorf(format, v...)
+		case rec.status >= http.StatusBadRequest:
+			log.Warnf(format, v...)
+		default:
+			log.Debugf(format, v...)
+		}
+	})
+}
+
+// requestLog returns a logger that records the ID, address and actor of the request with every message
+func (ipam *IPAMServer) requestLog(r *http.Request) *logging.Logger {
+	remoteIP, _, _ := net.SplitHostPort(r.RemoteAddr)
+	info, ok := r.Context().Value(requestInfoKey{}).(*requestInfo)
+	if !ok {
+		return ipam.log.With("", remoteIP, "")
+	}
+	return ipam.log.With(info.id, remoteIP, info.getActor())
+}
+
+// authenticateRequest authenticates the credentials sent with a request and records who made it
+func (ipam *IPAMServer) authenticateRequest(r *http.Request, user, pass string) (*auth.Identity, error) {
+	id, err := ipam.authenticate(user, pass)
+	if err != nil {
+		remoteIP, _, _ := net.SplitHostPort(r.RemoteAddr)
+		ipam.requestLog(r).Warnf("(%v) failed to authenticate as '%v' > %v\n", remoteIP, user, err)
+		return nil, err
+	}
+	if info, ok := r.Context().Value(requestInfoKey{}).(*requestInfo); ok {
+		info.mtx.Lock()
+		info.actor = id.User
+		info.mtx.Unlock()
+	}
+	return id, nil
+}
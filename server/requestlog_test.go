@@ -0,0 +1,62 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/demskie/ipam/server/history"
+	"github.com/demskie/ipam/server/logging"
+)
+
+func newTestServer(t *testing.T) *IPAMServer {
+	ipam, err := NewIPAMServer(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ipam.Shutdown(context.Background()) })
+	ipam.debug.SetLevel(logging.LevelDebug)
+	return ipam
+}
+
+// newAuthTestServer returns a test server that accepts any user whose password is "secret"
+func newAuthTestServer(t *testing.T) *IPAMServer {
+	ipam := newTestServer(t)
+	ipam.SetAuthCallback(func(user, pass string) bool { return pass == "secret" })
+	return ipam
+}
+
+func TestRequestLogRecordsActor(t *testing.T) {
+	ipam := newTestServer(t)
+	ipam.SetAuthCallback(func(user, pass string) bool { return pass == "secret" })
+	handler := ipam.requestLogger(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := ipam.authenticateRequest(r, "alice", "secret"); err != nil {
+			t.Error(err)
+		}
+		ipam.requestLog(r).Infof("changed something\n")
+	}))
+	req := httptest.NewRequest(http.MethodPost, "/api/subnets", nil)
+	req.Header.Set(requestIDHeader, "abc")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	entries := ipam.debug.GetEntries(history.EntryQuery{})
+	if len(entries) != 2 {
+		t.Fatalf("expected the handler's entry and the request summary but got %+v", entries)
+	}
+	for _, e := range entries {
+		if e.Actor != "alice" || e.RequestID != "abc" || e.RemoteIP != "192.0.2.1" {
+			t.Fatalf("expected the request fields and actor to be recorded but got %+v", e)
+		}
+	}
+}
+
+func TestServersKeepTheirOwnLogs(t *testing.T) {
+	first, second := newTestServer(t), newTestServer(t)
+	first.history.OverwriteUserHistory([]string{"not a history line\n"})
+	if entries := second.debug.GetEntries(history.EntryQuery{}); len(entries) != 0 {
+		t.Fatalf("expected the newest server to not receive the first server's lines but got %+v", entries)
+	}
+	if entries := first.debug.GetEntries(history.EntryQuery{}); len(entries) != 1 {
+		t.Fatalf("expected the first server to record its own history warning but got %+v", entries)
+	}
+}
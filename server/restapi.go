@@ -4,26 +4,84 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
-	"log"
 	"net"
 	"net/http"
+	"net/url"
 	"strconv"
 	"time"
 
+	"github.com/demskie/ipam/server/history"
+	"github.com/demskie/ipam/server/ping"
 	"github.com/demskie/ipam/server/subnets"
+	"github.com/demskie/ipam/server/sweep"
+	"github.com/demskie/ipam/server/timeline"
+	"github.com/demskie/ipam/server/webhooks"
 	"github.com/demskie/subnetmath"
+	"github.com/gorilla/mux"
 )
 
 // curl http://localhost/api/subnets | python -m json.tool
+// curl "http://localhost/api/subnets?asOf=2019-06-01T12:00:00Z" | python -m json.tool
 
 func (ipam *IPAMServer) handleRestfulSubnets(w http.ResponseWriter, r *http.Request) {
 	remoteIP, _, _ := net.SplitHostPort(r.RemoteAddr)
 	results := ipam.subnets.GetJSON()
+	if s := r.URL.Query().Get("asOf"); s != "" {
+		asOf, err := history.ParseQueryTime(s)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("asOf: %v", err), http.StatusBadRequest)
+			return
+		}
+		results = ipam.subnetsAsOf(asOf).GetJSON()
+	}
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 	w.WriteHeader(http.StatusOK)
 	err := json.NewEncoder(w).Encode(results)
 	if err != nil {
-		log.Printf("failed serializing JSON for (%v) because %v\n", remoteIP, err.Error())
+		ipam.requestLog(r).Errorf("failed serializing JSON for (%v) because %v\n", remoteIP, err.Error())
+	}
+}
+
+// curl "http://localhost/api/subnetsdiff?from=2019-06-01&to=2019-07-01" | python -m json.tool
+
+func (ipam *IPAMServer) handleRestfulSubnetsDiff(w http.ResponseWriter, r *http.Request) {
+	remoteIP, _, _ := net.SplitHostPort(r.RemoteAddr)
+	params := r.URL.Query()
+	from, err := history.ParseQueryTime(params.Get("from"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("from: %v", err), http.StatusBadRequest)
+		return
+	}
+	to := time.Now()
+	if params.Get("to") != "" {
+		to, err = history.ParseQueryTime(params.Get("to"))
+		if err != nil {
+			http.Error(w, fmt.Sprintf("to: %v", err), http.StatusBadRequest)
+			return
+		}
+	}
+	added, removed, modified := timeline.Diff(
+		ipam.subnetsAsOf(from).GetAllSubnets(),
+		ipam.subnetsAsOf(to).GetAllSubnets(),
+	)
+	type outgoingJSON struct {
+		From     time.Time               `json:"from"`
+		To       time.Time               `json:"to"`
+		Added    []timeline.SubnetChange `json:"added"`
+		Removed  []timeline.SubnetChange `json:"removed"`
+		Modified []timeline.SubnetChange `json:"modified"`
+	}
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	err = json.NewEncoder(w).Encode(outgoingJSON{
+		From:     from,
+		To:       to,
+		Added:    added,
+		Removed:  removed,
+		Modified: modified,
+	})
+	if err != nil {
+		ipam.requestLog(r).Errorf("failed serializing JSON for (%v) because %v\n", remoteIP, err.Error())
 	}
 }
 
@@ -40,29 +98,30 @@ func (ipam *IPAMServer) handleRestfulSpecificHosts(w http.ResponseWriter, r *htt
 	decoder := json.NewDecoder(r.Body)
 	err := decoder.Decode(&inMsg)
 	if err != nil {
-		log.Printf("(%v) sent an invalid request: %v\n", remoteIP, err.Error())
+		ipam.requestLog(r).Warnf("(%v) sent an invalid request: %v\n", remoteIP, err.Error())
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 	defer r.Body.Close()
 	ip, network, err := net.ParseCIDR(inMsg.Subnet)
 	if err != nil {
-		log.Printf("(%v) sent an invalid subnetzero: %v\n", remoteIP, r.URL.String())
+		ipam.requestLog(r).Warnf("(%v) sent an invalid subnetzero: %v\n", remoteIP, r.URL.String())
 		http.Error(w, err.Error(), http.StatusNoContent)
 		return
 	} else if ip.Equal(network.IP) == false {
-		log.Printf("(%v) sent an invalid subnetzero: %v\n", remoteIP, r.URL.String())
+		ipam.requestLog(r).Warnf("(%v) sent an invalid subnetzero: %v\n", remoteIP, r.URL.String())
 		http.Error(w, "specified IP address is not subnetzero", http.StatusNoContent)
 		return
 	}
-	log.Printf("(%v) is requesting restfulHosts for %v\n", remoteIP, r.URL.String())
+	ipam.requestLog(r).Debugf("(%v) is requesting restfulHosts for %v\n", remoteIP, r.URL.String())
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 	w.WriteHeader(http.StatusOK)
 	type hostJSON struct {
-		Address         string `json:"address"`
-		ForwardRecord   string `json:"forwardRecord"`
-		PingResult      int    `json:"pingResult"`
-		LastPingAttempt string `json:"lastPingAttempt"`
+		Address         string      `json:"address"`
+		ForwardRecord   string      `json:"forwardRecord"`
+		ReverseRecord   string      `json:"reverseRecord"`
+		PingResult      ping.Result `json:"pingResult"`
+		LastPingAttempt string      `json:"lastPingAttempt"`
 	}
 	type outgoingJSON struct {
 		Data []hostJSON `json:"data"`
@@ -74,38 +133,128 @@ func (ipam *IPAMServer) handleRestfulSpecificHosts(w http.ResponseWriter, r *htt
 		currentIP = subnetmath.NextAddr(currentIP)
 	}
 	forwardRecords := ipam.dns.GetFirstHostnameForAddresses(sliceOfAddresses)
+	reverseRecords := ipam.rdns.GetCachedHostnames(sliceOfAddresses)
 	pingResults := ipam.pinger.GetPingResultsForAddresses(sliceOfAddresses)
 	lastPingAttempts := ipam.pinger.GetPingTimesForAddresses(sliceOfAddresses)
 	results := make([]hostJSON, len(sliceOfAddresses))
 	for i := range sliceOfAddresses {
 		results[i].Address = sliceOfAddresses[i]
 		results[i].ForwardRecord = forwardRecords[i]
-		results[i].PingResult, _ = strconv.Atoi(pingResults[i])
+		results[i].ReverseRecord = reverseRecords[i]
+		results[i].PingResult = pingResults[i]
 		results[i].LastPingAttempt = lastPingAttempts[i]
 	}
 	err = json.NewEncoder(w).Encode(outgoingJSON{
 		Data: results,
 	})
 	if err != nil {
-		log.Printf("failed serializing JSON for (%v) because %v\n", remoteIP, err.Error())
+		ipam.requestLog(r).Errorf("failed serializing JSON for (%v) because %v\n", remoteIP, err.Error())
+	}
+}
+
+// curl http://localhost/api/hosts/192.168.0.1 | python -m json.tool
+
+func (ipam *IPAMServer) handleRestfulHostDetail(w http.ResponseWriter, r *http.Request) {
+	remoteIP, _, _ := net.SplitHostPort(r.RemoteAddr)
+	detail, exists := ipam.getHostDetail(mux.Vars(r)["address"])
+	if !exists {
+		http.Error(w, fmt.Sprintf("'%v' is not a valid address", mux.Vars(r)["address"]), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	err := json.NewEncoder(w).Encode(detail)
+	if err != nil {
+		ipam.requestLog(r).Errorf("failed serializing JSON for (%v) because %v\n", remoteIP, err.Error())
 	}
 }
 
 // curl http://localhost/api/history | python -m json.tool
+// curl "http://localhost/api/history?cidr=10.0.0.0/8&descendants=true&actor=alex&action=deleteSubnet&since=2019-04-01&until=2019-05-01&q=corp&offset=0&limit=50"
+
+func parseHistoryQuery(values url.Values) (history.Query, error) {
+	var err error
+	query := history.Query{
+		CIDR:               values.Get("cidr"),
+		IncludeDescendants: values.Get("descendants") == "true",
+		Actor:              values.Get("actor"),
+		Action:             values.Get("action"),
+		Text:               values.Get("q"),
+	}
+	query.Since, err = history.ParseQueryTime(values.Get("since"))
+	if err != nil {
+		return query, err
+	}
+	query.Until, err = history.ParseQueryTime(values.Get("until"))
+	if err != nil {
+		return query, err
+	}
+	if values.Get("offset") != "" {
+		query.Offset, err = strconv.Atoi(values.Get("offset"))
+		if err != nil {
+			return query, fmt.Errorf("'%v' is not a valid offset", values.Get("offset"))
+		}
+	}
+	if values.Get("limit") != "" {
+		query.Limit, err = strconv.Atoi(values.Get("limit"))
+		if err != nil {
+			return query, fmt.Errorf("'%v' is not a valid limit", values.Get("limit"))
+		}
+	}
+	return query, nil
+}
 
 func (ipam *IPAMServer) handleRestfulHistory(w http.ResponseWriter, r *http.Request) {
 	remoteIP, _, _ := net.SplitHostPort(r.RemoteAddr)
-	log.Printf("(%v) is requesting restfulHistory\n", remoteIP)
+	ipam.requestLog(r).Debugf("(%v) is requesting restfulHistory for %v\n", remoteIP, r.URL.String())
+	query, err := parseHistoryQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	matches, total, err := ipam.history.Search(query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 	w.WriteHeader(http.StatusOK)
 	type outgoingJSON struct {
-		History []string `json:"history"`
+		History []string        `json:"history"`
+		Events  []history.Event `json:"events"`
+		Total   int             `json:"total"`
+		Offset  int             `json:"offset"`
+		Limit   int             `json:"limit"`
 	}
-	err := json.NewEncoder(w).Encode(outgoingJSON{
-		History: ipam.history.GetAllUserActions(),
+	lines := make([]string, len(matches))
+	for i := range matches {
+		lines[i] = matches[i].String() + "\n"
+	}
+	err = json.NewEncoder(w).Encode(outgoingJSON{
+		History: lines,
+		Events:  matches,
+		Total:   total,
+		Offset:  query.Offset,
+		Limit:   query.PageLimit(),
 	})
 	if err != nil {
-		log.Printf("failed serializing historyJSON for (%v) because %v\n", remoteIP, err.Error())
+		ipam.requestLog(r).Errorf("failed serializing historyJSON for (%v) because %v\n", remoteIP, err.Error())
+	}
+}
+
+// curl http://localhost/api/history/verify | python -m json.tool
+
+func (ipam *IPAMServer) handleRestfulVerifyHistory(w http.ResponseWriter, r *http.Request) {
+	remoteIP, _, _ := net.SplitHostPort(r.RemoteAddr)
+	report := ipam.history.Verify()
+	if !report.Valid {
+		ipam.requestLog(r).Errorf("history verification requested by (%v) failed at index %v > %v\n", remoteIP, report.BrokenIndex, report.Reason)
+	}
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	err := json.NewEncoder(w).Encode(report)
+	if err != nil {
+		ipam.requestLog(r).Errorf("failed serializing JSON for (%v) because %v\n", remoteIP, err.Error())
 	}
 }
 
@@ -127,18 +276,17 @@ func (ipam *IPAMServer) handleRestfulCreateSubnet(w http.ResponseWriter, r *http
 	decoder := json.NewDecoder(r.Body)
 	err := decoder.Decode(&inMsg)
 	if err != nil {
-		log.Printf("(%v) sent an invalid request - %v\n", remoteIP, err.Error())
+		ipam.requestLog(r).Warnf("(%v) sent an invalid request - %v\n", remoteIP, err.Error())
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 	defer r.Body.Close()
-	ipam.authCallbackMtx.RLock()
-	if ipam.authCallback(inMsg.User, inMsg.Pass) == false {
+	identity, err := ipam.authenticateRequest(r, inMsg.User, inMsg.Pass)
+	if err != nil {
 		s := fmt.Sprintf("could not create subnet '%v' due to auth failure", inMsg.Subnet)
 		http.Error(w, s, http.StatusUnauthorized)
 		return
 	}
-	ipam.authCallbackMtx.RUnlock()
 	newSkeleton := &subnets.SubnetSkeleton{
 		Net:     inMsg.Subnet,
 		Desc:    inMsg.Description,
@@ -149,12 +297,21 @@ func (ipam *IPAMServer) handleRestfulCreateSubnet(w http.ResponseWriter, r *http
 	err = ipam.subnets.CreateSubnet(newSkeleton)
 	if err != nil {
 		message := fmt.Sprintf("could not create '%v' because %v", newSkeleton.Net, err.Error())
-		log.Printf("(%v) sent an invalid request - %v\n", remoteIP, message)
+		ipam.requestLog(r).Warnf("(%v) sent an invalid request - %v\n", remoteIP, message)
 		http.Error(w, message, http.StatusNoContent)
 		return
 	}
-	msg := ipam.history.RecordUserAction(remoteIP, "creating subnet", newSkeleton.ToSlice())
-	ipam.signalMutation(msg)
+	_, err = ipam.commitMutation(history.Event{
+		Actor:    identity.User,
+		SourceIP: remoteIP,
+		Action:   history.ActionCreateSubnet,
+		Target:   newSkeleton.Net,
+		After:    newSkeleton,
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 	io.WriteString(w, "operation successful")
 }
 
@@ -176,18 +333,17 @@ func (ipam *IPAMServer) handleRestfulReplaceSubnet(w http.ResponseWriter, r *htt
 	decoder := json.NewDecoder(r.Body)
 	err := decoder.Decode(&inMsg)
 	if err != nil {
-		log.Printf("(%v) sent an invalid request - %v\n", remoteIP, err.Error())
+		ipam.requestLog(r).Warnf("(%v) sent an invalid request - %v\n", remoteIP, err.Error())
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 	defer r.Body.Close()
-	ipam.authCallbackMtx.RLock()
-	if ipam.authCallback(inMsg.User, inMsg.Pass) == false {
+	identity, err := ipam.authenticateRequest(r, inMsg.User, inMsg.Pass)
+	if err != nil {
 		s := fmt.Sprintf("could not reserve subnet '%v' due to auth failure", inMsg.Subnet)
 		http.Error(w, s, http.StatusUnauthorized)
 		return
 	}
-	ipam.authCallbackMtx.RUnlock()
 	network := subnetmath.ParseNetworkCIDR(inMsg.Subnet)
 	oldSkeleton := ipam.subnets.GetSubnetSkeleton(network)
 	newSkeleton := &subnets.SubnetSkeleton{
@@ -200,19 +356,29 @@ func (ipam *IPAMServer) handleRestfulReplaceSubnet(w http.ResponseWriter, r *htt
 	differences := oldSkeleton.ListDifferences(newSkeleton)
 	if differences == nil {
 		message := fmt.Sprintf("could not modify '%v' because there were no changes", network)
-		log.Printf("(%v) sent an invalid request - %v\n", remoteIP, message)
+		ipam.requestLog(r).Warnf("(%v) sent an invalid request - %v\n", remoteIP, message)
 		http.Error(w, message, http.StatusNoContent)
 		return
 	}
 	err = ipam.subnets.ReplaceSubnet(newSkeleton)
 	if err != nil {
 		message := fmt.Sprintf("could not modify '%v' because %v", newSkeleton.Net, err.Error())
-		log.Printf("(%v) sent an invalid request - %v\n", remoteIP, message)
+		ipam.requestLog(r).Warnf("(%v) sent an invalid request - %v\n", remoteIP, message)
 		http.Error(w, message, http.StatusNoContent)
 		return
 	}
-	msg := ipam.history.RecordUserAction(remoteIP, "pushing changes", differences)
-	ipam.signalMutation(msg)
+	_, err = ipam.commitMutation(history.Event{
+		Actor:    identity.User,
+		SourceIP: remoteIP,
+		Action:   history.ActionModifySubnet,
+		Target:   newSkeleton.Net,
+		Before:   oldSkeleton,
+		After:    newSkeleton,
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 	io.WriteString(w, "operation successful")
 }
 
@@ -231,18 +397,17 @@ func (ipam *IPAMServer) handleRestfulDeleteSubnet(w http.ResponseWriter, r *http
 	decoder := json.NewDecoder(r.Body)
 	err := decoder.Decode(&inMsg)
 	if err != nil {
-		log.Println(remoteIP, "sent an invalid request -", err.Error())
+		ipam.requestLog(r).Warnf("(%v) sent an invalid request - %v\n", remoteIP, err.Error())
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 	defer r.Body.Close()
-	ipam.authCallbackMtx.RLock()
-	if ipam.authCallback(inMsg.User, inMsg.Pass) == false {
+	identity, err := ipam.authenticateRequest(r, inMsg.User, inMsg.Pass)
+	if err != nil {
 		s := fmt.Sprintf("could not delete '%v' due to auth failure", inMsg.Subnet)
 		http.Error(w, s, http.StatusUnauthorized)
 		return
 	}
-	ipam.authCallbackMtx.RUnlock()
 	network := subnetmath.ParseNetworkCIDR(inMsg.Subnet)
 	oldSkeleton := ipam.subnets.GetSubnetSkeleton(network)
 	if network == nil || ipam.subnets.DeleteSubnet(network) != nil {
@@ -250,11 +415,65 @@ func (ipam *IPAMServer) handleRestfulDeleteSubnet(w http.ResponseWriter, r *http
 		http.Error(w, message, http.StatusNoContent)
 		return
 	}
-	msg := ipam.history.RecordUserAction(remoteIP, "deleting subnet", oldSkeleton.ToSlice())
-	ipam.signalMutation(msg)
+	_, err = ipam.commitMutation(history.Event{
+		Actor:    identity.User,
+		SourceIP: remoteIP,
+		Action:   history.ActionDeleteSubnet,
+		Target:   oldSkeleton.Net,
+		Before:   oldSkeleton,
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 	io.WriteString(w, "operation successful")
 }
 
+// curl --header "Content-Type: application/json" --request POST \
+//		--data '{"user":"admin","pass":"secret"}' \
+//		http://localhost/api/revert/0123456789abcdef
+
+func (ipam *IPAMServer) handleRestfulRevert(w http.ResponseWriter, r *http.Request) {
+	remoteIP, _, _ := net.SplitHostPort(r.RemoteAddr)
+	type incomingJSON struct {
+		User string `json:"user"`
+		Pass string `json:"pass"`
+	}
+	var inMsg incomingJSON
+	decoder := json.NewDecoder(r.Body)
+	err := decoder.Decode(&inMsg)
+	if err != nil {
+		ipam.requestLog(r).Warnf("(%v) sent an invalid request - %v\n", remoteIP, err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+	eventID := mux.Vars(r)["eventID"]
+	identity, err := ipam.authenticateRequest(r, inMsg.User, inMsg.Pass)
+	if err != nil {
+		s := fmt.Sprintf("could not revert '%v' due to auth failure", eventID)
+		http.Error(w, s, http.StatusUnauthorized)
+		return
+	}
+	record, err := ipam.revertHistoryEvent(eventID, identity.User, remoteIP)
+	if err != nil {
+		status := http.StatusBadRequest
+		if revertErr, ok := err.(*revertError); ok {
+			if revertErr.conflict {
+				status = http.StatusConflict
+			} else if revertErr.missing {
+				status = http.StatusNotFound
+			}
+		} else if _, ok := err.(*saveError); ok {
+			status = http.StatusInternalServerError
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(record)
+}
+
 // curl --header "Content-Type: application/json" --request POST \
 // 		--data '{"subnet":"10.128.8.0/21", "description":"MyDockerService", "details":"jira123456789"}' \
 // 		http://localhost/api/reservehost
@@ -272,18 +491,17 @@ func (ipam *IPAMServer) handleRestfulReserveHost(w http.ResponseWriter, r *http.
 	decoder := json.NewDecoder(r.Body)
 	err := decoder.Decode(&inMsg)
 	if err != nil {
-		log.Println(remoteIP, "sent an invalid request -", err.Error())
+		ipam.requestLog(r).Warnf("(%v) sent an invalid request - %v\n", remoteIP, err.Error())
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 	defer r.Body.Close()
-	ipam.authCallbackMtx.RLock()
-	if ipam.authCallback(inMsg.User, inMsg.Pass) == false {
+	identity, err := ipam.authenticateRequest(r, inMsg.User, inMsg.Pass)
+	if err != nil {
 		s := fmt.Sprintf("could not reserve host in '%v' due to auth failure", inMsg.Subnet)
 		http.Error(w, s, http.StatusUnauthorized)
 		return
 	}
-	ipam.authCallbackMtx.RUnlock()
 	network := subnetmath.ParseNetworkCIDR(inMsg.Subnet)
 	if network == nil {
 		http.Error(w, fmt.Sprintf("'%v' is not a valid subnet", inMsg.Subnet), http.StatusBadRequest)
@@ -298,17 +516,21 @@ func (ipam *IPAMServer) handleRestfulReserveHost(w http.ResponseWriter, r *http.
 	}
 	host, err := ipam.subnets.CreateAvailableSubnet(network, inMsg.Description, inMsg.Details, "", cidr)
 	if err != nil {
-		log.Println(remoteIP, "request failed because", err.Error())
+		ipam.requestLog(r).Errorf("(%v) request failed because %v\n", remoteIP, err.Error())
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	slc := []string{
-		fmt.Sprintf("net='%s'", host),
-		fmt.Sprintf("desc='%v'", inMsg.Description),
-		fmt.Sprintf("details='%v'", inMsg.Details),
+	_, err = ipam.commitMutation(history.Event{
+		Actor:    identity.User,
+		SourceIP: remoteIP,
+		Action:   history.ActionReserveHost,
+		Target:   host,
+		After:    ipam.subnets.GetSubnetSkeleton(subnetmath.ParseNetworkCIDR(host)),
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
-	msg := ipam.history.RecordUserAction(remoteIP, "reserving host", slc)
-	ipam.signalMutation(msg)
 	io.WriteString(w, host)
 }
 
@@ -330,18 +552,17 @@ func (ipam *IPAMServer) handleRestfulReserveSubnet(w http.ResponseWriter, r *htt
 	var inMsg incomingJSON
 	err := json.NewDecoder(r.Body).Decode(&inMsg)
 	if err != nil {
-		log.Println(remoteIP, "sent an invalid request -", err.Error())
+		ipam.requestLog(r).Warnf("(%v) sent an invalid request - %v\n", remoteIP, err.Error())
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 	defer r.Body.Close()
-	ipam.authCallbackMtx.RLock()
-	if ipam.authCallback(inMsg.User, inMsg.Pass) == false {
+	identity, err := ipam.authenticateRequest(r, inMsg.User, inMsg.Pass)
+	if err != nil {
 		s := fmt.Sprintf("could not reserve subnet of '%v' due to auth failure", inMsg.Supernet)
 		http.Error(w, s, http.StatusUnauthorized)
 		return
 	}
-	ipam.authCallbackMtx.RUnlock()
 	supernet := subnetmath.ParseNetworkCIDR(inMsg.Supernet)
 	if supernet == nil {
 		http.Error(w, fmt.Sprintf("'%v' is not a valid supernet", inMsg.Supernet), http.StatusBadRequest)
@@ -352,16 +573,321 @@ func (ipam *IPAMServer) handleRestfulReserveSubnet(w http.ResponseWriter, r *htt
 	}
 	subnet, err := ipam.subnets.CreateAvailableSubnet(supernet, inMsg.Description, inMsg.Details, inMsg.Vlan, inMsg.SubnetCIDR)
 	if err != nil {
-		log.Println(remoteIP, "request failed because", err.Error())
+		ipam.requestLog(r).Errorf("(%v) request failed because %v\n", remoteIP, err.Error())
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	slc := []string{
-		fmt.Sprintf("net='%s'", subnet),
-		fmt.Sprintf("desc='%v'", inMsg.Description),
-		fmt.Sprintf("details='%v'", inMsg.Details),
+	_, err = ipam.commitMutation(history.Event{
+		Actor:    identity.User,
+		SourceIP: remoteIP,
+		Action:   history.ActionReserveSubnet,
+		Target:   subnet,
+		After:    ipam.subnets.GetSubnetSkeleton(subnetmath.ParseNetworkCIDR(subnet)),
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
-	msg := ipam.history.RecordUserAction(remoteIP, "reserving subnet", slc)
-	ipam.signalMutation(msg)
 	io.WriteString(w, subnet)
 }
+
+// decodeCredentials reads the user and pass of a request that otherwise has no body
+func decodeCredentials(r *http.Request) (user, pass string, err error) {
+	var inMsg struct {
+		User string `json:"user"`
+		Pass string `json:"pass"`
+	}
+	err = json.NewDecoder(r.Body).Decode(&inMsg)
+	if err == io.EOF {
+		err = nil
+	}
+	return inMsg.User, inMsg.Pass, err
+}
+
+// persistWebhooks saves every registration and must not be called while holding storeMtx
+func (ipam *IPAMServer) persistWebhooks(reason, actor string) error {
+	ipam.storeMtx.Lock()
+	defer ipam.storeMtx.Unlock()
+	_, err := ipam.persistMutation(reason, actor)
+	return err
+}
+
+// curl --header "Content-Type: application/json" --request POST \
+//		--data '{"user":"alex", "pass":"secret"}' \
+//		http://localhost/api/webhooks | python -m json.tool
+
+func (ipam *IPAMServer) handleRestfulWebhooks(w http.ResponseWriter, r *http.Request) {
+	remoteIP, _, _ := net.SplitHostPort(r.RemoteAddr)
+	user, pass, err := decodeCredentials(r)
+	if err != nil {
+		ipam.requestLog(r).Warnf("(%v) sent an invalid request - %v\n", remoteIP, err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+	_, err = ipam.authenticateRequest(r, user, pass)
+	if err != nil {
+		http.Error(w, "could not list webhooks due to auth failure", http.StatusUnauthorized)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	type outgoingJSON struct {
+		Webhooks []webhooks.Registration `json:"webhooks"`
+	}
+	err = json.NewEncoder(w).Encode(outgoingJSON{
+		Webhooks: ipam.webhooks.List(),
+	})
+	if err != nil {
+		ipam.requestLog(r).Errorf("failed serializing webhooksJSON for (%v) because %v\n", remoteIP, err.Error())
+	}
+}
+
+// curl --header "Content-Type: application/json" --request POST \
+//		--data '{"user":"alex", "pass":"secret", "url":"https://example.com/hook", "eventTypes":["subnetCreated"], "subtree":"10.0.0.0/8"}' \
+//		http://localhost/api/createwebhook
+
+func (ipam *IPAMServer) handleRestfulCreateWebhook(w http.ResponseWriter, r *http.Request) {
+	remoteIP, _, _ := net.SplitHostPort(r.RemoteAddr)
+	type incomingJSON struct {
+		User       string   `json:"user"`
+		Pass       string   `json:"pass"`
+		URL        string   `json:"url"`
+		Secret     string   `json:"secret"`
+		EventTypes []string `json:"eventTypes"`
+		Subtree    string   `json:"subtree"`
+	}
+	var inMsg incomingJSON
+	err := json.NewDecoder(r.Body).Decode(&inMsg)
+	if err != nil {
+		ipam.requestLog(r).Warnf("(%v) sent an invalid request - %v\n", remoteIP, err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+	identity, err := ipam.authenticateRequest(r, inMsg.User, inMsg.Pass)
+	if err != nil {
+		s := fmt.Sprintf("could not create webhook for '%v' due to auth failure", inMsg.URL)
+		http.Error(w, s, http.StatusUnauthorized)
+		return
+	}
+	reg, err := ipam.webhooks.Register(webhooks.Registration{
+		URL:        inMsg.URL,
+		Secret:     inMsg.Secret,
+		EventTypes: inMsg.EventTypes,
+		Subtree:    inMsg.Subtree,
+	})
+	if err != nil {
+		ipam.requestLog(r).Warnf("(%v) sent an invalid request - %v\n", remoteIP, err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	err = ipam.persistWebhooks(fmt.Sprintf("created webhook '%v' for '%v'\n", reg.ID, reg.URL), identity.User)
+	if err != nil {
+		ipam.webhooks.Remove(reg.ID)
+		http.Error(w, fmt.Sprintf("unable to save changes > %v", err), http.StatusInternalServerError)
+		return
+	}
+	ipam.requestLog(r).Infof("(%v) has created webhook '%v' for '%v'\n", remoteIP, reg.ID, reg.URL)
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	err = json.NewEncoder(w).Encode(reg)
+	if err != nil {
+		ipam.requestLog(r).Errorf("failed serializing webhookJSON for (%v) because %v\n", remoteIP, err.Error())
+	}
+}
+
+// curl --header "Content-Type: application/json" --request POST \
+//		--data '{"user":"alex", "pass":"secret", "id":"0123456789abcdef"}' \
+//		http://localhost/api/deletewebhook
+
+func (ipam *IPAMServer) handleRestfulDeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	remoteIP, _, _ := net.SplitHostPort(r.RemoteAddr)
+	type incomingJSON struct {
+		User string `json:"user"`
+		Pass string `json:"pass"`
+		ID   string `json:"id"`
+	}
+	var inMsg incomingJSON
+	err := json.NewDecoder(r.Body).Decode(&inMsg)
+	if err != nil {
+		ipam.requestLog(r).Warnf("(%v) sent an invalid request - %v\n", remoteIP, err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+	identity, err := ipam.authenticateRequest(r, inMsg.User, inMsg.Pass)
+	if err != nil {
+		s := fmt.Sprintf("could not delete webhook '%v' due to auth failure", inMsg.ID)
+		http.Error(w, s, http.StatusUnauthorized)
+		return
+	}
+	reg, err := ipam.webhooks.Remove(inMsg.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	err = ipam.persistWebhooks(fmt.Sprintf("deleted webhook '%v' for '%v'\n", reg.ID, reg.URL), identity.User)
+	if err != nil {
+		ipam.webhooks.Register(reg)
+		http.Error(w, fmt.Sprintf("unable to save changes > %v", err), http.StatusInternalServerError)
+		return
+	}
+	ipam.requestLog(r).Infof("(%v) has deleted webhook '%v'\n", remoteIP, inMsg.ID)
+	io.WriteString(w, "operation successful")
+}
+
+// curl --header "Content-Type: application/json" --request POST \
+//		--data '{"user":"alex", "pass":"secret"}' \
+//		http://localhost/api/webhookdeliveries?id=0123456789abcdef | python -m json.tool
+
+func (ipam *IPAMServer) handleRestfulWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	remoteIP, _, _ := net.SplitHostPort(r.RemoteAddr)
+	user, pass, err := decodeCredentials(r)
+	if err != nil {
+		ipam.requestLog(r).Warnf("(%v) sent an invalid request - %v\n", remoteIP, err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+	_, err = ipam.authenticateRequest(r, user, pass)
+	if err != nil {
+		http.Error(w, "could not list webhook deliveries due to auth failure", http.StatusUnauthorized)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	type outgoingJSON struct {
+		Deliveries []webhooks.Delivery `json:"deliveries"`
+	}
+	err = json.NewEncoder(w).Encode(outgoingJSON{
+		Deliveries: ipam.webhooks.Deliveries(r.URL.Query().Get("id")),
+	})
+	if err != nil {
+		ipam.requestLog(r).Errorf("failed serializing deliveriesJSON for (%v) because %v\n", remoteIP, err.Error())
+	}
+}
+
+// curl http://localhost/api/sweep | python -m json.tool
+
+func (ipam *IPAMServer) handleRestfulSweep(w http.ResponseWriter, r *http.Request) {
+	remoteIP, _, _ := net.SplitHostPort(r.RemoteAddr)
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	type outgoingJSON struct {
+		Summary  sweep.Summary             `json:"summary"`
+		Settings map[string]sweep.Settings `json:"settings"`
+		Subnets  []sweep.Status            `json:"subnets"`
+	}
+	summary, statuses := ipam.sweeper.Progress(ipam.getAllSubnetCIDRs(), time.Now())
+	err := json.NewEncoder(w).Encode(outgoingJSON{
+		Summary:  summary,
+		Settings: ipam.sweeper.GetSubnetSettings(),
+		Subnets:  statuses,
+	})
+	if err != nil {
+		ipam.requestLog(r).Errorf("failed serializing sweepJSON for (%v) because %v\n", remoteIP, err.Error())
+	}
+}
+
+// curl --header "Content-Type: application/json" --request POST \
+//		--data '{"user":"admin","pass":"secret","subnet":"10.0.0.0/8","interval":"1h","exclude":false,"clear":false}' \
+//		http://localhost/api/sweepsettings
+
+func (ipam *IPAMServer) handleRestfulSweepSettings(w http.ResponseWriter, r *http.Request) {
+	remoteIP, _, _ := net.SplitHostPort(r.RemoteAddr)
+	type incomingJSON struct {
+		User     string `json:"user"`
+		Pass     string `json:"pass"`
+		Subnet   string `json:"subnet"`
+		Interval string `json:"interval"`
+		Exclude  bool   `json:"exclude"`
+		Clear    bool   `json:"clear"`
+	}
+	var inMsg incomingJSON
+	err := json.NewDecoder(r.Body).Decode(&inMsg)
+	if err != nil {
+		ipam.requestLog(r).Warnf("(%v) sent an invalid request - %v\n", remoteIP, err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+	_, err = ipam.authenticateRequest(r, inMsg.User, inMsg.Pass)
+	if err != nil {
+		s := fmt.Sprintf("could not change sweep settings for '%v' due to auth failure", inMsg.Subnet)
+		http.Error(w, s, http.StatusUnauthorized)
+		return
+	}
+	network := subnetmath.ParseNetworkCIDR(inMsg.Subnet)
+	if network == nil {
+		http.Error(w, fmt.Sprintf("'%v' is not a valid CIDR network", inMsg.Subnet), http.StatusBadRequest)
+		return
+	}
+	settings := sweep.Settings{Exclude: inMsg.Exclude}
+	if inMsg.Interval != "" {
+		settings.Interval, err = time.ParseDuration(inMsg.Interval)
+		if err != nil || settings.Interval <= 0 {
+			http.Error(w, fmt.Sprintf("'%v' is not a valid interval", inMsg.Interval), http.StatusBadRequest)
+			return
+		}
+	}
+	if inMsg.Clear {
+		ipam.sweeper.ClearSubnetSettings(network.String())
+	} else {
+		ipam.sweeper.SetSubnetSettings(network.String(), settings)
+	}
+	ipam.requestLog(r).Infof("(%v) has changed the sweep settings for '%v'\n", remoteIP, network.String())
+	io.WriteString(w, "operation successful")
+}
+
+// curl http://localhost/api/probesettings | python -m json.tool
+// curl --header "Content-Type: application/json" --request POST \
+//		--data '{"user":"admin","pass":"secret","subnet":"10.0.0.0/24","probe":"tcp:22,443|icmp","clear":false}' \
+//		http://localhost/api/probesettings
+
+func (ipam *IPAMServer) handleRestfulProbeSettings(w http.ResponseWriter, r *http.Request) {
+	remoteIP, _, _ := net.SplitHostPort(r.RemoteAddr)
+	if r.Method != http.MethodPost {
+		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+		w.WriteHeader(http.StatusOK)
+		err := json.NewEncoder(w).Encode(ipam.pinger.GetSubnetProbers())
+		if err != nil {
+			ipam.requestLog(r).Errorf("failed serializing probeSettingsJSON for (%v) because %v\n", remoteIP, err.Error())
+		}
+		return
+	}
+	type incomingJSON struct {
+		User   string `json:"user"`
+		Pass   string `json:"pass"`
+		Subnet string `json:"subnet"`
+		Probe  string `json:"probe"`
+		Clear  bool   `json:"clear"`
+	}
+	var inMsg incomingJSON
+	err := json.NewDecoder(r.Body).Decode(&inMsg)
+	if err != nil {
+		ipam.requestLog(r).Warnf("(%v) sent an invalid request - %v\n", remoteIP, err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+	_, err = ipam.authenticateRequest(r, inMsg.User, inMsg.Pass)
+	if err != nil {
+		s := fmt.Sprintf("could not change probe settings for '%v' due to auth failure", inMsg.Subnet)
+		http.Error(w, s, http.StatusUnauthorized)
+		return
+	}
+	network := subnetmath.ParseNetworkCIDR(inMsg.Subnet)
+	if network == nil {
+		http.Error(w, fmt.Sprintf("'%v' is not a valid CIDR network", inMsg.Subnet), http.StatusBadRequest)
+		return
+	}
+	if inMsg.Clear {
+		ipam.pinger.ClearSubnetProber(network)
+	} else if err = ipam.pinger.SetSubnetProber(network, inMsg.Probe); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	ipam.requestLog(r).Infof("(%v) has changed the probe settings for '%v'\n", remoteIP, network.String())
+	io.WriteString(w, "operation successful")
+}
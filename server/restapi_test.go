@@ -0,0 +1,102 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/demskie/ipam/server/history"
+	"github.com/demskie/ipam/server/webhooks"
+)
+
+func postJSON(handler http.HandlerFunc, path, body string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(http.MethodPost, path, strings.NewReader(body)))
+	return rec
+}
+
+func TestWebhookEndpointsRequireAuthentication(t *testing.T) {
+	ipam := newTestServer(t)
+	ipam.SetAuthCallback(func(user, pass string) bool { return user == "alice" && pass == "secret" })
+	handlers := map[string]http.HandlerFunc{
+		"/api/webhooks":          ipam.handleRestfulWebhooks,
+		"/api/webhookdeliveries": ipam.handleRestfulWebhookDeliveries,
+	}
+	for path, handler := range handlers {
+		for _, body := range []string{"", `{"user":"alice","pass":"wrong"}`} {
+			if rec := postJSON(handler, path, body); rec.Code != http.StatusUnauthorized {
+				t.Fatalf("expected %v with %q to be unauthorized but got %v", path, body, rec.Code)
+			}
+		}
+		if rec := postJSON(handler, path, `{"user":"alice","pass":"secret"}`); rec.Code != http.StatusOK {
+			t.Fatalf("expected %v to succeed with valid credentials but got %v", path, rec.Code)
+		}
+	}
+}
+
+func TestWebhooksArePersisted(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ipam, err := NewIPAMServer(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ipam.SetAuthCallback(func(user, pass string) bool { return pass == "secret" })
+	rec := postJSON(ipam.handleRestfulCreateWebhook, "/api/createwebhook",
+		`{"user":"alice","pass":"secret","url":"https://example.com/hook","secret":"shh"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected the webhook to be created but got %v %v", rec.Code, rec.Body.String())
+	}
+	var reg webhooks.Registration
+	json.NewDecoder(rec.Body).Decode(&reg)
+	ipam.Shutdown(context.Background())
+
+	store, err = NewFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restarted, err := NewIPAMServer(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer restarted.Shutdown(context.Background())
+	restarted.SetAuthCallback(func(user, pass string) bool { return pass == "secret" })
+	list := restarted.webhooks.List()
+	if len(list) != 1 || list[0].ID != reg.ID {
+		t.Fatalf("expected webhook '%v' to survive a restart but got %+v", reg.ID, list)
+	}
+	rec = postJSON(restarted.handleRestfulDeleteWebhook, "/api/deletewebhook", `{"user":"alice","pass":"secret","id":"`+reg.ID+`"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected the webhook to be deleted but got %v %v", rec.Code, rec.Body.String())
+	}
+	data, err := store.Load()
+	if err != nil || len(trimLines(data.Webhooks)) != 0 {
+		t.Fatalf("expected the deletion to be saved but loaded %q (%v)", data.Webhooks, err)
+	}
+}
+
+func TestHistoryLimitIsCapped(t *testing.T) {
+	ipam := newTestServer(t)
+	for _, query := range []string{"", "?limit=0", "?limit=20000"} {
+		rec := httptest.NewRecorder()
+		ipam.handleRestfulHistory(rec, httptest.NewRequest(http.MethodGet, "/api/history"+query, nil))
+		result := struct {
+			Limit int `json:"limit"`
+		}{}
+		json.NewDecoder(rec.Body).Decode(&result)
+		if rec.Code != http.StatusOK || result.Limit != history.MaxQueryLimit {
+			t.Fatalf("expected '%v' to be limited to %v but got %v (%v)", query, history.MaxQueryLimit, result.Limit, rec.Code)
+		}
+	}
+	rec := httptest.NewRecorder()
+	ipam.handleRestfulHistory(rec, httptest.NewRequest(http.MethodGet, "/api/history?limit=-1", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected a negative limit to be rejected but got %v", rec.Code)
+	}
+}
@@ -0,0 +1,120 @@
+package server
+
+import (
+	"sync"
+	"time"
+
+	"github.com/demskie/subnetmath"
+)
+
+const (
+	defaultReverseDNSCacheSize = 1 << 16
+	reverseDNSInterval         = time.Minute
+	reverseDNSConcurrency      = 8
+)
+
+// SetReverseDNSServers enables PTR lookups for every address that has replied to a probe or is a known host
+func (ipam *IPAMServer) SetReverseDNSServers(servers ...string) error {
+	err := ipam.rdns.SetServers(servers...)
+	if err != nil {
+		return err
+	}
+	select {
+	case ipam.rdnsWake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// getHostnames prefers imported forward records and falls back to cached PTR records
+func (ipam *IPAMServer) getHostnames(addresses []string) []string {
+	hostnames := ipam.dns.GetFirstHostnameForAddresses(addresses)
+	reverseRecords := ipam.rdns.GetCachedHostnames(addresses)
+	for i := range hostnames {
+		if hostnames[i] == "" {
+			hostnames[i] = reverseRecords[i]
+		}
+	}
+	return hostnames
+}
+
+// reverseDNSAddresses returns every address that has replied to a probe along with the single host
+// subnets in the tree and in history so that reserved hosts are named before they are ever seen alive
+func (ipam *IPAMServer) reverseDNSAddresses() []string {
+	addresses := ipam.pinger.GetAliveAddresses()
+	seen := make(map[string]bool, len(addresses))
+	for _, address := range addresses {
+		seen[address] = true
+	}
+	add := func(cidr string) {
+		network := subnetmath.ParseNetworkCIDR(cidr)
+		if network == nil {
+			return
+		}
+		if ones, bits := network.Mask.Size(); ones != bits {
+			return
+		}
+		address := network.IP.String()
+		if !seen[address] {
+			seen[address] = true
+			addresses = append(addresses, address)
+		}
+	}
+	for _, cidr := range ipam.getAllSubnetCIDRs() {
+		add(cidr)
+	}
+	for _, evt := range ipam.history.GetAllEvents() {
+		add(evt.Target)
+	}
+	return addresses
+}
+
+// resolveReverseDNS refreshes expired PTR records until Shutdown is called
+func (ipam *IPAMServer) resolveReverseDNS() {
+	defer ipam.workers.Done()
+	ticker := time.NewTicker(reverseDNSInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-ipam.rdnsWake:
+		case <-ipam.ctx.Done():
+			return
+		}
+		if !ipam.rdns.Enabled() {
+			continue
+		}
+		semaphore := make(chan struct{}, reverseDNSConcurrency)
+		wg := &sync.WaitGroup{}
+		failures := 0
+		var lastErr error
+		failuresMtx := &sync.Mutex{}
+	loop:
+		for _, address := range ipam.reverseDNSAddresses() {
+			if !ipam.rdns.Due(address) {
+				continue
+			}
+			select {
+			case semaphore <- struct{}{}:
+			case <-ipam.ctx.Done():
+				break loop
+			}
+			wg.Add(1)
+			go func(address string) {
+				defer wg.Done()
+				_, err := ipam.rdns.Lookup(address)
+				if err != nil {
+					failuresMtx.Lock()
+					failures++
+					lastErr = err
+					failuresMtx.Unlock()
+				}
+				<-semaphore
+			}(address)
+		}
+		wg.Wait()
+		if failures > 0 {
+			ipam.log.Errorf("unable to resolve %v PTR records > %v\n", failures, lastErr)
+		}
+	}
+}
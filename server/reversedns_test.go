@@ -0,0 +1,30 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestReverseDNSIncludesKnownHosts(t *testing.T) {
+	ipam := newTestServer(t)
+	ipam.SetAuthCallback(func(user, pass string) bool { return pass == "secret" })
+	for _, body := range []string{
+		`{"user":"alice","pass":"secret","subnet":"10.0.0.0/24"}`,
+		`{"user":"alice","pass":"secret","subnet":"10.0.0.5/32"}`,
+		`{"user":"alice","pass":"secret","subnet":"10.0.0.6/32"}`,
+		`{"user":"alice","pass":"secret","subnet":"2001:db8::7/128"}`,
+	} {
+		if rec := postJSON(ipam.handleRestfulCreateSubnet, "/api/createsubnet", body); rec.Code != http.StatusOK {
+			t.Fatalf("expected %v to be created but got %v %v", body, rec.Code, rec.Body.String())
+		}
+	}
+	rec := postJSON(ipam.handleRestfulDeleteSubnet, "/api/deletesubnet", `{"user":"alice","pass":"secret","subnet":"10.0.0.6/32"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected the subnet to be deleted but got %v %v", rec.Code, rec.Body.String())
+	}
+	addresses := strings.Join(ipam.reverseDNSAddresses(), " ")
+	if addresses != "2001:db8::7 10.0.0.5 10.0.0.6" {
+		t.Fatalf("expected each host from the tree and then history once but got '%v'", addresses)
+	}
+}
@@ -0,0 +1,77 @@
+package server
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/demskie/subnetmath"
+
+	"github.com/demskie/ipam/server/events"
+	"github.com/demskie/ipam/server/history"
+	"github.com/demskie/ipam/server/subnets"
+)
+
+type revertError struct {
+	conflict bool
+	missing  bool
+	message  string
+}
+
+func (e *revertError) Error() string {
+	return e.message
+}
+
+// revertHistoryEvent applies the inverse of a previously recorded event and records the revert as a new event
+func (ipam *IPAMServer) revertHistoryEvent(eventID, actor, sourceIP string) (history.Event, error) {
+	original, exists := ipam.history.GetEvent(eventID)
+	if !exists {
+		return history.Event{}, &revertError{missing: true, message: fmt.Sprintf("could not revert '%v' as it does not exist", eventID)}
+	}
+	if !original.IsReversible() {
+		return history.Event{}, fmt.Errorf("could not revert '%v' as it does not record the previous state", eventID)
+	}
+	network := subnetmath.ParseNetworkCIDR(original.Target)
+	if network == nil {
+		return history.Event{}, fmt.Errorf("could not revert '%v' as '%v' is not a valid CIDR subnet", eventID, original.Target)
+	}
+	var restored *subnets.SubnetSkeleton
+	if original.Before != nil {
+		restored = &subnets.SubnetSkeleton{
+			Net:     network.String(),
+			Desc:    original.Before.Desc,
+			Details: original.Before.Details,
+			Vlan:    original.Before.Vlan,
+			Mod:     time.Now().Format(defaultTimeLayout),
+		}
+	}
+	err := ipam.subnets.SwapSubnet(network, original.After, restored)
+	if err == subnets.ErrConflict {
+		s := fmt.Sprintf("could not revert '%v' because '%v' has changed since", eventID, original.Target)
+		return history.Event{}, &revertError{conflict: true, message: s}
+	} else if err != nil {
+		return history.Event{}, err
+	}
+	record, err := ipam.commitMutation(history.Event{
+		Actor:    actor,
+		SourceIP: sourceIP,
+		Action:   history.ActionRevert,
+		Target:   network.String(),
+		Before:   original.After,
+		After:    restored,
+		RevertOf: original.ID,
+	})
+	if err != nil {
+		return history.Event{}, err
+	}
+	return record, nil
+}
+
+func revertEventType(record history.Event) string {
+	switch {
+	case record.Before == nil:
+		return events.SubnetCreated
+	case record.After == nil:
+		return events.SubnetDeleted
+	}
+	return events.SubnetModified
+}
@@ -0,0 +1,168 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/demskie/ipam/server/history"
+	"github.com/gorilla/mux"
+)
+
+// failingStore refuses every save once fail is set
+type failingStore struct {
+	fail bool
+}
+
+func (fs *failingStore) Load() (*MutatedData, error) {
+	return &MutatedData{}, nil
+}
+
+func (fs *failingStore) Save(data MutatedData) error {
+	if fs.fail {
+		return errors.New("disk is full")
+	}
+	return nil
+}
+
+func (fs *failingStore) Close() error {
+	return nil
+}
+
+func mutate(t *testing.T, handler http.HandlerFunc, path, body string) string {
+	t.Helper()
+	rec := postJSON(handler, path, `{"user":"alice","pass":"secret",`+body[1:])
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected %v %v to succeed but got %v %v", path, body, rec.Code, rec.Body.String())
+	}
+	return rec.Body.String()
+}
+
+func newestEventID(ipam *IPAMServer) string {
+	evts := ipam.history.GetAllEvents()
+	return evts[len(evts)-1].ID
+}
+
+func revert(ipam *IPAMServer, eventID, body string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/api/revert/"+eventID, strings.NewReader(body))
+	ipam.handleRestfulRevert(rec, mux.SetURLVars(req, map[string]string{"eventID": eventID}))
+	return rec
+}
+
+func expectSubnets(t *testing.T, ipam *IPAMServer, expected string) {
+	t.Helper()
+	descriptions := []string{}
+	for _, sn := range ipam.subnets.GetAllSubnets() {
+		descriptions = append(descriptions, sn.Net+"="+sn.Desc)
+	}
+	if actual := strings.Join(descriptions, " "); actual != expected {
+		t.Fatalf("expected subnets '%v' but got '%v'", expected, actual)
+	}
+}
+
+func TestRevertRestoresPreviousState(t *testing.T) {
+	ipam := newAuthTestServer(t)
+	const credentials = `{"user":"bob","pass":"secret"}`
+	mutate(t, ipam.handleRestfulCreateSubnet, "/api/createsubnet", `{"subnet":"10.0.0.0/24","description":"one"}`)
+	created := newestEventID(ipam)
+	mutate(t, ipam.handleRestfulReplaceSubnet, "/api/replacesubnet", `{"subnet":"10.0.0.0/24","description":"two"}`)
+	replaced := newestEventID(ipam)
+
+	rec := revert(ipam, replaced, credentials)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected the modification to be reverted but got %v %v", rec.Code, rec.Body.String())
+	}
+	record := history.Event{}
+	json.NewDecoder(rec.Body).Decode(&record)
+	if record.Action != history.ActionRevert || record.RevertOf != replaced || record.Actor != "bob" ||
+		record.Before.Desc != "two" || record.After.Desc != "one" {
+		t.Fatalf("expected the revert to be recorded against the modification but got %+v", record)
+	}
+	expectSubnets(t, ipam, "10.0.0.0/24=one")
+
+	// the subnet now matches what the create produced so it can be reverted too
+	if rec = revert(ipam, created, credentials); rec.Code != http.StatusOK {
+		t.Fatalf("expected the creation to be reverted but got %v %v", rec.Code, rec.Body.String())
+	}
+	expectSubnets(t, ipam, "")
+
+	// reverting the revert of a creation brings the subnet back
+	if rec = revert(ipam, newestEventID(ipam), credentials); rec.Code != http.StatusOK {
+		t.Fatalf("expected the revert to be reverted but got %v %v", rec.Code, rec.Body.String())
+	}
+	expectSubnets(t, ipam, "10.0.0.0/24=one")
+	if evts := ipam.history.GetAllEvents(); len(evts) != 5 {
+		t.Fatalf("expected every revert to be recorded but history has %v events", len(evts))
+	}
+}
+
+func TestRevertRestoresDeletedSubnet(t *testing.T) {
+	ipam := newAuthTestServer(t)
+	mutate(t, ipam.handleRestfulCreateSubnet, "/api/createsubnet", `{"subnet":"10.0.0.0/24","description":"one","vlan":"7"}`)
+	mutate(t, ipam.handleRestfulDeleteSubnet, "/api/deletesubnet", `{"subnet":"10.0.0.0/24"}`)
+	if rec := revert(ipam, newestEventID(ipam), `{"user":"bob","pass":"secret"}`); rec.Code != http.StatusOK {
+		t.Fatalf("expected the deletion to be reverted but got %v %v", rec.Code, rec.Body.String())
+	}
+	expectSubnets(t, ipam, "10.0.0.0/24=one")
+	if sn := ipam.subnets.GetAllSubnets()[0]; sn.Vlan != "7" {
+		t.Fatalf("expected every field to be restored but got %+v", sn)
+	}
+}
+
+func TestRevertErrors(t *testing.T) {
+	store := &failingStore{}
+	ipam, err := NewIPAMServer(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ipam.Shutdown(context.Background()) })
+	ipam.SetAuthCallback(func(user, pass string) bool { return pass == "secret" })
+	const credentials = `{"user":"bob","pass":"secret"}`
+	mutate(t, ipam.handleRestfulCreateSubnet, "/api/createsubnet", `{"subnet":"10.0.0.0/24","description":"one"}`)
+	created := newestEventID(ipam)
+	mutate(t, ipam.handleRestfulReplaceSubnet, "/api/replacesubnet", `{"subnet":"10.0.0.0/24","description":"two"}`)
+	mutate(t, ipam.handleRestfulCreateSubnet, "/api/createsubnet", `{"subnet":"10.0.1.0/24","description":"three"}`)
+	unchanged := newestEventID(ipam)
+	ipam.history.OverwriteEvents(append(ipam.history.GetAllEvents(), history.Event{
+		ID:     "legacy",
+		Action: history.ActionModifySubnet,
+		Target: "10.0.0.0/24",
+		Legacy: "04-22-2019 10:00:00 (alice) is pushing changes: [net='10.0.0.0/24' desc='two']",
+	}))
+
+	tests := []struct {
+		name    string
+		eventID string
+		body    string
+		status  int
+		message string
+	}{
+		{"invalid body", created, `{"user":`, http.StatusBadRequest, ""},
+		{"wrong password", created, `{"user":"bob","pass":"wrong"}`, http.StatusUnauthorized, "auth failure"},
+		{"missing event", "0123456789abcdef", credentials, http.StatusNotFound, "does not exist"},
+		{"changed by a later event", created, credentials, http.StatusConflict, "has changed since"},
+		{"legacy modification", "legacy", credentials, http.StatusBadRequest, "does not record the previous state"},
+	}
+	for _, test := range tests {
+		rec := revert(ipam, test.eventID, test.body)
+		if rec.Code != test.status || !strings.Contains(rec.Body.String(), test.message) {
+			t.Fatalf("%v: expected %v '%v' but got %v %v", test.name, test.status, test.message, rec.Code, rec.Body.String())
+		}
+	}
+	expectSubnets(t, ipam, "10.0.0.0/24=two 10.0.1.0/24=three")
+
+	store.fail = true
+	rec := revert(ipam, unchanged, credentials)
+	if rec.Code != http.StatusInternalServerError || !strings.Contains(rec.Body.String(), "disk is full") {
+		t.Fatalf("expected a failed save to be reported but got %v %v", rec.Code, rec.Body.String())
+	}
+	expectSubnets(t, ipam, "10.0.0.0/24=two 10.0.1.0/24=three")
+	if evts := ipam.history.GetAllEvents(); evts[len(evts)-1].ID != "legacy" {
+		t.Fatalf("expected the failed revert to be discarded but history ends with %+v", evts[len(evts)-1])
+	}
+}
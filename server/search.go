@@ -41,7 +41,7 @@ func (ipam *IPAMServer) searchHostData(query string, stopChan chan struct{}) Hos
 		}
 		hostData := HostData{
 			Addresses:    sliceOfAddresses,
-			Arecords:     ipam.dns.GetFirstHostnameForAddresses(sliceOfAddresses),
+			Arecords:     ipam.getHostnames(sliceOfAddresses),
 			LastAttempts: ipam.pinger.GetPingTimesForAddresses(sliceOfAddresses),
 			PingResults:  ipam.pinger.GetPingResultsForAddresses(sliceOfAddresses),
 			CustomData:   ipam.custom.GetCustomData(sliceOfAddresses),
@@ -56,7 +56,7 @@ func (ipam *IPAMServer) searchHostData(query string, stopChan chan struct{}) Hos
 	}
 	hostData := HostData{
 		Addresses:    sliceOfAddresses,
-		Arecords:     ipam.dns.GetFirstHostnameForAddresses(sliceOfAddresses),
+		Arecords:     ipam.getHostnames(sliceOfAddresses),
 		LastAttempts: ipam.pinger.GetPingTimesForAddresses(sliceOfAddresses),
 		PingResults:  ipam.pinger.GetPingResultsForAddresses(sliceOfAddresses),
 		CustomData:   ipam.custom.GetCustomData(sliceOfAddresses),
@@ -1,82 +1,276 @@
 package server
 
 import (
-	"crypto/tls"
-	"log"
+	"context"
+	"crypto/ed25519"
+	"fmt"
+	"net"
 	"net/http"
-	"net/http/pprof"
-	"path/filepath"
+	"strings"
 	"sync"
 	"time"
 
-	"github.com/davecgh/go-spew/spew"
-	"github.com/demskie/archive"
+	"github.com/demskie/ipam/server/auth"
 	"github.com/demskie/ipam/server/custom"
 	"github.com/demskie/ipam/server/dns"
+	"github.com/demskie/ipam/server/events"
 	"github.com/demskie/ipam/server/history"
+	"github.com/demskie/ipam/server/logging"
 	"github.com/demskie/ipam/server/ping"
 	"github.com/demskie/ipam/server/subnets"
-
-	"github.com/demskie/randutil"
+	"github.com/demskie/ipam/server/sweep"
+	"github.com/demskie/ipam/server/syslog"
+	"github.com/demskie/ipam/server/timeline"
+	"github.com/demskie/ipam/server/webhooks"
+	"github.com/demskie/subnetmath"
 
 	"github.com/gorilla/mux"
-
-	gsyslog "github.com/hashicorp/go-syslog"
 )
 
 const (
-	defaultTimeLayout = "01-02-2006 15:04:05"
+	defaultTimeLayout         = "01-02-2006 15:04:05"
+	defaultSessionIdleTimeout = 30 * time.Minute
+	sessionSweepInterval      = time.Minute
+	defaultSweepInterval      = 15 * time.Minute
 )
 
 // IPAMServer is the object used to mutate and read data
 type IPAMServer struct {
-	mutationMtx     *sync.Mutex
-	mutationChan    chan MutatedData
-	demoModeBool    bool
-	authCallbackMtx *sync.RWMutex
-	authCallback    func(user, pass string) bool
-	subnets         *subnets.Tree
-	history         *history.UserActions
-	debug           *history.ServerLogger
-	dns             *dns.Bucket
-	pinger          *ping.Pinger
-	custom          *custom.Datastore
-	httpRouter      *mux.Router
-	semaphore       chan struct{}
-}
-
-// MutatedData contains the raw lines of the changed subnets.csv and history.txt files
+	mutationMtx   *sync.Mutex
+	mutationChan  chan MutatedData
+	demoModeBool  bool
+	authMtx       *sync.RWMutex
+	authenticator auth.Authenticator
+	authGroups    []string
+	sessions      *auth.SessionStore
+	origins       []string
+	subnets       *subnets.Tree
+	history       *history.UserActions
+	debug         *history.ServerLogger
+	log           *logging.Logger
+	dns           *dns.Bucket
+	rdns          *dns.Resolver
+	rdnsWake      chan struct{}
+	pinger        *ping.Pinger
+	sweeper       *sweep.Scheduler
+	custom        *custom.Datastore
+	events        *events.Broker
+	webhooks      *webhooks.Manager
+	httpRouter    *mux.Router
+	manualScans   *scanQueue
+	storeMtx      *sync.Mutex
+	store         Store
+	snapshotMtx   *sync.RWMutex
+	snapshots     *timeline.Archive
+	auditMtx      *sync.RWMutex
+	auditSyslogs  []*syslog.Writer
+	diagSyslogs   []*syslog.Writer
+	lifecycleMtx  *sync.RWMutex
+	ctx           context.Context
+	cancel        context.CancelFunc
+	started       bool
+	stopping      bool
+	stopped       chan struct{}
+	workers       *sync.WaitGroup
+	webOptions    WebOptions
+	httpServers   []*http.Server
+	pingStatePath string
+	wsMtx         *sync.Mutex
+	wsClients     map[*wsClient]struct{}
+	wsActive      *sync.WaitGroup
+}
+
+// MutatedData contains the raw lines of the changed subnets.csv and history.jsonl files
 type MutatedData struct {
 	CommitMsg string
+	Actor     string
 	Subnets   []string
 	History   []string
+	Webhooks  []string // registrations as JSON lines including their secrets
+}
+
+// logger is used by stores until an IPAMServer gives them its own log
+var logger = logging.New(nil)
+
+// loggerSetter is implemented by components that are able to log through the server log of their IPAMServer
+type loggerSetter interface {
+	SetLogger(log *logging.Logger)
+}
+
+// NewIPAMServer returns a new server object. If a store is provided then its data is loaded immediately.
+func NewIPAMServer(store Store) (*IPAMServer, error) {
+	ipam := &IPAMServer{
+		mutationMtx:   &sync.Mutex{},
+		mutationChan:  nil,
+		demoModeBool:  false,
+		authMtx:       &sync.RWMutex{},
+		authenticator: auth.CallbackAuthenticator(func(user, pass string) bool { return false }),
+		authGroups:    []string{},
+		sessions:      auth.NewSessionStore(defaultSessionIdleTimeout),
+		origins:       nil,
+		subnets:       subnets.NewTree(),
+		history:       history.NewUserActions(),
+		debug:         history.NewServerLogger(),
+		dns:           dns.NewBucket(),
+		rdns:          dns.NewResolver(defaultReverseDNSCacheSize),
+		rdnsWake:      make(chan struct{}, 1),
+		pinger:        ping.NewPinger(),
+		sweeper:       sweep.NewScheduler(defaultSweepInterval),
+		custom:        custom.NewDatastore(),
+		events:        events.NewBroker(),
+		webhooks:      webhooks.NewManager(),
+		httpRouter:    mux.NewRouter(),
+		manualScans:   newScanQueue(DefaultManualScanLimits),
+		storeMtx:      &sync.Mutex{},
+		store:         store,
+		snapshots:     timeline.NewMemoryArchive(),
+		snapshotMtx:   &sync.RWMutex{},
+		auditMtx:      &sync.RWMutex{},
+		lifecycleMtx:  &sync.RWMutex{},
+		stopped:       make(chan struct{}),
+		workers:       &sync.WaitGroup{},
+		webOptions:    WebOptions{InsecureAddr: ":80", SecureAddr: ":443", DebugAddr: ":8080"},
+		wsMtx:         &sync.Mutex{},
+		wsClients:     make(map[*wsClient]struct{}, 0),
+		wsActive:      &sync.WaitGroup{},
+	}
+	ipam.ctx, ipam.cancel = context.WithCancel(context.Background())
+	ipam.log = logging.New(ipam.debug)
+	for _, component := range []loggerSetter{ipam.history, ipam.events, ipam.webhooks, ipam.pinger} {
+		component.SetLogger(ipam.log)
+	}
+	if component, ok := store.(loggerSetter); ok {
+		component.SetLogger(ipam.log)
+	}
+	ipam.pinger.SetHostnameLookup(ipam.getHostnames)
+	ipam.httpRouter.Use(ipam.requestLogger)
+	ipam.pinger.SetStatusChangeCallback(func(address string, reachable bool) {
+		ipam.events.Publish(events.Event{
+			Type:      events.PingStatusChanged,
+			Address:   address,
+			Reachable: reachable,
+		})
+	})
+	if store != nil {
+		data, err := store.Load()
+		if err != nil {
+			ipam.cancel()
+			return nil, fmt.Errorf("unable to load from store > %v", err)
+		}
+		err = ipam.IngestSubnetCSVLines(data.Subnets)
+		if err != nil {
+			ipam.cancel()
+			return nil, fmt.Errorf("unable to ingest subnets from store > %v", err)
+		}
+		ipam.IngestUserHistory(data.History)
+		err = ipam.webhooks.Restore(data.Webhooks)
+		if err != nil {
+			ipam.cancel()
+			return nil, fmt.Errorf("unable to restore webhooks from store > %v", err)
+		}
+	}
+	ipam.events.Seed(eventRevisionSeed(ipam.history.GetAllEvents(), time.Now()))
+	ipam.snapshotIfDue()
+	// workers are only started once nothing else can fail so that an error never leaves them running
+	ipam.addWorker()
+	go ipam.runManualScans()
+	ipam.addWorker()
+	go ipam.resolveReverseDNS()
+	ipam.addWorker()
+	go ipam.runSnapshots()
+	ipam.addWorker()
+	go ipam.runSessionSweep()
+	return ipam, nil
 }
 
-// NewIPAMServer returns a new server object
-func NewIPAMServer() *IPAMServer {
-	return &IPAMServer{
-		mutationMtx:     &sync.Mutex{},
-		mutationChan:    nil,
-		demoModeBool:    false,
-		authCallbackMtx: &sync.RWMutex{},
-		authCallback:    func(user, pass string) bool { return false },
-		subnets:         subnets.NewTree(),
-		history:         history.NewUserActions(),
-		debug:           history.NewServerLogger(),
-		dns:             dns.NewBucket(),
-		pinger:          ping.NewPinger(),
-		custom:          custom.NewDatastore(),
-		httpRouter:      mux.NewRouter(),
-		semaphore:       make(chan struct{}, 10000),
+var historyActionEvents = map[history.Action]string{
+	history.ActionCreateSubnet:  events.SubnetCreated,
+	history.ActionModifySubnet:  events.SubnetModified,
+	history.ActionDeleteSubnet:  events.SubnetDeleted,
+	history.ActionReserveHost:   events.SubnetReserved,
+	history.ActionReserveSubnet: events.SubnetReserved,
+}
+
+type saveError struct {
+	message string
+}
+
+func (e *saveError) Error() string {
+	return e.message
+}
+
+// commitMutation records and persists a change that has already been applied to the subnets
+// and undoes that change if it could not be saved
+func (ipam *IPAMServer) commitMutation(evt history.Event) (history.Event, error) {
+	ipam.storeMtx.Lock()
+	// recording under storeMtx guarantees that a failed event is still the newest when it is discarded
+	record := ipam.history.Record(evt)
+	data, err := ipam.persistMutation(record.Message(), record.Actor)
+	if err != nil {
+		ipam.history.Discard(record.ID)
+		ipam.storeMtx.Unlock()
+		rollbackErr := ipam.subnets.SwapSubnet(subnetmath.ParseNetworkCIDR(record.Target), record.After, record.Before)
+		if rollbackErr != nil {
+			ipam.log.Errorf("unable to roll back '%v' > %v\n", strings.TrimSpace(record.Message()), rollbackErr)
+		}
+		return history.Event{}, &saveError{fmt.Sprintf("unable to save changes > %v", err)}
 	}
+	ipam.storeMtx.Unlock()
+	ipam.signalMutation(record, data)
+	return record, nil
 }
 
-func (ipam *IPAMServer) signalMutation(reason string) {
-	ipam.mutationChan <- MutatedData{
+func (ipam *IPAMServer) signalMutation(record history.Event, data MutatedData) {
+	evtType := historyActionEvents[record.Action]
+	if record.Action == history.ActionRevert {
+		evtType = revertEventType(record)
+	}
+	evt := ipam.events.Publish(events.Event{
+		Type:   evtType,
+		Time:   record.Time,
+		Actor:  record.Who(),
+		Subnet: record.Target,
+		Old:    record.Before,
+		New:    record.After,
+	})
+	ipam.webhooks.Dispatch(evt)
+	ipam.sendAuditSyslog(record)
+	ipam.debug.Logf(logging.LevelInfo, "", record.SourceIP, record.Actor, "%v", strings.TrimSpace(record.Message()))
+	ipam.events.Publish(events.Event{
+		Type:    events.HistoryAppended,
+		Time:    record.Time,
+		Actor:   record.Who(),
+		Subnet:  record.Target,
+		History: record.String(),
+	})
+	ipam.lifecycleMtx.RLock()
+	defer ipam.lifecycleMtx.RUnlock()
+	if ipam.mutationChan != nil && ipam.ctx.Err() == nil {
+		select {
+		case ipam.mutationChan <- data:
+		case <-ipam.ctx.Done():
+		}
+	}
+}
+
+// persistMutation must be called while holding storeMtx
+func (ipam *IPAMServer) persistMutation(reason, actor string) (MutatedData, error) {
+	data := MutatedData{
 		CommitMsg: reason,
+		Actor:     actor,
 		Subnets:   ipam.ExportSubnetCSVLines(),
-		History:   ipam.history.GetAllUserActions(),
+		History:   ipam.history.ExportJSONL(),
+		Webhooks:  ipam.webhooks.Export(),
+	}
+	if ipam.store != nil {
+		err := ipam.store.Save(data)
+		if err != nil {
+			ipam.log.Errorf("unable to persist '%v' > %v\n", strings.TrimSpace(reason), err)
+			return data, err
+		}
 	}
+	ipam.snapshotIfDue()
+	return data, nil
 }
 
 // EnableDemoMode is used to fake ping results for demonstration purposes
@@ -86,37 +280,238 @@ func (ipam *IPAMServer) EnableDemoMode() {
 	ipam.demoModeBool = true
 }
 
+// SetPingSocketMode chooses between raw and unprivileged ICMP sockets (defaults to ping.SocketAuto)
+func (ipam *IPAMServer) SetPingSocketMode(mode ping.SocketMode) {
+	ipam.pinger.SetSocketMode(mode)
+}
+
+// SetPingStateFile restores ping results from path and then saves them there on every interval
+func (ipam *IPAMServer) SetPingStateFile(path string, interval time.Duration) error {
+	err := ipam.pinger.LoadState(path)
+	if err != nil {
+		return err
+	}
+	ipam.lifecycleMtx.Lock()
+	ipam.pingStatePath = path
+	ipam.lifecycleMtx.Unlock()
+	if !ipam.addWorker() {
+		return nil
+	}
+	go func() {
+		defer ipam.workers.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+			case <-ipam.ctx.Done():
+				return
+			}
+			err := ipam.pinger.SaveState(path)
+			if err != nil {
+				ipam.log.Errorf("unable to save ping results > %v\n", err)
+			}
+		}
+	}()
+	return nil
+}
+
 // SetAuthCallback is used to specify whether users are authenticated to make modifications
 func (ipam *IPAMServer) SetAuthCallback(callback func(user, pass string) bool) {
-	ipam.authCallbackMtx.Lock()
-	defer ipam.authCallbackMtx.Unlock()
-	ipam.authCallback = callback
+	ipam.SetAuthenticator(auth.CallbackAuthenticator(callback))
+}
+
+// SetAuthenticator is used to specify the backend that authenticates users before modifications
+func (ipam *IPAMServer) SetAuthenticator(authenticator auth.Authenticator) {
+	if component, ok := authenticator.(loggerSetter); ok {
+		component.SetLogger(ipam.log)
+	}
+	ipam.authMtx.Lock()
+	defer ipam.authMtx.Unlock()
+	ipam.authenticator = authenticator
+}
+
+// SetAuthorizedGroups restricts modifications to users that are a member of at least one group
+func (ipam *IPAMServer) SetAuthorizedGroups(groups ...string) {
+	ipam.authMtx.Lock()
+	defer ipam.authMtx.Unlock()
+	ipam.authGroups = append([]string{}, groups...)
+}
+
+// SetSessionIdleTimeout specifies how long a websocket login remains valid without activity
+func (ipam *IPAMServer) SetSessionIdleTimeout(idleTimeout time.Duration) {
+	ipam.sessions.SetIdleTimeout(idleTimeout)
+}
+
+// runSessionSweep ends idle sessions of clients that never logged out or disconnected
+func (ipam *IPAMServer) runSessionSweep() {
+	defer ipam.workers.Done()
+	ticker := time.NewTicker(sessionSweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-ipam.ctx.Done():
+			return
+		}
+		if expired := ipam.sessions.Sweep(); expired > 0 {
+			ipam.log.Debugf("expired %v idle sessions\n", expired)
+		}
+	}
+}
+
+// SetAllowedOrigins permits websocket connections from these origins in addition to the same host ("*" permits any)
+func (ipam *IPAMServer) SetAllowedOrigins(origins ...string) {
+	ipam.authMtx.Lock()
+	defer ipam.authMtx.Unlock()
+	ipam.origins = append([]string{}, origins...)
+}
+
+// SetAuditSigningKey signs every Nth history event with the key so the hash chain can be anchored to a trusted checkpoint
+func (ipam *IPAMServer) SetAuditSigningKey(key ed25519.PrivateKey, checkpointEvery int) error {
+	ipam.storeMtx.Lock()
+	defer ipam.storeMtx.Unlock()
+	if !ipam.history.SetSigningKey(key, checkpointEvery) {
+		return nil
+	}
+	// history migrated from history.txt has just been signed and would otherwise be migrated again without signatures
+	_, err := ipam.persistMutation("signing history migrated from history.txt\n", "")
+	return err
+}
+
+func (ipam *IPAMServer) authenticate(user, pass string) (*auth.Identity, error) {
+	ipam.authMtx.RLock()
+	authenticator, groups := ipam.authenticator, ipam.authGroups
+	ipam.authMtx.RUnlock()
+	id, err := authenticator.Authenticate(user, pass)
+	if err != nil {
+		return nil, err
+	}
+	if len(groups) > 0 && !id.InGroup(groups...) {
+		return nil, fmt.Errorf("'%v' is not a member of any authorized group", id.User)
+	}
+	return id, nil
 }
 
-// PingSweepSubnets will pick subnets at random and ping all nonBroadcast addresses
+// PingSweepSubnets will ping every subnet within its sweep interval starting with the most overdue until Shutdown is called
 func (ipam *IPAMServer) PingSweepSubnets(pingsPerSecond, pingerGoroutineCount int) {
+	if !ipam.addWorker() {
+		return
+	}
+	defer ipam.workers.Done()
 	go ipam.pinger.InitializeBackgroundPinger(pingsPerSecond, pingerGoroutineCount)
-	rnum := randutil.CreateBasicMathRnum()
-	for {
-		subnet := ipam.subnets.GetRandomNetwork(rnum)
-		if subnet != nil {
-			if !ipam.demoModeBool {
-				ipam.pinger.ScanNetwork(subnet)
-			} else {
-				ipam.pinger.ScanPretendNetwork(subnet)
+	for ipam.ctx.Err() == nil {
+		cidrs := ipam.getAllSubnetCIDRs()
+		cidr, ok := ipam.sweeper.Next(cidrs, time.Now())
+		if !ok {
+			select {
+			case <-time.After(time.Second):
+			case <-ipam.ctx.Done():
+			}
+			continue
+		}
+		network := subnetmath.ParseNetworkCIDR(cidr)
+		if network != nil {
+			for _, part := range ipam.sweepRanges(network, cidrs) {
+				if !ipam.demoModeBool {
+					ipam.pinger.ScanNetwork(part)
+				} else {
+					ipam.pinger.ScanPretendNetwork(part)
+				}
 			}
 		}
-		time.Sleep(128 * time.Millisecond)
+		ipam.sweeper.Done(cidr, time.Now())
+	}
+}
+
+// sweepRanges returns the parts of network that are neither excluded nor swept on behalf of a more specific subnet
+func (ipam *IPAMServer) sweepRanges(network *net.IPNet, cidrs []string) []*net.IPNet {
+	skip := ipam.sweeper.ExcludedWithin(network)
+	for _, cidr := range cidrs {
+		child := subnetmath.ParseNetworkCIDR(cidr)
+		if child == nil || subnetmath.NetworksAreIdentical(child, network) {
+			continue
+		}
+		if subnetmath.NetworkContainsSubnet(network, child) {
+			skip = append(skip, child)
+		}
+	}
+	if len(skip) == 0 {
+		return []*net.IPNet{network}
+	}
+	return subnetmath.FindUnusedSubnets(network, skip...)
+}
+
+func (ipam *IPAMServer) getAllSubnetCIDRs() []string {
+	allSubnets := ipam.subnets.GetAllSubnets()
+	cidrs := make([]string, len(allSubnets))
+	for i, sn := range allSubnets {
+		cidrs[i] = sn.Net
+	}
+	return cidrs
+}
+
+// SetSweepInterval changes how often every subnet is swept unless it has its own interval
+func (ipam *IPAMServer) SetSweepInterval(interval time.Duration) {
+	ipam.sweeper.SetDefaultInterval(interval)
+}
+
+// SetSubnetSweepInterval changes how often a subnet and everything inside of it is swept
+func (ipam *IPAMServer) SetSubnetSweepInterval(cidr string, interval time.Duration) error {
+	network := subnetmath.ParseNetworkCIDR(cidr)
+	if network == nil {
+		return fmt.Errorf("'%v' is not a valid CIDR network", cidr)
+	}
+	ipam.sweeper.SetSubnetSettings(network.String(), sweep.Settings{Interval: interval})
+	return nil
+}
+
+// ExcludeSubnetFromSweep stops the background sweep from pinging a subnet and everything inside of it
+func (ipam *IPAMServer) ExcludeSubnetFromSweep(cidr string) error {
+	network := subnetmath.ParseNetworkCIDR(cidr)
+	if network == nil {
+		return fmt.Errorf("'%v' is not a valid CIDR network", cidr)
+	}
+	ipam.sweeper.SetSubnetSettings(network.String(), sweep.Settings{Exclude: true})
+	return nil
+}
+
+// SetSubnetProbe chooses how addresses inside a subnet are probed such as "icmp", "tcp:22,443", "udp", "arp" or "tcp:22|icmp"
+func (ipam *IPAMServer) SetSubnetProbe(cidr, spec string) error {
+	network := subnetmath.ParseNetworkCIDR(cidr)
+	if network == nil {
+		return fmt.Errorf("'%v' is not a valid CIDR network", cidr)
+	}
+	return ipam.pinger.SetSubnetProber(network, spec)
+}
+
+// SetLogLevel changes the minimum level of server log entries that are recorded ("debug", "info", "warn" or "error")
+func (ipam *IPAMServer) SetLogLevel(level string) error {
+	l, err := logging.ParseLevel(level)
+	if err != nil {
+		return err
 	}
+	ipam.debug.SetLevel(l)
+	return nil
+}
+
+// CaptureStandardLogger records the lines the embedding application writes to the standard logger as well
+func (ipam *IPAMServer) CaptureStandardLogger() {
+	ipam.debug.CaptureStandardLogger()
+}
+
+// SetJSONLogging switches stdout from human readable lines to JSON lines
+func (ipam *IPAMServer) SetJSONLogging(enabled bool) {
+	ipam.debug.SetJSONOutput(enabled)
 }
 
-// AddSyslogServer is used for remote logging purposes
-func (ipam *IPAMServer) AddSyslogServer(address, port string) error {
-	wr, err := gsyslog.DialLogger("tcp", address+":"+port, gsyslog.LOG_INFO, "IPAM", "server")
+// SetLogFile writes JSON log lines to path and rotates it once it exceeds maxSize bytes or maxAge
+func (ipam *IPAMServer) SetLogFile(path string, maxSize int64, maxAge time.Duration, maxBackups int) error {
+	rf, err := history.NewRotatingFile(path, maxSize, maxAge, maxBackups)
 	if err != nil {
 		return err
 	}
-	ipam.debug.AddIOWriter(wr)
+	ipam.debug.SetFile(rf)
 	return nil
 }
 
@@ -134,128 +529,25 @@ func (ipam *IPAMServer) AttachCustomHandlerFunc(path string, handlerFunc func(w
 	ipam.httpRouter.HandleFunc(path, handlerFunc)
 }
 
-// ServeAndReceiveChan will start the HTTP Webserver and stream results back to the caller
+// ServeAndReceiveChan will start the HTTP Webserver and stream results back to the caller until Shutdown is called
 func (ipam *IPAMServer) ServeAndReceiveChan(directory, crtPath, keyPath string, debug bool) chan MutatedData {
+	ipam.lifecycleMtx.Lock()
 	if ipam.mutationChan != nil {
+		ipam.lifecycleMtx.Unlock()
 		return ipam.mutationChan
 	}
-	ipam.mutationMtx.Lock()
-	defer ipam.mutationMtx.Unlock()
 	ipam.mutationChan = make(chan MutatedData, 1)
+	ipam.webOptions.PublicDir = directory
+	ipam.webOptions.CrtPath = crtPath
+	ipam.webOptions.KeyPath = keyPath
+	ipam.webOptions.Debug = debug
+	ipam.lifecycleMtx.Unlock()
 	go func() {
-		ipam.startWebServer(debug, directory, crtPath, keyPath)
-		close(ipam.mutationChan)
+		err := ipam.Start(context.Background())
+		if err != nil {
+			ipam.log.Errorf("unable to start web server > %v\n", err)
+			ipam.Shutdown(context.Background())
+		}
 	}()
 	return ipam.mutationChan
 }
-
-func (ipam *IPAMServer) startWebServer(debug bool, publicDir, crtPath, keyPath string) {
-	// conditionally start debug server
-	if debug {
-		go startDebugServer()
-	}
-
-	// modify filepaths to match the environment's preferred filepath seperator
-	publicDir = filepath.Clean(publicDir)
-
-	// recurse through all static web content and create compressed copies
-	fileList, err := archive.CompressWebserverFiles(publicDir)
-	if err != nil {
-		log.Printf("unable to compress static webserver files because: %v\n", err)
-	}
-	log.Println("compressed the following:", spew.Sdump(fileList))
-
-	// conditionally start secure server
-	if crtPath != "" && keyPath != "" {
-		go startInsecureRedirectServer()
-		startSecureServer(publicDir, crtPath, keyPath, ipam)
-	} else {
-		startInsecureServer(publicDir, ipam)
-	}
-}
-
-func startDebugServer() {
-	profmux := http.NewServeMux()
-	profmux.HandleFunc("/debug/pprof/", pprof.Index)
-	profmux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
-	profmux.HandleFunc("/debug/pprof/profile", pprof.Profile)
-	profmux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
-	profmux.HandleFunc("/debug/pprof/trace", pprof.Trace)
-	profmux.Handle("/debug/pprof/block", pprof.Handler("block"))
-	profmux.Handle("/debug/pprof/goroutine", pprof.Handler("goroutine"))
-	profmux.Handle("/debug/pprof/heap", pprof.Handler("heap"))
-	profmux.Handle("/debug/pprof/threadcreate", pprof.Handler("threadcreate"))
-	profmux.Handle("/debug/pprof/mutex", pprof.Handler("mutex"))
-	srvProf := &http.Server{
-		Addr:    ":8080",
-		Handler: profmux,
-	}
-	errServeInsecure := srvProf.ListenAndServe()
-	log.Printf("startDebugServer: %v\n", errServeInsecure)
-}
-
-func startSecureServer(publicDir, crtPath, keyPath string, ipam *IPAMServer) {
-	publicDir = filepath.Clean(publicDir)
-	crtPath = filepath.Clean(crtPath)
-	keyPath = filepath.Clean(keyPath)
-	ipam.mutationMtx.Lock()
-	ipam.httpRouter.HandleFunc("/api/subnets", ipam.handleRestfulSubnets)
-	ipam.httpRouter.HandleFunc("/api/hosts", ipam.handleRestfulSpecificHosts)
-	ipam.httpRouter.HandleFunc("/api/history", ipam.handleRestfulHistory)
-	ipam.httpRouter.HandleFunc("/api/createsubnet", ipam.handleRestfulCreateSubnet)
-	ipam.httpRouter.HandleFunc("/api/replacesubnet", ipam.handleRestfulReplaceSubnet)
-	ipam.httpRouter.HandleFunc("/api/deletesubnet", ipam.handleRestfulDeleteSubnet)
-	ipam.httpRouter.HandleFunc("/api/reservehost", ipam.handleRestfulReserveHost)
-	ipam.httpRouter.HandleFunc("/api/reservesubnet", ipam.handleRestfulReserveSubnet)
-	ipam.httpRouter.HandleFunc("/sync", ipam.handleWebsocketClient)
-	ipam.httpRouter.PathPrefix("/").Handler(archive.FileServer(http.Dir(publicDir)))
-	ipam.mutationMtx.Unlock()
-	srvSecure := &http.Server{
-		ReadTimeout:  60 * time.Second,
-		WriteTimeout: 60 * time.Second,
-		IdleTimeout:  60 * time.Second,
-		Addr:         ":443",
-		Handler:      ipam.httpRouter,
-		TLSConfig: &tls.Config{
-			MinVersion:               tls.VersionTLS12,
-			CurvePreferences:         []tls.CurveID{tls.CurveP521, tls.CurveP384, tls.CurveP256},
-			PreferServerCipherSuites: true,
-			CipherSuites:             nil, // use default
-		},
-		TLSNextProto: nil,
-	}
-	log.Fatal("startSecureServer: ", srvSecure.ListenAndServeTLS(crtPath, keyPath))
-}
-
-func startInsecureRedirectServer() {
-	log.Fatal("startInsecureRedirectServer: ",
-		http.ListenAndServe(":80", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			http.Redirect(w, r, "https://"+r.Host+r.URL.String(), http.StatusMovedPermanently)
-		})),
-	)
-}
-
-func startInsecureServer(publicDir string, ipam *IPAMServer) {
-	publicDir = filepath.Clean(publicDir)
-	ipam.mutationMtx.Lock()
-	ipam.httpRouter.HandleFunc("/source", ipam.handleRestfulSubnets)
-	ipam.httpRouter.HandleFunc("/api/subnets", ipam.handleRestfulSubnets)
-	ipam.httpRouter.HandleFunc("/api/hosts", ipam.handleRestfulSpecificHosts)
-	ipam.httpRouter.HandleFunc("/api/history", ipam.handleRestfulHistory)
-	ipam.httpRouter.HandleFunc("/api/createsubnet", ipam.handleRestfulCreateSubnet)
-	ipam.httpRouter.HandleFunc("/api/replacesubnet", ipam.handleRestfulReplaceSubnet)
-	ipam.httpRouter.HandleFunc("/api/deletesubnet", ipam.handleRestfulDeleteSubnet)
-	ipam.httpRouter.HandleFunc("/api/reservehost", ipam.handleRestfulReserveHost)
-	ipam.httpRouter.HandleFunc("/api/reservesubnet", ipam.handleRestfulReserveSubnet)
-	ipam.httpRouter.HandleFunc("/sync", ipam.handleWebsocketClient)
-	ipam.httpRouter.PathPrefix("/").Handler(archive.FileServer(http.Dir(publicDir)))
-	ipam.mutationMtx.Unlock()
-	srvInsecure := &http.Server{
-		Addr:         ":80",
-		Handler:      ipam.httpRouter,
-		ReadTimeout:  60 * time.Second,
-		WriteTimeout: 60 * time.Second,
-		IdleTimeout:  60 * time.Second,
-	}
-	log.Fatal("ListenAndServe: ", srvInsecure.ListenAndServe())
-}
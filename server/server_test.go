@@ -0,0 +1,27 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/demskie/ipam/server/sweep"
+	"github.com/demskie/subnetmath"
+)
+
+func TestSweepRangesSkipsChildrenAndExclusions(t *testing.T) {
+	ipam := &IPAMServer{sweeper: sweep.NewScheduler(time.Minute)}
+	network := subnetmath.ParseNetworkCIDR("10.0.0.0/22")
+	ranges := ipam.sweepRanges(network, []string{"10.0.0.0/22", "10.0.1.0/24", "192.168.0.0/24"})
+	if len(ranges) != 2 || ranges[0].String() != "10.0.0.0/24" || ranges[1].String() != "10.0.2.0/23" {
+		t.Fatalf("expected [10.0.0.0/24 10.0.2.0/23] but got %v", ranges)
+	}
+	ipam.sweeper.SetSubnetSettings("10.0.2.0/24", sweep.Settings{Exclude: true})
+	ranges = ipam.sweepRanges(network, []string{"10.0.0.0/22", "10.0.1.0/24"})
+	if len(ranges) != 2 || ranges[0].String() != "10.0.0.0/24" || ranges[1].String() != "10.0.3.0/24" {
+		t.Fatalf("expected [10.0.0.0/24 10.0.3.0/24] but got %v", ranges)
+	}
+	ranges = ipam.sweepRanges(subnetmath.ParseNetworkCIDR("10.0.3.0/24"), []string{"10.0.3.0/24"})
+	if len(ranges) != 1 || ranges[0].String() != "10.0.3.0/24" {
+		t.Fatalf("expected a subnet without children to be swept whole but got %v", ranges)
+	}
+}
@@ -0,0 +1,81 @@
+package server
+
+import (
+	"time"
+
+	"github.com/demskie/ipam/server/subnets"
+	"github.com/demskie/ipam/server/timeline"
+)
+
+// SetSnapshotDirectory persists periodic subnet snapshots to the directory and loads any that already exist
+func (ipam *IPAMServer) SetSnapshotDirectory(directory string) error {
+	archive, err := timeline.NewArchive(directory, ipam.log)
+	if err != nil {
+		return err
+	}
+	ipam.snapshotMtx.Lock()
+	ipam.snapshots = archive
+	ipam.snapshotMtx.Unlock()
+	ipam.snapshotIfDue()
+	return nil
+}
+
+// SetSnapshotInterval changes how often a snapshot of every subnet is taken
+func (ipam *IPAMServer) SetSnapshotInterval(interval time.Duration) {
+	ipam.snapshotMtx.RLock()
+	ipam.snapshots.SetInterval(interval)
+	ipam.snapshotMtx.RUnlock()
+}
+
+const snapshotCheckInterval = time.Minute
+
+// runSnapshots takes a snapshot whenever one is due until Shutdown is called
+func (ipam *IPAMServer) runSnapshots() {
+	defer ipam.workers.Done()
+	ticker := time.NewTicker(snapshotCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-ipam.ctx.Done():
+			return
+		}
+		ipam.storeMtx.Lock()
+		ipam.snapshotIfDue()
+		ipam.storeMtx.Unlock()
+	}
+}
+
+func (ipam *IPAMServer) snapshotIfDue() {
+	ipam.snapshotMtx.RLock()
+	archive := ipam.snapshots
+	ipam.snapshotMtx.RUnlock()
+	now := time.Now().Round(0)
+	if !archive.Due(now) {
+		return
+	}
+	_, err := archive.Add(timeline.Snapshot{Time: now, Subnets: ipam.subnets.GetAllSubnets()})
+	if err != nil {
+		ipam.log.Errorf("unable to take snapshot > %v\n", err)
+	}
+}
+
+// subnetsAsOf rebuilds the subnets as they existed at the requested time
+func (ipam *IPAMServer) subnetsAsOf(asOf time.Time) *subnets.Tree {
+	current := timeline.Snapshot{Time: time.Now().Round(0), Subnets: ipam.subnets.GetAllSubnets()}
+	ipam.snapshotMtx.RLock()
+	base, exists, err := ipam.snapshots.Nearest(asOf)
+	ipam.snapshotMtx.RUnlock()
+	if err != nil {
+		// replaying backwards from the current subnets is slower but still correct
+		ipam.log.Errorf("unable to load snapshot for %v > %v\n", asOf.Format(time.RFC3339), err)
+	}
+	if !exists || !asOf.Before(current.Time) {
+		base = current
+	}
+	tree, skipped := timeline.Replay(base, ipam.history.GetAllEvents(), asOf)
+	if skipped > 0 {
+		ipam.log.Warnf("%v history events could not be replayed while rebuilding subnets as of %v\n", skipped, asOf.Format(time.RFC3339))
+	}
+	return tree
+}
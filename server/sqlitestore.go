@@ -0,0 +1,150 @@
+package server
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	// registers the sqlite3 driver
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// sqliteCommitLimit is how many commit messages are kept
+const sqliteCommitLimit = 1000
+
+// SQLiteStore persists all data inside of an SQLite database
+type SQLiteStore struct {
+	db *sql.DB
+}
+
+// NewSQLiteStore opens or creates the database file
+func NewSQLiteStore(path string) (*SQLiteStore, error) {
+	db, err := sql.Open("sqlite3", path+"?_journal_mode=WAL&_synchronous=FULL&_busy_timeout=5000")
+	if err != nil {
+		return nil, fmt.Errorf("unable to open sqlite database > %v", err)
+	}
+	db.SetMaxOpenConns(1)
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS subnets (line INTEGER PRIMARY KEY, value TEXT NOT NULL);
+		CREATE TABLE IF NOT EXISTS history (line INTEGER PRIMARY KEY, value TEXT NOT NULL);
+		CREATE TABLE IF NOT EXISTS webhooks (line INTEGER PRIMARY KEY, value TEXT NOT NULL);
+		CREATE TABLE IF NOT EXISTS commits (id INTEGER PRIMARY KEY AUTOINCREMENT, message TEXT NOT NULL, time TEXT NOT NULL);
+	`)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("unable to create sqlite tables > %v", err)
+	}
+	return &SQLiteStore{db: db}, nil
+}
+
+func (ss *SQLiteStore) loadTable(table string) ([]string, error) {
+	rows, err := ss.db.Query("SELECT value FROM " + table + " ORDER BY line")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	lines := []string{}
+	for rows.Next() {
+		var line string
+		err = rows.Scan(&line)
+		if err != nil {
+			return nil, err
+		}
+		lines = append(lines, line)
+	}
+	return lines, rows.Err()
+}
+
+// Load reads the most recently saved data
+func (ss *SQLiteStore) Load() (*MutatedData, error) {
+	var err error
+	data := &MutatedData{}
+	data.Subnets, err = ss.loadTable("subnets")
+	if err != nil {
+		return nil, err
+	}
+	data.History, err = ss.loadTable("history")
+	if err != nil {
+		return nil, err
+	}
+	data.Webhooks, err = ss.loadTable("webhooks")
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func saveTable(tx *sql.Tx, table string, lines []string) error {
+	_, err := tx.Exec("DELETE FROM " + table)
+	if err != nil {
+		return err
+	}
+	return insertLines(tx, table, lines, 0)
+}
+
+func insertLines(tx *sql.Tx, table string, lines []string, first int) error {
+	stmt, err := tx.Prepare("INSERT INTO " + table + " (line, value) VALUES (?, ?)")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for i := first; i < len(lines); i++ {
+		_, err = stmt.Exec(i, lines[i])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// appendHistory only inserts the lines that follow the newest stored event. Events are hash chained
+// so a stored line that still matches means that every line before it is unchanged as well.
+func appendHistory(tx *sql.Tx, history []string) error {
+	var stored int
+	err := tx.QueryRow("SELECT COUNT(*) FROM history").Scan(&stored)
+	if err != nil {
+		return err
+	}
+	if stored > 0 && stored <= len(history) {
+		var newest string
+		err = tx.QueryRow("SELECT value FROM history WHERE line = ?", stored-1).Scan(&newest)
+		if err != nil && err != sql.ErrNoRows {
+			return err
+		}
+		if err == nil && newest == history[stored-1] && strings.HasPrefix(newest, "{") {
+			return insertLines(tx, "history", history, stored)
+		}
+	}
+	return saveTable(tx, "history", history)
+}
+
+// Save replaces the subnets and webhooks and appends new history within a single transaction
+func (ss *SQLiteStore) Save(data MutatedData) error {
+	tx, err := ss.db.Begin()
+	if err != nil {
+		return err
+	}
+	err = saveTable(tx, "subnets", data.Subnets)
+	if err == nil {
+		err = appendHistory(tx, data.History)
+	}
+	if err == nil {
+		err = saveTable(tx, "webhooks", data.Webhooks)
+	}
+	if err == nil {
+		_, err = tx.Exec("INSERT INTO commits (message, time) VALUES (?, datetime('now'))", data.CommitMsg)
+	}
+	if err == nil {
+		_, err = tx.Exec("DELETE FROM commits WHERE id <= (SELECT MAX(id) FROM commits) - ?", sqliteCommitLimit)
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// Close releases the database file
+func (ss *SQLiteStore) Close() error {
+	return ss.db.Close()
+}
@@ -0,0 +1,202 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Store is used to load all data at startup and persist every mutation
+type Store interface {
+	Load() (*MutatedData, error)
+	Save(data MutatedData) error
+	Close() error
+}
+
+const (
+	subnetsFileName       = "subnets.csv"
+	historyFileName       = "history.jsonl"
+	legacyHistoryFileName = "history.txt"
+	webhooksFileName      = "webhooks.jsonl"
+)
+
+func joinLines(lines []string) string {
+	return strings.Join(lines, "")
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return []string{}
+	}
+	return strings.Split(s, "\n")
+}
+
+// pendingSaveFileName lists the temporary files of a save that has not finished renaming them into place
+const pendingSaveFileName = ".pending-save.json"
+
+// FileStore persists subnets.csv, history.jsonl and webhooks.jsonl into a directory using atomic renames
+type FileStore struct {
+	directory string
+}
+
+// NewFileStore returns a new FileStore object and finishes any save that was interrupted
+func NewFileStore(directory string) (*FileStore, error) {
+	err := os.MkdirAll(directory, 0755)
+	if err != nil {
+		return nil, err
+	}
+	fs := &FileStore{directory: filepath.Clean(directory)}
+	err = fs.finishPendingSave()
+	if err != nil {
+		return nil, fmt.Errorf("unable to finish an interrupted save > %v", err)
+	}
+	return fs, nil
+}
+
+// finishPendingSave renames every temporary file that is still listed so that every file comes from the same save
+func (fs *FileStore) finishPendingSave() error {
+	markerPath := filepath.Join(fs.directory, pendingSaveFileName)
+	b, err := ioutil.ReadFile(markerPath)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	pending := map[string]string{}
+	err = json.Unmarshal(b, &pending)
+	if err != nil {
+		return fmt.Errorf("'%v' is corrupt > %v", markerPath, err)
+	}
+	for name, tempName := range pending {
+		err = os.Rename(filepath.Join(fs.directory, tempName), filepath.Join(fs.directory, name))
+		// a missing temporary file has already been renamed
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	err = syncDirectory(fs.directory)
+	if err != nil {
+		return err
+	}
+	return os.Remove(markerPath)
+}
+
+// Load reads subnets.csv, history.jsonl and webhooks.jsonl if they exist. A legacy history.txt is used if there is no history.jsonl.
+func (fs *FileStore) Load() (*MutatedData, error) {
+	data := &MutatedData{}
+	subnetsBytes, err := ioutil.ReadFile(filepath.Join(fs.directory, subnetsFileName))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	data.Subnets = splitLines(string(subnetsBytes))
+	historyBytes, err := ioutil.ReadFile(filepath.Join(fs.directory, historyFileName))
+	if os.IsNotExist(err) {
+		historyBytes, err = ioutil.ReadFile(filepath.Join(fs.directory, legacyHistoryFileName))
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	data.History = splitLines(string(historyBytes))
+	webhooksBytes, err := ioutil.ReadFile(filepath.Join(fs.directory, webhooksFileName))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	data.Webhooks = splitLines(string(webhooksBytes))
+	return data, nil
+}
+
+// Save writes every file to a temporary file and lists them in a marker before renaming them into place
+// so that an interrupted save is finished rather than leaving subnets and history from different saves
+func (fs *FileStore) Save(data MutatedData) error {
+	err := fs.finishPendingSave()
+	if err != nil {
+		return err
+	}
+	files := map[string][]byte{
+		subnetsFileName:  []byte(joinLines(data.Subnets)),
+		historyFileName:  []byte(joinLines(data.History)),
+		webhooksFileName: []byte(joinLines(data.Webhooks)),
+	}
+	tempPaths := make(map[string]string, len(files))
+	committed := false
+	defer func() {
+		if !committed {
+			for _, tempPath := range tempPaths {
+				os.Remove(tempPath)
+			}
+		}
+	}()
+	pending := make(map[string]string, len(files))
+	for name, b := range files {
+		tempPath, err := writeTempFile(fs.directory, name, b)
+		if err != nil {
+			return err
+		}
+		tempPaths[name] = tempPath
+		pending[name] = filepath.Base(tempPath)
+	}
+	b, err := json.Marshal(pending)
+	if err != nil {
+		return err
+	}
+	markerTemp, err := writeTempFile(fs.directory, pendingSaveFileName, b)
+	if err != nil {
+		return err
+	}
+	err = os.Rename(markerTemp, filepath.Join(fs.directory, pendingSaveFileName))
+	if err != nil {
+		os.Remove(markerTemp)
+		return err
+	}
+	// from here on the save is finished by the next Save or NewFileStore if it is interrupted
+	committed = true
+	err = syncDirectory(fs.directory)
+	if err != nil {
+		return err
+	}
+	return fs.finishPendingSave()
+}
+
+// Close does nothing as files are not held open between saves
+func (fs *FileStore) Close() error {
+	return nil
+}
+
+func writeTempFile(directory, name string, b []byte) (string, error) {
+	f, err := ioutil.TempFile(directory, "."+name+".")
+	if err != nil {
+		return "", err
+	}
+	_, err = f.Write(b)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(f.Name(), 0644)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+func syncDirectory(directory string) error {
+	d, err := os.Open(directory)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	err = d.Sync()
+	if err != nil && os.IsPermission(err) {
+		// some platforms do not allow directories to be synced
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,288 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/demskie/ipam/server/history"
+	bolt "go.etcd.io/bbolt"
+)
+
+func historyLines(count int) []string {
+	lines := []string{}
+	for i := 0; i < count; i++ {
+		lines = append(lines, `{"id":"`+strings.Repeat("a", i+1)+`"}`+"\n")
+	}
+	return lines
+}
+
+// expectLoaded compares lines without their newlines since the file backends split on them
+func expectLoaded(t *testing.T, s Store, subnetLines, history []string) {
+	t.Helper()
+	data, err := s.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(trimLines(data.Subnets)) != fmt.Sprint(trimLines(subnetLines)) ||
+		fmt.Sprint(trimLines(data.History)) != fmt.Sprint(trimLines(history)) {
+		t.Fatalf("expected subnets %q and history %q but loaded %q and %q", subnetLines, history, data.Subnets, data.History)
+	}
+}
+
+func expectWebhooks(t *testing.T, s Store, lines []string) {
+	t.Helper()
+	data, err := s.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(trimLines(data.Webhooks)) != fmt.Sprint(trimLines(lines)) {
+		t.Fatalf("expected webhooks %q but loaded %q", lines, data.Webhooks)
+	}
+}
+
+func trimLines(lines []string) []string {
+	results := []string{}
+	for _, line := range lines {
+		line = strings.TrimSuffix(line, "\n")
+		if line != "" {
+			results = append(results, line)
+		}
+	}
+	return results
+}
+
+func testStoreRoundTrip(t *testing.T, open func() Store) {
+	s := open()
+	expectLoaded(t, s, nil, nil)
+	saves := []MutatedData{
+		{CommitMsg: "one", Subnets: []string{"10.0.0.0/24,one,,\n"}, History: historyLines(1)},
+		{CommitMsg: "two", Subnets: []string{"10.0.0.0/24,one,,\n", "10.0.1.0/24,two,,\n"}, History: historyLines(2)},
+		{CommitMsg: "three", Subnets: []string{"10.0.1.0/24,two,,\n"}, History: historyLines(3), Webhooks: []string{`{"id":"hook"}` + "\n"}},
+	}
+	for _, data := range saves {
+		err := s.Save(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expectLoaded(t, s, data.Subnets, data.History)
+		expectWebhooks(t, s, data.Webhooks)
+	}
+	// history that no longer shares a prefix with what was stored must replace it
+	rewritten := []string{`{"id":"z"}` + "\n"}
+	err := s.Save(MutatedData{Subnets: saves[2].Subnets, History: rewritten})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectLoaded(t, s, saves[2].Subnets, rewritten)
+	err = s.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	reopened := open()
+	defer reopened.Close()
+	expectLoaded(t, reopened, saves[2].Subnets, rewritten)
+	expectWebhooks(t, reopened, nil)
+}
+
+func TestFileStoreRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	testStoreRoundTrip(t, func() Store {
+		fs, err := NewFileStore(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return fs
+	})
+}
+
+func TestBoltStoreRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ipam.db")
+	testStoreRoundTrip(t, func() Store {
+		bs, err := NewBoltStore(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return bs
+	})
+}
+
+func TestSQLiteStoreRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ipam.sqlite")
+	testStoreRoundTrip(t, func() Store {
+		ss, err := NewSQLiteStore(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ss
+	})
+}
+
+func TestSQLiteStoreAppendsHistory(t *testing.T) {
+	ss, err := NewSQLiteStore(filepath.Join(t.TempDir(), "ipam.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ss.Close()
+	for i := 1; i <= 3; i++ {
+		err = ss.Save(MutatedData{History: historyLines(i)})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	// rows that were kept are not deleted and inserted again so their rowid stays the same
+	var first string
+	err = ss.db.QueryRow("SELECT value FROM history WHERE line = 0").Scan(&first)
+	if err != nil || first != `{"id":"a"}`+"\n" {
+		t.Fatalf("unexpected first row '%v' (%v)", first, err)
+	}
+	_, err = ss.db.Exec("UPDATE history SET value = 'untouched' WHERE line = 0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ss.Save(MutatedData{History: append([]string{`{"id":"a"}` + "\n"}, historyLines(4)[1:]...)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ss.db.QueryRow("SELECT value FROM history WHERE line = 0").Scan(&first)
+	if err != nil || first != "untouched" {
+		t.Fatalf("expected only new events to be written but the first row is '%v' (%v)", first, err)
+	}
+}
+
+func TestSQLiteStoreLimitsCommits(t *testing.T) {
+	ss, err := NewSQLiteStore(filepath.Join(t.TempDir(), "ipam.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ss.Close()
+	_, err = ss.db.Exec("WITH RECURSIVE n(i) AS (SELECT 1 UNION ALL SELECT i+1 FROM n WHERE i < ?) "+
+		"INSERT INTO commits (message, time) SELECT 'old', datetime('now') FROM n", sqliteCommitLimit+50)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ss.Save(MutatedData{CommitMsg: "new"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var count int
+	ss.db.QueryRow("SELECT COUNT(*) FROM commits").Scan(&count)
+	if count != sqliteCommitLimit {
+		t.Fatalf("expected %v commits to be kept but there are %v", sqliteCommitLimit, count)
+	}
+}
+
+func TestFileStoreFinishesInterruptedSave(t *testing.T) {
+	dir := t.TempDir()
+	fs, err := NewFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = fs.Save(MutatedData{Subnets: []string{"10.0.0.0/24,old,,\n"}, History: historyLines(1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// simulate a crash after subnets.csv was renamed but before history.jsonl was
+	historyTemp, err := writeTempFile(dir, historyFileName, []byte(joinLines(historyLines(2))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(filepath.Join(dir, subnetsFileName), []byte("10.0.0.0/24,new,,\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	marker := `{"` + subnetsFileName + `":".subnets.csv.gone","` + historyFileName + `":"` + filepath.Base(historyTemp) + `"}`
+	err = ioutil.WriteFile(filepath.Join(dir, pendingSaveFileName), []byte(marker), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reopened, err := NewFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectLoaded(t, reopened, []string{"10.0.0.0/24,new,,\n"}, historyLines(2))
+	if _, err = os.Stat(filepath.Join(dir, pendingSaveFileName)); !os.IsNotExist(err) {
+		t.Fatal("expected the marker to be removed once the save was finished")
+	}
+}
+
+func TestBoltStoreMigratesLegacyKeys(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ipam.db")
+	legacyHistory := "04-21-2019 14:42:10 (::1) is creating subnet: [net='10.0.0.0/8' desc='CORP VRF' details='Corporate Infrastructure' vlan='']\n" +
+		"04-22-2019 10:00:00 (alex.demskie@127.0.0.1) is pushing changes: [net='10.0.0.0/8' desc='CORP']\n"
+	legacySubnets := "SUBNET,DESCRIPTION,DETAILS,VLAN,LASTMODIFIED\n10.0.0.0/8,CORP,Corporate Infrastructure,,04-22-2019 10:00:00\n"
+	db, err := bolt.Open(path, 0644, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(boltBucket)
+		if err != nil {
+			return err
+		}
+		err = b.Put([]byte(subnetsFileName), []byte(legacySubnets))
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(legacyHistoryFileName), []byte(legacyHistory))
+	})
+	db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bs, err := NewBoltStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectLoaded(t, bs, splitLines(legacySubnets), splitLines(legacyHistory))
+	err = bs.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(boltBucket)
+		for _, legacyKey := range boltLegacyKeys {
+			if b.Get(legacyKey) != nil {
+				return fmt.Errorf("expected '%s' to be removed", legacyKey)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ipam, err := NewIPAMServer(bs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ipam.Shutdown(context.Background())
+	evts := ipam.history.GetAllEvents()
+	if len(evts) != 2 || evts[0].Action != history.ActionCreateSubnet || !evts[0].IsReversible() ||
+		evts[1].Action != history.ActionModifySubnet || evts[1].IsReversible() {
+		t.Fatalf("expected the legacy lines to become a reversible create and a non-reversible modification but got %+v", evts)
+	}
+}
+
+func TestBoltStoreKeepsCurrentKeysOverLegacyKeys(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ipam.db")
+	bs, err := NewBoltStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = bs.Save(MutatedData{Subnets: []string{"10.0.1.0/24,current,,\n"}, History: historyLines(1)})
+	if err == nil {
+		err = bs.db.Update(func(tx *bolt.Tx) error {
+			return tx.Bucket(boltBucket).Put([]byte(subnetsFileName), []byte("10.0.0.0/24,stale,,\n"))
+		})
+	}
+	bs.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	reopened, err := NewBoltStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	expectLoaded(t, reopened, []string{"10.0.1.0/24,current,,"}, historyLines(1))
+}
@@ -7,7 +7,11 @@ import (
 
 // SubnetSkeleton is an inbetween data type to simplify marshalling
 type SubnetSkeleton struct {
-	Net, Desc, Details, Vlan, Mod string
+	Net     string `json:"net"`
+	Desc    string `json:"desc"`
+	Details string `json:"details"`
+	Vlan    string `json:"vlan"`
+	Mod     string `json:"mod"`
 }
 
 func (subnet *subnet) toSkeleton() *SubnetSkeleton {
@@ -1,6 +1,7 @@
 package subnets
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"math/rand"
@@ -142,6 +143,11 @@ func (tree *Tree) DeleteSubnet(network *net.IPNet) error {
 	if sn == nil {
 		return fmt.Errorf("could not delete '%v' as it does not exist", network.String())
 	}
+	tree.deleteSubnet(sn)
+	return nil
+}
+
+func (tree *Tree) deleteSubnet(sn *subnet) {
 	for _, child := range sn.children {
 		if sn.parent == nil {
 			child.parent = nil
@@ -156,6 +162,37 @@ func (tree *Tree) DeleteSubnet(network *net.IPNet) error {
 	} else {
 		sn.parent.children = removeSubnetFromSlice(sn.parent.children, sn)
 	}
+}
+
+// ErrConflict is returned by SwapSubnet when the subnet no longer matches what was expected
+var ErrConflict = errors.New("subnet has changed since it was last observed")
+
+// SwapSubnet atomically replaces expected with replacement where a nil skeleton means the subnet does not exist
+func (tree *Tree) SwapSubnet(network *net.IPNet, expected, replacement *SubnetSkeleton) error {
+	if network == nil {
+		return fmt.Errorf("could not modify subnet as it is not a valid CIDR network")
+	}
+	tree.mtx.Lock()
+	defer tree.mtx.Unlock()
+	sn := findSubnet(network, tree.roots)
+	current := sn.toSkeleton()
+	switch {
+	case expected == nil && current != nil:
+		return ErrConflict
+	case expected != nil && (current == nil || current.ListDifferences(expected) != nil):
+		return ErrConflict
+	}
+	switch {
+	case replacement == nil && sn != nil:
+		tree.deleteSubnet(sn)
+	case replacement != nil && sn == nil:
+		return tree.createSubnet(replacement)
+	case replacement != nil:
+		sn.description = replacement.Desc
+		sn.vlan = replacement.Vlan
+		sn.details = replacement.Details
+		sn.modifiedTime = replacement.Mod
+	}
 	return nil
 }
 
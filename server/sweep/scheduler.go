@@ -0,0 +1,245 @@
+package sweep
+
+import (
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/demskie/subnetmath"
+)
+
+// Settings override how often a subnet and its descendants are swept
+type Settings struct {
+	Interval time.Duration `json:"interval"`
+	Exclude  bool          `json:"exclude"`
+}
+
+type state struct {
+	lastStarted   time.Time
+	lastCompleted time.Time
+	inProgress    bool
+	sweeps        int
+}
+
+// Scheduler chooses the subnet that is the most overdue so that every subnet is swept within its interval
+type Scheduler struct {
+	mtx             *sync.Mutex
+	defaultInterval time.Duration
+	overrides       map[string]Settings
+	states          map[string]*state
+}
+
+// NewScheduler returns a new Scheduler object
+func NewScheduler(defaultInterval time.Duration) *Scheduler {
+	return &Scheduler{
+		mtx:             &sync.Mutex{},
+		defaultInterval: defaultInterval,
+		overrides:       make(map[string]Settings, 0),
+		states:          make(map[string]*state, 0),
+	}
+}
+
+// SetDefaultInterval changes how often subnets without an override are swept
+func (s *Scheduler) SetDefaultInterval(interval time.Duration) {
+	s.mtx.Lock()
+	s.defaultInterval = interval
+	s.mtx.Unlock()
+}
+
+// SetSubnetSettings overrides the interval or excludes a subnet and everything inside of it
+func (s *Scheduler) SetSubnetSettings(cidr string, settings Settings) {
+	s.mtx.Lock()
+	s.overrides[cidr] = settings
+	s.mtx.Unlock()
+}
+
+// ClearSubnetSettings removes an override
+func (s *Scheduler) ClearSubnetSettings(cidr string) {
+	s.mtx.Lock()
+	delete(s.overrides, cidr)
+	s.mtx.Unlock()
+}
+
+// GetSubnetSettings returns every override
+func (s *Scheduler) GetSubnetSettings() map[string]Settings {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	results := make(map[string]Settings, len(s.overrides))
+	for cidr, settings := range s.overrides {
+		results[cidr] = settings
+	}
+	return results
+}
+
+// settingsFor returns the override of the most specific enclosing subnet and must be called while holding mtx
+func (s *Scheduler) settingsFor(network *net.IPNet) Settings {
+	best := Settings{Interval: s.defaultInterval}
+	bestOnes := -1
+	for cidr, settings := range s.overrides {
+		override := subnetmath.ParseNetworkCIDR(cidr)
+		if override == nil || !override.Contains(network.IP) {
+			continue
+		}
+		overrideOnes, _ := override.Mask.Size()
+		networkOnes, _ := network.Mask.Size()
+		if overrideOnes > networkOnes || overrideOnes <= bestOnes {
+			continue
+		}
+		bestOnes = overrideOnes
+		best = settings
+		if best.Interval <= 0 {
+			best.Interval = s.defaultInterval
+		}
+	}
+	return best
+}
+
+// ExcludedWithin returns every excluded override that is more specific than network and inside of it
+func (s *Scheduler) ExcludedWithin(network *net.IPNet) []*net.IPNet {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	results := []*net.IPNet{}
+	for cidr, settings := range s.overrides {
+		override := subnetmath.ParseNetworkCIDR(cidr)
+		if !settings.Exclude || override == nil || subnetmath.NetworksAreIdentical(override, network) {
+			continue
+		}
+		if subnetmath.NetworkContainsSubnet(network, override) {
+			results = append(results, override)
+		}
+	}
+	return results
+}
+
+// Next returns the subnet that is the most overdue or false if nothing is due yet
+func (s *Scheduler) Next(cidrs []string, now time.Time) (string, bool) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	chosen := ""
+	var chosenDue time.Time
+	for _, cidr := range cidrs {
+		network := subnetmath.ParseNetworkCIDR(cidr)
+		if network == nil {
+			continue
+		}
+		settings := s.settingsFor(network)
+		st, exists := s.states[cidr]
+		if settings.Exclude || (exists && st.inProgress) {
+			continue
+		}
+		// subnets that have never been swept are due immediately and ordered ahead of everything else
+		var due time.Time
+		if exists && !st.lastCompleted.IsZero() {
+			due = st.lastCompleted.Add(settings.Interval)
+		}
+		if due.After(now) {
+			continue
+		}
+		if chosen == "" || due.Before(chosenDue) || (due.Equal(chosenDue) && cidr < chosen) {
+			chosen, chosenDue = cidr, due
+		}
+	}
+	if chosen == "" {
+		return "", false
+	}
+	st, exists := s.states[chosen]
+	if !exists {
+		st = &state{}
+		s.states[chosen] = st
+	}
+	st.inProgress = true
+	st.lastStarted = now
+	return chosen, true
+}
+
+// Done records that the sweep returned by Next has finished
+func (s *Scheduler) Done(cidr string, now time.Time) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	st, exists := s.states[cidr]
+	if !exists {
+		return
+	}
+	st.inProgress = false
+	st.lastCompleted = now
+	st.sweeps++
+}
+
+// Status describes the sweep progress of a single subnet
+type Status struct {
+	Subnet        string     `json:"subnet"`
+	Interval      string     `json:"interval"`
+	Excluded      bool       `json:"excluded"`
+	InProgress    bool       `json:"inProgress"`
+	Sweeps        int        `json:"sweeps"`
+	LastStarted   *time.Time `json:"lastStarted"`
+	LastCompleted *time.Time `json:"lastCompleted"`
+	NextDue       *time.Time `json:"nextDue"`
+	LagSeconds    float64    `json:"lagSeconds"`
+}
+
+// Summary describes the sweep progress of every subnet
+type Summary struct {
+	Total         int     `json:"total"`
+	Excluded      int     `json:"excluded"`
+	NeverSwept    int     `json:"neverSwept"`
+	Overdue       int     `json:"overdue"`
+	InProgress    int     `json:"inProgress"`
+	MaxLagSeconds float64 `json:"maxLagSeconds"`
+}
+
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+// Progress reports the state of every subnet ordered by how overdue it is
+func (s *Scheduler) Progress(cidrs []string, now time.Time) (Summary, []Status) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	summary := Summary{}
+	results := make([]Status, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		network := subnetmath.ParseNetworkCIDR(cidr)
+		if network == nil {
+			continue
+		}
+		settings := s.settingsFor(network)
+		status := Status{Subnet: cidr, Interval: settings.Interval.String(), Excluded: settings.Exclude}
+		summary.Total++
+		st, exists := s.states[cidr]
+		if exists {
+			status.InProgress = st.inProgress
+			status.Sweeps = st.sweeps
+			status.LastStarted = optionalTime(st.lastStarted)
+			status.LastCompleted = optionalTime(st.lastCompleted)
+		}
+		switch {
+		case settings.Exclude:
+			summary.Excluded++
+		case status.LastCompleted == nil:
+			summary.NeverSwept++
+		default:
+			due := st.lastCompleted.Add(settings.Interval)
+			status.NextDue = &due
+			if now.After(due) {
+				status.LagSeconds = now.Sub(due).Seconds()
+				summary.Overdue++
+			}
+		}
+		if status.InProgress {
+			summary.InProgress++
+		}
+		if status.LagSeconds > summary.MaxLagSeconds {
+			summary.MaxLagSeconds = status.LagSeconds
+		}
+		results = append(results, status)
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].LagSeconds > results[j].LagSeconds
+	})
+	return summary, results
+}
@@ -0,0 +1,110 @@
+package sweep
+
+import (
+	"testing"
+	"time"
+
+	"github.com/demskie/subnetmath"
+)
+
+var epoch = time.Date(2019, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+func sweepAll(t *testing.T, s *Scheduler, cidrs []string, now time.Time) []string {
+	order := []string{}
+	for {
+		cidr, ok := s.Next(cidrs, now)
+		if !ok {
+			return order
+		}
+		order = append(order, cidr)
+		s.Done(cidr, now)
+		if len(order) > len(cidrs) {
+			t.Fatalf("scheduler returned more subnets than exist: %v", order)
+		}
+	}
+}
+
+func TestNextOrdersNeverSweptByCIDR(t *testing.T) {
+	s := NewScheduler(time.Minute)
+	order := sweepAll(t, s, []string{"10.0.2.0/24", "10.0.0.0/24", "10.0.1.0/24"}, epoch)
+	expected := []string{"10.0.0.0/24", "10.0.1.0/24", "10.0.2.0/24"}
+	if len(order) != len(expected) {
+		t.Fatalf("expected %v but got %v", expected, order)
+	}
+	for i := range expected {
+		if order[i] != expected[i] {
+			t.Fatalf("expected %v but got %v", expected, order)
+		}
+	}
+}
+
+func TestNextPrefersMostOverdue(t *testing.T) {
+	s := NewScheduler(10 * time.Minute)
+	cidrs := []string{"10.0.0.0/24", "10.0.1.0/24"}
+	s.Next(cidrs, epoch)
+	s.Done("10.0.0.0/24", epoch.Add(time.Minute))
+	s.Next(cidrs, epoch)
+	s.Done("10.0.1.0/24", epoch)
+	if _, ok := s.Next(cidrs, epoch.Add(5*time.Minute)); ok {
+		t.Fatal("expected nothing to be due before the interval has elapsed")
+	}
+	cidr, ok := s.Next(cidrs, epoch.Add(15*time.Minute))
+	if !ok || cidr != "10.0.1.0/24" {
+		t.Fatalf("expected the subnet that completed first to be chosen but got '%v'", cidr)
+	}
+	cidr, ok = s.Next(cidrs, epoch.Add(15*time.Minute))
+	if !ok || cidr != "10.0.0.0/24" {
+		t.Fatalf("expected the subnet in progress to be skipped but got '%v'", cidr)
+	}
+}
+
+func TestSubnetIntervalOverride(t *testing.T) {
+	s := NewScheduler(time.Hour)
+	s.SetSubnetSettings("10.0.0.0/16", Settings{Interval: time.Minute})
+	cidrs := []string{"10.0.1.0/24", "10.1.0.0/24"}
+	sweepAll(t, s, cidrs, epoch)
+	order := sweepAll(t, s, cidrs, epoch.Add(2*time.Minute))
+	if len(order) != 1 || order[0] != "10.0.1.0/24" {
+		t.Fatalf("expected only the subnet inside the override to be due but got %v", order)
+	}
+}
+
+func TestExclusion(t *testing.T) {
+	s := NewScheduler(time.Minute)
+	s.SetSubnetSettings("10.0.0.0/16", Settings{Exclude: true})
+	s.SetSubnetSettings("10.0.5.0/24", Settings{Interval: time.Minute})
+	order := sweepAll(t, s, []string{"10.0.1.0/24", "10.0.5.0/24", "10.1.0.0/24"}, epoch)
+	if len(order) != 2 || order[0] != "10.0.5.0/24" || order[1] != "10.1.0.0/24" {
+		t.Fatalf("expected the more specific override to win over the exclusion but got %v", order)
+	}
+	summary, _ := s.Progress([]string{"10.0.1.0/24", "10.0.5.0/24", "10.1.0.0/24"}, epoch)
+	if summary.Excluded != 1 || summary.Total != 3 {
+		t.Fatalf("expected one excluded subnet but got %+v", summary)
+	}
+}
+
+func TestExcludedWithin(t *testing.T) {
+	s := NewScheduler(time.Minute)
+	s.SetSubnetSettings("10.0.2.0/24", Settings{Exclude: true})
+	s.SetSubnetSettings("10.0.3.0/24", Settings{Interval: time.Second})
+	s.SetSubnetSettings("10.0.0.0/22", Settings{Exclude: true})
+	s.SetSubnetSettings("192.168.0.0/24", Settings{Exclude: true})
+	excluded := s.ExcludedWithin(subnetmath.ParseNetworkCIDR("10.0.0.0/22"))
+	if len(excluded) != 1 || excluded[0].String() != "10.0.2.0/24" {
+		t.Fatalf("expected only 10.0.2.0/24 but got %v", excluded)
+	}
+}
+
+func TestProgressReportsLag(t *testing.T) {
+	s := NewScheduler(time.Minute)
+	cidrs := []string{"10.0.0.0/24", "10.0.1.0/24"}
+	s.Next(cidrs, epoch)
+	s.Done("10.0.0.0/24", epoch)
+	summary, statuses := s.Progress(cidrs, epoch.Add(3*time.Minute))
+	if summary.NeverSwept != 1 || summary.Overdue != 1 || summary.MaxLagSeconds != 120 {
+		t.Fatalf("unexpected summary %+v", summary)
+	}
+	if statuses[0].Subnet != "10.0.0.0/24" || statuses[0].LagSeconds != 120 {
+		t.Fatalf("expected the overdue subnet first but got %+v", statuses[0])
+	}
+}
@@ -0,0 +1,101 @@
+package server
+
+import (
+	"github.com/demskie/ipam/server/history"
+	"github.com/demskie/ipam/server/logging"
+	"github.com/demskie/ipam/server/syslog"
+)
+
+var levelSeverities = map[logging.Level]syslog.Severity{
+	logging.LevelDebug: syslog.SevDebug,
+	logging.LevelInfo:  syslog.SevInfo,
+	logging.LevelWarn:  syslog.SevWarning,
+	logging.LevelError: syslog.SevError,
+}
+
+// AddSyslogServer is used for remote logging purposes. Messages are RFC5424 formatted and sent over TCP one per line
+// as before, use AddDiagnosticSyslog to choose "udp" or "tls", octet counting or an enterprise number.
+func (ipam *IPAMServer) AddSyslogServer(address, port string) error {
+	return ipam.AddDiagnosticSyslog(syslog.Config{
+		Network:  "tcp",
+		Address:  address + ":" + port,
+		Facility: syslog.LogDaemon,
+		Framing:  syslog.FramingNewline,
+	})
+}
+
+// AddDiagnosticSyslog forwards every server log entry to a RFC5424 syslog server (using LogDaemon unless specified)
+func (ipam *IPAMServer) AddDiagnosticSyslog(cfg syslog.Config) error {
+	if cfg.Facility == syslog.LogKern {
+		cfg.Facility = syslog.LogDaemon
+	}
+	wr, err := syslog.Dial(cfg)
+	if err != nil {
+		return err
+	}
+	ipam.auditMtx.Lock()
+	ipam.diagSyslogs = append(ipam.diagSyslogs, wr)
+	ipam.auditMtx.Unlock()
+	ipam.debug.AddEntryHandler(func(e history.Entry) {
+		msg := syslog.Message{
+			Time:     e.Time,
+			Severity: levelSeverities[e.Level],
+			MsgID:    "diag",
+			Text:     e.Message,
+		}
+		if e.RequestID != "" || e.RemoteIP != "" || e.Actor != "" {
+			elem := syslog.Element{ID: "request"}
+			for _, p := range []syslog.Param{{Name: "id", Value: e.RequestID}, {Name: "remoteIP", Value: e.RemoteIP}, {Name: "actor", Value: e.Actor}} {
+				if p.Value != "" {
+					elem.Params = append(elem.Params, p)
+				}
+			}
+			msg.StructuredData = []syslog.Element{elem}
+		}
+		wr.Send(msg)
+	})
+	return nil
+}
+
+// AddAuditSyslog forwards every change made by users to a RFC5424 syslog server (using LogAuth unless specified)
+func (ipam *IPAMServer) AddAuditSyslog(cfg syslog.Config) error {
+	if cfg.Facility == syslog.LogKern {
+		cfg.Facility = syslog.LogAuth
+	}
+	wr, err := syslog.Dial(cfg)
+	if err != nil {
+		return err
+	}
+	ipam.auditMtx.Lock()
+	ipam.auditSyslogs = append(ipam.auditSyslogs, wr)
+	ipam.auditMtx.Unlock()
+	return nil
+}
+
+func (ipam *IPAMServer) sendAuditSyslog(record history.Event) {
+	ipam.auditMtx.RLock()
+	defer ipam.auditMtx.RUnlock()
+	if len(ipam.auditSyslogs) == 0 {
+		return
+	}
+	msg := syslog.Message{
+		Time:     record.Time,
+		Severity: syslog.SevNotice,
+		MsgID:    "audit",
+		StructuredData: []syslog.Element{{
+			ID: "audit",
+			Params: []syslog.Param{
+				{Name: "id", Value: record.ID},
+				{Name: "actor", Value: record.Actor},
+				{Name: "sourceIP", Value: record.SourceIP},
+				{Name: "action", Value: string(record.Action)},
+				{Name: "cidr", Value: record.Target},
+				{Name: "hash", Value: record.Hash},
+			},
+		}},
+		Text: record.String(),
+	}
+	for _, wr := range ipam.auditSyslogs {
+		wr.Send(msg)
+	}
+}
@@ -0,0 +1,314 @@
+package syslog
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Facility is the RFC5424 facility code
+type Facility int
+
+// Facilities
+const (
+	LogKern     Facility = 0
+	LogUser     Facility = 1
+	LogDaemon   Facility = 3
+	LogAuth     Facility = 4
+	LogAuthPriv Facility = 10
+	LogLocal0   Facility = 16
+	LogLocal1   Facility = 17
+	LogLocal2   Facility = 18
+	LogLocal3   Facility = 19
+	LogLocal4   Facility = 20
+	LogLocal5   Facility = 21
+	LogLocal6   Facility = 22
+	LogLocal7   Facility = 23
+)
+
+// Severity is the RFC5424 severity code
+type Severity int
+
+// Severities
+const (
+	SevEmergency Severity = iota
+	SevAlert
+	SevCritical
+	SevError
+	SevWarning
+	SevNotice
+	SevInfo
+	SevDebug
+)
+
+// DefaultEnterpriseID is the example private enterprise number reserved for documentation by RFC 5612.
+// It is only used when Config.EnterpriseID is empty so deployments should set their own number.
+const DefaultEnterpriseID = "32473"
+
+// Framing controls how messages are separated on "tcp" and "tls" connections
+type Framing int
+
+// Framings
+const (
+	FramingOctetCounting Framing = iota // RFC 5425 length prefix
+	FramingNewline                      // RFC 6587 non-transparent framing used by older BSD style receivers
+)
+
+// Config describes where and how messages are delivered
+type Config struct {
+	Network      string // "udp", "tcp" or "tls"
+	Address      string // host:port
+	TLSConfig    *tls.Config
+	Facility     Facility // LogKern is reserved for the kernel so the zero value means the default
+	AppName      string
+	Hostname     string
+	Timeout      time.Duration
+	EnterpriseID string // IANA private enterprise number that structured data element IDs are qualified with
+	Framing      Framing
+}
+
+// Param is a single structured data parameter
+type Param struct {
+	Name  string
+	Value string
+}
+
+// Element is a structured data element such as [audit@32473 actor="bob"]. IDs without an '@' that are not
+// registered with IANA are qualified with the configured enterprise number.
+type Element struct {
+	ID     string
+	Params []Param
+}
+
+// Message is a single syslog record
+type Message struct {
+	Time           time.Time
+	Severity       Severity
+	MsgID          string
+	StructuredData []Element
+	Text           string
+}
+
+const queueSize = 4096
+
+// Writer delivers RFC5424 formatted messages from a background goroutine so that callers never block
+type Writer struct {
+	mtx     *sync.Mutex
+	cfg     Config
+	conn    net.Conn
+	queue   chan Message
+	done    chan struct{}
+	abandon chan struct{}
+}
+
+// Dial connects to the syslog server and starts delivering queued messages
+func Dial(cfg Config) (*Writer, error) {
+	switch cfg.Network {
+	case "udp", "tcp", "tls":
+	default:
+		return nil, fmt.Errorf("'%v' is not a supported syslog network", cfg.Network)
+	}
+	if cfg.Facility == LogKern {
+		cfg.Facility = LogUser
+	}
+	if cfg.AppName == "" {
+		cfg.AppName = "ipam"
+	}
+	if cfg.Hostname == "" {
+		cfg.Hostname, _ = os.Hostname()
+	}
+	if cfg.Timeout == 0 {
+		cfg.Timeout = 10 * time.Second
+	}
+	if cfg.EnterpriseID == "" {
+		cfg.EnterpriseID = DefaultEnterpriseID
+	}
+	w := &Writer{
+		mtx:     &sync.Mutex{},
+		cfg:     cfg,
+		queue:   make(chan Message, queueSize),
+		done:    make(chan struct{}),
+		abandon: make(chan struct{}),
+	}
+	err := w.connect()
+	if err != nil {
+		return nil, err
+	}
+	go w.run(w.queue)
+	return w, nil
+}
+
+func (w *Writer) connect() error {
+	dialer := &net.Dialer{Timeout: w.cfg.Timeout}
+	var conn net.Conn
+	var err error
+	if w.cfg.Network == "tls" {
+		conn, err = tls.DialWithDialer(dialer, "tcp", w.cfg.Address, w.cfg.TLSConfig)
+	} else {
+		conn, err = dialer.Dial(w.cfg.Network, w.cfg.Address)
+	}
+	if err != nil {
+		return fmt.Errorf("unable to connect to syslog server > %v", err)
+	}
+	w.conn = conn
+	return nil
+}
+
+// Send queues the message and drops it if the queue is full
+func (w *Writer) Send(msg Message) {
+	if msg.Time.IsZero() {
+		msg.Time = time.Now()
+	}
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	if w.queue == nil {
+		return
+	}
+	select {
+	case w.queue <- msg:
+	default:
+	}
+}
+
+// Close stops delivery after the queued messages have been written
+func (w *Writer) Close() error {
+	return w.CloseContext(context.Background())
+}
+
+// CloseContext stops delivery after the queued messages have been written. If ctx expires first
+// the remaining messages are dropped once the write in progress returns and ctx.Err() is returned.
+func (w *Writer) CloseContext(ctx context.Context) error {
+	w.mtx.Lock()
+	if w.queue == nil {
+		w.mtx.Unlock()
+		return nil
+	}
+	close(w.queue)
+	w.queue = nil
+	w.mtx.Unlock()
+	var result error
+	select {
+	case <-w.done:
+	case <-ctx.Done():
+		close(w.abandon)
+		<-w.done
+		result = ctx.Err()
+	}
+	if w.conn != nil {
+		err := w.conn.Close()
+		if result == nil {
+			result = err
+		}
+	}
+	return result
+}
+
+func (w *Writer) run(queue chan Message) {
+	defer close(w.done)
+	for msg := range queue {
+		select {
+		case <-w.abandon:
+			return
+		default:
+		}
+		b := w.format(msg)
+		if w.cfg.Network != "udp" {
+			b = w.frame(b)
+		}
+		for attempt := 0; attempt < 2; attempt++ {
+			if w.conn == nil && w.connect() != nil {
+				continue
+			}
+			w.conn.SetWriteDeadline(time.Now().Add(w.cfg.Timeout))
+			_, err := w.conn.Write(b)
+			if err == nil {
+				break
+			}
+			// the server logger may be forwarding to us so errors must not be logged through it
+			fmt.Fprintf(os.Stderr, "unable to write to syslog server %v > %v\n", w.cfg.Address, err)
+			w.conn.Close()
+			w.conn = nil
+		}
+	}
+}
+
+func (w *Writer) format(msg Message) []byte {
+	msgID := msg.MsgID
+	if msgID == "" {
+		msgID = "-"
+	}
+	sd := "-"
+	if len(msg.StructuredData) > 0 {
+		var sb strings.Builder
+		for _, elem := range msg.StructuredData {
+			sb.WriteString("[")
+			sb.WriteString(w.elementID(elem.ID))
+			for _, p := range elem.Params {
+				sb.WriteString(" ")
+				sb.WriteString(p.Name)
+				sb.WriteString(`="`)
+				sb.WriteString(escapeParamValue(p.Value))
+				sb.WriteString(`"`)
+			}
+			sb.WriteString("]")
+		}
+		sd = sb.String()
+	}
+	line := fmt.Sprintf("<%d>1 %v %v %v %d %v %v",
+		int(w.cfg.Facility)*8+int(msg.Severity),
+		msg.Time.Format(time.RFC3339Nano),
+		headerField(w.cfg.Hostname),
+		headerField(w.cfg.AppName),
+		os.Getpid(),
+		headerField(msgID),
+		sd,
+	)
+	if msg.Text != "" {
+		line += " " + msg.Text
+	}
+	return []byte(line)
+}
+
+// frame separates messages on a stream so that the receiver can tell where each one ends
+func (w *Writer) frame(b []byte) []byte {
+	if w.cfg.Framing == FramingNewline {
+		// the trailer can not appear inside a message so embedded newlines are replaced
+		return append(bytes.ReplaceAll(b, []byte("\n"), []byte(" ")), '\n')
+	}
+	return append([]byte(fmt.Sprintf("%d ", len(b))), b...)
+}
+
+// ianaElementIDs are the structured data IDs registered by RFC 5424
+var ianaElementIDs = map[string]bool{"timeQuality": true, "origin": true, "meta": true}
+
+func (w *Writer) elementID(id string) string {
+	if strings.Contains(id, "@") || ianaElementIDs[id] {
+		return id
+	}
+	return id + "@" + w.cfg.EnterpriseID
+}
+
+var paramEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`)
+
+func escapeParamValue(s string) string {
+	return paramEscaper.Replace(s)
+}
+
+// headerField replaces characters that are not permitted in header fields
+func headerField(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return strings.Map(func(r rune) rune {
+		if r <= ' ' || r > '~' {
+			return '_'
+		}
+		return r
+	}, s)
+}
@@ -0,0 +1,238 @@
+package syslog
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+var epoch = time.Date(2019, time.January, 2, 15, 4, 5, 0, time.UTC)
+
+func newUDPListener(t *testing.T) (net.PacketConn, chan string) {
+	listener, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	received := make(chan string, 16)
+	go func() {
+		buf := make([]byte, 65536)
+		for {
+			n, _, err := listener.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			received <- string(buf[:n])
+		}
+	}()
+	return listener, received
+}
+
+// newTCPListener splits the stream into messages using octet counting
+func newTCPListener(t *testing.T) (net.Listener, chan string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	received := make(chan string, 16)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		for {
+			prefix, err := r.ReadString(' ')
+			if err != nil {
+				return
+			}
+			length, err := strconv.Atoi(strings.TrimSpace(prefix))
+			if err != nil {
+				received <- "invalid frame length: " + prefix
+				return
+			}
+			b := make([]byte, length)
+			_, err = io.ReadFull(r, b)
+			if err != nil {
+				return
+			}
+			received <- string(b)
+		}
+	}()
+	return listener, received
+}
+
+func waitForMessage(t *testing.T, received chan string) string {
+	select {
+	case msg := <-received:
+		return msg
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a syslog message")
+	}
+	return ""
+}
+
+func TestUDPFormatting(t *testing.T) {
+	listener, received := newUDPListener(t)
+	w, err := Dial(Config{Network: "udp", Address: listener.LocalAddr().String(), Hostname: "ipam host"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	w.Send(Message{
+		Time:     epoch,
+		Severity: SevNotice,
+		MsgID:    "subnet",
+		StructuredData: []Element{{
+			ID:     "audit",
+			Params: []Param{{"actor", "alice"}, {"desc", `a "quoted" [value]\`}},
+		}},
+		Text: "created 10.0.0.0/24",
+	})
+	expected := fmt.Sprintf(`<13>1 2019-01-02T15:04:05Z ipam_host ipam %d subnet [audit@32473 actor="alice" desc="a \"quoted\" [value\]\\"] created 10.0.0.0/24`, os.Getpid())
+	if msg := waitForMessage(t, received); msg != expected {
+		t.Fatalf("expected\n%v\nbut got\n%v", expected, msg)
+	}
+	w.Send(Message{Time: epoch, Severity: SevError})
+	expected = fmt.Sprintf("<11>1 2019-01-02T15:04:05Z ipam_host ipam %d - -", os.Getpid())
+	if msg := waitForMessage(t, received); msg != expected {
+		t.Fatalf("expected empty fields to be replaced with a hyphen but got\n%v", msg)
+	}
+}
+
+func TestFacility(t *testing.T) {
+	listener, received := newUDPListener(t)
+	for facility, priority := range map[Facility]string{LogKern: "<14>", LogAuth: "<38>", LogLocal7: "<190>"} {
+		w, err := Dial(Config{Network: "udp", Address: listener.LocalAddr().String(), Facility: facility})
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Send(Message{Severity: SevInfo, Text: "hello"})
+		w.Close()
+		if msg := waitForMessage(t, received); !strings.HasPrefix(msg, priority) {
+			t.Fatalf("expected facility %v to use priority %v but got %v", facility, priority, msg)
+		}
+	}
+}
+
+func TestTCPFraming(t *testing.T) {
+	listener, received := newTCPListener(t)
+	w, err := Dial(Config{Network: "tcp", Address: listener.Addr().String(), Facility: LogDaemon})
+	if err != nil {
+		t.Fatal(err)
+	}
+	texts := []string{"first", "second message", "third\nwith a newline"}
+	for _, text := range texts {
+		w.Send(Message{Time: epoch, Severity: SevInfo, Text: text})
+	}
+	err = w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, text := range texts {
+		msg := waitForMessage(t, received)
+		if !strings.HasPrefix(msg, "<30>1 ") || !strings.HasSuffix(msg, " "+text) {
+			t.Fatalf("expected a framed message ending with '%v' but got '%v'", text, msg)
+		}
+	}
+	w.Send(Message{Text: "after close"})
+}
+
+func TestNewlineFramingAndEnterpriseID(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	received := make(chan string, 16)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		scanner := bufio.NewScanner(conn)
+		for scanner.Scan() {
+			received <- scanner.Text()
+		}
+	}()
+	w, err := Dial(Config{Network: "tcp", Address: listener.Addr().String(), Framing: FramingNewline, EnterpriseID: "99999"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Send(Message{Time: epoch, StructuredData: []Element{{ID: "audit"}, {ID: "origin"}, {ID: "custom@1234"}}, Text: "two\nlines"})
+	w.Send(Message{Time: epoch, Text: "second"})
+	err = w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := waitForMessage(t, received)
+	if !strings.Contains(msg, " [audit@99999][origin][custom@1234] two lines") {
+		t.Fatalf("expected qualified element IDs and a single line but got '%v'", msg)
+	}
+	if msg = waitForMessage(t, received); !strings.HasSuffix(msg, " second") {
+		t.Fatalf("expected each message on its own line but got '%v'", msg)
+	}
+}
+
+func TestDialErrors(t *testing.T) {
+	_, err := Dial(Config{Network: "unix", Address: "/dev/log"})
+	if err == nil {
+		t.Fatal("expected an unsupported network to be rejected")
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := listener.Addr().String()
+	listener.Close()
+	_, err = Dial(Config{Network: "tcp", Address: address, Timeout: time.Second})
+	if err == nil {
+		t.Fatal("expected a refused connection to be returned")
+	}
+}
+
+func TestCloseContextAbandonsQueue(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	// accept connections but never read from them so that every write times out
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+	w, err := Dial(Config{Network: "tcp", Address: listener.Addr().String(), Timeout: 20 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	text := strings.Repeat("x", 16<<20)
+	for i := 0; i < 200; i++ {
+		w.Send(Message{Time: epoch, Text: text})
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err = w.CloseContext(ctx)
+	if err != context.DeadlineExceeded {
+		t.Fatalf("expected the deadline to be returned but got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("expected the queue to be abandoned but closing took %v", elapsed)
+	}
+}
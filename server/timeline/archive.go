@@ -0,0 +1,269 @@
+package timeline
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/demskie/ipam/server/logging"
+	"github.com/demskie/ipam/server/subnets"
+)
+
+var logger = logging.New(nil)
+
+// SetLogOutput redirects the lines logged while loading snapshots
+func SetLogOutput(w io.Writer, flags int) {
+	logger.SetOutput(w, flags)
+}
+
+// Snapshot is every subnet as it existed at a moment in time
+type Snapshot struct {
+	Time    time.Time                 `json:"time"`
+	Subnets []*subnets.SubnetSkeleton `json:"subnets"`
+}
+
+const (
+	defaultInterval = time.Hour
+	defaultLimit    = 24 * 90
+	filePrefix      = "snapshot-"
+	fileSuffix      = ".json"
+)
+
+type snapshotEntry struct {
+	time     time.Time
+	filename string
+	snap     *Snapshot // only kept in memory when there is no directory
+}
+
+// Archive keeps periodic snapshots so that past states can be rebuilt without replaying all of history.
+// Only the time and file of each snapshot is kept in memory as snapshots are loaded when they are needed.
+type Archive struct {
+	mtx       *sync.RWMutex
+	directory string
+	interval  time.Duration
+	limit     int
+	entries   []snapshotEntry
+	digest    string    // of the newest snapshot
+	checked   time.Time // when the subnets were last found to be unchanged
+	log       *logging.Logger
+}
+
+// NewMemoryArchive returns an Archive that only keeps snapshots in memory
+func NewMemoryArchive() *Archive {
+	return &Archive{
+		mtx:      &sync.RWMutex{},
+		interval: defaultInterval,
+		limit:    defaultLimit,
+		entries:  []snapshotEntry{},
+		log:      logger,
+	}
+}
+
+// NewArchive returns a new Archive object. If directory is empty then snapshots are only kept in memory.
+// Snapshots that cannot be indexed are reported to log or to the package logger if it is nil.
+func NewArchive(directory string, log *logging.Logger) (*Archive, error) {
+	a := NewMemoryArchive()
+	if log != nil {
+		a.log = log
+	}
+	if directory == "" {
+		return a, nil
+	}
+	a.directory = directory
+	err := os.MkdirAll(directory, 0755)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create snapshot directory > %v", err)
+	}
+	names, err := filepath.Glob(filepath.Join(directory, filePrefix+"*"+fileSuffix))
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		base := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(name), filePrefix), fileSuffix)
+		nanos, err := strconv.ParseInt(base, 10, 64)
+		if err != nil {
+			a.log.Warnf("skipping snapshot '%v' as its name is not a timestamp\n", name)
+			continue
+		}
+		a.entries = append(a.entries, snapshotEntry{time: time.Unix(0, nanos), filename: name})
+	}
+	sort.Slice(a.entries, func(i, j int) bool {
+		return a.entries[i].time.Before(a.entries[j].time)
+	})
+	if len(a.entries) > 0 {
+		newest, err := a.load(a.entries[len(a.entries)-1])
+		if err != nil {
+			a.log.Errorf("unable to read the newest snapshot > %v\n", err)
+		} else {
+			a.digest = digest(newest.Subnets)
+		}
+	}
+	return a, nil
+}
+
+func digest(skeletons []*subnets.SubnetSkeleton) string {
+	b, _ := json.Marshal(skeletons)
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// SetInterval changes how often Due will report that a new snapshot should be taken
+func (a *Archive) SetInterval(interval time.Duration) {
+	a.mtx.Lock()
+	a.interval = interval
+	a.mtx.Unlock()
+}
+
+// SetLimit changes how many snapshots are retained before the oldest are discarded
+func (a *Archive) SetLimit(limit int) {
+	a.mtx.Lock()
+	a.limit = limit
+	a.trim()
+	a.mtx.Unlock()
+}
+
+// Due returns true if the subnets have not been snapshotted or found unchanged within the interval
+func (a *Archive) Due(now time.Time) bool {
+	a.mtx.RLock()
+	defer a.mtx.RUnlock()
+	if len(a.entries) == 0 {
+		return true
+	}
+	last := a.entries[len(a.entries)-1].time
+	if a.checked.After(last) {
+		last = a.checked
+	}
+	return now.Sub(last) >= a.interval
+}
+
+// Add stores the snapshot and discards the oldest snapshots beyond the limit. A snapshot that is
+// identical to the newest one is not stored and false is returned.
+func (a *Archive) Add(snap Snapshot) (bool, error) {
+	sum := digest(snap.Subnets)
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	if len(a.entries) > 0 && sum == a.digest && snap.Time.After(a.entries[len(a.entries)-1].time) {
+		a.checked = snap.Time
+		return false, nil
+	}
+	entry := snapshotEntry{time: snap.Time}
+	if a.directory != "" {
+		entry.filename = a.filename(snap)
+		err := a.writeSnapshot(snap, entry.filename)
+		if err != nil {
+			return false, err
+		}
+	} else {
+		entry.snap = &snap
+	}
+	i := sort.Search(len(a.entries), func(i int) bool {
+		return a.entries[i].time.After(snap.Time)
+	})
+	a.entries = append(a.entries, snapshotEntry{})
+	copy(a.entries[i+1:], a.entries[i:])
+	a.entries[i] = entry
+	if i == len(a.entries)-1 {
+		a.digest = sum
+	}
+	a.trim()
+	return true, nil
+}
+
+func (a *Archive) trim() {
+	for a.limit > 0 && len(a.entries) > a.limit {
+		if a.entries[0].filename != "" {
+			os.Remove(a.entries[0].filename)
+		}
+		a.entries[0] = snapshotEntry{}
+		a.entries = a.entries[1:]
+	}
+}
+
+func (a *Archive) load(entry snapshotEntry) (Snapshot, error) {
+	if entry.snap != nil {
+		return *entry.snap, nil
+	}
+	b, err := ioutil.ReadFile(entry.filename)
+	if err != nil {
+		return Snapshot{}, fmt.Errorf("unable to read snapshot > %v", err)
+	}
+	snap := Snapshot{}
+	err = json.Unmarshal(b, &snap)
+	if err != nil {
+		return Snapshot{}, fmt.Errorf("unable to parse snapshot '%v' > %v", entry.filename, err)
+	}
+	return snap, nil
+}
+
+// Nearest loads the newest snapshot taken at or before t, otherwise the oldest snapshot taken after t
+func (a *Archive) Nearest(t time.Time) (Snapshot, bool, error) {
+	a.mtx.RLock()
+	if len(a.entries) == 0 {
+		a.mtx.RUnlock()
+		return Snapshot{}, false, nil
+	}
+	i := sort.Search(len(a.entries), func(i int) bool {
+		return a.entries[i].time.After(t)
+	})
+	if i > 0 {
+		i--
+	}
+	entry := a.entries[i]
+	a.mtx.RUnlock()
+	snap, err := a.load(entry)
+	if err != nil {
+		return Snapshot{}, false, err
+	}
+	return snap, true, nil
+}
+
+// List returns the time of every snapshot with the oldest first
+func (a *Archive) List() []time.Time {
+	a.mtx.RLock()
+	defer a.mtx.RUnlock()
+	results := make([]time.Time, len(a.entries))
+	for i, entry := range a.entries {
+		results[i] = entry.time
+	}
+	return results
+}
+
+func (a *Archive) filename(snap Snapshot) string {
+	return filepath.Join(a.directory, filePrefix+strconv.FormatInt(snap.Time.UnixNano(), 10)+fileSuffix)
+}
+
+func (a *Archive) writeSnapshot(snap Snapshot, filename string) error {
+	b, err := json.Marshal(snap)
+	if err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(a.directory, "."+filePrefix)
+	if err != nil {
+		return fmt.Errorf("unable to write snapshot > %v", err)
+	}
+	_, err = f.Write(b)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), filename)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return fmt.Errorf("unable to write snapshot > %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package timeline
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/demskie/ipam/server/subnets"
+)
+
+var epoch = time.Date(2019, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+func skeletons(descs ...string) []*subnets.SubnetSkeleton {
+	results := []*subnets.SubnetSkeleton{}
+	for i, desc := range descs {
+		results = append(results, &subnets.SubnetSkeleton{Net: "10.0." + string(rune('0'+i)) + ".0/24", Desc: desc})
+	}
+	return results
+}
+
+func TestArchiveSkipsUnchangedSnapshots(t *testing.T) {
+	a := NewMemoryArchive()
+	a.SetInterval(time.Hour)
+	for i, descs := range [][]string{{"a"}, {"a"}, {"a", "b"}, {"a", "b"}} {
+		now := epoch.Add(time.Duration(i) * time.Hour)
+		if !a.Due(now) {
+			t.Fatalf("expected a snapshot to be due at %v", now)
+		}
+		added, err := a.Add(Snapshot{Time: now, Subnets: skeletons(descs...)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if added != (i%2 == 0) {
+			t.Fatalf("expected snapshot %v to be added=%v", i, i%2 == 0)
+		}
+	}
+	if len(a.List()) != 2 {
+		t.Fatalf("expected only the two distinct snapshots to be kept but got %v", a.List())
+	}
+	if a.Due(epoch.Add(3*time.Hour + time.Minute)) {
+		t.Fatal("expected an unchanged check to postpone the next snapshot")
+	}
+}
+
+func TestArchiveNearest(t *testing.T) {
+	a := NewMemoryArchive()
+	if _, exists, _ := a.Nearest(epoch); exists {
+		t.Fatal("expected an empty archive to have nothing")
+	}
+	for i, descs := range [][]string{{"a"}, {"a", "b"}, {"a", "b", "c"}} {
+		a.Add(Snapshot{Time: epoch.Add(time.Duration(i+1) * time.Hour), Subnets: skeletons(descs...)})
+	}
+	tests := map[time.Duration]int{0: 1, 90 * time.Minute: 1, 2 * time.Hour: 2, 24 * time.Hour: 3}
+	for offset, count := range tests {
+		snap, exists, err := a.Nearest(epoch.Add(offset))
+		if err != nil || !exists || len(snap.Subnets) != count {
+			t.Fatalf("expected the snapshot with %v subnets at +%v but got %v (%v)", count, offset, len(snap.Subnets), err)
+		}
+	}
+}
+
+func TestArchiveDirectory(t *testing.T) {
+	dir := t.TempDir()
+	a, err := NewArchive(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.SetLimit(2)
+	for i, descs := range [][]string{{"a"}, {"a", "b"}, {"a", "b", "c"}} {
+		_, err = a.Add(Snapshot{Time: epoch.Add(time.Duration(i) * time.Hour), Subnets: skeletons(descs...)})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	names, _ := filepath.Glob(filepath.Join(dir, filePrefix+"*"))
+	if len(names) != 2 {
+		t.Fatalf("expected the oldest snapshot file to be removed but found %v", names)
+	}
+	ioutil.WriteFile(filepath.Join(dir, filePrefix+"bogus"+fileSuffix), []byte("{}"), 0644)
+	reopened, err := NewArchive(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	times := reopened.List()
+	if len(times) != 2 || !times[0].Equal(epoch.Add(time.Hour)) || !times[1].Equal(epoch.Add(2*time.Hour)) {
+		t.Fatalf("expected both remaining snapshots to be indexed but got %v", times)
+	}
+	snap, exists, err := reopened.Nearest(epoch.Add(time.Hour))
+	if err != nil || !exists || len(snap.Subnets) != 2 || snap.Subnets[1].Desc != "b" {
+		t.Fatalf("expected the snapshot to be loaded from disk but got %+v (%v)", snap, err)
+	}
+	added, _ := reopened.Add(Snapshot{Time: epoch.Add(3 * time.Hour), Subnets: skeletons("a", "b", "c")})
+	if added {
+		t.Fatal("expected the newest snapshot on disk to be used to detect unchanged subnets")
+	}
+}
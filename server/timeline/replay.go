@@ -0,0 +1,93 @@
+package timeline
+
+import (
+	"time"
+
+	"github.com/demskie/subnetmath"
+
+	"github.com/demskie/ipam/server/history"
+	"github.com/demskie/ipam/server/subnets"
+)
+
+// Replay rebuilds the subnets as they existed at asOf starting from the snapshot.
+// Events newer than the snapshot are applied forwards and events older than the snapshot are undone in reverse.
+// The number of events that could not be applied because they lack the necessary state is also returned.
+func Replay(base Snapshot, evts []history.Event, asOf time.Time) (*subnets.Tree, int) {
+	tree := subnets.NewTree()
+	for _, skeleton := range base.Subnets {
+		copied := *skeleton
+		tree.CreateSubnet(&copied)
+	}
+	skipped := 0
+	if !asOf.Before(base.Time) {
+		for _, evt := range evts {
+			if !evt.Time.After(base.Time) || evt.Time.After(asOf) {
+				continue
+			}
+			if !applyState(tree, evt.Target, evt.IsReversible(), evt.After) {
+				skipped++
+			}
+		}
+	} else {
+		for i := len(evts) - 1; i >= 0; i-- {
+			evt := evts[i]
+			if !evt.Time.After(asOf) || evt.Time.After(base.Time) {
+				continue
+			}
+			if !applyState(tree, evt.Target, evt.IsReversible(), evt.Before) {
+				skipped++
+			}
+		}
+	}
+	return tree, skipped
+}
+
+// applyState forces the subnet to match the skeleton where a nil skeleton means it should not exist
+func applyState(tree *subnets.Tree, target string, reversible bool, skeleton *subnets.SubnetSkeleton) bool {
+	network := subnetmath.ParseNetworkCIDR(target)
+	if !reversible || network == nil {
+		return false
+	}
+	current := tree.GetSubnetSkeleton(network)
+	if skeleton == nil {
+		if current != nil {
+			tree.DeleteSubnet(network)
+		}
+		return true
+	}
+	copied := *skeleton
+	return tree.SwapSubnet(network, current, &copied) == nil
+}
+
+// SubnetChange describes how a single subnet differs between two points in time
+type SubnetChange struct {
+	Net    string                  `json:"net"`
+	Before *subnets.SubnetSkeleton `json:"before,omitempty"`
+	After  *subnets.SubnetSkeleton `json:"after,omitempty"`
+}
+
+// Diff lists the subnets that were added, removed or modified between before and after
+func Diff(before, after []*subnets.SubnetSkeleton) (added, removed, modified []SubnetChange) {
+	added, removed, modified = []SubnetChange{}, []SubnetChange{}, []SubnetChange{}
+	previous := make(map[string]*subnets.SubnetSkeleton, len(before))
+	for _, skeleton := range before {
+		previous[skeleton.Net] = skeleton
+	}
+	for _, skeleton := range after {
+		old, exists := previous[skeleton.Net]
+		if !exists {
+			added = append(added, SubnetChange{Net: skeleton.Net, After: skeleton})
+			continue
+		}
+		delete(previous, skeleton.Net)
+		if old.ListDifferences(skeleton) != nil {
+			modified = append(modified, SubnetChange{Net: skeleton.Net, Before: old, After: skeleton})
+		}
+	}
+	for _, skeleton := range before {
+		if _, exists := previous[skeleton.Net]; exists {
+			removed = append(removed, SubnetChange{Net: skeleton.Net, Before: skeleton})
+		}
+	}
+	return added, removed, modified
+}
@@ -0,0 +1,85 @@
+package timeline
+
+import (
+	"testing"
+	"time"
+
+	"github.com/demskie/ipam/server/history"
+	"github.com/demskie/ipam/server/subnets"
+)
+
+func skeleton(net, desc string) *subnets.SubnetSkeleton {
+	return &subnets.SubnetSkeleton{Net: net, Desc: desc}
+}
+
+// replayEvents creates 10.0.0.0/24, renames it, creates 10.0.1.0/24 and then deletes 10.0.0.0/24
+func replayEvents() []history.Event {
+	return []history.Event{
+		{Time: epoch.Add(1 * time.Hour), Target: "10.0.0.0/24", After: skeleton("10.0.0.0/24", "one")},
+		{Time: epoch.Add(2 * time.Hour), Target: "10.0.0.0/24", Before: skeleton("10.0.0.0/24", "one"), After: skeleton("10.0.0.0/24", "renamed")},
+		{Time: epoch.Add(3 * time.Hour), Target: "10.0.1.0/24", After: skeleton("10.0.1.0/24", "two")},
+		{Time: epoch.Add(4 * time.Hour), Target: "10.0.0.0/24", Before: skeleton("10.0.0.0/24", "renamed")},
+	}
+}
+
+func descriptions(tree *subnets.Tree) map[string]string {
+	results := map[string]string{}
+	for _, sn := range tree.GetAllSubnets() {
+		results[sn.Net] = sn.Desc
+	}
+	return results
+}
+
+func TestReplay(t *testing.T) {
+	evts := replayEvents()
+	empty := Snapshot{Time: epoch}
+	final := Snapshot{Time: epoch.Add(5 * time.Hour), Subnets: []*subnets.SubnetSkeleton{skeleton("10.0.1.0/24", "two")}}
+	expected := map[time.Duration]map[string]string{
+		90 * time.Minute:  {"10.0.0.0/24": "one"},
+		150 * time.Minute: {"10.0.0.0/24": "renamed"},
+		210 * time.Minute: {"10.0.0.0/24": "renamed", "10.0.1.0/24": "two"},
+		270 * time.Minute: {"10.0.1.0/24": "two"},
+	}
+	for offset, want := range expected {
+		for name, base := range map[string]Snapshot{"forwards": empty, "backwards": final} {
+			tree, skipped := Replay(base, evts, epoch.Add(offset))
+			got := descriptions(tree)
+			if skipped != 0 || len(got) != len(want) {
+				t.Fatalf("replaying %v to +%v expected %v but got %v (skipped %v)", name, offset, want, got, skipped)
+			}
+			for net, desc := range want {
+				if got[net] != desc {
+					t.Fatalf("replaying %v to +%v expected %v but got %v", name, offset, want, got)
+				}
+			}
+		}
+	}
+}
+
+func TestReplaySkipsLegacyEvents(t *testing.T) {
+	evts := replayEvents()
+	evts[1].Legacy = "01-02-2019 02:00:00 (alice) is modifying subnet"
+	tree, skipped := Replay(Snapshot{Time: epoch}, evts, epoch.Add(150*time.Minute))
+	if skipped != 1 || descriptions(tree)["10.0.0.0/24"] != "one" {
+		t.Fatalf("expected the legacy event to be skipped but got %v (skipped %v)", descriptions(tree), skipped)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	before := []*subnets.SubnetSkeleton{skeleton("10.0.0.0/24", "one"), skeleton("10.0.1.0/24", "two"), skeleton("10.0.2.0/24", "three")}
+	after := []*subnets.SubnetSkeleton{skeleton("10.0.0.0/24", "one"), skeleton("10.0.1.0/24", "changed"), skeleton("10.0.3.0/24", "four")}
+	added, removed, modified := Diff(before, after)
+	if len(added) != 1 || added[0].Net != "10.0.3.0/24" || added[0].Before != nil {
+		t.Fatalf("unexpected added %+v", added)
+	}
+	if len(removed) != 1 || removed[0].Net != "10.0.2.0/24" || removed[0].After != nil {
+		t.Fatalf("unexpected removed %+v", removed)
+	}
+	if len(modified) != 1 || modified[0].Before.Desc != "two" || modified[0].After.Desc != "changed" {
+		t.Fatalf("unexpected modified %+v", modified)
+	}
+	added, removed, modified = Diff(before, before)
+	if len(added)+len(removed)+len(modified) != 0 {
+		t.Fatal("expected identical subnets to have no differences")
+	}
+}
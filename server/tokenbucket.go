@@ -0,0 +1,57 @@
+package server
+
+import (
+	"math"
+	"time"
+)
+
+// tokenBucket refills at rate tokens per second up to burst and is not safe for concurrent use
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst int, now time.Time) *tokenBucket {
+	return &tokenBucket{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   now,
+	}
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	if now.After(b.last) {
+		b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+		b.last = now
+	}
+}
+
+// wait returns how long it will be until n tokens are available or false if they never will be
+func (b *tokenBucket) wait(n int, now time.Time) (time.Duration, bool) {
+	b.refill(now)
+	missing := float64(n) - b.tokens
+	if missing <= 0 {
+		return 0, true
+	}
+	if b.rate <= 0 || b.exceeds(n) {
+		return 0, false
+	}
+	return time.Duration(missing / b.rate * float64(time.Second)), true
+}
+
+// exceeds reports whether n tokens are more than the bucket is able to hold
+func (b *tokenBucket) exceeds(n int) bool {
+	return float64(n) > b.burst
+}
+
+func (b *tokenBucket) take(n int) {
+	b.tokens -= float64(n)
+}
+
+func (b *tokenBucket) full(now time.Time) bool {
+	b.refill(now)
+	return b.tokens >= b.burst
+}
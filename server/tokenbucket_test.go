@@ -0,0 +1,40 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTokenBucketRefillsUpToBurst(t *testing.T) {
+	now := time.Now()
+	b := newTokenBucket(10, 20, now)
+	if wait, ok := b.wait(20, now); !ok || wait != 0 {
+		t.Fatalf("expected a full bucket to allow its burst but had to wait %v", wait)
+	}
+	b.take(20)
+	if wait, _ := b.wait(5, now); wait != 500*time.Millisecond {
+		t.Fatalf("expected to wait 500ms for 5 tokens but got %v", wait)
+	}
+	if wait, _ := b.wait(5, now.Add(500*time.Millisecond)); wait != 0 {
+		t.Fatalf("expected 5 tokens after 500ms but had to wait %v", wait)
+	}
+	if b.full(now.Add(time.Second)) {
+		t.Fatal("expected the bucket to still be refilling after one second")
+	}
+	if !b.full(now.Add(time.Hour)) || b.tokens != 20 {
+		t.Fatalf("expected the bucket to be capped at its burst but it has %v tokens", b.tokens)
+	}
+}
+
+func TestTokenBucketRejectsMoreThanBurst(t *testing.T) {
+	now := time.Now()
+	b := newTokenBucket(10, 20, now)
+	if _, ok := b.wait(21, now); ok || !b.exceeds(21) {
+		t.Fatal("expected a request larger than the burst to never be satisfied")
+	}
+	b = newTokenBucket(0, 20, now)
+	b.take(20)
+	if _, ok := b.wait(1, now.Add(time.Hour)); ok {
+		t.Fatal("expected a bucket without a rate to never refill")
+	}
+}
@@ -0,0 +1,441 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/demskie/ipam/server/events"
+	"github.com/demskie/ipam/server/logging"
+	"github.com/demskie/ipam/server/subnets"
+	"github.com/demskie/subnetmath"
+)
+
+var logger = logging.New(nil)
+
+// SetLogOutput redirects the lines logged while delivering webhooks
+func SetLogOutput(w io.Writer, flags int) {
+	logger.SetOutput(w, flags)
+}
+
+const (
+	queueLength      = 1024
+	deliveryLogLimit = 1000
+	maximumAttempts  = 5
+	initialBackoff   = time.Second
+	maximumBackoff   = time.Minute
+)
+
+// SignatureHeader contains the hex encoded HMAC-SHA256 of the request body
+const SignatureHeader = "X-IPAM-Signature"
+
+// Registration describes where and when payloads should be delivered
+type Registration struct {
+	ID         string    `json:"id"`
+	URL        string    `json:"url"`
+	Secret     string    `json:"secret,omitempty"`
+	EventTypes []string  `json:"eventTypes"`
+	Subtree    string    `json:"subtree"`
+	Created    time.Time `json:"created"`
+}
+
+// Payload is the signed JSON document POSTed to each receiver
+type Payload struct {
+	Revision uint64                  `json:"revision"`
+	Event    string                  `json:"event"`
+	Time     time.Time               `json:"time"`
+	Actor    string                  `json:"actor"`
+	Subnet   string                  `json:"subnet"`
+	Old      *subnets.SubnetSkeleton `json:"old"`
+	New      *subnets.SubnetSkeleton `json:"new"`
+}
+
+// Delivery records the outcome of a single attempt to deliver a payload
+type Delivery struct {
+	WebhookID  string        `json:"webhookID"`
+	Revision   uint64        `json:"revision"`
+	Event      string        `json:"event"`
+	Attempt    int           `json:"attempt"`
+	Time       time.Time     `json:"time"`
+	Duration   time.Duration `json:"duration"`
+	StatusCode int           `json:"statusCode"`
+	Error      string        `json:"error"`
+	Delivered  bool          `json:"delivered"`
+}
+
+type webhook struct {
+	reg     Registration
+	network *net.IPNet
+	types   map[string]bool
+	queue   chan Payload
+}
+
+// Manager keeps track of registrations and delivers payloads to them
+type Manager struct {
+	mtx        *sync.RWMutex
+	client     *http.Client
+	webhooks   map[string]*webhook
+	deliveries []Delivery
+	log        *logging.Logger
+	ctx        context.Context
+	cancel     context.CancelFunc
+	running    *sync.WaitGroup
+	closed     bool
+}
+
+// NewManager returns a new Manager object
+func NewManager() *Manager {
+	m := &Manager{
+		mtx:        &sync.RWMutex{},
+		client:     &http.Client{Timeout: 10 * time.Second},
+		webhooks:   make(map[string]*webhook, 0),
+		deliveries: make([]Delivery, 0),
+		log:        logger,
+		running:    &sync.WaitGroup{},
+	}
+	m.ctx, m.cancel = context.WithCancel(context.Background())
+	return m
+}
+
+// Stop abandons every queued payload and retry and waits for the deliveries in progress to return
+func (m *Manager) Stop() {
+	m.cancel()
+	m.running.Wait()
+}
+
+// Close delivers the payloads that are already queued and then stops. If ctx expires first the
+// remaining payloads and retries are abandoned the same way as Stop and ctx.Err() is returned.
+func (m *Manager) Close(ctx context.Context) error {
+	m.mtx.Lock()
+	if !m.closed {
+		m.closed = true
+		for _, hook := range m.webhooks {
+			close(hook.queue)
+		}
+	}
+	m.mtx.Unlock()
+	drained := make(chan struct{})
+	go func() {
+		m.running.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+		m.cancel()
+		return nil
+	case <-ctx.Done():
+		m.Stop()
+		return ctx.Err()
+	}
+}
+
+// SetLogger replaces the logger that failed deliveries are reported to
+func (m *Manager) SetLogger(log *logging.Logger) {
+	m.mtx.Lock()
+	m.log = log
+	m.mtx.Unlock()
+}
+
+func (m *Manager) logger() *logging.Logger {
+	m.mtx.RLock()
+	defer m.mtx.RUnlock()
+	return m.log
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Register validates and adds the registration. A secret is generated if one was not provided.
+func (m *Manager) Register(reg Registration) (Registration, error) {
+	u, err := url.Parse(reg.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return Registration{}, fmt.Errorf("'%v' is not a valid http(s) URL", reg.URL)
+	}
+	hook := &webhook{types: make(map[string]bool, len(reg.EventTypes))}
+	for _, eventType := range reg.EventTypes {
+		switch eventType {
+		case events.SubnetCreated, events.SubnetModified, events.SubnetDeleted, events.SubnetReserved:
+			hook.types[eventType] = true
+		default:
+			return Registration{}, fmt.Errorf("'%v' is not a supported event type", eventType)
+		}
+	}
+	if reg.Subtree != "" {
+		hook.network = subnetmath.ParseNetworkCIDR(reg.Subtree)
+		if hook.network == nil {
+			return Registration{}, fmt.Errorf("'%v' is not a valid CIDR network", reg.Subtree)
+		}
+		reg.Subtree = hook.network.String()
+	}
+	if reg.Secret == "" {
+		reg.Secret = randomHex(32)
+	}
+	if reg.ID == "" {
+		reg.ID = randomHex(8)
+	}
+	if reg.Created.IsZero() {
+		reg.Created = time.Now()
+	}
+	reg.EventTypes = append([]string{}, reg.EventTypes...)
+	hook.reg = reg
+	hook.queue = make(chan Payload, queueLength)
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	if _, exists := m.webhooks[reg.ID]; exists {
+		return Registration{}, fmt.Errorf("webhook '%v' already exists", reg.ID)
+	}
+	if m.closed || m.ctx.Err() != nil {
+		return Registration{}, fmt.Errorf("webhooks have been stopped")
+	}
+	m.webhooks[reg.ID] = hook
+	m.running.Add(1)
+	go m.deliverQueue(hook)
+	return reg, nil
+}
+
+// Export returns every registration including its secret as JSON lines so that they can be saved
+func (m *Manager) Export() []string {
+	m.mtx.RLock()
+	regs := make([]Registration, 0, len(m.webhooks))
+	for _, hook := range m.webhooks {
+		regs = append(regs, hook.reg)
+	}
+	m.mtx.RUnlock()
+	sort.Slice(regs, func(i, j int) bool {
+		return regs[i].Created.Before(regs[j].Created)
+	})
+	lines := make([]string, 0, len(regs))
+	for _, reg := range regs {
+		b, _ := json.Marshal(reg)
+		lines = append(lines, string(b)+"\n")
+	}
+	return lines
+}
+
+// Restore registers every saved registration that is not already registered
+func (m *Manager) Restore(lines []string) error {
+	for _, line := range lines {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		var reg Registration
+		err := json.Unmarshal([]byte(line), &reg)
+		if err != nil {
+			return fmt.Errorf("unable to decode webhook '%v' > %v", strings.TrimSpace(line), err)
+		}
+		if reg.ID == "" || reg.Secret == "" {
+			return fmt.Errorf("webhook '%v' is missing its id or secret", strings.TrimSpace(line))
+		}
+		m.mtx.RLock()
+		_, exists := m.webhooks[reg.ID]
+		m.mtx.RUnlock()
+		if exists {
+			continue
+		}
+		_, err = m.Register(reg)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Remove deletes the registration although payloads that were already queued will still be attempted
+func (m *Manager) Remove(id string) (Registration, error) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	hook, exists := m.webhooks[id]
+	if !exists {
+		return Registration{}, fmt.Errorf("webhook '%v' does not exist", id)
+	}
+	delete(m.webhooks, id)
+	if !m.closed {
+		close(hook.queue)
+	}
+	return hook.reg, nil
+}
+
+// List returns all registrations without their secrets
+func (m *Manager) List() []Registration {
+	m.mtx.RLock()
+	results := make([]Registration, 0, len(m.webhooks))
+	for _, hook := range m.webhooks {
+		reg := hook.reg
+		reg.Secret = ""
+		results = append(results, reg)
+	}
+	m.mtx.RUnlock()
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Created.Before(results[j].Created)
+	})
+	return results
+}
+
+// Deliveries returns the most recent delivery attempts for a webhook or all of them if id is empty
+func (m *Manager) Deliveries(id string) []Delivery {
+	m.mtx.RLock()
+	defer m.mtx.RUnlock()
+	results := make([]Delivery, 0)
+	for i := len(m.deliveries) - 1; i >= 0; i-- {
+		if id == "" || m.deliveries[i].WebhookID == id {
+			results = append(results, m.deliveries[i])
+		}
+	}
+	return results
+}
+
+func (hook *webhook) matches(evt events.Event) bool {
+	if len(hook.types) > 0 && !hook.types[evt.Type] {
+		return false
+	}
+	switch evt.Type {
+	case events.SubnetCreated, events.SubnetModified, events.SubnetDeleted, events.SubnetReserved:
+	default:
+		return false
+	}
+	if hook.network != nil {
+		network := subnetmath.ParseNetworkCIDR(evt.Subnet)
+		if network == nil || !hook.network.Contains(network.IP) {
+			return false
+		}
+		hookOnes, _ := hook.network.Mask.Size()
+		ones, _ := network.Mask.Size()
+		if ones < hookOnes {
+			return false
+		}
+	}
+	return true
+}
+
+// Dispatch queues the event for every matching registration
+func (m *Manager) Dispatch(evt events.Event) {
+	payload := Payload{
+		Revision: evt.Revision,
+		Event:    evt.Type,
+		Time:     evt.Time,
+		Actor:    evt.Actor,
+		Subnet:   evt.Subnet,
+		Old:      evt.Old,
+		New:      evt.New,
+	}
+	m.mtx.RLock()
+	defer m.mtx.RUnlock()
+	if m.closed {
+		return
+	}
+	for _, hook := range m.webhooks {
+		if hook.matches(evt) {
+			select {
+			case hook.queue <- payload:
+			default:
+				m.log.Warnf("webhook '%v' queue is full so revision %v was dropped\n", hook.reg.ID, evt.Revision)
+			}
+		}
+	}
+}
+
+// Sign returns the value of the SignatureHeader for the body
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func (m *Manager) deliverQueue(hook *webhook) {
+	defer m.running.Done()
+	retry := time.NewTimer(initialBackoff)
+	retry.Stop()
+	defer retry.Stop()
+	for {
+		var payload Payload
+		var open bool
+		select {
+		case payload, open = <-hook.queue:
+			if !open {
+				return
+			}
+		case <-m.ctx.Done():
+			return
+		}
+		body, err := json.Marshal(payload)
+		if err != nil {
+			m.logger().Errorf("unable to encode webhook payload > %v\n", err)
+			continue
+		}
+		backoff := initialBackoff
+		for attempt := 1; attempt <= maximumAttempts; attempt++ {
+			if m.deliver(hook, payload, body, attempt) {
+				break
+			}
+			if attempt < maximumAttempts {
+				retry.Reset(backoff)
+				select {
+				case <-retry.C:
+				case <-m.ctx.Done():
+					return
+				}
+				backoff *= 2
+				if backoff > maximumBackoff {
+					backoff = maximumBackoff
+				}
+			}
+		}
+	}
+}
+
+func (m *Manager) deliver(hook *webhook, payload Payload, body []byte, attempt int) bool {
+	d := Delivery{
+		WebhookID: hook.reg.ID,
+		Revision:  payload.Revision,
+		Event:     payload.Event,
+		Attempt:   attempt,
+		Time:      time.Now(),
+	}
+	req, err := http.NewRequestWithContext(m.ctx, http.MethodPost, hook.reg.URL, bytes.NewReader(body))
+	if err == nil {
+		req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+		req.Header.Set("X-IPAM-Event", payload.Event)
+		req.Header.Set("X-IPAM-Delivery", fmt.Sprintf("%v-%v", hook.reg.ID, payload.Revision))
+		req.Header.Set(SignatureHeader, Sign(hook.reg.Secret, body))
+		var resp *http.Response
+		resp, err = m.client.Do(req)
+		if err == nil {
+			io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+			d.StatusCode = resp.StatusCode
+			d.Delivered = resp.StatusCode >= 200 && resp.StatusCode < 300
+			if !d.Delivered {
+				err = fmt.Errorf("receiver responded with '%v'", resp.Status)
+			}
+		}
+	}
+	d.Duration = time.Since(d.Time)
+	if err != nil {
+		d.Error = err.Error()
+		m.logger().Warnf("webhook '%v' delivery attempt %v failed > %v\n", hook.reg.ID, attempt, err)
+	}
+	m.mtx.Lock()
+	if len(m.deliveries) >= deliveryLogLimit {
+		m.deliveries = append(m.deliveries[:0], m.deliveries[len(m.deliveries)-deliveryLogLimit/2:]...)
+	}
+	m.deliveries = append(m.deliveries, d)
+	m.mtx.Unlock()
+	return d.Delivered
+}
@@ -0,0 +1,282 @@
+package webhooks
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/demskie/ipam/server/events"
+	"github.com/demskie/ipam/server/subnets"
+)
+
+type receivedRequest struct {
+	header http.Header
+	body   []byte
+}
+
+// newReceiver responds with each status in turn and then 200 once they run out
+func newReceiver(t *testing.T, statuses ...int) (*httptest.Server, chan receivedRequest) {
+	received := make(chan receivedRequest, 16)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		received <- receivedRequest{header: r.Header, body: body}
+		if len(statuses) > 0 {
+			w.WriteHeader(statuses[0])
+			statuses = statuses[1:]
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv, received
+}
+
+func waitForRequest(t *testing.T, received chan receivedRequest) receivedRequest {
+	select {
+	case req := <-received:
+		return req
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the webhook to be delivered")
+	}
+	return receivedRequest{}
+}
+
+func expectNoRequest(t *testing.T, received chan receivedRequest) {
+	select {
+	case req := <-received:
+		t.Fatalf("expected nothing to be delivered but received %s", req.body)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func subnetEvent(revision uint64, eventType, subnet string) events.Event {
+	return events.Event{
+		Revision: revision,
+		Type:     eventType,
+		Time:     time.Now(),
+		Actor:    "alice",
+		Subnet:   subnet,
+		New:      &subnets.SubnetSkeleton{Net: subnet, Desc: "test"},
+	}
+}
+
+func TestDispatchSignsPayload(t *testing.T) {
+	srv, received := newReceiver(t)
+	m := NewManager()
+	reg, err := m.Register(Registration{URL: srv.URL, Secret: "shared"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Dispatch(subnetEvent(7, events.SubnetCreated, "10.0.0.0/24"))
+	req := waitForRequest(t, received)
+	if req.header.Get(SignatureHeader) != Sign("shared", req.body) {
+		t.Fatalf("expected the body to be signed with the secret but got '%v'", req.header.Get(SignatureHeader))
+	}
+	if req.header.Get("X-IPAM-Event") != events.SubnetCreated || req.header.Get("X-IPAM-Delivery") != reg.ID+"-7" {
+		t.Fatalf("unexpected headers %v", req.header)
+	}
+	payload := Payload{}
+	err = json.Unmarshal(req.body, &payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if payload.Revision != 7 || payload.Subnet != "10.0.0.0/24" || payload.Actor != "alice" || payload.New == nil || payload.Old != nil {
+		t.Fatalf("unexpected payload %+v", payload)
+	}
+}
+
+func TestDispatchFiltersEvents(t *testing.T) {
+	srv, received := newReceiver(t)
+	m := NewManager()
+	_, err := m.Register(Registration{URL: srv.URL, EventTypes: []string{events.SubnetDeleted}, Subtree: "10.0.0.0/16"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Dispatch(subnetEvent(1, events.SubnetCreated, "10.0.1.0/24"))
+	m.Dispatch(subnetEvent(2, events.SubnetDeleted, "10.1.0.0/24"))
+	m.Dispatch(subnetEvent(3, events.SubnetDeleted, "10.0.0.0/8"))
+	m.Dispatch(subnetEvent(4, events.PingStatusChanged, "10.0.1.0/24"))
+	m.Dispatch(subnetEvent(5, events.SubnetDeleted, "10.0.1.0/24"))
+	payload := Payload{}
+	json.Unmarshal(waitForRequest(t, received).body, &payload)
+	if payload.Revision != 5 {
+		t.Fatalf("expected only revision 5 to be delivered but got %v", payload.Revision)
+	}
+	expectNoRequest(t, received)
+}
+
+func TestDeliveryRetriesFailures(t *testing.T) {
+	SetLogOutput(ioutil.Discard, 0)
+	srv, received := newReceiver(t, http.StatusInternalServerError)
+	m := NewManager()
+	reg, err := m.Register(Registration{URL: srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Dispatch(subnetEvent(1, events.SubnetModified, "10.0.0.0/24"))
+	first, second := waitForRequest(t, received), waitForRequest(t, received)
+	if string(first.body) != string(second.body) {
+		t.Fatal("expected the same payload to be retried")
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for len(m.Deliveries(reg.ID)) < 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	deliveries := m.Deliveries(reg.ID)
+	if len(deliveries) != 2 {
+		t.Fatalf("expected two delivery attempts but got %+v", deliveries)
+	}
+	if !deliveries[0].Delivered || deliveries[0].Attempt != 2 || deliveries[0].StatusCode != http.StatusOK {
+		t.Fatalf("expected the newest attempt to succeed but got %+v", deliveries[0])
+	}
+	if deliveries[1].Delivered || deliveries[1].StatusCode != http.StatusInternalServerError || deliveries[1].Error == "" {
+		t.Fatalf("expected the first attempt to fail but got %+v", deliveries[1])
+	}
+}
+
+func TestRegisterAndRemove(t *testing.T) {
+	srv, received := newReceiver(t)
+	m := NewManager()
+	invalid := []Registration{
+		{URL: "ftp://example.com"},
+		{URL: srv.URL, EventTypes: []string{"bogus"}},
+		{URL: srv.URL, Subtree: "10.0.0.0/33"},
+	}
+	for _, reg := range invalid {
+		_, err := m.Register(reg)
+		if err == nil {
+			t.Fatalf("expected %+v to be rejected", reg)
+		}
+	}
+	reg, err := m.Register(Registration{URL: srv.URL, Subtree: "10.0.0.0/16"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reg.Secret == "" || reg.Subtree != "10.0.0.0/16" {
+		t.Fatalf("expected a generated secret but got %+v", reg)
+	}
+	list := m.List()
+	if len(list) != 1 || list[0].ID != reg.ID || list[0].Secret != "" {
+		t.Fatalf("expected the registration to be listed without its secret but got %+v", list)
+	}
+	removed, err := m.Remove(reg.ID)
+	if err != nil || removed.Secret != reg.Secret {
+		t.Fatalf("expected the removed registration to be returned but got %+v (%v)", removed, err)
+	}
+	if _, err = m.Remove(reg.ID); err == nil || len(m.List()) != 0 {
+		t.Fatal("expected the registration to be gone")
+	}
+	m.Dispatch(subnetEvent(1, events.SubnetCreated, "10.0.0.0/24"))
+	expectNoRequest(t, received)
+}
+
+func TestExportAndRestore(t *testing.T) {
+	srv, received := newReceiver(t)
+	m := NewManager()
+	defer m.Stop()
+	reg, err := m.Register(Registration{URL: srv.URL, Secret: "shh", EventTypes: []string{events.SubnetCreated}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := m.Export()
+	restored := NewManager()
+	defer restored.Stop()
+	err = restored.Restore(append(lines, "\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	list := restored.List()
+	if len(list) != 1 || list[0].ID != reg.ID || !list[0].Created.Equal(reg.Created) {
+		t.Fatalf("expected the registration to be restored with its id but got %+v", list)
+	}
+	if err = restored.Restore(lines); err != nil || len(restored.List()) != 1 {
+		t.Fatalf("expected restoring twice to keep a single registration but got %v (%v)", restored.List(), err)
+	}
+	restored.Dispatch(subnetEvent(1, events.SubnetCreated, "10.0.0.0/24"))
+	req := waitForRequest(t, received)
+	if req.header.Get(SignatureHeader) != Sign("shh", req.body) {
+		t.Fatal("expected the restored secret to sign payloads")
+	}
+	if restored.Restore([]string{`{"url":"` + srv.URL + `"}`}) == nil {
+		t.Fatal("expected a registration without an id or secret to be rejected")
+	}
+}
+
+func TestStopAbandonsRetries(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+	m := NewManager()
+	_, err := m.Register(Registration{URL: srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Dispatch(subnetEvent(1, events.SubnetCreated, "10.0.0.0/24"))
+	deadline := time.Now().Add(5 * time.Second)
+	for len(m.Deliveries("")) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	stopped := make(chan struct{})
+	go func() {
+		m.Stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("expected Stop to interrupt the retry backoff")
+	}
+	if _, err = m.Register(Registration{URL: srv.URL}); err == nil {
+		t.Fatal("expected registrations to be refused once stopped")
+	}
+}
+
+func TestCloseDeliversQueuedPayloads(t *testing.T) {
+	srv, received := newReceiver(t)
+	m := NewManager()
+	_, err := m.Register(Registration{URL: srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for revision := uint64(1); revision <= 3; revision++ {
+		m.Dispatch(subnetEvent(revision, events.SubnetCreated, "10.0.0.0/24"))
+	}
+	err = m.Close(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(received) != 3 {
+		t.Fatalf("expected every queued payload to be delivered before Close returned but got %v", len(received))
+	}
+	m.Dispatch(subnetEvent(4, events.SubnetCreated, "10.0.0.0/24"))
+	if _, err = m.Register(Registration{URL: srv.URL}); err == nil {
+		t.Fatal("expected registrations to be refused once closed")
+	}
+	if len(m.List()) != 1 {
+		t.Fatalf("expected the registration to be kept so that it can still be saved but got %+v", m.List())
+	}
+}
+
+func TestCloseAbandonsRetriesOnceExpired(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+	m := NewManager()
+	_, err := m.Register(Registration{URL: srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Dispatch(subnetEvent(1, events.SubnetCreated, "10.0.0.0/24"))
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err = m.Close(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("expected the retry backoff to outlast ctx but got %v", err)
+	}
+	if _, err = m.Remove(m.List()[0].ID); err != nil {
+		t.Fatalf("expected removing after Close to not close the queue twice but got %v", err)
+	}
+}
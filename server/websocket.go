@@ -3,15 +3,20 @@ package server
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
 	"net"
 	"net/http"
+	"net/url"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/demskie/subnetmath"
 
+	"github.com/demskie/ipam/server/events"
+	"github.com/demskie/ipam/server/history"
+	"github.com/demskie/ipam/server/logging"
 	"github.com/demskie/ipam/server/ping"
 	"github.com/demskie/ipam/server/subnets"
 	"github.com/gorilla/websocket"
@@ -36,42 +41,120 @@ const (
 	CreateSubnet
 	ModifySubnet
 	DeleteSubnet
+	Login
+	Logout
+	Subscribe
+	Unsubscribe
+	RevertAction
+	HostDetail
+	ManualPingScanQueue
+	ManualPingScanProgress
+	ManualPingScanResult
+	ManualPingScanComplete
 )
 
+type wsClient struct {
+	conn         *websocket.Conn
+	writeMtx     *sync.Mutex
+	remoteIP     string
+	sessionID    string
+	subscription chan events.Event
+	log          *logging.Logger
+}
+
+func newWebsocketClient(conn *websocket.Conn, log *logging.Logger) *wsClient {
+	remoteIP, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
+	return &wsClient{
+		conn:     conn,
+		writeMtx: &sync.Mutex{},
+		remoteIP: remoteIP,
+		log:      log,
+	}
+}
+
+func (c *wsClient) WriteMessage(messageType int, data []byte) error {
+	c.writeMtx.Lock()
+	defer c.writeMtx.Unlock()
+	return c.conn.WriteMessage(messageType, data)
+}
+
+func (c *wsClient) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+func (ipam *IPAMServer) checkOrigin(r *http.Request) bool {
+	ipam.authMtx.RLock()
+	origins := ipam.origins
+	ipam.authMtx.RUnlock()
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		// only browsers send an Origin and other clients are not exposed to cross site requests
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	if strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	for _, allowed := range origins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) || strings.EqualFold(allowed, u.Host) {
+			return true
+		}
+	}
+	return false
+}
+
 func (ipam *IPAMServer) handleWebsocketClient(w http.ResponseWriter, r *http.Request) {
 	upgrader := websocket.Upgrader{
 		HandshakeTimeout:  30 * time.Second,
 		ReadBufferSize:    1024,
 		WriteBufferSize:   1024,
 		EnableCompression: true,
-		CheckOrigin:       func(r *http.Request) bool { return true },
+		CheckOrigin:       ipam.checkOrigin,
 	}
-	conn, err := upgrader.Upgrade(w, r, nil)
+	wsConn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
-		log.Fatal(err)
+		ipam.log.Errorf("unable to upgrade websocket connection from (%v) > %v\n", r.RemoteAddr, err)
+		return
+	}
+	defer wsConn.Close()
+	conn := newWebsocketClient(wsConn, ipam.log)
+	if !ipam.trackWebsocket(conn) {
+		msg := websocket.FormatCloseMessage(websocket.CloseGoingAway, "server is shutting down")
+		wsConn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+		return
 	}
-	defer conn.Close()
-	conn.EnableWriteCompression(true)
-	conn.SetCompressionLevel(1)
-	conn.SetReadLimit(1000000) // one megabyte
+	defer ipam.untrackWebsocket(conn)
+	defer ipam.manualScans.cancel(conn)
+	defer func() {
+		ipam.sessions.Delete(conn.sessionID)
+		if conn.subscription != nil {
+			ipam.events.Unsubscribe(conn.subscription)
+		}
+	}()
+	wsConn.EnableWriteCompression(true)
+	wsConn.SetCompressionLevel(1)
+	wsConn.SetReadLimit(1000000) // one megabyte
 	// reused bytes
 	var (
 		networkIn bytes.Buffer
 		decJSON   = json.NewDecoder(&networkIn)
 	)
-	remoteIP, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
+	remoteIP := conn.remoteIP
 	for {
 		// receive data from client
-		msgType, newData, err := conn.ReadMessage()
+		msgType, newData, err := wsConn.ReadMessage()
 		if err != nil {
 			if strings.Contains(err.Error(), "websocket: close 1001 (going away)") ||
 				strings.Contains(err.Error(), "connection reset by peer") {
 				return
 			}
-			log.Printf("error receiving message from (%v) > %v\n", remoteIP, err)
+			ipam.log.Warnf("error receiving message from (%v) > %v\n", remoteIP, err)
 		}
 		if msgType != websocket.TextMessage {
-			log.Printf("received an invalid message from (%v)\n", remoteIP)
+			ipam.log.Warnf("received an invalid message from (%v)\n", remoteIP)
 			return
 		}
 		networkIn.Reset()
@@ -79,7 +162,7 @@ func (ipam *IPAMServer) handleWebsocketClient(w http.ResponseWriter, r *http.Req
 		inMsg := baseMessage{}
 		err = decJSON.Decode(&inMsg)
 		if err != nil {
-			log.Printf("error decoding incoming message from (%v)\n", remoteIP)
+			ipam.log.Warnf("error decoding incoming message from (%v)\n", remoteIP)
 			return
 		}
 		networkIn.Reset()
@@ -89,9 +172,9 @@ func (ipam *IPAMServer) handleWebsocketClient(w http.ResponseWriter, r *http.Req
 		case Ping:
 			ipam.handlePing(conn, inMsg.SessionGUID)
 		case GenericError:
-			log.Printf("received invalid requestType (GenericError) from (%v)\n", remoteIP)
+			ipam.log.Warnf("received invalid requestType (GenericError) from (%v)\n", remoteIP)
 		case GenericInfo:
-			log.Printf("received invalid requestType (GenericInfo) from (%v)\n", remoteIP)
+			ipam.log.Warnf("received invalid requestType (GenericInfo) from (%v)\n", remoteIP)
 		case AllSubnets:
 			ipam.handleAllSubnets(conn, inMsg.SessionGUID)
 		case SpecificHosts:
@@ -99,9 +182,9 @@ func (ipam *IPAMServer) handleWebsocketClient(w http.ResponseWriter, r *http.Req
 		case SomeHosts:
 			ipam.handleSomeHosts(conn, decJSON)
 		case History:
-			ipam.handleHistory(conn, inMsg.SessionGUID)
+			ipam.handleHistory(conn, decJSON)
 		case DebugLog:
-			ipam.handleDebugLog(conn, inMsg.SessionGUID)
+			ipam.handleDebugLog(conn, decJSON)
 		case ManualPingScan:
 			ipam.handleManualPingScan(conn, decJSON)
 		case CreateSubnet:
@@ -110,8 +193,20 @@ func (ipam *IPAMServer) handleWebsocketClient(w http.ResponseWriter, r *http.Req
 			ipam.handleModifySubnet(conn, decJSON)
 		case DeleteSubnet:
 			ipam.handleDeleteSubnet(conn, decJSON)
+		case Login:
+			ipam.handleLogin(conn, decJSON)
+		case Logout:
+			ipam.handleLogout(conn, inMsg.SessionGUID)
+		case Subscribe:
+			ipam.handleSubscribe(conn, decJSON)
+		case Unsubscribe:
+			ipam.handleUnsubscribe(conn, inMsg.SessionGUID)
+		case RevertAction:
+			ipam.handleRevertAction(conn, decJSON)
+		case HostDetail:
+			ipam.handleHostDetail(conn, decJSON)
 		default:
-			log.Printf("received unknown request from (%v)\n", remoteIP)
+			ipam.log.Warnf("received unknown request from (%v)\n", remoteIP)
 		}
 	}
 }
@@ -121,7 +216,7 @@ type outboundPing struct {
 	DemoMode bool `json:"demoMode"`
 }
 
-func (ipam *IPAMServer) handlePing(conn *websocket.Conn, guid string) {
+func (ipam *IPAMServer) handlePing(conn *wsClient, guid string) {
 	outMsg := outboundPing{}
 	outMsg.MessageType = Ping
 	outMsg.SessionGUID = guid
@@ -129,7 +224,7 @@ func (ipam *IPAMServer) handlePing(conn *websocket.Conn, guid string) {
 	b, err := json.Marshal(outMsg)
 	if err != nil {
 		remoteIP, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
-		log.Printf("error encoding outgoing Ping to (%v)\n", remoteIP)
+		ipam.log.Errorf("error encoding outgoing Ping to (%v)\n", remoteIP)
 	} else {
 		conn.WriteMessage(websocket.TextMessage, b)
 	}
@@ -143,6 +238,9 @@ const (
 	AlreadyExists
 	AuthenticationFailure
 	UnknownFault
+	Conflict
+	RateLimited
+	ScanTooLarge
 )
 
 type outboundGenericError struct {
@@ -151,7 +249,7 @@ type outboundGenericError struct {
 	ErrorValue string  `json:"errorValue"`
 }
 
-func sendGenericError(conn *websocket.Conn, message string, guid string, errorType int) {
+func sendGenericError(conn *wsClient, message string, guid string, errorType int) {
 	remoteIP, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
 	outMsg := outboundGenericError{}
 	outMsg.MessageType = GenericError
@@ -160,7 +258,7 @@ func sendGenericError(conn *websocket.Conn, message string, guid string, errorTy
 	outMsg.ErrorValue = message
 	b, err := json.Marshal(outMsg)
 	if err != nil {
-		log.Printf("error encoding outgoing error message to (%v)\n", remoteIP)
+		conn.log.Errorf("error encoding outgoing error message to (%v)\n", remoteIP)
 	} else {
 		conn.WriteMessage(websocket.TextMessage, b)
 	}
@@ -171,7 +269,7 @@ type outboundGenericInfo struct {
 	Info string `json:"info"`
 }
 
-func sendGenericInfo(conn *websocket.Conn, message string, guid string) {
+func sendGenericInfo(conn *wsClient, message string, guid string) {
 	remoteIP, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
 	outMsg := outboundGenericInfo{}
 	outMsg.MessageType = GenericInfo
@@ -179,7 +277,7 @@ func sendGenericInfo(conn *websocket.Conn, message string, guid string) {
 	outMsg.Info = message
 	b, err := json.Marshal(outMsg)
 	if err != nil {
-		log.Printf("error encoding outgoing info message to (%v)\n", remoteIP)
+		conn.log.Errorf("error encoding outgoing info message to (%v)\n", remoteIP)
 	} else {
 		conn.WriteMessage(websocket.TextMessage, b)
 	}
@@ -190,7 +288,7 @@ type outboundAllSubnets struct {
 	Subnets []subnets.SubnetJSON `json:"subnets"`
 }
 
-func (ipam *IPAMServer) handleAllSubnets(conn *websocket.Conn, guid string) {
+func (ipam *IPAMServer) handleAllSubnets(conn *wsClient, guid string) {
 	outMsg := outboundAllSubnets{}
 	outMsg.MessageType = AllSubnets
 	outMsg.SessionGUID = guid
@@ -198,7 +296,7 @@ func (ipam *IPAMServer) handleAllSubnets(conn *websocket.Conn, guid string) {
 	b, err := json.Marshal(outMsg)
 	if err != nil {
 		remoteIP, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
-		log.Printf("error encoding outgoing AllSubnets to (%v)\n", remoteIP)
+		ipam.log.Errorf("error encoding outgoing AllSubnets to (%v)\n", remoteIP)
 	} else {
 		conn.WriteMessage(websocket.TextMessage, b)
 	}
@@ -211,11 +309,11 @@ type inboundSpecificHosts struct {
 
 // HostData is structured data for client side use
 type HostData struct {
-	Addresses    []string   `json:"addresses"`
-	Arecords     []string   `json:"aRecords"`
-	LastAttempts []string   `json:"lastAttempts"`
-	PingResults  []string   `json:"pingResults"`
-	CustomData   [][]string `json:"customData"`
+	Addresses    []string      `json:"addresses"`
+	Arecords     []string      `json:"aRecords"`
+	LastAttempts []string      `json:"lastAttempts"`
+	PingResults  []ping.Result `json:"pingResults"`
+	CustomData   [][]string    `json:"customData"`
 }
 
 type outboundSpecificHosts struct {
@@ -223,20 +321,20 @@ type outboundSpecificHosts struct {
 	Hosts HostData `json:"hosts"`
 }
 
-func (ipam *IPAMServer) handleSpecificHosts(conn *websocket.Conn, decJSON *json.Decoder) {
+func (ipam *IPAMServer) handleSpecificHosts(conn *wsClient, decJSON *json.Decoder) {
 	remoteIP, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
 	inMsg := inboundSpecificHosts{}
 	err := decJSON.Decode(&inMsg)
 	if err != nil {
-		log.Printf("error decoding specificHosts request from (%v)\n", remoteIP)
+		ipam.log.Warnf("error decoding specificHosts request from (%v)\n", remoteIP)
 		return
 	}
 	network := subnetmath.ParseNetworkCIDR(inMsg.Network)
 	if network == nil {
-		log.Printf("received an invalid specificHosts query '%v' from (%v)\n", inMsg.Network, remoteIP)
+		ipam.log.Warnf("received an invalid specificHosts query '%v' from (%v)\n", inMsg.Network, remoteIP)
 		return
 	}
-	log.Printf("(%v) has requested specificHosts for '%v'\n", remoteIP, network.String())
+	ipam.log.Debugf("(%v) has requested specificHosts for '%v'\n", remoteIP, network.String())
 	sliceOfAddresses := []string{}
 	currentIP := subnetmath.DuplicateAddr(network.IP)
 	i := 0
@@ -253,14 +351,14 @@ func (ipam *IPAMServer) handleSpecificHosts(conn *websocket.Conn, decJSON *json.
 	outMsg.SessionGUID = inMsg.SessionGUID
 	outMsg.Hosts = HostData{
 		Addresses:    sliceOfAddresses,
-		Arecords:     ipam.dns.GetFirstHostnameForAddresses(sliceOfAddresses),
+		Arecords:     ipam.getHostnames(sliceOfAddresses),
 		LastAttempts: ipam.pinger.GetPingTimesForAddresses(sliceOfAddresses),
 		PingResults:  ipam.pinger.GetPingResultsForAddresses(sliceOfAddresses),
 		CustomData:   ipam.custom.GetCustomData(sliceOfAddresses),
 	}
 	b, err := json.Marshal(outMsg)
 	if err != nil {
-		log.Printf("error encoding outgoing message to (%v)\n", remoteIP)
+		ipam.log.Errorf("error encoding outgoing message to (%v)\n", remoteIP)
 	} else {
 		conn.WriteMessage(websocket.TextMessage, b)
 	}
@@ -276,21 +374,21 @@ type outboundSomeHosts struct {
 	Hosts HostData `json:"hosts"`
 }
 
-func (ipam *IPAMServer) handleSomeHosts(conn *websocket.Conn, decJSON *json.Decoder) {
+func (ipam *IPAMServer) handleSomeHosts(conn *wsClient, decJSON *json.Decoder) {
 	remoteIP, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
 	inMsg := inboundSomeHosts{}
 	err := decJSON.Decode(&inMsg)
 	if err != nil {
-		log.Printf("error decoding someHosts request from (%v)\n", remoteIP)
+		ipam.log.Warnf("error decoding someHosts request from (%v)\n", remoteIP)
 		return
 	}
 	inMsg.Filter = strings.TrimSpace(inMsg.Filter)
 	inMsg.Filter = strings.ToLower(inMsg.Filter)
 	if inMsg.Filter == "" {
-		log.Printf("(%v) has sent an empty search query\n", remoteIP)
+		ipam.log.Warnf("(%v) has sent an empty search query\n", remoteIP)
 		return
 	}
-	log.Printf("(%v) has requested someHosts matching '%v'\n", remoteIP, inMsg.Filter)
+	ipam.log.Debugf("(%v) has requested someHosts matching '%v'\n", remoteIP, inMsg.Filter)
 	outMsg := outboundSomeHosts{}
 	outMsg.MessageType = SomeHosts
 	outMsg.SessionGUID = inMsg.SessionGUID
@@ -302,48 +400,181 @@ func (ipam *IPAMServer) handleSomeHosts(conn *websocket.Conn, decJSON *json.Deco
 	outMsg.Hosts = ipam.searchHostData(inMsg.Filter, timeoutChan)
 	b, err := json.Marshal(outMsg)
 	if err != nil {
-		log.Printf("error encoding someHosts to (%v)\n", remoteIP)
+		ipam.log.Errorf("error encoding someHosts to (%v)\n", remoteIP)
 	} else {
 		conn.WriteMessage(websocket.TextMessage, b)
 	}
 }
 
+type inboundHistory struct {
+	baseMessage
+	CIDR               string `json:"cidr"`
+	IncludeDescendants bool   `json:"includeDescendants"`
+	Actor              string `json:"actor"`
+	Action             string `json:"action"`
+	Since              string `json:"since"`
+	Until              string `json:"until"`
+	Text               string `json:"text"`
+	Offset             int    `json:"offset"`
+	Limit              int    `json:"limit"`
+}
+
 type outboundHistory struct {
 	baseMessage
-	History []string `json:"history"`
+	History []string        `json:"history"`
+	Events  []history.Event `json:"events"`
+	Total   int             `json:"total"`
 }
 
-func (ipam *IPAMServer) handleHistory(conn *websocket.Conn, guid string) {
+func (ipam *IPAMServer) handleHistory(conn *wsClient, decJSON *json.Decoder) {
+	remoteIP, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
+	inMsg := inboundHistory{}
+	err := decJSON.Decode(&inMsg)
+	if err != nil {
+		ipam.log.Warnf("error decoding history request from (%v)\n", remoteIP)
+		return
+	}
+	query := history.Query{
+		CIDR:               inMsg.CIDR,
+		IncludeDescendants: inMsg.IncludeDescendants,
+		Actor:              inMsg.Actor,
+		Action:             inMsg.Action,
+		Text:               inMsg.Text,
+		Offset:             inMsg.Offset,
+		Limit:              inMsg.Limit,
+	}
+	query.Since, err = history.ParseQueryTime(inMsg.Since)
+	if err == nil {
+		query.Until, err = history.ParseQueryTime(inMsg.Until)
+	}
+	if err != nil {
+		sendGenericError(conn, err.Error(), inMsg.SessionGUID, int(UnknownFault))
+		return
+	}
 	outMsg := outboundHistory{}
 	outMsg.MessageType = History
-	outMsg.SessionGUID = guid
-	outMsg.History = ipam.history.GetAllUserActions()
-	if len(outMsg.History) > 10000 {
-		outMsg.History = outMsg.History[:10000]
+	outMsg.SessionGUID = inMsg.SessionGUID
+	outMsg.Events, outMsg.Total, err = ipam.history.Search(query)
+	if err != nil {
+		sendGenericError(conn, err.Error(), inMsg.SessionGUID, int(UnknownFault))
+		return
+	}
+	outMsg.History = make([]string, len(outMsg.Events))
+	for i := range outMsg.Events {
+		outMsg.History[i] = outMsg.Events[i].String() + "\n"
 	}
 	b, err := json.Marshal(outMsg)
 	if err != nil {
-		remoteIP, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
-		log.Printf("error encoding history for (%v)\n", remoteIP)
+		ipam.log.Errorf("error encoding history for (%v)\n", remoteIP)
 	} else {
 		conn.WriteMessage(websocket.TextMessage, b)
 	}
 }
 
+type inboundDebugLog struct {
+	baseMessage
+	Level string `json:"level"`
+	Since string `json:"since"`
+	Until string `json:"until"`
+	Limit int    `json:"limit"`
+}
+
 type outboundDebugLog struct {
 	baseMessage
-	DebugLog []string `json:"debugLog"`
+	DebugLog []string        `json:"debugLog"`
+	Entries  []history.Entry `json:"entries"`
 }
 
-func (ipam *IPAMServer) handleDebugLog(conn *websocket.Conn, guid string) {
+func (ipam *IPAMServer) handleDebugLog(conn *wsClient, decJSON *json.Decoder) {
+	remoteIP, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
+	inMsg := inboundDebugLog{}
+	err := decJSON.Decode(&inMsg)
+	if err != nil {
+		ipam.log.Warnf("error decoding debugLog request from (%v)\n", remoteIP)
+		return
+	}
+	query := history.EntryQuery{MinLevel: logging.LevelDebug, Limit: inMsg.Limit}
+	if inMsg.Level != "" {
+		query.MinLevel, err = logging.ParseLevel(inMsg.Level)
+		if err != nil {
+			sendGenericError(conn, err.Error(), inMsg.SessionGUID, int(UnknownFault))
+			return
+		}
+	}
+	query.Since, err = history.ParseQueryTime(inMsg.Since)
+	if err == nil {
+		query.Until, err = history.ParseQueryTime(inMsg.Until)
+	}
+	if err != nil {
+		sendGenericError(conn, err.Error(), inMsg.SessionGUID, int(UnknownFault))
+		return
+	}
 	outMsg := outboundDebugLog{}
 	outMsg.MessageType = DebugLog
-	outMsg.SessionGUID = guid
-	outMsg.DebugLog = strings.Split(ipam.debug.GetString(), "\n")
+	outMsg.SessionGUID = inMsg.SessionGUID
+	outMsg.Entries = ipam.debug.GetEntries(query)
+	outMsg.DebugLog = make([]string, len(outMsg.Entries))
+	for i, entry := range outMsg.Entries {
+		outMsg.DebugLog[i] = entry.String()
+	}
 	b, err := json.Marshal(outMsg)
 	if err != nil {
-		remoteIP, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
-		log.Printf("error encoding debugLog for (%v)\n", remoteIP)
+		ipam.log.Errorf("error encoding debugLog for (%v)\n", remoteIP)
+	} else {
+		conn.WriteMessage(websocket.TextMessage, b)
+	}
+}
+
+// HostDetailData combines the reachability history of an address with what else is known about it
+type HostDetailData struct {
+	ping.HostHistory
+	Arecord    string     `json:"aRecord"`
+	CustomData [][]string `json:"customData"`
+}
+
+func (ipam *IPAMServer) getHostDetail(address string) (HostDetailData, bool) {
+	h, exists := ipam.pinger.GetHostHistory(address)
+	if !exists {
+		return HostDetailData{}, false
+	}
+	detail := HostDetailData{
+		HostHistory: h,
+		Arecord:     ipam.getHostnames([]string{h.Address})[0],
+		CustomData:  ipam.custom.GetCustomData([]string{h.Address}),
+	}
+	return detail, true
+}
+
+type inboundHostDetail struct {
+	baseMessage
+	Address string `json:"address"`
+}
+
+type outboundHostDetail struct {
+	baseMessage
+	Host HostDetailData `json:"host"`
+}
+
+func (ipam *IPAMServer) handleHostDetail(conn *wsClient, decJSON *json.Decoder) {
+	remoteIP, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
+	inMsg := inboundHostDetail{}
+	err := decJSON.Decode(&inMsg)
+	if err != nil {
+		ipam.log.Warnf("error decoding hostDetail request from (%v)\n", remoteIP)
+		return
+	}
+	detail, exists := ipam.getHostDetail(strings.TrimSpace(inMsg.Address))
+	if !exists {
+		s := fmt.Sprintf("'%v' is not a valid address", inMsg.Address)
+		sendGenericError(conn, s, inMsg.SessionGUID, int(UnknownFault))
+		return
+	}
+	outMsg := outboundHostDetail{Host: detail}
+	outMsg.MessageType = HostDetail
+	outMsg.SessionGUID = inMsg.SessionGUID
+	b, err := json.Marshal(outMsg)
+	if err != nil {
+		ipam.log.Errorf("error encoding outgoing HostDetail to (%v)\n", remoteIP)
 	} else {
 		conn.WriteMessage(websocket.TextMessage, b)
 	}
@@ -359,12 +590,12 @@ type outboundManualPingScan struct {
 	Results []ping.ScanResult `json:"results"`
 }
 
-func (ipam *IPAMServer) handleManualPingScan(conn *websocket.Conn, decJSON *json.Decoder) {
+func (ipam *IPAMServer) handleManualPingScan(conn *wsClient, decJSON *json.Decoder) {
 	remoteIP, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
 	inMsg := inboundManualPingScan{}
 	err := decJSON.Decode(&inMsg)
 	if err != nil {
-		log.Printf("error decoding manualPingScan request from (%v)\n", remoteIP)
+		ipam.log.Warnf("error decoding manualPingScan request from (%v)\n", remoteIP)
 		return
 	}
 	var networks []*net.IPNet
@@ -375,20 +606,22 @@ func (ipam *IPAMServer) handleManualPingScan(conn *websocket.Conn, decJSON *json
 		}
 	}
 	if networks == nil {
-		log.Printf("received an invalid manualPingScan 'Networks' query from (%v)\n", remoteIP)
+		ipam.log.Warnf("received an invalid manualPingScan 'Networks' query from (%v)\n", remoteIP)
 		return
 	}
-	go func() {
-		for _, network := range networks {
-			ipam.semaphore <- struct{}{}
-			if !ipam.demoModeBool {
-				ipam.pinger.ScanNetwork(network)
-			} else {
-				ipam.pinger.ScanPretendNetwork(network)
-			}
-			<-ipam.semaphore
+	scan := newManualScan(conn, remoteIP, inMsg.SessionGUID, networks)
+	err = ipam.manualScans.enqueue(scan)
+	if err != nil {
+		ipam.log.Warnf("rejected manualPingScan from (%v) > %v\n", remoteIP, err)
+		errorType := float64(UnknownFault)
+		var rejection *scanRejection
+		if errors.As(err, &rejection) {
+			errorType = rejection.errorType
 		}
-	}()
+		sendGenericError(conn, err.Error(), inMsg.SessionGUID, int(errorType))
+		return
+	}
+	ipam.sendManualScanPositions()
 	outMsg := outboundManualPingScan{}
 	outMsg.MessageType = ManualPingScan
 	outMsg.SessionGUID = inMsg.SessionGUID
@@ -398,7 +631,7 @@ func (ipam *IPAMServer) handleManualPingScan(conn *websocket.Conn, decJSON *json
 	b, err := json.Marshal(outMsg)
 	if err != nil {
 		remoteIP, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
-		log.Printf("error encoding ManualPingScan for (%v)\n", remoteIP)
+		ipam.log.Errorf("error encoding ManualPingScan for (%v)\n", remoteIP)
 	} else {
 		conn.WriteMessage(websocket.TextMessage, b)
 	}
@@ -416,12 +649,12 @@ type inboundCreateSubnet struct {
 	} `json:"subnetRequest"`
 }
 
-func (ipam *IPAMServer) handleCreateSubnet(conn *websocket.Conn, decJSON *json.Decoder) {
+func (ipam *IPAMServer) handleCreateSubnet(conn *wsClient, decJSON *json.Decoder) {
 	remoteIP, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
 	inMsg := inboundCreateSubnet{}
 	err := decJSON.Decode(&inMsg)
 	if err != nil {
-		log.Printf("error decoding inboundCreateSubnet request from (%v)\n", remoteIP)
+		ipam.log.Warnf("error decoding inboundCreateSubnet request from (%v)\n", remoteIP)
 		return
 	}
 	user := strings.TrimSpace(inMsg.SubnetRequest.User)
@@ -434,18 +667,12 @@ func (ipam *IPAMServer) handleCreateSubnet(conn *websocket.Conn, decJSON *json.D
 		return
 	}
 	subnet := network.String()
-	ipam.authCallbackMtx.RLock()
-	if ipam.authCallback(user, pass) == false {
+	user, err = ipam.authenticateClient(conn, user, pass)
+	if err != nil {
 		s := fmt.Sprintf("could not create '%v' because of auth failure", subnet)
 		sendGenericError(conn, s, inMsg.SessionGUID, int(AuthenticationFailure))
 		return
 	}
-	ipam.authCallbackMtx.RUnlock()
-	if user != "" {
-		user = fmt.Sprintf("%v@%v", user, remoteIP)
-	} else {
-		user = remoteIP
-	}
 	desc := strings.TrimSpace(inMsg.SubnetRequest.Desc)
 	details := strings.TrimSpace(inMsg.SubnetRequest.Notes)
 	vlan := strings.TrimSpace(inMsg.SubnetRequest.Vlan)
@@ -455,19 +682,28 @@ func (ipam *IPAMServer) handleCreateSubnet(conn *websocket.Conn, decJSON *json.D
 		sendGenericError(conn, err.Error(), inMsg.SessionGUID, int(UnknownFault))
 		return
 	}
-	msg := ipam.history.RecordUserAction(user, "creating subnet", newSkeleton.ToSlice())
-	ipam.signalMutation(msg)
+	_, err = ipam.commitMutation(history.Event{
+		Actor:    user,
+		SourceIP: remoteIP,
+		Action:   history.ActionCreateSubnet,
+		Target:   newSkeleton.Net,
+		After:    newSkeleton,
+	})
+	if err != nil {
+		sendGenericError(conn, err.Error(), inMsg.SessionGUID, int(UnknownFault))
+		return
+	}
 	sendGenericInfo(conn, "success", inMsg.SessionGUID)
 }
 
 type inboundModifySubnet inboundCreateSubnet
 
-func (ipam *IPAMServer) handleModifySubnet(conn *websocket.Conn, decJSON *json.Decoder) {
+func (ipam *IPAMServer) handleModifySubnet(conn *wsClient, decJSON *json.Decoder) {
 	remoteIP, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
 	inMsg := inboundModifySubnet{}
 	err := decJSON.Decode(&inMsg)
 	if err != nil {
-		log.Printf("error decoding inboundModifySubnet request from (%v)\n", remoteIP)
+		ipam.log.Warnf("error decoding inboundModifySubnet request from (%v)\n", remoteIP)
 		return
 	}
 	user := strings.TrimSpace(inMsg.SubnetRequest.User)
@@ -480,18 +716,12 @@ func (ipam *IPAMServer) handleModifySubnet(conn *websocket.Conn, decJSON *json.D
 		return
 	}
 	subnet := network.String()
-	ipam.authCallbackMtx.RLock()
-	if ipam.authCallback(user, pass) == false {
+	user, err = ipam.authenticateClient(conn, user, pass)
+	if err != nil {
 		s := fmt.Sprintf("could not modify '%v' because of auth failure", subnet)
 		sendGenericError(conn, s, inMsg.SessionGUID, int(AuthenticationFailure))
 		return
 	}
-	ipam.authCallbackMtx.RUnlock()
-	if user != "" {
-		user = fmt.Sprintf("%v@%v", user, remoteIP)
-	} else {
-		user = remoteIP
-	}
 	desc := strings.TrimSpace(inMsg.SubnetRequest.Desc)
 	details := strings.TrimSpace(inMsg.SubnetRequest.Notes)
 	vlan := strings.TrimSpace(inMsg.SubnetRequest.Vlan)
@@ -513,19 +743,29 @@ func (ipam *IPAMServer) handleModifySubnet(conn *websocket.Conn, decJSON *json.D
 		sendGenericError(conn, err.Error(), inMsg.SessionGUID, int(UnknownFault))
 		return
 	}
-	msg := ipam.history.RecordUserAction(user, "pushing changes", differences)
-	ipam.signalMutation(msg)
+	_, err = ipam.commitMutation(history.Event{
+		Actor:    user,
+		SourceIP: remoteIP,
+		Action:   history.ActionModifySubnet,
+		Target:   newSkeleton.Net,
+		Before:   oldSkeleton,
+		After:    newSkeleton,
+	})
+	if err != nil {
+		sendGenericError(conn, err.Error(), inMsg.SessionGUID, int(UnknownFault))
+		return
+	}
 	sendGenericInfo(conn, "success", inMsg.SessionGUID)
 }
 
 type inboundDeleteSubnet inboundCreateSubnet
 
-func (ipam *IPAMServer) handleDeleteSubnet(conn *websocket.Conn, decJSON *json.Decoder) {
+func (ipam *IPAMServer) handleDeleteSubnet(conn *wsClient, decJSON *json.Decoder) {
 	remoteIP, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
 	inMsg := inboundDeleteSubnet{}
 	err := decJSON.Decode(&inMsg)
 	if err != nil {
-		log.Printf("error decoding inboundDeleteSubnet request from (%v)\n", remoteIP)
+		ipam.log.Warnf("error decoding inboundDeleteSubnet request from (%v)\n", remoteIP)
 		return
 	}
 	network := subnetmath.ParseNetworkCIDR(strings.TrimSpace(inMsg.SubnetRequest.Net))
@@ -535,27 +775,223 @@ func (ipam *IPAMServer) handleDeleteSubnet(conn *websocket.Conn, decJSON *json.D
 		return
 	}
 	subnet := network.String()
-	ipam.authCallbackMtx.RLock()
 	user := strings.TrimSpace(inMsg.SubnetRequest.User)
 	pass := strings.TrimSpace(inMsg.SubnetRequest.Pass)
-	if ipam.authCallback(user, pass) == false {
-		s := fmt.Sprintf("could not modify '%v' because of auth failure", subnet)
+	user, err = ipam.authenticateClient(conn, user, pass)
+	if err != nil {
+		s := fmt.Sprintf("could not delete '%v' because of auth failure", subnet)
 		sendGenericError(conn, s, inMsg.SessionGUID, int(AuthenticationFailure))
 		return
 	}
-	if user != "" {
-		user = fmt.Sprintf("%v@%v", user, remoteIP)
-	} else {
-		user = remoteIP
-	}
-	ipam.authCallbackMtx.RUnlock()
 	oldSkeleton := ipam.subnets.GetSubnetSkeleton(network)
 	if oldSkeleton == nil || ipam.subnets.DeleteSubnet(network) != nil {
 		s := fmt.Sprintf("could not delete '%v' as it does not exist", subnet)
 		sendGenericError(conn, s, inMsg.SessionGUID, int(DoesNotExist))
 		return
 	}
-	msg := ipam.history.RecordUserAction(user, "deleting subnet", oldSkeleton.ToSlice())
-	ipam.signalMutation(msg)
+	_, err = ipam.commitMutation(history.Event{
+		Actor:    user,
+		SourceIP: remoteIP,
+		Action:   history.ActionDeleteSubnet,
+		Target:   oldSkeleton.Net,
+		Before:   oldSkeleton,
+	})
+	if err != nil {
+		sendGenericError(conn, err.Error(), inMsg.SessionGUID, int(UnknownFault))
+		return
+	}
 	sendGenericInfo(conn, "success", inMsg.SessionGUID)
 }
+
+type inboundRevertAction struct {
+	baseMessage
+	User    string `json:"user"`
+	Pass    string `json:"pass"`
+	EventID string `json:"eventID"`
+}
+
+type outboundRevertAction struct {
+	baseMessage
+	Event history.Event `json:"event"`
+}
+
+func (ipam *IPAMServer) handleRevertAction(conn *wsClient, decJSON *json.Decoder) {
+	remoteIP, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
+	inMsg := inboundRevertAction{}
+	err := decJSON.Decode(&inMsg)
+	if err != nil {
+		ipam.log.Warnf("error decoding inboundRevertAction request from (%v)\n", remoteIP)
+		return
+	}
+	eventID := strings.TrimSpace(inMsg.EventID)
+	user, err := ipam.authenticateClient(conn, strings.TrimSpace(inMsg.User), strings.TrimSpace(inMsg.Pass))
+	if err != nil {
+		s := fmt.Sprintf("could not revert '%v' because of auth failure", eventID)
+		sendGenericError(conn, s, inMsg.SessionGUID, int(AuthenticationFailure))
+		return
+	}
+	record, err := ipam.revertHistoryEvent(eventID, user, remoteIP)
+	if err != nil {
+		errType := UnknownFault
+		if revertErr, ok := err.(*revertError); ok {
+			if revertErr.conflict {
+				errType = Conflict
+			} else if revertErr.missing {
+				errType = DoesNotExist
+			}
+		}
+		sendGenericError(conn, err.Error(), inMsg.SessionGUID, int(errType))
+		return
+	}
+	outMsg := outboundRevertAction{Event: record}
+	outMsg.MessageType = RevertAction
+	outMsg.SessionGUID = inMsg.SessionGUID
+	b, err := json.Marshal(outMsg)
+	if err != nil {
+		ipam.log.Errorf("error encoding outgoing RevertAction to (%v)\n", remoteIP)
+	} else {
+		conn.WriteMessage(websocket.TextMessage, b)
+	}
+}
+
+func (ipam *IPAMServer) authenticateClient(conn *wsClient, user, pass string) (string, error) {
+	if pass == "" && conn.sessionID != "" {
+		sess, valid := ipam.sessions.Touch(conn.sessionID)
+		if !valid {
+			conn.sessionID = ""
+			return "", fmt.Errorf("session has expired")
+		}
+		return sess.Identity.User, nil
+	}
+	id, err := ipam.authenticate(user, pass)
+	if err != nil {
+		return "", err
+	}
+	return id.User, nil
+}
+
+type inboundLogin struct {
+	baseMessage
+	User string `json:"user"`
+	Pass string `json:"pass"`
+}
+
+type outboundLogin struct {
+	baseMessage
+	User        string   `json:"user"`
+	Groups      []string `json:"groups"`
+	IdleTimeout int      `json:"idleTimeout"`
+}
+
+func (ipam *IPAMServer) handleLogin(conn *wsClient, decJSON *json.Decoder) {
+	remoteIP, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
+	inMsg := inboundLogin{}
+	err := decJSON.Decode(&inMsg)
+	if err != nil {
+		ipam.log.Warnf("error decoding login request from (%v)\n", remoteIP)
+		return
+	}
+	user := strings.TrimSpace(inMsg.User)
+	pass := strings.TrimSpace(inMsg.Pass)
+	id, err := ipam.authenticate(user, pass)
+	if err != nil {
+		ipam.log.Warnf("(%v) failed to login as '%v' > %v\n", remoteIP, user, err)
+		s := fmt.Sprintf("could not login as '%v' because of auth failure", user)
+		sendGenericError(conn, s, inMsg.SessionGUID, int(AuthenticationFailure))
+		return
+	}
+	ipam.sessions.Delete(conn.sessionID)
+	conn.sessionID = ""
+	conn.sessionID = ipam.sessions.Create(id, remoteIP).ID
+	ipam.log.Infof("(%v) has logged in as '%v'\n", remoteIP, id.User)
+	outMsg := outboundLogin{}
+	outMsg.MessageType = Login
+	outMsg.SessionGUID = inMsg.SessionGUID
+	outMsg.User = id.User
+	outMsg.Groups = id.Groups
+	outMsg.IdleTimeout = int(ipam.sessions.GetIdleTimeout() / time.Second)
+	b, err := json.Marshal(outMsg)
+	if err != nil {
+		ipam.log.Errorf("error encoding login for (%v)\n", remoteIP)
+	} else {
+		conn.WriteMessage(websocket.TextMessage, b)
+	}
+}
+
+func (ipam *IPAMServer) handleLogout(conn *wsClient, guid string) {
+	remoteIP, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
+	if conn.sessionID == "" {
+		sendGenericError(conn, "could not logout because there is no active session", guid, int(AuthenticationFailure))
+		return
+	}
+	ipam.sessions.Delete(conn.sessionID)
+	conn.sessionID = ""
+	ipam.log.Infof("(%v) has logged out\n", remoteIP)
+	outMsg := baseMessage{}
+	outMsg.MessageType = Logout
+	outMsg.SessionGUID = guid
+	b, err := json.Marshal(outMsg)
+	if err != nil {
+		ipam.log.Errorf("error encoding logout for (%v)\n", remoteIP)
+	} else {
+		conn.WriteMessage(websocket.TextMessage, b)
+	}
+}
+
+type inboundSubscribe struct {
+	baseMessage
+	EventTypes []string `json:"eventTypes"`
+}
+
+type outboundSubscribe struct {
+	baseMessage
+	Event events.Event `json:"event"`
+}
+
+func (ipam *IPAMServer) handleSubscribe(conn *wsClient, decJSON *json.Decoder) {
+	remoteIP, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
+	inMsg := inboundSubscribe{}
+	err := decJSON.Decode(&inMsg)
+	if err != nil {
+		ipam.log.Warnf("error decoding subscribe request from (%v)\n", remoteIP)
+		return
+	}
+	wanted := make(map[string]bool, len(inMsg.EventTypes))
+	for _, eventType := range inMsg.EventTypes {
+		wanted[eventType] = true
+	}
+	if conn.subscription != nil {
+		ipam.events.Unsubscribe(conn.subscription)
+	}
+	conn.subscription = ipam.events.Subscribe(256)
+	ipam.log.Debugf("(%v) has subscribed to %v event types\n", remoteIP, len(wanted))
+	go func(subscription chan events.Event, guid string) {
+		for evt := range subscription {
+			if len(wanted) > 0 && !wanted[evt.Type] {
+				continue
+			}
+			outMsg := outboundSubscribe{}
+			outMsg.MessageType = Subscribe
+			outMsg.SessionGUID = guid
+			outMsg.Event = evt
+			b, err := json.Marshal(outMsg)
+			if err != nil {
+				ipam.log.Errorf("error encoding event for (%v)\n", remoteIP)
+				continue
+			}
+			conn.WriteMessage(websocket.TextMessage, b)
+		}
+		if ipam.events.Lagged(subscription) {
+			sendGenericError(conn, "events were sent faster than they could be received so subscribe again", guid, int(UnknownFault))
+		}
+	}(conn.subscription, inMsg.SessionGUID)
+	sendGenericInfo(conn, "subscribed", inMsg.SessionGUID)
+}
+
+func (ipam *IPAMServer) handleUnsubscribe(conn *wsClient, guid string) {
+	if conn.subscription != nil {
+		ipam.events.Unsubscribe(conn.subscription)
+		conn.subscription = nil
+	}
+	sendGenericInfo(conn, "unsubscribed", guid)
+}
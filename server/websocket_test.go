@@ -0,0 +1,264 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/demskie/ipam/server/ping"
+	"github.com/demskie/subnetmath"
+	"github.com/gorilla/websocket"
+)
+
+func dialWebsocket(t *testing.T, ipam *IPAMServer) *websocket.Conn {
+	srv := httptest.NewServer(http.HandlerFunc(ipam.handleWebsocketClient))
+	t.Cleanup(srv.Close)
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ws.Close() })
+	return ws
+}
+
+// sendMessage writes the message with the messageType and sessionGUID added to its fields
+func sendMessage(t *testing.T, ws *websocket.Conn, messageType float64, guid, fields string) {
+	t.Helper()
+	msg := fmt.Sprintf(`{"messageType":%v,"sessionGUID":"%v"`, messageType, guid)
+	if fields != "" {
+		msg += "," + fields
+	}
+	if err := ws.WriteMessage(websocket.TextMessage, []byte(msg+"}")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// readMessage decodes the next message into v and returns its baseMessage
+func readMessage(t *testing.T, ws *websocket.Conn, v interface{}) baseMessage {
+	t.Helper()
+	ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, b, err := ws.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	base := baseMessage{}
+	if err = json.Unmarshal(b, &base); err == nil && v != nil {
+		err = json.Unmarshal(b, v)
+	}
+	if err != nil {
+		t.Fatalf("expected a valid message but got %s > %v", b, err)
+	}
+	return base
+}
+
+func expectWebsocketError(t *testing.T, ws *websocket.Conn, guid string, errorType float64) {
+	t.Helper()
+	outMsg := outboundGenericError{}
+	base := readMessage(t, ws, &outMsg)
+	if base.MessageType != GenericError || base.SessionGUID != guid || outMsg.ErrorType != errorType {
+		t.Fatalf("expected error %v for '%v' but got %+v", errorType, guid, outMsg)
+	}
+}
+
+func createSubnetWithSession(t *testing.T, ws *websocket.Conn, guid, subnet string) {
+	t.Helper()
+	sendMessage(t, ws, CreateSubnet, guid, fmt.Sprintf(`"subnetRequest":{"user":"","pass":"","net":"%v"}`, subnet))
+}
+
+func login(t *testing.T, ws *websocket.Conn, guid, pass string) outboundLogin {
+	t.Helper()
+	sendMessage(t, ws, Login, guid, fmt.Sprintf(`"user":"alice","pass":"%v"`, pass))
+	outMsg := outboundLogin{}
+	readMessage(t, ws, &outMsg)
+	return outMsg
+}
+
+func TestWebsocketLoginAndLogout(t *testing.T) {
+	ipam := newAuthTestServer(t)
+	ws := dialWebsocket(t, ipam)
+
+	createSubnetWithSession(t, ws, "before", "10.0.0.0/24")
+	expectWebsocketError(t, ws, "before", AuthenticationFailure)
+	sendMessage(t, ws, Login, "wrong", `"user":"alice","pass":"wrong"`)
+	expectWebsocketError(t, ws, "wrong", AuthenticationFailure)
+	if ipam.sessions.Len() != 0 {
+		t.Fatal("expected a failed login to not create a session")
+	}
+
+	outMsg := login(t, ws, "login", "secret")
+	if outMsg.MessageType != Login || outMsg.SessionGUID != "login" || outMsg.User != "alice" || outMsg.IdleTimeout != 1800 {
+		t.Fatalf("expected alice to be logged in for 30 minutes but got %+v", outMsg)
+	}
+	if ipam.sessions.Len() != 1 {
+		t.Fatalf("expected one session but got %v", ipam.sessions.Len())
+	}
+	// the session is identified by the server rather than by the request
+	createSubnetWithSession(t, ws, "during", "10.0.0.0/24")
+	info := outboundGenericInfo{}
+	if base := readMessage(t, ws, &info); base.MessageType != GenericInfo || info.Info != "success" {
+		t.Fatalf("expected the session to authenticate the change but got %+v", info)
+	}
+	if evts := ipam.history.GetAllEvents(); len(evts) != 1 || evts[0].Actor != "alice" {
+		t.Fatalf("expected the change to be made by alice but got %+v", evts)
+	}
+
+	sendMessage(t, ws, Logout, "logout", "")
+	if base := readMessage(t, ws, nil); base.MessageType != Logout || base.SessionGUID != "logout" {
+		t.Fatalf("expected to be logged out but got %+v", base)
+	}
+	if ipam.sessions.Len() != 0 {
+		t.Fatal("expected the session to end on logout")
+	}
+	createSubnetWithSession(t, ws, "after", "10.0.1.0/24")
+	expectWebsocketError(t, ws, "after", AuthenticationFailure)
+	sendMessage(t, ws, Logout, "again", "")
+	expectWebsocketError(t, ws, "again", AuthenticationFailure)
+}
+
+func TestWebsocketSessionsExpire(t *testing.T) {
+	ipam := newAuthTestServer(t)
+	ws := dialWebsocket(t, ipam)
+
+	login(t, ws, "login", "secret")
+	ipam.SetSessionIdleTimeout(50 * time.Millisecond)
+	time.Sleep(100 * time.Millisecond)
+	if expired := ipam.sessions.Sweep(); expired != 1 {
+		t.Fatalf("expected the idle session to be swept but %v were", expired)
+	}
+	createSubnetWithSession(t, ws, "expired", "10.0.0.0/24")
+	expectWebsocketError(t, ws, "expired", AuthenticationFailure)
+
+	ipam.SetSessionIdleTimeout(time.Hour)
+	login(t, ws, "again", "secret")
+	if ipam.sessions.Len() != 1 {
+		t.Fatal("expected to be able to login again")
+	}
+	ws.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for ipam.sessions.Len() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the session to end when the client disconnected")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// scanMessage holds the fields of every message that is streamed during a manual scan
+type scanMessage struct {
+	baseMessage
+	Position    int
+	QueueLength int
+	Completed   int
+	Total       int
+	Cancelled   bool
+	Result      ping.ScanResult
+	Results     []ping.ScanResult
+}
+
+// readScanMessages returns every message up to and including the first one of messageType
+func readScanMessages(t *testing.T, ws *websocket.Conn, messageType float64) []scanMessage {
+	t.Helper()
+	msgs := []scanMessage{}
+	for {
+		msg := scanMessage{}
+		readMessage(t, ws, &msg)
+		msgs = append(msgs, msg)
+		if msg.MessageType == messageType {
+			return msgs
+		}
+	}
+}
+
+func newScanTestServer(t *testing.T, workers int) *IPAMServer {
+	ipam := newTestServer(t)
+	ipam.EnableDemoMode()
+	go ipam.pinger.InitializeBackgroundPinger(1000, workers)
+	return ipam
+}
+
+func TestWebsocketManualScanStreamsResults(t *testing.T) {
+	ipam := newScanTestServer(t, 4)
+	ws := dialWebsocket(t, ipam)
+	sendMessage(t, ws, ManualPingScan, "scan", `"networks":["10.0.0.0/30"]`)
+	counts := map[float64]int{}
+	completed := map[int]bool{}
+	msgs := readScanMessages(t, ws, ManualPingScanComplete)
+	for _, msg := range msgs {
+		if msg.SessionGUID != "scan" {
+			t.Fatalf("expected every message to belong to the scan but got %+v", msg)
+		}
+		counts[msg.MessageType]++
+		switch msg.MessageType {
+		case ManualPingScan:
+			if len(msg.Results) != 4 {
+				t.Fatalf("expected the remembered results to be sent immediately but got %+v", msg)
+			}
+		case ManualPingScanQueue:
+			if msg.Position > 1 || msg.QueueLength > 1 {
+				t.Fatalf("expected the scan to be first in the queue but got %+v", msg)
+			}
+		case ManualPingScanProgress:
+			if msg.Total != 4 || msg.Completed > 4 {
+				t.Fatalf("expected progress out of 4 but got %+v", msg)
+			}
+		case ManualPingScanResult:
+			if msg.Total != 4 || completed[msg.Completed] || msg.Result.Status == ping.StatusUnknown {
+				t.Fatalf("expected a new result out of 4 but got %+v", msg)
+			}
+			completed[msg.Completed] = true
+		}
+	}
+	if counts[ManualPingScan] != 1 || counts[ManualPingScanQueue] == 0 || counts[ManualPingScanProgress] == 0 || counts[ManualPingScanResult] != 4 {
+		t.Fatalf("expected the reply, queue positions, progress and 4 results but got %v", counts)
+	}
+	complete := msgs[len(msgs)-1]
+	if complete.Completed != 4 || complete.Total != 4 || complete.Cancelled || len(complete.Results) != 4 {
+		t.Fatalf("expected the scan to complete with every result but got %+v", complete)
+	}
+	for _, res := range complete.Results {
+		if res.Status == ping.StatusUnknown {
+			t.Fatalf("expected every address to have been probed but got %+v", res)
+		}
+	}
+}
+
+func TestWebsocketManualScanQueuesAndCancelsOnDisconnect(t *testing.T) {
+	ipam := newScanTestServer(t, 1)
+	first, second := dialWebsocket(t, ipam), dialWebsocket(t, ipam)
+	sendMessage(t, first, ManualPingScan, "first", `"networks":["10.0.0.0/26"]`)
+	readScanMessages(t, first, ManualPingScanResult)
+
+	sendMessage(t, second, ManualPingScan, "second", `"networks":["10.0.1.0/30"]`)
+	msgs := readScanMessages(t, second, ManualPingScanQueue)
+	if queued := msgs[len(msgs)-1]; queued.Position != 1 || queued.QueueLength != 1 {
+		t.Fatalf("expected to wait behind the running scan but got %+v", queued)
+	}
+
+	// the second scan only starts once the first has stopped
+	first.Close()
+	running := false
+	for _, msg := range readScanMessages(t, second, ManualPingScanComplete) {
+		if msg.MessageType == ManualPingScanQueue && msg.Position == 0 {
+			running = true
+		}
+		if msg.MessageType == ManualPingScanComplete && (msg.Completed != 4 || msg.Cancelled) {
+			t.Fatalf("expected the queued scan to complete but got %+v", msg)
+		}
+	}
+	if !running {
+		t.Fatal("expected to be told when the queued scan started running")
+	}
+	probed := 0
+	for _, res := range ipam.pinger.GetScanResults(subnetmath.ParseNetworkCIDR("10.0.0.0/26")) {
+		if res.Status != ping.StatusUnknown {
+			probed++
+		}
+	}
+	if probed == 0 || probed == 64 {
+		t.Fatalf("expected the scan to stop when its client disconnected but %v of 64 addresses were probed", probed)
+	}
+}